@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -257,3 +258,57 @@ func UnpackRevert(data []byte) (string, error) {
 	}
 	return reason, nil
 }
+
+// panicSelector is a special function selector for panic code unpacking.
+var panicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+
+// panicReasons are the descriptions of the panic codes raised by the compiler
+// generated checks of Solidity 0.8.0+.
+var panicReasons = map[uint64]string{
+	0x00: "generic panic",
+	0x01: "assert(false)",
+	0x11: "arithmetic underflow or overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum overflow",
+	0x22: "invalid encoded storage byte array accessed",
+	0x31: "out-of-bounds array access; popping on an empty array",
+	0x32: "out-of-bounds access of an array or bytesN",
+	0x41: "out of memory",
+	0x51: "uninitialized function",
+}
+
+// UnpackPanic resolves the abi-encoded panic code. Failing assertions and
+// runtime checks revert as if calling a function `Panic(uint256)` with a code
+// identifying the failure.
+func UnpackPanic(data []byte) (*big.Int, error) {
+	if len(data) < 4 {
+		return nil, errors.New("invalid data for unpacking")
+	}
+	if !bytes.Equal(data[:4], panicSelector) {
+		return nil, errors.New("invalid data for unpacking")
+	}
+	typ, _ := NewType("uint256", "", nil)
+	values, err := (Arguments{{Type: typ}}).UnpackValues(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	return values[0].(*big.Int), nil
+}
+
+// UnpackRevertReason resolves a human readable reason from the data of a
+// revert, which is either an `Error(string)` or a `Panic(uint256)` payload.
+func UnpackRevertReason(data []byte) (string, error) {
+	if reason, err := UnpackRevert(data); err == nil {
+		return reason, nil
+	}
+	code, err := UnpackPanic(data)
+	if err != nil {
+		return "", err
+	}
+	if code.IsUint64() {
+		if reason, ok := panicReasons[code.Uint64()]; ok {
+			return fmt.Sprintf("panic: %s (%#x)", reason, code), nil
+		}
+	}
+	return fmt.Sprintf("panic: unknown code %#x", code), nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// accessListPrefix is the database key prefix of stored block access lists:
+// accessListPrefix + block hash -> RLP([]AccessTuple).
+var accessListPrefix = []byte("BHE-access-")
+
+// AccessTuple is an account touched during the execution of a block, along
+// with the storage slots of it that were accessed.
+type AccessTuple struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []common.Hash  `json:"storageKeys"`
+}
+
+// accessRecorder is a vm.Tracer collecting every account and storage slot
+// accessed by the transactions it observes.
+type accessRecorder struct {
+	accounts map[common.Address]map[common.Hash]struct{}
+}
+
+func newAccessRecorder() *accessRecorder {
+	return &accessRecorder{accounts: make(map[common.Address]map[common.Hash]struct{})}
+}
+
+func (r *accessRecorder) touch(addr common.Address) map[common.Hash]struct{} {
+	slots, ok := r.accounts[addr]
+	if !ok {
+		slots = make(map[common.Hash]struct{})
+		r.accounts[addr] = slots
+	}
+	return slots
+}
+
+// CaptureStart implements vm.Tracer, recording the sender and recipient.
+func (r *accessRecorder) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	r.touch(from)
+	r.touch(to)
+	return nil
+}
+
+// CaptureState implements vm.Tracer, recording the accounts and slots accessed
+// by the opcode about to be executed.
+func (r *accessRecorder) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	slots := r.touch(contract.Address())
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		if stack.Len() >= 1 {
+			slots[common.BytesToHash(stack.Back(0).Bytes())] = struct{}{}
+		}
+	case vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODECOPY, vm.EXTCODEHASH, vm.SELFDESTRUCT:
+		if stack.Len() >= 1 {
+			r.touch(common.BytesToAddress(stack.Back(0).Bytes()))
+		}
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		if stack.Len() >= 2 {
+			r.touch(common.BytesToAddress(stack.Back(1).Bytes()))
+		}
+	}
+	return nil
+}
+
+// CaptureFault implements vm.Tracer.
+func (r *accessRecorder) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements vm.Tracer.
+func (r *accessRecorder) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
+}
+
+// list returns the recorded accesses sorted by address and slot.
+func (r *accessRecorder) list() []AccessTuple {
+	list := make([]AccessTuple, 0, len(r.accounts))
+	for addr, slots := range r.accounts {
+		tuple := AccessTuple{Address: addr, StorageKeys: make([]common.Hash, 0, len(slots))}
+		for slot := range slots {
+			tuple.StorageKeys = append(tuple.StorageKeys, slot)
+		}
+		sort.Slice(tuple.StorageKeys, func(i, j int) bool {
+			return bytes.Compare(tuple.StorageKeys[i][:], tuple.StorageKeys[j][:]) < 0
+		})
+		list = append(list, tuple)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].Address[:], list[j].Address[:]) < 0
+	})
+	return list
+}
+
+// computeAccessList re-executes a block on top of its parent state and returns
+// every account and slot accessed, including the block rewards.
+func (s *BHEereum) computeAccessList(block *types.Block) ([]AccessTuple, error) {
+	parent := s.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, errors.New("parent block not found")
+	}
+	statedb, err := s.blockchain.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
+	recorder := newAccessRecorder()
+	if _, _, _, err := s.blockchain.Processor().Process(block, statedb, vm.Config{Debug: true, Tracer: recorder}); err != nil {
+		return nil, err
+	}
+	recorder.touch(block.Coinbase())
+	for _, uncle := range block.Uncles() {
+		recorder.touch(uncle.Coinbase)
+	}
+	return recorder.list(), nil
+}
+
+// readAccessList retrieves the stored access list of a block, if any.
+func readAccessList(db BHEdb.KeyValueReader, hash common.Hash) []AccessTuple {
+	blob, err := db.Get(append(accessListPrefix, hash.Bytes()...))
+	if err != nil || len(blob) == 0 {
+		return nil
+	}
+	var list []AccessTuple
+	if err := rlp.DecodeBytes(blob, &list); err != nil {
+		log.Error("Invalid block access list", "hash", hash, "err", err)
+		return nil
+	}
+	return list
+}
+
+// writeAccessList stores the access list of a block.
+func writeAccessList(db BHEdb.KeyValueWriter, hash common.Hash, list []AccessTuple) {
+	blob, err := rlp.EncodeToBytes(list)
+	if err != nil {
+		log.Crit("Failed to encode block access list", "err", err)
+	}
+	if err := db.Put(append(accessListPrefix, hash.Bytes()...), blob); err != nil {
+		log.Crit("Failed to store block access list", "err", err)
+	}
+}
+
+// startAccessLists starts recording the access list of every newly imported
+// block, until the subscription is torn down on shutdown.
+func (s *BHEereum) startAccessLists() {
+	events := make(chan core.ChainEvent, 64)
+	s.accessListSub = s.blockchain.SubscribeChainEvent(events)
+
+	go func() {
+		for {
+			select {
+			case ev := <-events:
+				start := time.Now()
+				list, err := s.computeAccessList(ev.Block)
+				if err != nil {
+					log.Warn("Failed to record block access list", "number", ev.Block.Number(), "hash", ev.Hash, "err", err)
+					continue
+				}
+				writeAccessList(s.chainDb, ev.Hash, list)
+				log.Debug("Recorded block access list", "number", ev.Block.Number(), "hash", ev.Hash, "accounts", len(list), "elapsed", common.PrettyDuration(time.Since(start)))
+
+			case <-s.accessListSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// GetBlockAccessList returns every account and storage slot accessed during
+// the execution of the given block. Recorded lists are served from disk, other
+// blocks are re-executed on demand if their parent state is available.
+func (api *PublicBHEereumAPI) GetBlockAccessList(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]AccessTuple, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		return nil, errors.New("pending block access list not available")
+	}
+	block, err := api.e.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	if list := readAccessList(api.e.chainDb, block.Hash()); list != nil {
+		return list, nil
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis block has no access list")
+	}
+	return api.e.computeAccessList(block)
+}
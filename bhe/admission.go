@@ -0,0 +1,128 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// admitTx runs the node-wide admission checks (calldata size limit, address
+// deny-list, spam protection of remote transactions and external policy
+// screening) on a transaction before it is handed to the pool.
+func (s *BHEereum) admitTx(ctx context.Context, tx *types.Transaction, local bool) error {
+	if err := s.checkCallData(tx); err != nil {
+		return err
+	}
+	if s.denyList.empty() && s.spam == nil && s.screener == nil {
+		return nil
+	}
+	signer := types.MakeSigner(s.blockchain.Config(), s.blockchain.CurrentBlock().Number())
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return err
+	}
+	if err := s.denyList.check(tx, from, local); err != nil {
+		return err
+	}
+	if !local && s.spam != nil {
+		if err := s.checkSpam(tx, from); err != nil {
+			return err
+		}
+	}
+	return s.screener.screen(ctx, tx, from, local)
+}
+
+// filterRemoteTxs runs the admission checks on a batch of transactions received
+// from the network, returning only those allowed into the pool. The protocol
+// handler calls it before handing the batch to the pool, so the transactions
+// are checked concurrently, bounding the wait for the policy service to about
+// a single verdict per batch.
+func (s *BHEereum) filterRemoteTxs(txs []*types.Transaction) []*types.Transaction {
+	if s.extensions == nil && s.denyList.empty() && s.spam == nil && s.screener == nil {
+		return txs
+	}
+	var (
+		errs  = make([]error, len(txs))
+		next  = make(chan int)
+		pend  sync.WaitGroup
+		procs = screeningWorkers
+	)
+	if procs > len(txs) {
+		procs = len(txs)
+	}
+	for i := 0; i < procs; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+			for i := range next {
+				errs[i] = s.admitTx(context.Background(), txs[i], false)
+			}
+		}()
+	}
+	for i := range txs {
+		next <- i
+	}
+	close(next)
+	pend.Wait()
+
+	admitted := txs[:0]
+	for i, tx := range txs {
+		if errs[i] != nil {
+			log.Trace("Dropped inadmissible remote transaction", "hash", tx.Hash(), "err", errs[i])
+			continue
+		}
+		admitted = append(admitted, tx)
+	}
+	return admitted
+}
+
+// dropDeniedTxs removes the transactions from or to an address from the pool,
+// once the address got denied. It returns the number of transactions removed.
+func (s *BHEereum) dropDeniedTxs(addr common.Address) int {
+	var denied []common.Hash
+
+	pending, queued := s.txPool.Content()
+	for _, content := range []map[common.Address]types.Transactions{pending, queued} {
+		for from, txs := range content {
+			for _, tx := range txs {
+				if from == addr || (tx.To() != nil && *tx.To() == addr) {
+					denied = append(denied, tx.Hash())
+				}
+			}
+		}
+	}
+	if len(denied) == 0 {
+		return 0
+	}
+	return s.txPool.RemoveTransactions(denied)
+}
+
+// checkCallData rejects transactions whose calldata exceeds the limit of the
+// next block, which could never be included.
+func (s *BHEereum) checkCallData(tx *types.Transaction) error {
+	if s.extensions == nil {
+		return nil
+	}
+	next := new(big.Int).Add(s.blockchain.CurrentBlock().Number(), big.NewInt(1))
+	if limit := s.extensions.MaxCallDataSize(next); limit != 0 && uint64(len(tx.Data())) > limit {
+		return fmt.Errorf("calldata too large: %d > %d", len(tx.Data()), limit)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Freezer tables the ancient data is served from.
+const (
+	ancientHashTable    = "hashes"
+	ancientBodyTable    = "bodies"
+	ancientReceiptTable = "receipts"
+)
+
+var (
+	ancientBodyMeter      = metrics.NewRegisteredMeter("BHE/ancient/bodies", nil)    // Ancient bodies served
+	ancientReceiptMeter   = metrics.NewRegisteredMeter("BHE/ancient/receipts", nil)  // Ancient receipt sets served
+	ancientThrottledMeter = metrics.NewRegisteredMeter("BHE/ancient/throttled", nil) // Requests cut short by the rate limits
+)
+
+// AncientServeConfig contains the settings of serving ancient chain data to
+// remote peers straight from the freezer.
+type AncientServeConfig struct {
+	Enabled       bool    // Advertise and serve ancient data as an archive peer
+	BodyRate      float64 // Ancient bodies served per second to a peer
+	ReceiptRate   float64 // Ancient receipt sets served per second to a peer
+	Burst         int     // Ancient items served to a peer in a burst
+	PreferArchive bool    // Prefer archive peers when syncing history
+}
+
+// DefaultAncientServeConfig contains the default ancient serving settings.
+var DefaultAncientServeConfig = AncientServeConfig{
+	BodyRate:    256,
+	ReceiptRate: 256,
+	Burst:       1024,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *AncientServeConfig) sanitize() AncientServeConfig {
+	conf := *config
+	if conf.BodyRate <= 0 {
+		log.Warn("Sanitizing invalid ancient body serving rate", "provided", conf.BodyRate, "updated", DefaultAncientServeConfig.BodyRate)
+		conf.BodyRate = DefaultAncientServeConfig.BodyRate
+	}
+	if conf.ReceiptRate <= 0 {
+		log.Warn("Sanitizing invalid ancient receipt serving rate", "provided", conf.ReceiptRate, "updated", DefaultAncientServeConfig.ReceiptRate)
+		conf.ReceiptRate = DefaultAncientServeConfig.ReceiptRate
+	}
+	if conf.Burst < 1 {
+		log.Warn("Sanitizing invalid ancient serving burst", "provided", conf.Burst, "updated", DefaultAncientServeConfig.Burst)
+		conf.Burst = DefaultAncientServeConfig.Burst
+	}
+	return conf
+}
+
+// ancientServer answers the body and receipt requests of remote peers for blocks
+// already moved into the freezer. Those are served as stored, without decoding
+// and in separately rate limited streams, so deep historical backfill of syncing
+// peers doesn't compete with the serving of recent data. The protocol handler
+// advertises archive capability in the status handshake and routes requests
+// for ancient blocks here.
+type ancientServer struct {
+	config   AncientServeConfig
+	db       BHEdb.Database
+	bodies   *rateLimiter
+	receipts *rateLimiter
+
+	archives map[string]struct{} // Remote peers advertising archive capability
+	lock     sync.RWMutex
+}
+
+// newAncientServer creates an ancient data server on top of the chain database.
+func newAncientServer(config AncientServeConfig, db BHEdb.Database) *ancientServer {
+	config = config.sanitize()
+	return &ancientServer{
+		config:   config,
+		db:       db,
+		bodies:   newRateLimiter(config.BodyRate, config.Burst),
+		receipts: newRateLimiter(config.ReceiptRate, config.Burst),
+		archives: make(map[string]struct{}),
+	}
+}
+
+// advertised returns whether the node announces itself as an archive peer in
+// the status handshake, which it only does if ancient data is actually held.
+func (a *ancientServer) advertised() bool {
+	if !a.config.Enabled {
+		return false
+	}
+	frozen, err := a.db.Ancients()
+	return err == nil && frozen > 0
+}
+
+// lookup returns the number of an ancient block by hash, verifying the freezer
+// holds that very block.
+func (a *ancientServer) lookup(hash common.Hash) (uint64, bool) {
+	number := rawdb.ReadHeaderNumber(a.db, hash)
+	if number == nil {
+		return 0, false
+	}
+	if ok, err := a.db.HasAncient(ancientHashTable, *number); err != nil || !ok {
+		return 0, false
+	}
+	stored, err := a.db.Ancient(ancientHashTable, *number)
+	if err != nil || !bytes.Equal(stored, hash.Bytes()) {
+		return 0, false
+	}
+	return *number, true
+}
+
+// serve retrieves the items of the given ancient blocks from a freezer table,
+// converting them into their wire encoding if needed. Serving stops at the first
+// block not in the freezer, leaving it to the recent data path, or once the peer
+// runs out of allowance. The number of requested hashes handled is returned
+// along with the RLP encoded items.
+func (a *ancientServer) serve(peer string, hashes []common.Hash, table string, limiter *rateLimiter, convert func([]byte) (rlp.RawValue, error)) ([]rlp.RawValue, int) {
+	if !a.config.Enabled {
+		return nil, 0
+	}
+	var (
+		items []rlp.RawValue
+		now   = time.Now()
+	)
+	for i, hash := range hashes {
+		number, ok := a.lookup(hash)
+		if !ok {
+			return items, i
+		}
+		if !limiter.allow(peer, now) {
+			ancientThrottledMeter.Mark(1)
+			return items, i
+		}
+		blob, err := a.db.Ancient(table, number)
+		if err != nil {
+			return items, i
+		}
+		if convert != nil {
+			if blob, err = convert(blob); err != nil {
+				log.Warn("Failed to convert ancient data", "table", table, "number", number, "err", err)
+				return items, i
+			}
+		}
+		items = append(items, blob)
+	}
+	return items, len(hashes)
+}
+
+// serveBodies retrieves ancient block bodies for a remote peer.
+func (a *ancientServer) serveBodies(peer string, hashes []common.Hash) ([]rlp.RawValue, int) {
+	bodies, n := a.serve(peer, hashes, ancientBodyTable, a.bodies, nil)
+	ancientBodyMeter.Mark(int64(len(bodies)))
+	return bodies, n
+}
+
+// serveReceipts retrieves ancient receipt sets for a remote peer. The freezer
+// holds them in the storage encoding lacking the blooms, so those are recomputed
+// to send the consensus encoding.
+func (a *ancientServer) serveReceipts(peer string, hashes []common.Hash) ([]rlp.RawValue, int) {
+	receipts, n := a.serve(peer, hashes, ancientReceiptTable, a.receipts, func(blob []byte) (rlp.RawValue, error) {
+		var stored []*types.ReceiptForStorage
+		if err := rlp.DecodeBytes(blob, &stored); err != nil {
+			return nil, err
+		}
+		receipts := make(types.Receipts, len(stored))
+		for i, receipt := range stored {
+			receipts[i] = (*types.Receipt)(receipt)
+			receipts[i].Bloom = types.CreateBloom(types.Receipts{receipts[i]})
+		}
+		return rlp.EncodeToBytes(receipts)
+	})
+	ancientReceiptMeter.Mark(int64(len(receipts)))
+	return receipts, n
+}
+
+// registerPeer records the archive advertisement of a remote peer after the
+// handshake.
+func (a *ancientServer) registerPeer(id string, archive bool) {
+	if !archive {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.archives[id] = struct{}{}
+}
+
+// unregisterPeer forgets a disconnected peer.
+func (a *ancientServer) unregisterPeer(id string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	delete(a.archives, id)
+}
+
+// preferred returns whether the peer should be picked first when retrieving
+// historical chain data.
+func (a *ancientServer) preferred(id string) bool {
+	if !a.config.PreferArchive {
+		return false
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	_, ok := a.archives[id]
+	return ok
+}
+
+// AncientServingStatus is the state of ancient data serving.
+type AncientServingStatus struct {
+	Serving  bool           `json:"serving"`  // Whether ancient data is advertised and served
+	Frozen   hexutil.Uint64 `json:"frozen"`   // Number of blocks in the freezer
+	Archives []string       `json:"archives"` // Connected peers advertising archive capability
+}
+
+// AncientServing returns whether ancient data is served to peers and which
+// connected peers serve it in turn.
+func (api *PrivateAdminAPI) AncientServing() (*AncientServingStatus, error) {
+	frozen, err := api.BHE.chainDb.Ancients()
+	if err != nil {
+		return nil, err
+	}
+	status := &AncientServingStatus{
+		Serving:  api.BHE.ancients.advertised(),
+		Frozen:   hexutil.Uint64(frozen),
+		Archives: []string{},
+	}
+	api.BHE.ancients.lock.RLock()
+	for id := range api.BHE.ancients.archives {
+		status.Archives = append(status.Archives, id)
+	}
+	api.BHE.ancients.lock.RUnlock()
+
+	sort.Strings(status.Archives)
+	return status, nil
+}
@@ -49,6 +49,13 @@ func (api *PublicBHEereumAPI) ChainId() hexutil.Uint64 {
 	return (hexutil.Uint64)(chainID.Uint64())
 }
 
+// GetStorageRangeAt enumerates the storage slots of a contract at the end of the
+// given block. It is an alias of debug_storageRange for tooling expecting it in
+// the main namespace.
+func (api *PublicBHEereumAPI) GetStorageRangeAt(blockNrOrHash rpc.BlockNumberOrHash, contractAddress common.Address, keyStart hexutil.Bytes, maxResults int) (StorageRangeResult, error) {
+	return NewPublicDebugAPI(api.e).StorageRange(blockNrOrHash, contractAddress, keyStart, maxResults)
+}
+
 // PublicMinerAPI provides an API to control the miner.
 // It offers only mBHEods that operate on data that pose no security risk when it is publicly accessible.
 type PublicMinerAPI struct {
@@ -96,6 +103,12 @@ func (api *PrivateMinerAPI) Stop() {
 
 // SetExtra sets the extra data string that is included when this miner mines a block.
 func (api *PrivateMinerAPI) SetExtra(extra string) (bool, error) {
+	if err := api.e.writable(); err != nil {
+		return false, err
+	}
+	if err := checkExtraData(api.e.blockchain.Config(), []byte(extra)); err != nil {
+		return false, err
+	}
 	if err := api.e.Miner().SetExtra([]byte(extra)); err != nil {
 		return false, err
 	}
@@ -104,6 +117,9 @@ func (api *PrivateMinerAPI) SetExtra(extra string) (bool, error) {
 
 // SetGasPrice sets the minimum accepted gas price for the miner.
 func (api *PrivateMinerAPI) SetGasPrice(gasPrice hexutil.Big) bool {
+	if api.e.writable() != nil {
+		return false
+	}
 	api.e.lock.Lock()
 	api.e.gasPrice = (*big.Int)(&gasPrice)
 	api.e.lock.Unlock()
@@ -114,13 +130,21 @@ func (api *PrivateMinerAPI) SetGasPrice(gasPrice hexutil.Big) bool {
 
 // SetBHEerbase sets the BHEerbase of the miner
 func (api *PrivateMinerAPI) SetBHEerbase(BHEerbase common.Address) bool {
+	if api.e.writable() != nil {
+		return false
+	}
 	api.e.SetBHEerbase(BHEerbase)
 	return true
 }
 
 // SetRecommitInterval updates the interval for miner sealing work recommitting.
 func (api *PrivateMinerAPI) SetRecommitInterval(interval int) {
-	api.e.Miner().SetRecommitInterval(time.Duration(interval) * time.Millisecond)
+	api.e.updateMinerTiming(func(timing *minerTiming) {
+		timing.recommit = time.Duration(interval) * time.Millisecond
+		if timing.recommit < minRecommitInterval {
+			timing.recommit = minRecommitInterval
+		}
+	})
 }
 
 // GBHEashrate returns the current hashrate of the miner.
@@ -191,6 +215,9 @@ func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
 
 // ImportChain imports a blockchain from a local file.
 func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
+	if err := api.BHE.writable(); err != nil {
+		return false, err
+	}
 	// Make sure the can access the file to import
 	in, err := os.Open(file)
 	if err != nil {
@@ -420,6 +447,99 @@ func storageRangeAt(st state.Trie, start []byte, maxResult int) (StorageRangeRes
 	return result, nil
 }
 
+// StorageRangeMaxResults is the maximum number of storage slots to be returned
+// per StorageRange call.
+const StorageRangeMaxResults = 1024
+
+// StorageRange enumerates the storage slots of a contract at the end of the
+// given block, starting at the given hashed slot key. If the flat state snapshot
+// covers the block it is used instead of the storage trie.
+func (api *PublicDebugAPI) StorageRange(blockNrOrHash rpc.BlockNumberOrHash, contractAddress common.Address, keyStart hexutil.Bytes, maxResults int) (StorageRangeResult, error) {
+	if maxResults > StorageRangeMaxResults || maxResults <= 0 {
+		maxResults = StorageRangeMaxResults
+	}
+	var block *types.Block
+	if number, ok := blockNrOrHash.Number(); ok {
+		switch number {
+		case rpc.PendingBlockNumber:
+			return StorageRangeResult{}, errors.New("pending storage ranges not supported")
+		case rpc.LatestBlockNumber:
+			block = api.BHE.blockchain.CurrentBlock()
+		default:
+			block = api.BHE.blockchain.GetBlockByNumber(uint64(number))
+		}
+		if block == nil {
+			return StorageRangeResult{}, fmt.Errorf("block #%d not found", number)
+		}
+	} else if hash, ok := blockNrOrHash.Hash(); ok {
+		if block = api.BHE.blockchain.GetBlockByHash(hash); block == nil {
+			return StorageRangeResult{}, fmt.Errorf("block %s not found", hash.Hex())
+		}
+	} else {
+		return StorageRangeResult{}, errors.New("invalid arguments; neither block nor hash specified")
+	}
+	// Serve from the snapshot if it's available for the requested root
+	if snaps := api.BHE.blockchain.Snapshot(); snaps != nil {
+		if result, err := snapshotStorageRange(api.BHE.ChainDb(), snaps, block.Root(), contractAddress, keyStart, maxResults); err == nil {
+			return result, nil
+		}
+	}
+	statedb, err := api.BHE.BlockChain().StateAt(block.Root())
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	st := statedb.StorageTrie(contractAddress)
+	if st == nil {
+		return StorageRangeResult{}, fmt.Errorf("account %x doesn't exist", contractAddress)
+	}
+	return storageRangeAt(st, keyStart, maxResults)
+}
+
+// snapshotStorageRange is the flat snapshot backed counterpart of storageRangeAt.
+// Preimages of the slot keys are resolved from the database, if recorded.
+func snapshotStorageRange(db BHEdb.Database, snaps *snapshot.Tree, root common.Hash, contractAddress common.Address, start []byte, maxResult int) (StorageRangeResult, error) {
+	accHash := crypto.Keccak256Hash(contractAddress.Bytes())
+
+	layer := snaps.Snapshot(root)
+	if layer == nil {
+		return StorageRangeResult{}, fmt.Errorf("snapshot %x not available", root)
+	}
+	account, err := layer.Account(accHash)
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	if account == nil {
+		return StorageRangeResult{}, fmt.Errorf("account %x doesn't exist", contractAddress)
+	}
+	it, err := snaps.StorageIterator(root, accHash, common.BytesToHash(start))
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	defer it.Release()
+
+	result := StorageRangeResult{Storage: storageMap{}}
+	for i := 0; i < maxResult && it.Next(); i++ {
+		_, content, _, err := rlp.Split(it.Slot())
+		if err != nil {
+			return StorageRangeResult{}, err
+		}
+		e := storageEntry{Value: common.BytesToHash(content)}
+		if preimage := rawdb.ReadPreimage(db, it.Hash()); preimage != nil {
+			preimage := common.BytesToHash(preimage)
+			e.Key = &preimage
+		}
+		result.Storage[it.Hash()] = e
+	}
+	if it.Next() {
+		next := it.Hash()
+		result.NextKey = &next
+	}
+	if err := it.Error(); err != nil {
+		return StorageRangeResult{}, err
+	}
+	return result, nil
+}
+
 // GetModifiedAccountsByNumber returns all accounts that have changed between the
 // two blocks specified. A change is defined as a difference in nonce, balance,
 // code hash, or storage hash.
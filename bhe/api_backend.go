@@ -27,6 +27,11 @@ type BHEAPIBackend struct {
 	extRPCEnabled bool
 	BHE           *BHEereum
 	gpo           *gasprice.Oracle
+	snapReads     *snapReader
+	evms          *evmLimiter
+	calls         *callCache
+	cache         *chainCache
+	tracer        SpanTracer // Tracer of the backend operations, nil if disabled
 }
 
 // ChainConfig returns the active chain configuration.
@@ -39,6 +44,10 @@ func (b *BHEAPIBackend) CurrentBlock() *types.Block {
 }
 
 func (b *BHEAPIBackend) SBHEead(number uint64) {
+	if err := b.BHE.writable(); err != nil {
+		log.Warn("Refusing to rewind chain head", "number", number, "err", err)
+		return
+	}
 	b.BHE.protocolManager.downloader.Cancel()
 	b.BHE.blockchain.SBHEead(number)
 }
@@ -53,6 +62,9 @@ func (b *BHEAPIBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumb
 	if number == rpc.LatestBlockNumber {
 		return b.BHE.blockchain.CurrentBlock().Header(), nil
 	}
+	if b.cache != nil {
+		return b.cache.headerByNumber(uint64(number)), nil
+	}
 	return b.BHE.blockchain.GBHEeaderByNumber(uint64(number)), nil
 }
 
@@ -74,6 +86,9 @@ func (b *BHEAPIBackend) HeaderByNumberOrHash(ctx context.Context, blockNrOrHash
 }
 
 func (b *BHEAPIBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	if b.cache != nil {
+		return b.cache.headerByHash(hash), nil
+	}
 	return b.BHE.blockchain.GBHEeaderByHash(hash), nil
 }
 
@@ -87,10 +102,16 @@ func (b *BHEAPIBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumbe
 	if number == rpc.LatestBlockNumber {
 		return b.BHE.blockchain.CurrentBlock(), nil
 	}
+	if b.cache != nil {
+		return b.cache.blockByNumber(uint64(number)), nil
+	}
 	return b.BHE.blockchain.GetBlockByNumber(uint64(number)), nil
 }
 
 func (b *BHEAPIBackend) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	if b.cache != nil {
+		return b.cache.blockByHash(hash), nil
+	}
 	return b.BHE.blockchain.GetBlockByHash(hash), nil
 }
 
@@ -129,7 +150,7 @@ func (b *BHEAPIBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.B
 	if header == nil {
 		return nil, nil, errors.New("header not found")
 	}
-	stateDb, err := b.BHE.BlockChain().StateAt(header.Root)
+	stateDb, err := b.stateAt(ctx, header.Root)
 	return stateDb, header, err
 }
 
@@ -148,18 +169,35 @@ func (b *BHEAPIBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockN
 		if blockNrOrHash.RequireCanonical && b.BHE.blockchain.GetCanonicalHash(header.Number.Uint64()) != hash {
 			return nil, nil, errors.New("hash is not currently canonical")
 		}
-		stateDb, err := b.BHE.BlockChain().StateAt(header.Root)
+		stateDb, err := b.stateAt(ctx, header.Root)
 		return stateDb, header, err
 	}
 	return nil, nil, errors.New("invalid arguments; neither block nor hash specified")
 }
 
-func (b *BHEAPIBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+// stateAt returns the state database rooted at the given hash.
+func (b *BHEAPIBackend) stateAt(ctx context.Context, root common.Hash) (stateDb *state.StateDB, err error) {
+	_, span := b.startSpan(ctx, "BHE.stateAt", "root", root)
+	defer finishSpan(span, &err)
+
+	return b.BHE.BlockChain().StateAt(root)
+}
+
+func (b *BHEAPIBackend) GetReceipts(ctx context.Context, hash common.Hash) (receipts types.Receipts, err error) {
+	_, span := b.startSpan(ctx, "BHE.getReceipts", "hash", hash)
+	defer finishSpan(span, &err)
+
+	if err := b.BHE.receiptsPruned(hash); err != nil {
+		return nil, err
+	}
+	if b.cache != nil {
+		return b.cache.receiptsByHash(hash), nil
+	}
 	return b.BHE.blockchain.GetReceiptsByHash(hash), nil
 }
 
 func (b *BHEAPIBackend) GetLogs(ctx context.Context, hash common.Hash) ([][]*types.Log, error) {
-	receipts := b.BHE.blockchain.GetReceiptsByHash(hash)
+	receipts, _ := b.GetReceipts(ctx, hash)
 	if receipts == nil {
 		return nil, nil
 	}
@@ -175,14 +213,31 @@ func (b *BHEAPIBackend) GetTd(blockHash common.Hash) *big.Int {
 }
 
 func (b *BHEAPIBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header) (*vm.EVM, func() error, error) {
-	vmError := func() error { return nil }
-
+	if !b.BHE.drainer.enter() {
+		return nil, nil, errShuttingDown
+	}
+	// Trace the execution from waiting for a slot until the caller is done
+	_, span := b.startSpan(ctx, "BHE.evm", "block", header.Number, "gas", msg.Gas())
+	if err := b.evms.acquire(ctx); err != nil {
+		span.RecordError(err)
+		span.End()
+		b.BHE.drainer.exit()
+		return nil, nil, err
+	}
 	context := core.NewEVMContext(msg, header, b.BHE.BlockChain(), nil)
-	return vm.NewEVM(context, state, b.BHE.blockchain.Config(), *b.BHE.blockchain.GetVMConfig()), vmError, nil
+	evm := vm.NewEVM(context, state, b.BHE.blockchain.Config(), *b.BHE.blockchain.GetVMConfig())
+
+	// Abort executions running over the time limit and free up the execution
+	// slot once the caller is done with the EVM (signalled by cancelling ctx)
+	done := func() {
+		span.End()
+		b.BHE.drainer.exit()
+	}
+	return evm, b.evms.watch(ctx, evm, done), nil
 }
 
 func (b *BHEAPIBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
-	return b.BHE.BlockChain().SubscribeRemovedLogsEvent(ch)
+	return b.BHE.events.SubscribeRemovedLogsEvent(ch)
 }
 
 func (b *BHEAPIBackend) SubscribePendingLogsEvent(ch chan<- []*types.Log) event.Subscription {
@@ -190,11 +245,11 @@ func (b *BHEAPIBackend) SubscribePendingLogsEvent(ch chan<- []*types.Log) event.
 }
 
 func (b *BHEAPIBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
-	return b.BHE.BlockChain().SubscribeChainEvent(ch)
+	return b.BHE.events.SubscribeChainEvent(ch)
 }
 
 func (b *BHEAPIBackend) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
-	return b.BHE.BlockChain().SubscribeChainHeadEvent(ch)
+	return b.BHE.events.SubscribeChainHeadEvent(ch)
 }
 
 func (b *BHEAPIBackend) SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription {
@@ -202,10 +257,19 @@ func (b *BHEAPIBackend) SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) e
 }
 
 func (b *BHEAPIBackend) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
-	return b.BHE.BlockChain().SubscribeLogsEvent(ch)
+	return b.BHE.events.SubscribeLogsEvent(ch)
 }
 
-func (b *BHEAPIBackend) SendTx(ctx context.Context, signedTx *types.Transaction) error {
+func (b *BHEAPIBackend) SendTx(ctx context.Context, signedTx *types.Transaction) (err error) {
+	ctx, span := b.startSpan(ctx, "BHE.sendTx", "hash", signedTx.Hash())
+	defer finishSpan(span, &err)
+
+	if err := b.BHE.writable(); err != nil {
+		return err
+	}
+	if err := b.BHE.admitTx(ctx, signedTx, true); err != nil {
+		return err
+	}
 	return b.BHE.txPool.AddLocal(signedTx)
 }
 
@@ -275,6 +339,9 @@ func (b *BHEAPIBackend) ExtRPCEnabled() bool {
 }
 
 func (b *BHEAPIBackend) RPCGasCap() *big.Int {
+	b.BHE.lock.RLock()
+	defer b.BHE.lock.RUnlock()
+
 	return b.BHE.config.RPCGasCap
 }
 
@@ -284,7 +351,5 @@ func (b *BHEAPIBackend) BloomStatus() (uint64, uint64) {
 }
 
 func (b *BHEAPIBackend) ServiceFilter(ctx context.Context, session *bloombits.MatcherSession) {
-	for i := 0; i < bloomFilterThreads; i++ {
-		go session.Multiplex(bloomRetrievalBatch, bloomRetrievalWait, b.BHE.bloomRequests)
-	}
+	b.BHE.bloomService.serve(ctx, session, b.BHE.bloomRequests, b.BHE.closeBloomHandler)
 }
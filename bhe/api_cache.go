@@ -0,0 +1,143 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+// chainCache is a read-through cache of recently requested headers, blocks and
+// receipts in front of the database. Items are keyed by hash and never change,
+// only the canonical number to hash index is invalidated when a reorg happens.
+type chainCache struct {
+	chain *core.BlockChain
+
+	numbers  *lru.Cache // Canonical number -> hash
+	headers  *lru.Cache // Hash -> *types.Header
+	blocks   *lru.Cache // Hash -> *types.Block
+	receipts *lru.Cache // Hash -> types.Receipts
+}
+
+// newChainCache creates a cache of the given size per item kind, tracking the
+// canonical chain through the events of the sequencer. It returns nil if size
+// is not positive.
+func newChainCache(chain *core.BlockChain, events *eventSequencer, size int) *chainCache {
+	if size <= 0 {
+		return nil
+	}
+	c := &chainCache{chain: chain}
+	c.numbers, _ = lru.New(size)
+	c.headers, _ = lru.New(size)
+	c.blocks, _ = lru.New(size)
+	c.receipts, _ = lru.New(size)
+
+	var (
+		chainCh = make(chan core.ChainEvent, 16)
+		headCh  = make(chan core.ChainHeadEvent, 16)
+	)
+	chainSub := events.SubscribeChainEvent(chainCh)
+	headSub := events.SubscribeChainHeadEvent(headCh)
+
+	go func() {
+		defer chainSub.Unsubscribe()
+		defer headSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-chainCh:
+				// New canonical block, overwriting any reorged mapping
+				c.numbers.Add(ev.Block.NumberU64(), ev.Hash)
+
+			case ev := <-headCh:
+				// Drop mappings above the head left over by a rewind
+				head := ev.Block.NumberU64()
+				for _, key := range c.numbers.Keys() {
+					if key.(uint64) > head {
+						c.numbers.Remove(key)
+					}
+				}
+			case <-chainSub.Err():
+				return
+			case <-headSub.Err():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// hash returns the canonical hash of a block number.
+func (c *chainCache) hash(number uint64) common.Hash {
+	if hash, ok := c.numbers.Get(number); ok {
+		return hash.(common.Hash)
+	}
+	hash := c.chain.GetCanonicalHash(number)
+	if hash != (common.Hash{}) {
+		c.numbers.Add(number, hash)
+	}
+	return hash
+}
+
+// headerByNumber returns the canonical header with the given number.
+func (c *chainCache) headerByNumber(number uint64) *types.Header {
+	hash := c.hash(number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return c.headerByHash(hash)
+}
+
+// headerByHash returns the header with the given hash.
+func (c *chainCache) headerByHash(hash common.Hash) *types.Header {
+	if header, ok := c.headers.Get(hash); ok {
+		return header.(*types.Header)
+	}
+	header := c.chain.GBHEeaderByHash(hash)
+	if header != nil {
+		c.headers.Add(hash, header)
+	}
+	return header
+}
+
+// blockByNumber returns the canonical block with the given number.
+func (c *chainCache) blockByNumber(number uint64) *types.Block {
+	hash := c.hash(number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return c.blockByHash(hash)
+}
+
+// blockByHash returns the block with the given hash.
+func (c *chainCache) blockByHash(hash common.Hash) *types.Block {
+	if block, ok := c.blocks.Get(hash); ok {
+		return block.(*types.Block)
+	}
+	block := c.chain.GetBlockByHash(hash)
+	if block != nil {
+		c.blocks.Add(hash, block)
+	}
+	return block
+}
+
+// receiptsByHash returns the receipts of the block with the given hash.
+func (c *chainCache) receiptsByHash(hash common.Hash) types.Receipts {
+	if receipts, ok := c.receipts.Get(hash); ok {
+		return receipts.(types.Receipts)
+	}
+	receipts := c.chain.GetReceiptsByHash(hash)
+	if receipts != nil {
+		c.receipts.Add(hash, receipts)
+	}
+	return receipts
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// callCache is an LRU cache of EVM call results (BHE_call, BHE_estimateGas)
+// keyed by the method, the block the call was executed on and its arguments.
+// It is flushed whenever the chain head changes, so polling clients hammering
+// the same calls are served from memory until a new block arrives.
+type callCache struct {
+	cache *lru.Cache
+	head  common.Hash // Chain head the cached results were computed at
+	lock  sync.Mutex
+}
+
+// newCallCache creates a call result cache, or nil if size is not positive.
+func newCallCache(size int) *callCache {
+	if size <= 0 {
+		return nil
+	}
+	cache, _ := lru.New(size)
+	return &callCache{cache: cache}
+}
+
+// key derives the cache key of a call, or false if the arguments can't be
+// encoded deterministically.
+func (c *callCache) key(method string, block common.Hash, args []interface{}) (common.Hash, bool) {
+	blob, err := json.Marshal(args)
+	if err != nil {
+		return common.Hash{}, false
+	}
+	return crypto.Keccak256Hash([]byte(method), block.Bytes(), blob), true
+}
+
+// sync flushes the cache if the chain head moved since the results were cached.
+func (c *callCache) sync(head common.Hash) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.head != head {
+		c.cache.Purge()
+		c.head = head
+	}
+}
+
+// CachedCall serves the result of an EVM call from the call cache if the same
+// method was invoked with the same arguments on the same block since the last
+// head change, otherwise it runs the call and caches its successful result.
+// Calls on the pending block are never cached. If the cache is disabled the
+// call is always run.
+func (b *BHEAPIBackend) CachedCall(ctx context.Context, method string, blockNrOrHash rpc.BlockNumberOrHash, args []interface{}, call func() (interface{}, error)) (interface{}, error) {
+	if b.calls == nil {
+		return call()
+	}
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		return call()
+	}
+	header, err := b.HeaderByNumberOrHash(ctx, blockNrOrHash)
+	if header == nil || err != nil {
+		return call()
+	}
+	key, ok := b.calls.key(method, header.Hash(), args)
+	if !ok {
+		return call()
+	}
+	b.calls.sync(b.BHE.blockchain.CurrentHeader().Hash())
+	if result, ok := b.calls.cache.Get(key); ok {
+		return result, nil
+	}
+	result, err := call()
+	if err != nil {
+		return nil, err
+	}
+	b.calls.cache.Add(key, result)
+	return result, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// DumpConfig holds extra parameters to the streaming state dump functions.
+type DumpConfig struct {
+	NoCode      bool `json:"noCode"`      // Omit contract code from the dump
+	NoStorage   bool `json:"noStorage"`   // Omit contract storage from the dump
+	Incompletes bool `json:"incompletes"` // Include accounts without a known address preimage
+	ChunkSize   int  `json:"chunkSize"`   // Accounts per streamed chunk (subscriptions only)
+}
+
+// dumpChunk is a single batch of accounts streamed to a dump subscriber.
+type dumpChunk struct {
+	Root     common.Hash                          `json:"root"`
+	Accounts map[common.Address]state.DumpAccount `json:"accounts"`
+	Next     hexutil.Bytes                        `json:"next,omitempty"` // Empty on the last chunk
+}
+
+// dumpState resolves the state database of the given block for dumping.
+func dumpState(BHE *BHEereum, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, error) {
+	var block *types.Block
+	if number, ok := blockNrOrHash.Number(); ok {
+		switch number {
+		case rpc.PendingBlockNumber:
+			_, stateDb := BHE.miner.Pending()
+			return stateDb, nil
+		case rpc.LatestBlockNumber:
+			block = BHE.blockchain.CurrentBlock()
+		default:
+			block = BHE.blockchain.GetBlockByNumber(uint64(number))
+		}
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+	} else if hash, ok := blockNrOrHash.Hash(); ok {
+		if block = BHE.blockchain.GetBlockByHash(hash); block == nil {
+			return nil, fmt.Errorf("block %s not found", hash.Hex())
+		}
+	} else {
+		return nil, errors.New("invalid arguments; neither block nor hash specified")
+	}
+	return BHE.BlockChain().StateAt(block.Root())
+}
+
+// DumpBlockStream streams the entire state of the given block to the subscriber
+// in chunks of accounts, without ever holding the full dump in memory. The
+// subscription ends after the chunk with an empty next key.
+func (api *PublicDebugAPI) DumpBlockStream(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, config *DumpConfig) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	if config == nil {
+		config = new(DumpConfig)
+	}
+	chunk := config.ChunkSize
+	if chunk > AccountRangeMaxResults || chunk <= 0 {
+		chunk = AccountRangeMaxResults
+	}
+	stateDb, err := dumpState(api.BHE, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		var (
+			start    = time.Now()
+			root     = stateDb.IntermediateRoot(false)
+			next     []byte
+			accounts int
+		)
+		for {
+			select {
+			case <-notifier.Closed():
+				log.Debug("State dump stream aborted", "root", root, "accounts", accounts, "elapsed", time.Since(start))
+				return
+			default:
+			}
+			dump := stateDb.IteratorDump(config.NoCode, config.NoStorage, !config.Incompletes, next, chunk)
+			accounts += len(dump.Accounts)
+
+			if err := notifier.Notify(sub.ID, &dumpChunk{Root: root, Accounts: dump.Accounts, Next: dump.Next}); err != nil {
+				log.Debug("State dump stream failed", "root", root, "err", err)
+				return
+			}
+			if len(dump.Next) == 0 {
+				log.Info("State dump streamed", "root", root, "accounts", accounts, "elapsed", common.PrettyDuration(time.Since(start)))
+				return
+			}
+			next = dump.Next
+		}
+	}()
+	return sub, nil
+}
+
+// DumpBlockToFile writes the entire state of the given block into a local file
+// as a stream of JSON objects, one account per line. Files ending in .gz are
+// compressed. The dump is never built in memory, so it is suitable for deriving
+// the genesis of a fork from a large live state.
+func (api *PrivateDebugAPI) DumpBlockToFile(blockNrOrHash rpc.BlockNumberOrHash, file string, config *DumpConfig) (bool, error) {
+	if config == nil {
+		config = new(DumpConfig)
+	}
+	if _, err := os.Stat(file); err == nil {
+		// File already exists. Allowing overwrite could be a DoS vector,
+		// since the 'file' may point to arbitrary paths on the drive
+		return false, errors.New("location would overwrite an existing file")
+	}
+	stateDb, err := dumpState(api.BHE, blockNrOrHash)
+	if err != nil {
+		return false, err
+	}
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+
+	buffered := bufio.NewWriter(out)
+	defer buffered.Flush()
+
+	var writer io.Writer = buffered
+	if strings.HasSuffix(file, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	start := time.Now()
+	stateDb.IterativeDump(config.NoCode, config.NoStorage, !config.Incompletes, json.NewEncoder(writer))
+
+	log.Info("State dumped to file", "file", file, "elapsed", common.PrettyDuration(time.Since(start)))
+	return true, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// EstimateGas binary searches the lowest gas limit a call succeeds with. All
+// attempts run on the same state, reverted to a snapshot after each of them,
+// instead of reloading the state of the block for every iteration.
+//
+// The search is bounded by the gas limit of the call (or the block), the gas cap
+// hint of the caller, the BHE_estimateGas gas cap and the funds of the sender.
+// The upper bound is tried first, so a call that can't succeed fails right away
+// with the revert reason and data of the execution.
+func (b *BHEAPIBackend) EstimateGas(ctx context.Context, args BHEapi.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, gasCap *hexutil.Uint64) (hexutil.Uint64, error) {
+	statedb, header, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if statedb == nil || err != nil {
+		return 0, err
+	}
+	var (
+		lo        = params.TxGas - 1
+		hi        = header.GasLimit
+		globalCap = b.MethodGasCap("BHE_estimateGas")
+	)
+	if args.Gas != nil && uint64(*args.Gas) >= params.TxGas {
+		hi = uint64(*args.Gas)
+	}
+	if gasCap != nil && uint64(*gasCap) >= params.TxGas && uint64(*gasCap) < hi {
+		hi = uint64(*gasCap)
+	}
+	if globalCap != nil && globalCap.IsUint64() && hi > globalCap.Uint64() {
+		log.Debug("Caller gas above allowance, capping", "requested", hi, "cap", globalCap)
+		hi = globalCap.Uint64()
+	}
+	if args.From == nil {
+		args.From = new(common.Address)
+	}
+	// Cap the search at what the sender can actually pay for
+	if args.GasPrice != nil && args.GasPrice.ToInt().Sign() != 0 {
+		available := new(big.Int).Set(statedb.GetBalance(*args.From))
+		if args.Value != nil {
+			if args.Value.ToInt().Cmp(available) >= 0 {
+				return 0, errors.New("insufficient funds for transfer")
+			}
+			available.Sub(available, args.Value.ToInt())
+		}
+		allowance := new(big.Int).Div(available, args.GasPrice.ToInt())
+		if allowance.IsUint64() && hi > allowance.Uint64() {
+			log.Debug("Gas estimation capped by limited funds", "original", hi, "balance", available, "gasprice", args.GasPrice.ToInt())
+			hi = allowance.Uint64()
+		}
+	}
+	limit := hi
+
+	// Create a helper to check if a gas allowance results in an executable transaction
+	executable := func(gas uint64) (bool, *core.ExecutionResult, error) {
+		args.Gas = (*hexutil.Uint64)(&gas)
+		msg := args.ToMessage(globalCap)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		evm, vmError, err := b.GetEVM(ctx, msg, statedb, header)
+		if err != nil {
+			return true, nil, err
+		}
+		snapshot := statedb.Snapshot()
+		result, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+		statedb.RevertToSnapshot(snapshot)
+
+		if err := vmError(); err != nil {
+			return true, nil, err
+		}
+		if err != nil {
+			if errors.Is(err, core.ErrIntrinsicGas) {
+				return true, nil, nil // Special case, raise gas limit
+			}
+			return true, nil, err // Bail out
+		}
+		return result.Failed(), result, nil
+	}
+	// Reject the call if it fails even with the highest allowance
+	failed, result, err := executable(limit)
+	if err != nil {
+		return 0, err
+	}
+	if failed {
+		if result != nil && result.Err != vm.ErrOutOfGas {
+			if len(result.Revert()) > 0 {
+				return 0, newRevertError(result)
+			}
+			return 0, result.Err
+		}
+		return 0, fmt.Errorf("gas required exceeds allowance (%d)", limit)
+	}
+	// Execute the binary search and hone in on an executable gas limit
+	for lo+1 < hi {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		mid := (hi + lo) / 2
+		failed, _, err := executable(mid)
+		if err != nil {
+			return 0, err
+		}
+		if failed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hexutil.Uint64(hi), nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// errMissingPreimage is returned if the state of a genesis export contains keys
+// whose preimages are unknown, as such an allocation could not be reproduced.
+var errMissingPreimage = errors.New("missing preimage (node must run with --cache.preimages)")
+
+// Genesis assembles a genesis specification from the current chain config and
+// the header and full state of the given block. Starting a new network from it
+// reproduces the state of this one at that block, so test forks of the live
+// network can be spun up without reconstructing the allocation by hand.
+func (api *PrivateAdminAPI) Genesis(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*core.Genesis, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		return nil, errors.New("pending state cannot be exported")
+	}
+	block, err := api.BHE.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	alloc, err := genesisAlloc(ctx, api.BHE.BlockChain().StateCache(), block.Root())
+	if err != nil {
+		return nil, err
+	}
+	header := block.Header()
+	return &core.Genesis{
+		Config:     api.BHE.BlockChain().Config(),
+		Nonce:      header.Nonce.Uint64(),
+		Timestamp:  header.Time,
+		ExtraData:  header.Extra,
+		GasLimit:   header.GasLimit,
+		Difficulty: header.Difficulty,
+		Mixhash:    header.MixDigest,
+		Coinbase:   header.Coinbase,
+		Alloc:      alloc,
+	}, nil
+}
+
+// ExportGenesis writes the genesis specification of the given block (see
+// Genesis) into a local JSON file.
+func (api *PrivateAdminAPI) ExportGenesis(ctx context.Context, file string, blockNrOrHash rpc.BlockNumberOrHash) (bool, error) {
+	if _, err := os.Stat(file); err == nil {
+		// File already exists. Allowing overwrite could be a DoS vector,
+		// since the 'file' may point to arbitrary paths on the drive
+		return false, errors.New("location would overwrite an existing file")
+	}
+	genesis, err := api.Genesis(ctx, blockNrOrHash)
+	if err != nil {
+		return false, err
+	}
+	blob, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(file, blob, 0644); err != nil {
+		return false, err
+	}
+	log.Info("Exported genesis", "file", file, "accounts", len(genesis.Alloc), "size", common.StorageSize(len(blob)))
+	return true, nil
+}
+
+// genesisAlloc collects every account of the state rooted at root into a
+// genesis allocation. All address and slot preimages must be known.
+func genesisAlloc(ctx context.Context, db state.Database, root common.Hash) (core.GenesisAlloc, error) {
+	accTrie, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		alloc  = make(core.GenesisAlloc)
+	)
+	it := trie.NewIterator(accTrie.NodeIterator(nil))
+	for it.Next() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting genesis allocation", "root", root, "accounts", len(alloc), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		preimage := accTrie.GetKey(it.Key)
+		if preimage == nil {
+			return nil, fmt.Errorf("account %x: %v", it.Key, errMissingPreimage)
+		}
+		var data state.Account
+		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
+			return nil, fmt.Errorf("invalid account %x: %v", it.Key, err)
+		}
+		account := core.GenesisAccount{
+			Balance: data.Balance,
+			Nonce:   data.Nonce,
+		}
+		addrHash := common.BytesToHash(it.Key)
+		if !bytes.Equal(data.CodeHash, emptyCodeHash) {
+			if account.Code, err = db.ContractCode(addrHash, common.BytesToHash(data.CodeHash)); err != nil {
+				return nil, fmt.Errorf("missing code for account %x: %v", it.Key, err)
+			}
+		}
+		if data.Root != types.EmptyRootHash {
+			storage, err := db.OpenStorageTrie(addrHash, data.Root)
+			if err != nil {
+				return nil, err
+			}
+			account.Storage = make(map[common.Hash]common.Hash)
+			sit := trie.NewIterator(storage.NodeIterator(nil))
+			for sit.Next() {
+				key := storage.GetKey(sit.Key)
+				if key == nil {
+					return nil, fmt.Errorf("account %x slot %x: %v", it.Key, sit.Key, errMissingPreimage)
+				}
+				_, content, _, err := rlp.Split(sit.Value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid slot %x of account %x: %v", sit.Key, it.Key, err)
+				}
+				account.Storage[common.BytesToHash(key)] = common.BytesToHash(content)
+			}
+			if sit.Err != nil {
+				return nil, sit.Err
+			}
+		}
+		alloc[common.BytesToAddress(preimage)] = account
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	return alloc, nil
+}
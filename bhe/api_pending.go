@@ -0,0 +1,70 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"time"
+)
+
+// pendingBlockPollInterval is how often the miner's pending block is checked
+// for changes on behalf of subscribers. The miner rebuilds it on every new head
+// and transaction batch but doesn't announce it, so it has to be polled.
+const pendingBlockPollInterval = 250 * time.Millisecond
+
+// NewPendingBlocks creates a subscription that is notified of the miner's
+// pending block every time it is rebuilt. With fullTx the notification holds
+// the full transactions, otherwise only their hashes.
+func (api *PublicBHEereumAPI) NewPendingBlocks(ctx context.Context, fullTx *bool) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	full := fullTx != nil && *fullTx
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		ticker := time.NewTicker(pendingBlockPollInterval)
+		defer ticker.Stop()
+
+		var last common.Hash
+		for {
+			select {
+			case <-ticker.C:
+				block := api.e.miner.PendingBlock()
+				if block == nil || block.Hash() == last {
+					continue
+				}
+				last = block.Hash()
+
+				fields, err := BHEapi.RPCMarshalBlock(block, true, full)
+				if err != nil {
+					log.Warn("Failed to marshal pending block", "number", block.Number(), "err", err)
+					continue
+				}
+				if err := notifier.Notify(sub.ID, fields); err != nil {
+					return
+				}
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxPendingCallTxs is the maximum number of pooled transactions of the caller
+// a pending call applies before the call itself.
+const maxPendingCallTxs = 128
+
+// PendingCallResult is the outcome of a call simulated after the caller's own
+// pooled transactions.
+type PendingCallResult struct {
+	Output  hexutil.Bytes `json:"output"`
+	Applied []common.Hash `json:"applied"` // Pooled transactions of the caller applied first, in nonce order
+}
+
+// senderPoolTxs returns the pooled transactions of an account that continue its
+// nonce sequence from the given nonce without gaps, pending and queued alike.
+func senderPoolTxs(pending, queued types.Transactions, nonce uint64) types.Transactions {
+	byNonce := make(map[uint64]*types.Transaction, len(pending)+len(queued))
+	for _, txs := range []types.Transactions{pending, queued} {
+		for _, tx := range txs {
+			byNonce[tx.Nonce()] = tx
+		}
+	}
+	var txs types.Transactions
+	for {
+		tx, ok := byNonce[nonce]
+		if !ok {
+			return txs
+		}
+		txs = append(txs, tx)
+		nonce++
+	}
+}
+
+// CallAfterPending executes a call against the miner's pending state after first
+// applying the caller's own pooled transactions in nonce order, including the
+// queued ones the pending block doesn't hold yet. It answers what a call returns
+// once all the caller's transactions have landed. Application stops at the first
+// transaction failing to apply, the call runs on top of the ones before it.
+// Transactions and call run in the RPC's EVM slots and share the BHE_call gas
+// cap, callers with more than maxPendingCallTxs transactions are refused.
+func (api *PublicBHEereumAPI) CallAfterPending(ctx context.Context, args BHEapi.CallArgs) (*PendingCallResult, error) {
+	if args.From == nil {
+		return nil, errors.New("missing caller")
+	}
+	var (
+		s      = api.e
+		b      = s.APIBackend
+		from   = *args.From
+		config = s.blockchain.Config()
+		gasCap = b.MethodGasCap("BHE_call")
+	)
+	block, statedb := s.miner.Pending()
+	header := block.Header()
+
+	pending, queued := s.txPool.Content()
+	txs := senderPoolTxs(pending[from], queued[from], statedb.GetNonce(from))
+	if len(txs) > maxPendingCallTxs {
+		return nil, fmt.Errorf("caller has %d pooled transactions, above the limit of %d", len(txs), maxPendingCallTxs)
+	}
+	gp := new(core.GasPool).AddGas(math.MaxUint64)
+	if gasCap != nil {
+		gp = new(core.GasPool).AddGas(gasCap.Uint64())
+	}
+	// apply runs a message on the pending state in an EVM of the RPC's, err being
+	// the message's failure, fatal one of the EVM the request is aborted on
+	apply := func(msg core.Message) (res *core.ExecutionResult, err error, fatal error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		evm, vmError, fatal := b.GetEVM(ctx, msg, statedb, header)
+		if fatal != nil {
+			return nil, nil, fatal
+		}
+		res, err = core.ApplyMessage(evm, msg, gp)
+		if verr := vmError(); verr != nil {
+			return nil, nil, verr
+		}
+		return res, err, nil
+	}
+	result := &PendingCallResult{Applied: []common.Hash{}}
+	signer := types.MakeSigner(config, header.Number)
+	for _, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			break
+		}
+		snapshot := statedb.Snapshot()
+		_, err, fatal := apply(msg)
+		if fatal != nil {
+			return nil, fatal
+		}
+		if err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			log.Debug("Pooled transaction not applicable for simulation", "hash", tx.Hash(), "err", err)
+			break
+		}
+		statedb.Finalise(config.IsEIP158(header.Number))
+		result.Applied = append(result.Applied, tx.Hash())
+	}
+	// Run the call on top of the caller's transactions
+	res, err, fatal := apply(args.ToMessage(gasCap))
+	if fatal != nil {
+		return nil, fatal
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Revert()) > 0 {
+		return nil, newRevertError(res)
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	result.Output = res.Return()
+	return result, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that only the gapless continuation of an account's nonce sequence is
+// picked from its pooled transactions.
+func TestSenderPoolTxs(t *testing.T) {
+	tx := func(nonce uint64) *types.Transaction {
+		return types.NewTransaction(nonce, common.Address{}, new(big.Int), 21000, big.NewInt(1), nil)
+	}
+	pending := types.Transactions{tx(5), tx(6)}
+	queued := types.Transactions{tx(7), tx(9)}
+
+	txs := senderPoolTxs(pending, queued, 5)
+	if len(txs) != 3 {
+		t.Fatalf("transaction count mismatch: have %d, want 3", len(txs))
+	}
+	for i, tx := range txs {
+		if tx.Nonce() != uint64(5+i) {
+			t.Errorf("transaction %d: nonce mismatch: have %d, want %d", i, tx.Nonce(), 5+i)
+		}
+	}
+	if txs := senderPoolTxs(pending, queued, 8); len(txs) != 0 {
+		t.Fatalf("transactions picked across a gap: %d", len(txs))
+	}
+	if txs := senderPoolTxs(pending, queued, 6); len(txs) != 2 {
+		t.Fatalf("transactions from mined nonces picked: %d", len(txs))
+	}
+}
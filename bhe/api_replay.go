@@ -0,0 +1,182 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ReplayConfig holds extra parameters to block replays.
+type ReplayConfig struct {
+	Reexec *uint64 `json:"reexec"` // Blocks to re-execute if the parent state is missing
+}
+
+// ReplayMismatch is a difference between the replayed and the stored results
+// of a block.
+type ReplayMismatch struct {
+	Field string      `json:"field"`
+	Tx    *int        `json:"tx,omitempty"` // Index of the transaction, unset for block level fields
+	Have  interface{} `json:"have"`         // Result of the replay
+	Want  interface{} `json:"want"`         // Stored result
+}
+
+// ReplayReport is the result of a debug_replayBlock call.
+type ReplayReport struct {
+	Number     hexutil.Uint64    `json:"number"`
+	Hash       common.Hash       `json:"hash"`
+	Root       common.Hash       `json:"root"` // State root computed by the replay
+	Match      bool              `json:"match"`
+	Error      string            `json:"error,omitempty"` // Failure to process the block at all
+	Receipts   bool              `json:"receipts"`        // Whether stored receipts were compared
+	Mismatches []*ReplayMismatch `json:"mismatches"`
+}
+
+// compareLogs returns the field of the first difference between two logs, or
+// an empty string if they are equal.
+func compareLogs(have, want *types.Log) string {
+	switch {
+	case have.Address != want.Address:
+		return "address"
+	case len(have.Topics) != len(want.Topics):
+		return "topics"
+	case !bytes.Equal(have.Data, want.Data):
+		return "data"
+	}
+	for i := range have.Topics {
+		if have.Topics[i] != want.Topics[i] {
+			return "topics"
+		}
+	}
+	return ""
+}
+
+// compareReceipts lists the differences between replayed and stored receipts,
+// transaction by transaction.
+func compareReceipts(have, want types.Receipts) []*ReplayMismatch {
+	if len(have) != len(want) {
+		return []*ReplayMismatch{{Field: "receipts", Have: len(have), Want: len(want)}}
+	}
+	var mismatches []*ReplayMismatch
+	for i := range have {
+		index := i
+		add := func(field string, have, want interface{}) {
+			mismatches = append(mismatches, &ReplayMismatch{Field: field, Tx: &index, Have: have, Want: want})
+		}
+		h, w := have[i], want[i]
+		if h.Status != w.Status {
+			add("status", h.Status, w.Status)
+		}
+		if !bytes.Equal(h.PostState, w.PostState) {
+			add("postState", hexutil.Bytes(h.PostState), hexutil.Bytes(w.PostState))
+		}
+		if h.CumulativeGasUsed != w.CumulativeGasUsed {
+			add("cumulativeGasUsed", h.CumulativeGasUsed, w.CumulativeGasUsed)
+		}
+		if h.GasUsed != w.GasUsed {
+			add("gasUsed", h.GasUsed, w.GasUsed)
+		}
+		if h.ContractAddress != w.ContractAddress {
+			add("contractAddress", h.ContractAddress, w.ContractAddress)
+		}
+		if len(h.Logs) != len(w.Logs) {
+			add("logs", len(h.Logs), len(w.Logs))
+			continue
+		}
+		for j := range h.Logs {
+			if field := compareLogs(h.Logs[j], w.Logs[j]); field != "" {
+				add(fmt.Sprintf("logs[%d].%s", j, field), h.Logs[j], w.Logs[j])
+			}
+		}
+	}
+	return mismatches
+}
+
+// compareBlockResults lists the differences between the results of executing a
+// block and the commitments of its header.
+func compareBlockResults(header *types.Header, root common.Hash, receipts types.Receipts, usedGas uint64) []*ReplayMismatch {
+	mismatches := []*ReplayMismatch{}
+	add := func(field string, have, want interface{}) {
+		mismatches = append(mismatches, &ReplayMismatch{Field: field, Have: have, Want: want})
+	}
+	if root != header.Root {
+		add("stateRoot", root, header.Root)
+	}
+	if root := types.DeriveSha(receipts); root != header.ReceiptHash {
+		add("receiptsRoot", root, header.ReceiptHash)
+	}
+	if bloom := types.CreateBloom(receipts); bloom != header.Bloom {
+		add("logsBloom", bloom, header.Bloom)
+	}
+	if usedGas != header.GasUsed {
+		add("gasUsed", usedGas, header.GasUsed)
+	}
+	return mismatches
+}
+
+// ReplayBlock re-executes a block on top of its parent state and compares the
+// resulting state root, receipts and logs against the stored ones, reporting
+// every difference. It is meant for tracking down consensus divergences between
+// node versions; nothing is written to the chain.
+func (api *PrivateDebugAPI) ReplayBlock(ctx context.Context, hash common.Hash, config *ReplayConfig) (*ReplayReport, error) {
+	block := api.BHE.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, fmt.Errorf("block %#x not found", hash)
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not replayable")
+	}
+	parent := api.BHE.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
+	}
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	statedb, err := api.computeStateDB(parent, reexec)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	report := &ReplayReport{
+		Number:     hexutil.Uint64(block.NumberU64()),
+		Hash:       hash,
+		Mismatches: []*ReplayMismatch{},
+	}
+	receipts, _, usedGas, err := api.BHE.blockchain.Processor().Process(block, statedb, vm.Config{})
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.Root = statedb.IntermediateRoot(api.BHE.blockchain.Config().IsEIP158(block.Number()))
+	report.Mismatches = compareBlockResults(block.Header(), report.Root, receipts, usedGas)
+
+	if stored := api.BHE.blockchain.GetReceiptsByHash(hash); stored != nil {
+		report.Receipts = true
+		report.Mismatches = append(report.Mismatches, compareReceipts(receipts, stored)...)
+	}
+	report.Match = len(report.Mismatches) == 0
+	if !report.Match {
+		log.Warn("Block replay diverged", "number", block.NumberU64(), "hash", hash, "mismatches", len(report.Mismatches))
+	}
+	return report, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+)
+
+// Tests that replayed receipts are compared field by field and log by log.
+func TestCompareReceipts(t *testing.T) {
+	logA := &types.Log{Address: common.HexToAddress("0xa"), Topics: []common.Hash{common.HexToHash("0x01")}, Data: []byte{1}}
+	receipt := func(gas uint64, logs ...*types.Log) *types.Receipt {
+		return &types.Receipt{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: gas, GasUsed: gas, Logs: logs}
+	}
+	stored := types.Receipts{receipt(21000, logA), receipt(42000)}
+
+	if mismatches := compareReceipts(types.Receipts{receipt(21000, logA), receipt(42000)}, stored); len(mismatches) != 0 {
+		t.Errorf("identical receipts reported as different: %v", mismatches)
+	}
+	if mismatches := compareReceipts(types.Receipts{receipt(21000, logA)}, stored); len(mismatches) != 1 || mismatches[0].Field != "receipts" {
+		t.Errorf("receipt count mismatch not reported: %v", mismatches)
+	}
+	logB := *logA
+	logB.Data = []byte{2}
+
+	mismatches := compareReceipts(types.Receipts{receipt(21000, &logB), receipt(43000)}, stored)
+	want := []struct {
+		field string
+		tx    int
+	}{
+		{"logs[0].data", 0},
+		{"cumulativeGasUsed", 1},
+		{"gasUsed", 1},
+	}
+	if len(mismatches) != len(want) {
+		t.Fatalf("mismatch count: have %d, want %d", len(mismatches), len(want))
+	}
+	for i, w := range want {
+		if mismatches[i].Field != w.field || mismatches[i].Tx == nil || *mismatches[i].Tx != w.tx {
+			t.Errorf("mismatch %d: have %s at %v, want %s at %d", i, mismatches[i].Field, mismatches[i].Tx, w.field, w.tx)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// UncleReward is the reward credited for an uncle, as computed by the rules of
+// the consensus engine in force at the including block.
+type UncleReward struct {
+	Hash            common.Hash    `json:"hash"`
+	Number          hexutil.Uint64 `json:"number"`
+	Miner           common.Address `json:"miner"`
+	IncludedIn      common.Hash    `json:"includedIn"`
+	IncludedAt      hexutil.Uint64 `json:"includedAt"`
+	Reward          *hexutil.Big   `json:"reward"`          // Credited to the uncle's miner
+	InclusionReward *hexutil.Big   `json:"inclusionReward"` // Credited to the including block's miner
+}
+
+// blockRewards returns the rewards credited for a block and its uncles by the
+// engine, which are zero for engines not minting any (e.g. clique).
+func (s *BHEereum) blockRewards(header *types.Header, uncles []*types.Header) (*big.Int, []*big.Int) {
+	if rewarder, ok := s.engine.(consensus.Rewarder); ok {
+		return rewarder.BlockRewards(s.blockchain.Config(), header, uncles)
+	}
+	rewards := make([]*big.Int, len(uncles))
+	for i := range rewards {
+		rewards[i] = new(big.Int)
+	}
+	return new(big.Int), rewards
+}
+
+// GetUncleReward returns the reward credited for the uncle at the given index
+// of a block, so that explorers don't have to re-implement the reward rules
+// of every fork.
+func (api *PublicBHEereumAPI) GetUncleReward(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash, index hexutil.Uint) (*UncleReward, error) {
+	block, err := api.e.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %v not found", blockNrOrHash)
+	}
+	uncles := block.Uncles()
+	if int(index) >= len(uncles) {
+		return nil, fmt.Errorf("uncle index %d out of range, block has %d", index, len(uncles))
+	}
+	reward, uncleRewards := api.e.blockRewards(block.Header(), uncles)
+	base, _ := api.e.blockRewards(block.Header(), nil)
+
+	uncle := uncles[index]
+	inclusion := new(big.Int).Sub(reward, base)
+	inclusion.Div(inclusion, big.NewInt(int64(len(uncles))))
+
+	return &UncleReward{
+		Hash:            uncle.Hash(),
+		Number:          hexutil.Uint64(uncle.Number.Uint64()),
+		Miner:           uncle.Coinbase,
+		IncludedIn:      block.Hash(),
+		IncludedAt:      hexutil.Uint64(block.NumberU64()),
+		Reward:          (*hexutil.Big)(uncleRewards[index]),
+		InclusionReward: (*hexutil.Big)(inclusion),
+	}, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+)
+
+// snapReader serves account and storage reads of the head state directly from
+// the flat snapshot, bypassing the trie. Every checkEvery'th read is verified
+// against the trie; on a mismatch snapshot reads are disabled until restart.
+type snapReader struct {
+	checkEvery uint64 // Verify one in this many reads against the trie (0 = never)
+	reads      uint64 // Number of snapshot reads served (atomic)
+	disabled   uint32 // Set if a self-check failed (atomic)
+}
+
+func newSnapReader(checkEvery uint64) *snapReader {
+	return &snapReader{checkEvery: checkEvery}
+}
+
+// enabled reports whBHEer snapshot reads may be served.
+func (r *snapReader) enabled() bool {
+	return r != nil && atomic.LoadUint32(&r.disabled) == 0
+}
+
+// shouldCheck reports whBHEer the current read should be verified.
+func (r *snapReader) shouldCheck() bool {
+	n := atomic.AddUint64(&r.reads, 1)
+	return r.checkEvery > 0 && n%r.checkEvery == 0
+}
+
+// mismatch disables snapshot reads after a failed self-check.
+func (r *snapReader) mismatch(ctx ...interface{}) {
+	if atomic.CompareAndSwapUint32(&r.disabled, 0, 1) {
+		log.Error("Snapshot read mismatch, falling back to trie reads", ctx...)
+	}
+}
+
+// headSnapshot returns the snapshot layer of the head state if the request
+// targets the latest block and snapshot reads are usable.
+func (b *BHEAPIBackend) headSnapshot(blockNrOrHash rpc.BlockNumberOrHash) (snapshot.Snapshot, *types.Header) {
+	if !b.snapReads.enabled() {
+		return nil, nil
+	}
+	head := b.BHE.blockchain.CurrentBlock().Header()
+	if number, ok := blockNrOrHash.Number(); ok && number != rpc.LatestBlockNumber && uint64(number) != head.Number.Uint64() {
+		return nil, nil
+	}
+	if hash, ok := blockNrOrHash.Hash(); ok && hash != head.Hash() {
+		return nil, nil
+	}
+	snaps := b.BHE.blockchain.Snapshot()
+	if snaps == nil {
+		return nil, nil
+	}
+	return snaps.Snapshot(head.Root), head
+}
+
+// BalanceAt returns the balance of an account. Reads of the head state are
+// served from the snapshot where possible, falling back to the trie if the
+// snapshot is unavailable or still being generated.
+func (b *BHEAPIBackend) BalanceAt(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*big.Int, error) {
+	if snap, head := b.headSnapshot(blockNrOrHash); snap != nil {
+		if account, err := snap.Account(crypto.Keccak256Hash(address.Bytes())); err == nil {
+			balance := new(big.Int)
+			if account != nil {
+				balance = account.Balance
+			}
+			if b.snapReads.shouldCheck() {
+				if stateDb, err := b.stateAt(ctx, head.Root); err == nil {
+					if want := stateDb.GetBalance(address); want.Cmp(balance) != 0 {
+						b.snapReads.mismatch("root", head.Root, "address", address, "snapshot", balance, "trie", want)
+						return want, stateDb.Error()
+					}
+				}
+			}
+			return balance, nil
+		}
+	}
+	stateDb, _, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if stateDb == nil || err != nil {
+		return nil, err
+	}
+	return stateDb.GetBalance(address), stateDb.Error()
+}
+
+// StorageAt returns a storage slot of an account. Reads of the head state are
+// served from the snapshot where possible, falling back to the trie if the
+// snapshot is unavailable or still being generated.
+func (b *BHEAPIBackend) StorageAt(ctx context.Context, address common.Address, key common.Hash, blockNrOrHash rpc.BlockNumberOrHash) (common.Hash, error) {
+	if snap, head := b.headSnapshot(blockNrOrHash); snap != nil {
+		if blob, err := snap.Storage(crypto.Keccak256Hash(address.Bytes()), crypto.Keccak256Hash(key.Bytes())); err == nil {
+			var value common.Hash
+			if len(blob) > 0 {
+				if _, content, _, err := rlp.Split(blob); err == nil {
+					value.SetBytes(content)
+				}
+			}
+			if b.snapReads.shouldCheck() {
+				if stateDb, err := b.stateAt(ctx, head.Root); err == nil {
+					if want := stateDb.GetState(address, key); want != value {
+						b.snapReads.mismatch("root", head.Root, "address", address, "key", key, "snapshot", value, "trie", want)
+						return want, stateDb.Error()
+					}
+				}
+			}
+			return value, nil
+		}
+	}
+	stateDb, _, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if stateDb == nil || err != nil {
+		return common.Hash{}, err
+	}
+	return stateDb.GetState(address, key), stateDb.Error()
+}
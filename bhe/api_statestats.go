@@ -0,0 +1,186 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultStateStatsTop is the number of heaviest contracts reported if the
+	// caller does not specify a limit.
+	defaultStateStatsTop = 10
+
+	// maxStateStatsTop is the maximum number of heaviest contracts reported.
+	maxStateStatsTop = 1000
+)
+
+// codeSizeBuckets are the upper bounds (inclusive) of the contract code size
+// histogram buckets. The last bucket is the EIP-170 limit, anything larger
+// is counted in an overflow bucket.
+var codeSizeBuckets = []int{1024, 4096, 12288, 24576}
+
+// emptyCodeHash is the known hash of the empty EVM bytecode.
+var emptyCodeHash = crypto.Keccak256(nil)
+
+// codeSizeBucket returns the name of the histogram bucket a contract code size
+// falls into.
+func codeSizeBucket(size int) string {
+	for i, limit := range codeSizeBuckets {
+		if size <= limit {
+			if i == 0 {
+				return fmt.Sprintf("0-%d", limit)
+			}
+			return fmt.Sprintf("%d-%d", codeSizeBuckets[i-1]+1, limit)
+		}
+	}
+	return fmt.Sprintf(">%d", codeSizeBuckets[len(codeSizeBuckets)-1])
+}
+
+// StateStatsConfig holds extra parameters to state statistics collection.
+type StateStatsConfig struct {
+	Top         *int  `json:"top"`         // Number of heaviest contracts to report
+	SkipStorage *bool `json:"skipStorage"` // Skip iterating storage tries (much faster)
+}
+
+// ContractWeight describes the footprint of a single contract.
+type ContractWeight struct {
+	AddressHash common.Hash     `json:"addressHash"`
+	Address     *common.Address `json:"address,omitempty"` // Only if the preimage is known
+	Slots       uint64          `json:"slots"`
+	CodeSize    int             `json:"codeSize"`
+}
+
+// StateStats is the result of a debug_stateStats call.
+type StateStats struct {
+	Root      common.Hash       `json:"root"`
+	Accounts  uint64            `json:"accounts"`
+	Contracts uint64            `json:"contracts"`
+	Slots     uint64            `json:"slots"`
+	CodeSizes map[string]uint64 `json:"codeSizes"` // Histogram of contract code sizes
+	Heaviest  []*ContractWeight `json:"heaviest"`  // Contracts with the most storage slots
+	Elapsed   string            `json:"elapsed"`
+}
+
+// StateStats iterates the entire state trie rooted at root and reports the
+// number of accounts and storage slots, the distribution of contract code
+// sizes and the contracts with the most storage. It only reads from the trie
+// database, so it is safe to run on a live node, but it may take a very long
+// time on large states and is aborted with the request context.
+func (api *PrivateDebugAPI) StateStats(ctx context.Context, root common.Hash, config *StateStatsConfig) (*StateStats, error) {
+	top, skipStorage := defaultStateStatsTop, false
+	if config != nil && config.Top != nil {
+		top = *config.Top
+	}
+	if config != nil && config.SkipStorage != nil {
+		skipStorage = *config.SkipStorage
+	}
+	if top < 0 || top > maxStateStatsTop {
+		return nil, fmt.Errorf("top out of range [0, %d]", maxStateStatsTop)
+	}
+	db := api.BHE.BlockChain().StateCache()
+	accTrie, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		stats  = &StateStats{Root: root, CodeSizes: make(map[string]uint64)}
+		heavy  []*ContractWeight
+	)
+	for _, limit := range codeSizeBuckets {
+		stats.CodeSizes[codeSizeBucket(limit)] = 0
+	}
+	stats.CodeSizes[codeSizeBucket(codeSizeBuckets[len(codeSizeBuckets)-1]+1)] = 0
+
+	it := trie.NewIterator(accTrie.NodeIterator(nil))
+	for it.Next() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Collecting state statistics", "root", root, "accounts", stats.Accounts, "slots", stats.Slots, "elapsed", time.Since(start))
+			logged = time.Now()
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return nil, fmt.Errorf("invalid account %x: %v", it.Key, err)
+		}
+		stats.Accounts++
+
+		if bytes.Equal(account.CodeHash, emptyCodeHash) {
+			continue
+		}
+		stats.Contracts++
+		addrHash := common.BytesToHash(it.Key)
+
+		size, err := db.ContractCodeSize(addrHash, common.BytesToHash(account.CodeHash))
+		if err != nil {
+			return nil, fmt.Errorf("missing code for account %x: %v", it.Key, err)
+		}
+		stats.CodeSizes[codeSizeBucket(size)]++
+
+		weight := &ContractWeight{AddressHash: addrHash, CodeSize: size}
+		if !skipStorage && account.Root != types.EmptyRootHash {
+			storage, err := db.OpenStorageTrie(addrHash, account.Root)
+			if err != nil {
+				return nil, err
+			}
+			sit := trie.NewIterator(storage.NodeIterator(nil))
+			for sit.Next() {
+				weight.Slots++
+			}
+			if sit.Err != nil {
+				return nil, sit.Err
+			}
+			stats.Slots += weight.Slots
+		}
+		// Keep only the heaviest contracts around to bound memory usage
+		if top > 0 {
+			heavy = append(heavy, weight)
+			if len(heavy) > 2*top {
+				sort.Slice(heavy, func(i, j int) bool { return heavy[i].Slots > heavy[j].Slots })
+				heavy = heavy[:top]
+			}
+		}
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	sort.Slice(heavy, func(i, j int) bool { return heavy[i].Slots > heavy[j].Slots })
+	if len(heavy) > top {
+		heavy = heavy[:top]
+	}
+	for _, weight := range heavy {
+		if preimage := accTrie.GetKey(weight.AddressHash.Bytes()); preimage != nil {
+			addr := common.BytesToAddress(preimage)
+			weight.Address = &addr
+		}
+	}
+	stats.Heaviest = heavy
+	stats.Elapsed = common.PrettyDuration(time.Since(start)).String()
+
+	log.Info("Collected state statistics", "root", root, "accounts", stats.Accounts, "contracts", stats.Contracts, "slots", stats.Slots, "elapsed", common.PrettyDuration(time.Since(start)))
+	return stats, nil
+}
@@ -0,0 +1,324 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var attackAlertMeter = metrics.NewRegisteredMeter("BHE/attack/alerts", nil)
+
+// AttackMonitorConfig contains the settings of the hashrate anomaly monitor.
+type AttackMonitorConfig struct {
+	Window     int     // Number of recent canonical blocks analysed; disabled if zero
+	AlertDepth uint64  // Minimum depth of a competing chain to alert on
+	AlertRatio float64 // Minimum work of a competing chain relative to the canonical one since the fork, to alert on
+}
+
+// DefaultAttackMonitorConfig contains the default hashrate anomaly monitor settings.
+var DefaultAttackMonitorConfig = AttackMonitorConfig{
+	Window:     256,
+	AlertDepth: 3,
+	AlertRatio: 0.8,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *AttackMonitorConfig) sanitize() AttackMonitorConfig {
+	conf := *config
+	if conf.Window < 16 {
+		log.Warn("Sanitizing invalid attack monitor window", "provided", conf.Window, "updated", DefaultAttackMonitorConfig.Window)
+		conf.Window = DefaultAttackMonitorConfig.Window
+	}
+	if conf.AlertDepth < 1 {
+		log.Warn("Sanitizing invalid attack monitor alert depth", "provided", conf.AlertDepth, "updated", DefaultAttackMonitorConfig.AlertDepth)
+		conf.AlertDepth = DefaultAttackMonitorConfig.AlertDepth
+	}
+	if conf.AlertRatio <= 0 || conf.AlertRatio > 1 {
+		log.Warn("Sanitizing invalid attack monitor alert ratio", "provided", conf.AlertRatio, "updated", DefaultAttackMonitorConfig.AlertRatio)
+		conf.AlertRatio = DefaultAttackMonitorConfig.AlertRatio
+	}
+	return conf
+}
+
+// CompetingChain describes a chain forking off the canonical one within the
+// monitored window.
+type CompetingChain struct {
+	Head      hexutil.Uint64 `json:"head"`
+	Hash      common.Hash    `json:"hash"`
+	Ancestor  hexutil.Uint64 `json:"ancestor"`
+	Depth     hexutil.Uint64 `json:"depth"`     // Number of blocks of the competing chain since the fork
+	WorkRatio float64        `json:"workRatio"` // Work of the competing chain relative to the canonical one since the fork
+	Announced bool           `json:"announced"` // Whether the head was announced by a peer rather than imported
+	Detected  time.Time      `json:"detected"`
+}
+
+// HashrateRisk is the assessment of the hashrate anomaly monitor.
+type HashrateRisk struct {
+	Score           float64         `json:"score"`           // From 0 (nominal) to 1 (attack likely)
+	DifficultyTrend float64         `json:"difficultyTrend"` // Relative change of the difficulty across the window
+	MeanBlockTime   float64         `json:"meanBlockTime"`   // Average block interval in seconds
+	BlockTimeCV     float64         `json:"blockTimeCV"`     // Coefficient of variation of the block intervals
+	CompetingChain  *CompetingChain `json:"competingChain,omitempty"`
+}
+
+// hashrateStats summarises a run of consecutive headers, oldest first: the
+// relative change of the average difficulty between its halves, and the mean
+// and coefficient of variation of the block intervals.
+func hashrateStats(headers []*types.Header) (trend, mean, cv float64) {
+	if len(headers) < 4 {
+		return 0, 0, 0
+	}
+	average := func(headers []*types.Header) float64 {
+		sum := new(big.Int)
+		for _, header := range headers {
+			sum.Add(sum, header.Difficulty)
+		}
+		avg, _ := new(big.Float).Quo(new(big.Float).SetInt(sum), big.NewFloat(float64(len(headers)))).Float64()
+		return avg
+	}
+	half := len(headers) / 2
+	if older := average(headers[:half]); older > 0 {
+		trend = (average(headers[half:]) - older) / older
+	}
+	intervals := make([]float64, 0, len(headers)-1)
+	for i := 1; i < len(headers); i++ {
+		interval := float64(headers[i].Time) - float64(headers[i-1].Time)
+		intervals = append(intervals, interval)
+		mean += interval
+	}
+	mean /= float64(len(intervals))
+	if mean <= 0 {
+		return trend, mean, 0
+	}
+	var variance float64
+	for _, interval := range intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	variance /= float64(len(intervals))
+	return trend, mean, math.Sqrt(variance) / mean
+}
+
+// clampRisk limits a risk to the [0, 1] range.
+func clampRisk(risk float64) float64 {
+	return math.Max(0, math.Min(1, risk))
+}
+
+// hashrateRisk scores the anomalies of a window. A falling difficulty hints at
+// hashrate withdrawn to mine a private chain, a halving scoring the maximum.
+// Block intervals of a steady hashrate vary about as much as they last (a
+// coefficient of variation of one), erratic ones score the maximum at three. A
+// competing chain scores its work ratio, scaled down below the alert depth.
+func hashrateRisk(trend, cv float64, chain *CompetingChain, alertDepth uint64) float64 {
+	risk := math.Max(clampRisk(-trend*2), clampRisk((cv-1)/2))
+	if chain != nil {
+		scale := math.Min(1, float64(chain.Depth)/float64(alertDepth))
+		risk = math.Max(risk, clampRisk(chain.WorkRatio*scale))
+	}
+	return risk
+}
+
+// attackMonitor watches for competing chains within the recent window of the
+// canonical chain, remembering the strongest one and alerting on those doing
+// almost as much work as the canonical chain.
+type attackMonitor struct {
+	config    AttackMonitorConfig
+	strongest *CompetingChain // Competing chain scoring the highest risk within the window
+	alerted   common.Hash     // Fork point of the last competing chain alerted on
+
+	feed  event.Feed
+	scope event.SubscriptionScope
+	lock  sync.Mutex
+}
+
+func newAttackMonitor(config AttackMonitorConfig) *attackMonitor {
+	return &attackMonitor{config: config.sanitize()}
+}
+
+// observe records a competing chain, returning whether it is worth an alert.
+// Every fork point is only alerted on once.
+func (m *attackMonitor) observe(chain *CompetingChain, ancestor common.Hash, head uint64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.strongest != nil && uint64(m.strongest.Ancestor)+uint64(m.config.Window) < head {
+		m.strongest = nil // Fell out of the window
+	}
+	risk := hashrateRisk(0, 0, chain, m.config.AlertDepth)
+	if m.strongest == nil || risk >= hashrateRisk(0, 0, m.strongest, m.config.AlertDepth) {
+		m.strongest = chain
+	}
+	if uint64(chain.Depth) < m.config.AlertDepth || chain.WorkRatio < m.config.AlertRatio || ancestor == m.alerted {
+		return false
+	}
+	m.alerted = ancestor
+	return true
+}
+
+// recentHeaders returns up to count canonical headers ending at the current
+// head, oldest first.
+func (s *BHEereum) recentHeaders(count int) []*types.Header {
+	headers := make([]*types.Header, 0, count)
+	for header := s.blockchain.CurrentHeader(); header != nil && len(headers) < count; {
+		headers = append(headers, header)
+		if header.Number.Sign() == 0 {
+			break
+		}
+		header = s.blockchain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	return headers
+}
+
+// checkCompetingChain measures the chain ending in a non-canonical header with
+// the given total difficulty against the canonical one, alerting if it rivals
+// it. The protocol manager calls it for every block announced by a peer, side
+// chain imports are checked by the monitor itself.
+func (s *BHEereum) checkCompetingChain(header *types.Header, td *big.Int, announced bool) {
+	if s.attacks == nil || td == nil {
+		return
+	}
+	var (
+		head     = s.blockchain.CurrentHeader()
+		window   = uint64(s.attacks.config.Window)
+		ancestor = header
+	)
+	// Walk back to the fork point, giving up on chains forking outside the window
+	for rawdb.ReadCanonicalHash(s.chainDb, ancestor.Number.Uint64()) != ancestor.Hash() {
+		if ancestor.Number.Sign() == 0 || header.Number.Uint64()-ancestor.Number.Uint64() >= window {
+			return
+		}
+		if ancestor = s.blockchain.GetHeader(ancestor.ParentHash, ancestor.Number.Uint64()-1); ancestor == nil {
+			return // Parent unknown, syncing or junk
+		}
+	}
+	if ancestor.Hash() == header.Hash() || ancestor.Number.Uint64()+window < head.Number.Uint64() {
+		return
+	}
+	var (
+		ancestorTd = s.blockchain.GetTd(ancestor.Hash(), ancestor.Number.Uint64())
+		headTd     = s.blockchain.GetTd(head.Hash(), head.Number.Uint64())
+	)
+	if ancestorTd == nil || headTd == nil {
+		return
+	}
+	forkWork := new(big.Int).Sub(td, ancestorTd)
+	canonWork := new(big.Int).Sub(headTd, ancestorTd)
+	if forkWork.Sign() <= 0 || canonWork.Sign() <= 0 {
+		return
+	}
+	ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(forkWork), new(big.Float).SetInt(canonWork)).Float64()
+	chain := &CompetingChain{
+		Head:      hexutil.Uint64(header.Number.Uint64()),
+		Hash:      header.Hash(),
+		Ancestor:  hexutil.Uint64(ancestor.Number.Uint64()),
+		Depth:     hexutil.Uint64(header.Number.Uint64() - ancestor.Number.Uint64()),
+		WorkRatio: ratio,
+		Announced: announced,
+		Detected:  time.Now(),
+	}
+	if !s.attacks.observe(chain, ancestor.Hash(), head.Number.Uint64()) {
+		return
+	}
+	attackAlertMeter.Mark(1)
+	log.Warn("Competing chain rivalling the canonical one", "head", chain.Head, "hash", chain.Hash, "ancestor", chain.Ancestor, "depth", chain.Depth, "ratio", ratio, "announced", announced)
+	s.attacks.feed.Send(*chain)
+}
+
+// startAttackMonitor checks every side chain block imported for a competing
+// chain rivalling the canonical one.
+func (s *BHEereum) startAttackMonitor() {
+	side := make(chan core.ChainSideEvent, 64)
+	s.attackSub = s.blockchain.SubscribeChainSideEvent(side)
+
+	go func() {
+		for {
+			select {
+			case ev := <-side:
+				s.checkCompetingChain(ev.Block.Header(), s.blockchain.GetTd(ev.Block.Hash(), ev.Block.NumberU64()), false)
+			case <-s.attackSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// HashrateRisk assesses the recent canonical chain for signs of a majority
+// attack: the difficulty trend, the block interval variation and the strongest
+// competing chain within the window.
+func (api *PrivateAdminAPI) HashrateRisk() (*HashrateRisk, error) {
+	m := api.BHE.attacks
+	if m == nil {
+		return nil, errors.New("attack monitor disabled")
+	}
+	trend, mean, cv := hashrateStats(api.BHE.recentHeaders(m.config.Window))
+
+	m.lock.Lock()
+	chain := m.strongest
+	if chain != nil && uint64(chain.Ancestor)+uint64(m.config.Window) < api.BHE.blockchain.CurrentHeader().Number.Uint64() {
+		chain = nil
+	}
+	m.lock.Unlock()
+
+	return &HashrateRisk{
+		Score:           hashrateRisk(trend, cv, chain, m.config.AlertDepth),
+		DifficultyTrend: trend,
+		MeanBlockTime:   mean,
+		BlockTimeCV:     cv,
+		CompetingChain:  chain,
+	}, nil
+}
+
+// AttackAlerts creates a subscription notified whenever a competing chain doing
+// almost as much work as the canonical one appears.
+func (api *PrivateAdminAPI) AttackAlerts(ctx context.Context) (*rpc.Subscription, error) {
+	if api.BHE.attacks == nil {
+		return nil, errors.New("attack monitor disabled")
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan CompetingChain, 16)
+		eventSub := api.BHE.attacks.scope.Track(api.BHE.attacks.feed.Subscribe(events))
+		defer eventSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(sub.ID, ev)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			case <-eventSub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// Tests that the difficulty trend and block interval variation are derived from
+// the halves and intervals of the window.
+func TestHashrateStats(t *testing.T) {
+	var headers []*types.Header
+	for i := 0; i < 8; i++ {
+		difficulty := int64(1000)
+		if i >= 4 {
+			difficulty = 500 // Half the hashrate left
+		}
+		headers = append(headers, &types.Header{Number: big.NewInt(int64(i)), Difficulty: big.NewInt(difficulty), Time: uint64(i * 10)})
+	}
+	trend, mean, cv := hashrateStats(headers)
+	if trend != -0.5 {
+		t.Errorf("trend mismatch: have %v, want %v", trend, -0.5)
+	}
+	if mean != 10 || cv != 0 {
+		t.Errorf("interval mismatch: have mean %v cv %v, want mean 10 cv 0", mean, cv)
+	}
+	// Alternate intervals of one and nineteen seconds
+	for i := range headers {
+		headers[i].Time = uint64(i/2*20 + i%2)
+	}
+	if _, _, cv := hashrateStats(headers); math.Abs(cv-1.02) > 0.01 {
+		t.Errorf("variation mismatch: have %v, want about %v", cv, 1.02)
+	}
+	if trend, mean, cv := hashrateStats(headers[:3]); trend != 0 || mean != 0 || cv != 0 {
+		t.Errorf("short window not ignored: %v %v %v", trend, mean, cv)
+	}
+}
+
+// Tests that the risk score is the highest of its clamped components.
+func TestHashrateRisk(t *testing.T) {
+	tests := []struct {
+		trend, cv float64
+		chain     *CompetingChain
+		want      float64
+	}{
+		{0, 1, nil, 0},
+		{0.5, 1, nil, 0}, // Rising difficulty is no anomaly
+		{-0.25, 1, nil, 0.5},
+		{-2, 1, nil, 1},
+		{0, 2, nil, 0.5},
+		{0, 1, &CompetingChain{Depth: 3, WorkRatio: 0.9}, 0.9},
+		{0, 1, &CompetingChain{Depth: 1, WorkRatio: 0.9}, 0.3}, // Below the alert depth
+		{-0.25, 1, &CompetingChain{Depth: 6, WorkRatio: 1.5}, 1},
+	}
+	for i, tt := range tests {
+		if have := hashrateRisk(tt.trend, tt.cv, tt.chain, 3); math.Abs(have-tt.want) > 1e-9 {
+			t.Errorf("test %d: risk mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that competing chains are each alerted on once, and that the strongest
+// one is remembered until it falls out of the window.
+func TestAttackMonitorObserve(t *testing.T) {
+	m := newAttackMonitor(AttackMonitorConfig{Window: 16, AlertDepth: 3, AlertRatio: 0.8})
+
+	var (
+		forkA = common.HexToHash("0xa")
+		forkB = common.HexToHash("0xb")
+	)
+	if m.observe(&CompetingChain{Ancestor: 10, Depth: 1, WorkRatio: 0.9}, forkA, 12) {
+		t.Errorf("shallow chain alerted on")
+	}
+	if !m.observe(&CompetingChain{Ancestor: 10, Depth: 3, WorkRatio: 0.9}, forkA, 13) {
+		t.Errorf("rivalling chain not alerted on")
+	}
+	if m.observe(&CompetingChain{Ancestor: 10, Depth: 4, WorkRatio: 0.95}, forkA, 14) {
+		t.Errorf("fork point alerted on twice")
+	}
+	if m.observe(&CompetingChain{Ancestor: 12, Depth: 5, WorkRatio: 0.5}, forkB, 17) {
+		t.Errorf("weak chain alerted on")
+	}
+	if m.strongest.Depth != 4 {
+		t.Errorf("strongest chain mismatch: have depth %d, want %d", m.strongest.Depth, 4)
+	}
+	m.observe(&CompetingChain{Ancestor: 30, Depth: 1, WorkRatio: 0.1}, forkB, 31)
+	if m.strongest.Ancestor != 30 {
+		t.Errorf("stale chain retained: have ancestor %d, want %d", m.strongest.Ancestor, 30)
+	}
+}
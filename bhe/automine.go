@@ -0,0 +1,175 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errAutomineUnsupported = errors.New("automine requires developer mode or fake proof-of-work")
+	errAutomining          = errors.New("automine enabled, disable it to start the miner")
+)
+
+// autominer seals a block whenever transactions become executable in the pool,
+// instead of waiting for the miner's sealing period.
+type autominer struct {
+	sub event.Subscription // Pool promotions triggering the sealing
+}
+
+// nextTimestamp returns the timestamp of a block sealed on demand, following the
+// block clock if timestamps are skewed. Blocks are never sealed ahead of it.
+func (s *BHEereum) nextTimestamp() uint64 {
+	if s.clock != nil {
+		return uint64(s.clock.now().Unix())
+	}
+	return uint64(time.Now().Unix())
+}
+
+// sealNow seals a block on demand, at the given timestamp or the next one due if
+// zero. Sealing on demand is serialized, so blocks never compete for the head.
+func (s *BHEereum) sealNow(timestamp uint64, empty bool, seal func(*types.Header) error) (*types.Block, error) {
+	s.sealLock.Lock()
+	defer s.sealLock.Unlock()
+
+	if timestamp == 0 {
+		timestamp = s.nextTimestamp()
+	}
+	return s.sealBlock(timestamp, empty, seal)
+}
+
+// automineSealer returns the function sealing blocks on demand: signing with
+// the key of the developer chain, or nothing at all with fake proof-of-work.
+//
+// Blocks sealed on demand are written straight to the chain, bypassing the
+// engine's sealing and header verification, so they are only produced where no
+// other node could be expected to accept them anyway. On a real clique network
+// the signer rotation and timestamps would go unchecked, building a chain the
+// peers reject.
+func (s *BHEereum) automineSealer() (func(*types.Header) error, error) {
+	if s.dev != nil {
+		return s.dev.seal, nil
+	}
+	if _, ok := s.engine.(*BHEash.BHEash); ok {
+		if mode := s.config.BHEash.PowMode; mode == BHEash.ModeFake || mode == BHEash.ModeFullFake {
+			return func(*types.Header) error { return nil }, nil
+		}
+	}
+	return nil, errAutomineUnsupported
+}
+
+// startAutomine starts sealing a block whenever transactions become executable,
+// as many as needed to include all of them. Transactions already pending are
+// sealed right away.
+func (s *BHEereum) startAutomine(seal func(*types.Header) error) *autominer {
+	txs := make(chan core.NewTxsEvent, 256)
+	miner := &autominer{sub: s.txPool.SubscribeNewTxsEvent(txs)}
+
+	drain := func() {
+		for {
+			block, err := s.sealNow(0, false, seal)
+			if err == errNothingToSeal {
+				return
+			}
+			if err != nil {
+				log.Error("Failed to seal block on demand", "err", err)
+				return
+			}
+			if block.GasUsed()+params.TxGas <= block.GasLimit() {
+				return // Block not full, everything executable included
+			}
+		}
+	}
+	go func() {
+		if pending, _ := s.txPool.Stats(); pending > 0 {
+			drain()
+		}
+		for {
+			select {
+			case <-txs:
+				drain()
+			case <-miner.sub.Err():
+				return
+			}
+		}
+	}()
+	return miner
+}
+
+// setAutomine switches between sealing blocks on demand and on the miner's
+// schedule, stopping the miner when enabled.
+func (s *BHEereum) setAutomine(enabled bool) error {
+	if !enabled {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if s.automine != nil {
+			s.automine.sub.Unsubscribe()
+			s.automine = nil
+			log.Info("Disabled automine")
+		}
+		return nil
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+	seal, err := s.automineSealer()
+	if err != nil {
+		return err
+	}
+	if s.IsMining() {
+		s.StopMining()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.automine != nil {
+		return nil
+	}
+	// Sealing makes the node a block producer, so accept transactions from peers
+	atomic.StoreUint32(&s.protocolManager.acceptTxs, 1)
+
+	s.automine = s.startAutomine(seal)
+	log.Info("Enabled automine")
+	return nil
+}
+
+// automining returns whether blocks are sealed on demand.
+func (s *BHEereum) automining() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.automine != nil
+}
+
+// SetAutomine selects whether a block is sealed as soon as transactions become
+// executable, instead of on the sealing schedule of the miner, which is stopped.
+// It is only supported in developer mode and with fake proof-of-work.
+func (api *PrivateMinerAPI) SetAutomine(enabled bool) (bool, error) {
+	if err := api.e.setAutomine(enabled); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Automine returns whether blocks are sealed as soon as transactions become
+// executable.
+func (api *PrivateMinerAPI) Automine() bool {
+	return api.e.automining()
+}
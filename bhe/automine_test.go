@@ -0,0 +1,45 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import "testing"
+
+// Tests that blocks are only sealed on demand where nobody else verifies them:
+// developer chains and fake proof-of-work.
+func TestAutomineSealer(t *testing.T) {
+	dev, err := newDeveloperChain(1)
+	if err != nil {
+		t.Fatalf("failed to create developer chain: %v", err)
+	}
+	signer := clique.New(params.AllCliqueProtocolChanges.Clique, rawdb.NewMemoryDatabase())
+
+	tests := []struct {
+		BHE *BHEereum
+		err error
+	}{
+		{&BHEereum{dev: dev, engine: signer, config: &Config{}}, nil},
+		{&BHEereum{engine: signer, config: &Config{}}, errAutomineUnsupported},
+		{&BHEereum{engine: BHEash.NewFaker(), config: &Config{BHEash: BHEash.Config{PowMode: BHEash.ModeFake}}}, nil},
+		{&BHEereum{engine: BHEash.NewFullFaker(), config: &Config{BHEash: BHEash.Config{PowMode: BHEash.ModeFullFake}}}, nil},
+		{&BHEereum{engine: BHEash.NewFaker(), config: &Config{BHEash: BHEash.Config{PowMode: BHEash.ModeNormal}}}, errAutomineUnsupported},
+	}
+	for i, tt := range tests {
+		if _, err := tt.BHE.automineSealer(); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
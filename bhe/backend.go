@@ -18,6 +18,7 @@
 package BHE
 
 import (
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"math/big"
@@ -33,6 +34,11 @@ type LesServer interface {
 	Protocols() []p2p.Protocol
 	SetBloomBitsIndexer(bbIndexer *core.ChainIndexer)
 	SetContractBackend(bind.ContractBackend)
+
+	// Request serving policy, adjustable at runtime through the les admin API
+	SetServingLimits(limits LesServingLimits) error
+	SetClientLimit(id enode.ID, limit *LesClientLimit) error
+	SetPriorityClients(ids []enode.ID) error
 }
 
 // BHEereum implements the BHEereum full node service.
@@ -41,10 +47,76 @@ type BHEereum struct {
 
 	// Handlers
 	txPool          *core.TxPool
+	txAges          *txAgeTracker      // First sightings of pooled transactions for lifetime eviction
+	txAgeSub        event.Subscription // Pool announcements feeding the age tracker
+	poolLimitSub    event.Subscription // Pool announcements triggering the size and gas limit checks
+	scheduler       *txScheduler       // Future-nonce transactions waiting for their predecessors
+	schedulerSub    event.Subscription // Pool announcements activating scheduled transactions
+	sponsors        *sponsorPool       // Sponsored calls waiting to be relayed, nil if disabled
+	sponsorSub      event.Subscription // Chain heads driving the sponsored call relay
+	bumper          *txBumper          // Stuck local transactions to re-sign at a higher price, nil if disabled
+	bumperSub       event.Subscription // Chain heads driving the fee bumping of local transactions
 	blockchain      *core.BlockChain
 	protocolManager *ProtocolManager
 	lesServer       LesServer
+	lesPolicy       *lesPolicy         // Light server serving policy, applied once the server registers
+	deposits        *depositTracker    // Light client deposits granting priority, nil if disabled
+	depositSub      event.Subscription // Chain events feeding the deposit tracker
 	dialCandidates  enode.Iterator
+	localNode       *enode.LocalNode     // Local node record, set on startup
+	nodeKey         *ecdsa.PrivateKey    // Key of the running node, set on startup
+	nodeKeyPath     string               // Path of the node key in the data directory
+	enrEntries      map[string]string    // Custom entries advertised in the node record
+	contractBackend bind.ContractBackend // Client of the local node for contract interactions, set after startup
+	checkpointSub   event.Subscription   // Chain heads driving the checkpoint signing
+	finality        *cliqueFinality      // Signer quorum finality of clique chains, nil if disabled
+	finalitySub     event.Subscription   // Chain heads advancing the finalized head
+	reorgLimit      *reorgLimiter        // Maximum depth of accepted reorgs, nil if unlimited
+	equivocations   *equivocationDetector
+	failover        *minerFailover     // Miner lock coordinating redundant validators, nil if disabled
+	dev             *developerChain    // On demand sealing of a developer chain, nil if disabled
+	clock           *blockClock        // Skewable clock of block timestamps, nil on real networks
+	automine        *autominer         // Sealing on transaction arrival, nil if disabled (protected by lock)
+	sealLock        sync.Mutex         // Serializes the sealing of blocks on demand
+	equivocationSub event.Subscription // Imported blocks checked for equivocating signers
+	attacks         *attackMonitor     // Hashrate anomaly monitor, nil if disabled
+	attackSub       event.Subscription // Side chain imports checked for competing chains
+	ancients        *ancientServer
+	proofs          *proofServer
+	propagation     *propagationTracer // Announcements and deliveries of recent blocks per peer
+	propagationSub  event.Subscription // Chain events recording the imports of traced blocks
+	bandwidth       *bandwidthThrottle // Allowance of sync traffic
+	screener        *txScreener
+	spam            *spamGuard // Account-level spam protection of remote transactions, nil if disabled
+	denyList        *denyList
+	signingAudit    *signingAudit
+	bridge          *foreignChain
+	stateServer     *stateServer
+	dbServer        *databaseServer // Chain database exposed to read replicas, nil if disabled
+	replicaQuit     chan struct{}   // Stops following the head of a remote chain database
+	sessions        *sessionManager
+	events          *eventSequencer
+	rateLimits      *requestLimiter
+	rpcAuth         *rpcAuth
+	rpcACL          *rpcACL
+	rpcAudit        *rpcAudit                 // Audit log of privileged RPC calls, nil if disabled
+	extSigner       *external.ExternalBackend // External signer for sealing, nil if keys are local
+	hwSignerSub     event.Subscription        // Wallet events of a hardware sealing wallet, nil if unused
+	accessListSub   event.Subscription        // Block access list recording, nil if disabled
+	witnessSub      event.Subscription        // Block witness recording, nil if disabled
+	issuanceSub     event.Subscription        // Issuance index maintenance, nil if disabled
+	tokenIndexSub   event.Subscription        // Token transfer indexing, nil if disabled
+	txLookupJob     *TxLookupJob              // Manual transaction lookup (un)indexing in progress
+	dbMigration     *DatabaseMigration        // Copy of the chain data into another database engine, if started
+	receiptTail     uint64                    // First block whose receipts are held (atomic)
+	receiptsQuit    chan struct{}             // Stops the periodic ancient receipt pruning
+	drainer         rpcDrainer                // RPC EVM executions in flight, drained on shutdown
+	reloadQuit      chan struct{}             // Stops reloading the configuration on SIGHUP
+	profiler        cpuProfiler               // CPU profile requested through the debug API
+	stalls          stallWatch                // Watchdog of chain imports stalling despite peers
+	p2pServer       *p2p.Server               // Server of the peer connections, set on startup
+	netStats        networkStatsCache         // Last network statistics served, valid until the head changes
+	extensions      *vm.ChainExtensions       // EVM rules extended by the chain config, nil if none
 
 	// DB interfaces
 	chainDb BHEdb.Database // Block chain database
@@ -55,13 +127,22 @@ type BHEereum struct {
 
 	bloomRequests     chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer      *core.ChainIndexer             // Bloom indexer operating during block imports
+	bloomService      *bloomService                  // Bloom retrieval pipeline configuration
+	chtIndexer        *core.ChainIndexer             // Canonical hash trie indexer, nil if disabled
+	bloomTrieIndexer  *core.ChainIndexer             // Bloom trie indexer chained to the bloom bits, nil if disabled
 	closeBloomHandler chan struct{}
 
+	resyncReports string // Path of the automatic resync history
+	remoteJournal string // Path of the remote transaction journal, empty if disabled
+
 	APIBackend *BHEAPIBackend
 
-	miner     *miner.Miner
-	gasPrice  *big.Int
-	BHEerbase common.Address
+	miner       *miner.Miner
+	gasPrice    *big.Int
+	BHEerbase   common.Address
+	coinbases   *coinbaseSchedule  // BHEerbase rotation, nil if disabled
+	coinbaseSub event.Subscription // Chain head subscription driving the rotation
+	minerTiming minerTiming        // Block building schedule applied to the miner
 
 	networkID     uint64
 	netRPCService *BHEapi.PublicNetAPI
@@ -70,12 +151,22 @@ type BHEereum struct {
 }
 
 func (s *BHEereum) AddLesServer(ls LesServer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.lesServer = ls
 	ls.SetBloomBitsIndexer(s.bloomIndexer)
+	if err := s.lesPolicy.apply(ls); err != nil {
+		log.Error("Failed to apply light server policy", "err", err)
+	}
 }
 
 // SetClient sets a rpc client which connecting to our local node.
 func (s *BHEereum) SetContractBackend(backend bind.ContractBackend) {
+	s.lock.Lock()
+	s.contractBackend = backend
+	s.lock.Unlock()
+
 	// Pass the rpc client to les server if it is enabled.
 	if s.lesServer != nil {
 		s.lesServer.SetContractBackend(backend)
@@ -92,9 +183,23 @@ func New(ctx *node.ServiceContext, config *Config) (*BHEereum, error) {
 	if !config.SyncMode.IsValid() {
 		return nil, fmt.Errorf("invalid sync mode %d", config.SyncMode)
 	}
-	if config.Miner.GasPrice == nil || config.Miner.GasPrice.Cmp(common.Big0) <= 0 {
-		log.Warn("Sanitizing invalid miner gas price", "provided", config.Miner.GasPrice, "updated", DefaultConfig.Miner.GasPrice)
-		config.Miner.GasPrice = new(big.Int).Set(DefaultConfig.Miner.GasPrice)
+	if err := sanitizeConfig(config); err != nil {
+		return nil, err
+	}
+	if config.ReadOnly && config.AutoResync {
+		return nil, errors.New("automatic resync requires a writable database")
+	}
+	// Developer chains are generated in memory and kept whole for reverts
+	var dev *developerChain
+	if config.DeveloperMode {
+		var err error
+		if dev, err = newDeveloperChain(config.DeveloperAccounts); err != nil {
+			return nil, err
+		}
+		config.Genesis = dev.genesis()
+		config.NoPruning = true
+		config.SnapshotCache = 0 // State snapshot layers are kept in memory, out of the reverts' reach
+		log.Warn("Running in developer mode", "signer", dev.address(), "accounts", len(dev.accounts))
 	}
 	if config.NoPruning && config.TrieDirtyCache > 0 {
 		if config.SnapshotCache > 0 {
@@ -107,16 +212,43 @@ func New(ctx *node.ServiceContext, config *Config) (*BHEereum, error) {
 	}
 	log.Info("Allocated trie memory caches", "clean", common.StorageSize(config.TrieCleanCache)*1024*1024, "dirty", common.StorageSize(config.TrieDirtyCache)*1024*1024)
 
-	// Assemble the BHEereum object
-	chainDb, err := ctx.OpenDatabaseWithFreezer("chaindata", config.DatabaseCache, config.DatabaseHandles, config.DatabaseFreezer, "BHE/db/chaindata/")
+	rpcAuth, err := newRPCAuth(ctx, config.RPCAuth)
+	if err != nil {
+		return nil, err
+	}
+	rpcACL, err := newRPCACL(ctx, config.RPCACL)
 	if err != nil {
 		return nil, err
 	}
+	rpcAudit, err := newRPCAudit(ctx, config.RPCAudit, rpcAuth)
+	if err != nil {
+		return nil, err
+	}
+	extSigner, err := openExternalSigner(ctx.AccountManager, config.ExternalSigner)
+	if err != nil {
+		return nil, err
+	}
+	lesPolicy, err := newLesPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	bloom := newBloomService(config)
+
+	// Assemble the BHEereum object
+	var chainDb BHEdb.Database
+	if dev != nil {
+		chainDb = rawdb.NewDatabase(dev.db)
+	} else if chainDb, err = openChainDatabase(ctx, config); err != nil {
+		return nil, err
+	}
 	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlock(chainDb, config.Genesis)
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
 	}
 	log.Info("Initialised chain configuration", "config", chainConfig)
+	if err := consensus.CheckHeaderExtension(chainConfig); err != nil {
+		return nil, err
+	}
 
 	BHE := &BHEereum{
 		config:            config,
@@ -128,8 +260,44 @@ func New(ctx *node.ServiceContext, config *Config) (*BHEereum, error) {
 		networkID:         config.NetworkId,
 		gasPrice:          config.Miner.GasPrice,
 		BHEerbase:         config.Miner.BHEerbase,
-		bloomRequests:     make(chan chan *bloombits.Retrieval),
+		bloomRequests:     make(chan chan *bloombits.Retrieval, bloom.queue),
+		bloomService:      bloom,
 		bloomIndexer:      NewBloomIndexer(chainDb, params.BloomBitsBlocks, params.BloomConfirms),
+		sessions:          newSessionManager(),
+		rateLimits:        newRequestLimiter(config.RateLimits, rpcAuth),
+		rpcAuth:           rpcAuth,
+		rpcACL:            rpcACL,
+		rpcAudit:          rpcAudit,
+		extSigner:         extSigner,
+		lesPolicy:         lesPolicy,
+		txAges:            newTxAgeTracker(),
+		equivocations:     newEquivocationDetector(),
+		scheduler:         newTxScheduler(config.TxSchedule),
+		resyncReports:     ctx.ResolvePath(resyncReportFile),
+		receiptTail:       readReceiptTail(chainDb),
+		nodeKeyPath:       ctx.ResolvePath(nodeKeyFile),
+		dev:               dev,
+	}
+	if dev != nil {
+		BHE.BHEerbase = dev.address()
+	}
+	if skewableClock(config) {
+		BHE.clock = new(blockClock)
+	}
+	if config.SealerHook != "" {
+		if err := consensus.InstallSealerHook(BHE.engine, config.SealerHook); err != nil {
+			return nil, err
+		}
+		log.Info("Installed block sealer hook", "hook", config.SealerHook)
+	}
+	if config.TxBump.Blocks > 0 {
+		BHE.bumper = newTxBumper(config.TxBump)
+	}
+	if config.LightDepositContract != (common.Address{}) {
+		BHE.deposits = newDepositTracker(config.LightDepositContract, config.LightDepositThreshold)
+	}
+	if BHE.sponsors, err = newSponsorPool(config.Sponsor, chainConfig.ChainID, BHE.admitSponsoredCall); err != nil {
+		return nil, err
 	}
 
 	bcVersion := rawdb.ReadDatabaseVersion(chainDb)
@@ -143,10 +311,16 @@ func New(ctx *node.ServiceContext, config *Config) (*BHEereum, error) {
 		if bcVersion != nil && *bcVersion > core.BlockChainVersion {
 			return nil, fmt.Errorf("database version is v%d, GBHE %s only supports v%d", *bcVersion, params.VersionWithMeta, core.BlockChainVersion)
 		} else if bcVersion == nil || *bcVersion < core.BlockChainVersion {
+			if config.ReadOnly {
+				return nil, fmt.Errorf("database version %s needs upgrade to v%d, impossible in read-only mode", dbVer, core.BlockChainVersion)
+			}
 			log.Warn("Upgrade blockchain database version", "from", dbVer, "to", core.BlockChainVersion)
 			rawdb.WriteDatabaseVersion(chainDb, core.BlockChainVersion)
 		}
 	}
+	if BHE.extensions, err = vm.ResolveExtensions(chainConfig); err != nil {
+		return nil, err
+	}
 	var (
 		vmConfig = vm.Config{
 			EnablePreimageRecording: config.EnablePreimageRecording,
@@ -166,18 +340,88 @@ func New(ctx *node.ServiceContext, config *Config) (*BHEereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := BHE.setupLogging(config.Logging); err != nil {
+		return nil, err
+	}
 	// Rewind the chain in case of an incompatible config upgrade.
 	if compat, ok := genesisErr.(*params.ConfigCompatError); ok {
+		if config.ReadOnly {
+			return nil, fmt.Errorf("chain needs rewind to upgrade configuration, impossible in read-only mode: %v", compat)
+		}
 		log.Warn("Rewinding chain to upgrade configuration", "err", compat)
 		BHE.blockchain.SBHEead(compat.RewindTo)
 		rawdb.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
-	BHE.bloomIndexer.Start(BHE.blockchain)
+	if config.IntegrityCheckBlocks > 0 {
+		if err := BHE.rollbackInconsistentBlocks(config.IntegrityCheckBlocks); err != nil {
+			return nil, err
+		}
+	}
+	if !config.ReadOnly {
+		BHE.bloomIndexer.Start(newFastSyncIndexerChain(BHE.blockchain, chainDb))
+	}
+	BHE.events = newEventSequencer(BHE.blockchain)
+
+	var guards []reorgGuard
+	if config.CliqueFinality {
+		if engine, ok := BHE.engine.(*clique.Clique); ok {
+			BHE.finality = newCliqueFinality(BHE.blockchain, engine)
+			guards = append(guards, BHE.finality.checkReorg)
+		}
+	}
+	if config.MaxReorgDepth > 0 {
+		BHE.reorgLimit = newReorgLimiter(config.MaxReorgDepth, BHE.blockchain.CurrentHeader)
+		guards = append(guards, BHE.reorgLimit.checkReorg)
+	}
+	if len(guards) > 0 {
+		BHE.blockchain.SetReorgGuard(combineReorgGuards(guards...))
+	}
+	// Difficulty is meaningless on clique, the hashrate can only be watched on PoW
+	if config.AttackMonitor.Window > 0 {
+		if _, ok := BHE.engine.(*clique.Clique); !ok {
+			BHE.attacks = newAttackMonitor(config.AttackMonitor)
+		}
+	}
+
+	if config.MinerLock != "" && !config.ReadOnly {
+		lock, ok := lookupMinerLock(config.MinerLock)
+		if !ok {
+			lock = newFileMinerLock(ctx.ResolvePath(config.MinerLock))
+		}
+		BHE.failover = newMinerFailover(config.MinerLock, lock, config.MinerLockTTL)
+	}
 
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
 	BHE.txPool = core.NewTxPool(config.TxPool, chainConfig, BHE.blockchain)
+	if config.TxPool.RemoteJournal != "" && !config.ReadOnly {
+		BHE.remoteJournal = ctx.ResolvePath(config.TxPool.RemoteJournal)
+	}
+
+	var signingAuditPath string
+	if config.SigningAudit != "" {
+		signingAuditPath = ctx.ResolvePath(config.SigningAudit)
+	}
+	if BHE.signingAudit, err = openSigningAudit(signingAuditPath); err != nil {
+		return nil, fmt.Errorf("failed to open signing audit trail: %v", err)
+	}
+	var denyListPath string
+	if config.DenyList != "" {
+		denyListPath = ctx.ResolvePath(config.DenyList)
+	}
+	if BHE.denyList, err = loadDenyList(denyListPath); err != nil {
+		return nil, fmt.Errorf("failed to load deny-list: %v", err)
+	}
+	BHE.spam = newSpamGuard(config.TxPool.SenderRateLimit, config.TxPool.SenderRateWindow, config.TxPool.MinBalanceMultiple)
+
+	var screeningJournal string
+	if config.Screening.Journal != "" {
+		screeningJournal = ctx.ResolvePath(config.Screening.Journal)
+	}
+	if BHE.screener, err = newTxScreener(config.Screening, screeningJournal); err != nil {
+		return nil, err
+	}
 
 	// Permit the downloader to use the trie cache allowance during fast sync
 	cacheLimit := cacheConfig.TrieCleanLimit + cacheConfig.TrieDirtyLimit + cacheConfig.SnapshotLimit
@@ -185,13 +429,50 @@ func New(ctx *node.ServiceContext, config *Config) (*BHEereum, error) {
 	if checkpoint == nil {
 		checkpoint = params.TrustedCheckpoints[genesisHash]
 	}
-	if BHE.protocolManager, err = NewProtocolManager(chainConfig, checkpoint, config.SyncMode, config.NetworkId, BHE.eventMux, BHE.txPool, BHE.engine, BHE.blockchain, chainDb, cacheLimit, config.Whitelist); err != nil {
+	BHE.ancients = newAncientServer(config.AncientServe, chainDb)
+	BHE.proofs = newProofServer(config.ProofServe, BHE.blockchain, BHE.proveHeader)
+	BHE.propagation = newPropagationTracer()
+	BHE.bandwidth = newBandwidthThrottle(config.Bandwidth)
+	if BHE.protocolManager, err = NewProtocolManager(chainConfig, checkpoint, config.SyncMode, config.NetworkId, BHE.eventMux, BHE.txPool, BHE.engine, BHE.blockchain, chainDb, cacheLimit, config.Whitelist, BHE.ancients, BHE.proofs, BHE.propagation, BHE.bandwidth); err != nil {
 		return nil, err
 	}
+	if config.Bridge != nil {
+		engine := CreateConsensusEngine(ctx, config.Bridge.ChainConfig, &config.BHEash, nil, false, rawdb.NewMemoryDatabase())
+		if BHE.bridge, err = newForeignChain(config.Bridge, engine); err != nil {
+			return nil, err
+		}
+	}
+	if config.StateServer.ListenAddr != "" {
+		BHE.stateServer = newStateServer(BHE, config.StateServer)
+	}
+	if config.DatabaseServer != "" {
+		BHE.dbServer = newDatabaseServer(chainDb, config.DatabaseServer)
+	}
 	BHE.miner = miner.New(BHE, &config.Miner, chainConfig, BHE.EventMux(), BHE.engine, BHE.isLocalBlock)
-	BHE.miner.SetExtra(makeExtraData(config.Miner.ExtraData))
+	extra := config.Miner.ExtraData
+	if config.ExtraDataTemplate != "" {
+		extra = expandExtraData(config.ExtraDataTemplate, ctx.Config.Name, config.PoolTag)
+	}
+	BHE.miner.SetExtra(makeExtraData(extra, chainConfig))
+	BHE.minerTiming = newMinerTiming(config)
+	BHE.minerTiming.apply(BHE.miner)
+	if BHE.coinbases, err = newCoinbaseSchedule(config.Coinbases, config.CoinbaseRotation, config.CoinbaseRotationBlocks); err != nil {
+		return nil, err
+	}
 
-	BHE.APIBackend = &BHEAPIBackend{ctx.ExtRPCEnabled(), BHE, nil}
+	BHE.APIBackend = &BHEAPIBackend{
+		extRPCEnabled: ctx.ExtRPCEnabled(),
+		BHE:           BHE,
+		snapReads:     newSnapReader(config.SnapshotReadCheck),
+		evms:          newEVMLimiter(config.RPCMaxEVMs, config.RPCEVMTimeout),
+		calls:         newCallCache(config.RPCCallCache),
+		cache:         newChainCache(BHE.blockchain, BHE.events, config.RPCChainCache),
+	}
+	if config.Tracing != "" {
+		if BHE.APIBackend.tracer, err = lookupSpanTracer(config.Tracing); err != nil {
+			return nil, err
+		}
+	}
 	gpoParams := config.GPO
 	if gpoParams.Default == nil {
 		gpoParams.Default = config.Miner.GasPrice
@@ -202,11 +483,14 @@ func New(ctx *node.ServiceContext, config *Config) (*BHEereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.Dial.LatencyProbes > 0 {
+		BHE.dialCandidates = newLatencyIterator(BHE.dialCandidates, config.Dial)
+	}
 
 	return BHE, nil
 }
 
-func makeExtraData(extra []byte) []byte {
+func makeExtraData(extra []byte, config *params.ChainConfig) []byte {
 	if len(extra) == 0 {
 		// create default extradata
 		extra, _ = rlp.EncodeToBytes([]interface{}{
@@ -216,8 +500,8 @@ func makeExtraData(extra []byte) []byte {
 			runtime.GOOS,
 		})
 	}
-	if uint64(len(extra)) > params.MaximumExtraDataSize {
-		log.Warn("Miner extra data exceed limit", "extra", hexutil.Bytes(extra), "limit", params.MaximumExtraDataSize)
+	if err := checkExtraData(config, extra); err != nil {
+		log.Warn("Miner extra data rejected", "extra", hexutil.Bytes(extra), "err", err)
 		extra = nil
 	}
 	return extra
@@ -264,6 +548,11 @@ func (s *BHEereum) APIs() []rpc.API {
 	// Append any APIs exposed explicitly by the les server
 	if s.lesServer != nil {
 		apis = append(apis, s.lesServer.APIs()...)
+		apis = append(apis, rpc.API{
+			Namespace: "les",
+			Version:   "1.0",
+			Service:   NewPrivateLesAPI(s),
+		})
 	}
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
@@ -273,8 +562,32 @@ func (s *BHEereum) APIs() []rpc.API {
 		apis = append(apis, s.lesServer.APIs()...)
 	}
 
-	// Append all the local APIs and return
-	return append(apis, []rpc.API{
+	// Append the test node controls of a developer chain
+	if s.clock != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "evm",
+			Version:   "1.0",
+			Service:   NewPrivateClockAPI(s),
+		})
+	}
+	if s.dev != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "evm",
+			Version:   "1.0",
+			Service:   NewDeveloperAPI(s),
+		})
+	}
+	// Append the cross-chain verifier if a foreign network is configured
+	if s.bridge != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "bridge",
+			Version:   "1.0",
+			Service:   NewPublicBridgeAPI(s.bridge),
+			Public:    true,
+		})
+	}
+	// Append all the local APIs
+	apis = append(apis, []rpc.API{
 		{
 			Namespace: "BHE",
 			Version:   "1.0",
@@ -320,6 +633,17 @@ func (s *BHEereum) APIs() []rpc.API {
 			Public:    true,
 		},
 	}...)
+
+	// Drop the namespaces denied to everyone by the access control list
+	filtered := apis[:0]
+	for _, api := range apis {
+		if s.rpcACL.namespaceDenied(api.Namespace) {
+			log.Info("Namespace disabled by RPC ACL", "namespace", api.Namespace)
+			continue
+		}
+		filtered = append(filtered, api)
+	}
+	return filtered
 }
 
 func (s *BHEereum) ResetWithGenesisBlock(gb *types.Block) {
@@ -334,17 +658,13 @@ func (s *BHEereum) BHEerbase() (eb common.Address, err error) {
 	if BHEerbase != (common.Address{}) {
 		return BHEerbase, nil
 	}
-	if wallets := s.AccountManager().Wallets(); len(wallets) > 0 {
-		if accounts := wallets[0].Accounts(); len(accounts) > 0 {
-			BHEerbase := accounts[0].Address
-
-			s.lock.Lock()
-			s.BHEerbase = BHEerbase
-			s.lock.Unlock()
+	if BHEerbase, ok := s.signerAccount(); ok {
+		s.lock.Lock()
+		s.BHEerbase = BHEerbase
+		s.lock.Unlock()
 
-			log.Info("BHEerbase automatically configured", "address", BHEerbase)
-			return BHEerbase, nil
-		}
+		log.Info("BHEerbase automatically configured", "address", BHEerbase)
+		return BHEerbase, nil
 	}
 	return common.Address{}, fmt.Errorf("BHEerbase must be explicitly specified")
 }
@@ -367,6 +687,13 @@ func (s *BHEereum) isLocalBlock(block *types.Block) bool {
 	if author == BHEerbase {
 		return true
 	}
+	// Check whBHEer the given address is one of the rotated BHEerbases.
+	s.lock.RLock()
+	coinbases := s.coinbases
+	s.lock.RUnlock()
+	if coinbases != nil && coinbases.contains(author) {
+		return true
+	}
 	// Check whBHEer the given address is specified by `txpool.local`
 	// CLI flag.
 	for _, account := range s.config.TxPool.Locals {
@@ -403,10 +730,11 @@ func (s *BHEereum) shouldPreserve(block *types.Block) bool {
 	return s.isLocalBlock(block)
 }
 
-// SetBHEerbase sets the mining reward address.
+// SetBHEerbase sets the mining reward address, disabling any rotation.
 func (s *BHEereum) SetBHEerbase(BHEerbase common.Address) {
 	s.lock.Lock()
 	s.BHEerbase = BHEerbase
+	s.coinbases = nil
 	s.lock.Unlock()
 
 	s.miner.SetBHEerbase(BHEerbase)
@@ -415,7 +743,27 @@ func (s *BHEereum) SetBHEerbase(BHEerbase common.Address) {
 // StartMining starts the miner with the given number of CPU threads. If mining
 // is already running, this mBHEod adjust the number of threads allowed to use
 // and updates the minimum price required by the transaction pool.
+//
+// If the node shares its validator with a redundant one through a miner lock,
+// it stands by instead, sealing only while holding the lock.
 func (s *BHEereum) StartMining(threads int) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if s.dev != nil {
+		return errDeveloperMode
+	}
+	if s.automining() {
+		return errAutomining
+	}
+	if s.failover != nil {
+		return s.failover.start(s, threads)
+	}
+	return s.startMining(threads)
+}
+
+// startMining starts the miner regardless of the miner lock.
+func (s *BHEereum) startMining(threads int) error {
 	// Update the thread count within the consensus engine
 	type threaded interface {
 		SetThreads(threads int)
@@ -442,12 +790,15 @@ func (s *BHEereum) StartMining(threads int) error {
 			return fmt.Errorf("BHEerbase missing: %v", err)
 		}
 		if clique, ok := s.engine.(*clique.Clique); ok {
-			wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
+			wallet, err := s.signerWallet(eb)
 			if wallet == nil || err != nil {
 				log.Error("BHEerbase account unavailable locally", "err", err)
 				return fmt.Errorf("signer missing: %v", err)
 			}
-			clique.Authorize(eb, wallet.SignData)
+			clique.Authorize(eb, s.signingAudit.wrap("clique", wallet.SignData))
+			if isHardwareWallet(wallet) {
+				s.watchHardwareSigner(clique, eb, wallet)
+			}
 		}
 		// If mining is started, we can disable the transaction rejection mechanism
 		// introduced to speed sync times.
@@ -461,6 +812,14 @@ func (s *BHEereum) StartMining(threads int) error {
 // StopMining terminates the miner, both at the consensus engine level as well as
 // at the block creation level.
 func (s *BHEereum) StopMining() {
+	if s.failover != nil {
+		s.failover.stop()
+	}
+	s.stopMining()
+}
+
+// stopMining stops the miner without giving up the miner lock.
+func (s *BHEereum) stopMining() {
 	// Update the thread count within the consensus engine
 	type threaded interface {
 		SetThreads(threads int)
@@ -506,10 +865,91 @@ func (s *BHEereum) Protocols() []p2p.Protocol {
 // Start implements node.Service, starting all internal goroutines needed by the
 // BHEereum protocol implementation.
 func (s *BHEereum) Start(srvr *p2p.Server) error {
+	s.p2pServer = srvr
 	s.startBHEEntryUpdate(srvr.LocalNode())
+	s.startNodeRecord(srvr)
 
-	// Start the bloom bits servicing goroutines
-	s.startBloomHandlers(params.BloomBitsBlocks)
+	// Start the bloom bits servicing goroutines and the API event sequencer
+	s.startBloomService(params.BloomBitsBlocks)
+	s.events.start()
+
+	// Start recording block access lists if requested
+	if s.config.AccessLists && !s.config.ReadOnly {
+		s.startAccessLists()
+	}
+	// Start sealing the developer chain on demand
+	if s.dev != nil {
+		s.startDeveloperChain()
+	}
+	// Start recording stateless block witnesses if requested
+	if s.config.Witnesses && !s.config.ReadOnly {
+		s.startWitnesses()
+	}
+	// Start evicting transactions outliving their lifetime
+	if !s.config.ReadOnly {
+		s.startTxEviction()
+	}
+	// Start holding the pool to its size and gas limits
+	if !s.config.ReadOnly {
+		s.startPoolLimits()
+	}
+	// Start activating scheduled transactions as their predecessors arrive
+	if !s.config.ReadOnly {
+		s.startTxScheduler()
+	}
+	// Start relaying sponsored calls if the experiment is enabled
+	if s.sponsors != nil && !s.config.ReadOnly {
+		s.startSponsorRelay()
+	}
+	// Start bumping the gas price of stuck local transactions if requested
+	if s.bumper != nil && !s.config.ReadOnly {
+		s.startTxBumper()
+	}
+	// Reload the remote transactions journaled on the last shutdown
+	if s.remoteJournal != "" {
+		if err := s.loadRemoteTxs(s.remoteJournal); err != nil {
+			log.Warn("Failed to load remote transaction journal", "err", err)
+		}
+	}
+	// Start tracking light client deposits if a deposit contract is configured
+	if s.deposits != nil {
+		s.startDepositTracking()
+	}
+	// Start maintaining the issuance index if requested
+	if s.config.IssuanceIndex && !s.config.ReadOnly {
+		s.startIssuanceIndex()
+	}
+	// Start indexing token transfers if requested
+	if s.config.TokenIndex && !s.config.ReadOnly {
+		s.startTokenIndex()
+	}
+	// Index the helper tries for light clients, unless the light server does
+	if s.config.CHTIndex && s.lesServer == nil && !s.config.ReadOnly {
+		s.startHelperTries()
+	}
+	// Start watching for signers sealing conflicting blocks
+	s.startEquivocationDetection()
+
+	// Start watching for competing chains rivalling the canonical one if requested
+	if s.attacks != nil {
+		s.startAttackMonitor()
+	}
+
+	// Start recording the imports of blocks traced on their way in
+	s.startPropagationTracing()
+
+	// Start tracking the finalized head of clique chains if requested
+	if s.finality != nil {
+		s.startFinality()
+	}
+	// Start signing the checkpoints of completed sections if a signer is configured
+	if s.config.CheckpointOracle != nil && s.config.CheckpointSigner != (common.Address{}) && !s.config.ReadOnly {
+		s.startCheckpointPublisher(s.config.CheckpointSigner)
+	}
+
+	// Rotate the BHEerbase along the chain head if multiple are configured
+	s.startCoinbaseRotation()
+	s.rotateBHEerbase(s.blockchain.CurrentBlock().NumberU64())
 
 	// Start the RPC service
 	s.netRPCService = BHEapi.NewPublicNetAPI(srvr, s.NetVersion())
@@ -522,31 +962,172 @@ func (s *BHEereum) Start(srvr *p2p.Server) error {
 		}
 		maxPeers -= s.config.LightPeers
 	}
+	// A read-only node can't import anything the network would send it
+	if s.config.ReadOnly {
+		log.Info("Read-only mode, not accepting peers")
+		maxPeers = 0
+	}
 	// Start the networking layer and the light server if requested
 	s.protocolManager.Start(maxPeers)
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	if s.stateServer != nil {
+		if err := s.stateServer.start(); err != nil {
+			return err
+		}
+	}
+	if s.dbServer != nil {
+		if err := s.dbServer.start(); err != nil {
+			return err
+		}
+	}
+	// Prune the ancient receipts outside the retention if requested
+	if s.config.AncientReceiptRetention > 0 && !s.config.ReadOnly {
+		s.startReceiptPruning()
+	}
+	// Follow the chain head of the node syncing a shared remote database
+	if s.config.RemoteDatabase != "" {
+		s.startReplicaFollower()
+	}
+	// Watch for chain imports stalling despite connected peers
+	if s.config.StallWatch.Timeout > 0 && !s.config.ReadOnly {
+		s.startStallWatch(s.config.StallWatch)
+	}
+	// Reload the configuration file on SIGHUP if one is configured
+	if s.config.ReloadFile != "" {
+		s.startConfigReload(s.config.ReloadFile)
+	}
 	return nil
 }
 
 // Stop implements node.Service, terminating all internal goroutines used by the
 // BHEereum protocol.
 func (s *BHEereum) Stop() error {
+	// Let the running RPC executions finish and refuse new ones
+	config := s.config.Shutdown.sanitize()
+	if active := s.drainer.drain(config.DrainTimeout); active > 0 {
+		log.Warn("Abandoning in-flight RPC executions", "active", active, "timeout", config.DrainTimeout)
+	}
 	// Stop all the peer-related stuff first.
+	s.stateServer.stop()
+	s.dbServer.stop()
+	if s.replicaQuit != nil {
+		close(s.replicaQuit)
+	}
+	if s.receiptsQuit != nil {
+		close(s.receiptsQuit)
+	}
+	if s.reloadQuit != nil {
+		close(s.reloadQuit)
+	}
+	if s.stalls.sub != nil {
+		s.stalls.sub.Unsubscribe()
+	}
+	s.stalls.scope.Close()
+	s.bandwidth.close()
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
 		s.lesServer.Stop()
 	}
+	// Stop sealing before anything still writes into the chain is torn down
+	if s.failover != nil {
+		s.failover.stop()
+	}
+	s.miner.Stop()
 
 	// Then stop everything else.
-	s.bloomIndexer.Close()
+	if s.accessListSub != nil {
+		s.accessListSub.Unsubscribe()
+	}
+	if s.witnessSub != nil {
+		s.witnessSub.Unsubscribe()
+	}
+	s.lock.Lock()
+	if s.automine != nil {
+		s.automine.sub.Unsubscribe()
+		s.automine = nil
+	}
+	s.lock.Unlock()
+	if s.issuanceSub != nil {
+		s.issuanceSub.Unsubscribe()
+	}
+	if s.depositSub != nil {
+		s.depositSub.Unsubscribe()
+	}
+	if s.tokenIndexSub != nil {
+		s.tokenIndexSub.Unsubscribe()
+	}
+	if s.checkpointSub != nil {
+		s.checkpointSub.Unsubscribe()
+	}
+	if s.finalitySub != nil {
+		s.finalitySub.Unsubscribe()
+	}
+	s.equivocationSub.Unsubscribe()
+	s.propagationSub.Unsubscribe()
+	s.equivocations.scope.Close()
+	if s.attacks != nil {
+		s.attackSub.Unsubscribe()
+		s.attacks.scope.Close()
+	}
+	if s.finality != nil {
+		s.finality.scope.Close()
+	}
+	if s.reorgLimit != nil {
+		s.reorgLimit.scope.Close()
+	}
+	s.stopTxLookupJob()
+	s.stopDatabaseMigration()
+	s.coinbaseSub.Unsubscribe()
+	s.lock.Lock()
+	if s.hwSignerSub != nil {
+		s.hwSignerSub.Unsubscribe()
+	}
+	s.lock.Unlock()
+	if s.chtIndexer != nil {
+		s.chtIndexer.Close()
+	}
+	s.bloomIndexer.Close() // Closes the chained bloom trie indexer too
 	close(s.closeBloomHandler)
+	s.events.stop()
+	if s.txAgeSub != nil {
+		s.txAgeSub.Unsubscribe()
+	}
+	if s.poolLimitSub != nil {
+		s.poolLimitSub.Unsubscribe()
+	}
+	if s.schedulerSub != nil {
+		s.schedulerSub.Unsubscribe()
+	}
+	if s.sponsorSub != nil {
+		s.sponsorSub.Unsubscribe()
+	}
+	if s.bumperSub != nil {
+		s.bumperSub.Unsubscribe()
+	}
+	s.scheduler.scope.Close()
+	if s.remoteJournal != "" {
+		if err := s.saveRemoteTxs(s.remoteJournal); err != nil {
+			log.Warn("Failed to journal remote transactions", "err", err)
+		}
+	}
 	s.txPool.Stop()
-	s.miner.Stop()
-	s.blockchain.Stop()
+	s.screener.close()
+
+	// Flush the chain state last, with all writers gone
+	flushed := s.flushChain(config.FlushTimeout)
 	s.engine.Close()
-	s.chainDb.Close()
+	if s.bridge != nil {
+		s.bridge.engine.Close()
+	}
+	s.signingAudit.close()
+	s.rpcAudit.close()
+	s.profiler.end() // Flush a CPU profile left running, if any
 	s.eventMux.Stop()
+	if !flushed {
+		return errors.New("chain state flush timed out, database left open")
+	}
+	s.chainDb.Close()
 	return nil
 }
@@ -0,0 +1,230 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	bandwidthDownloadMeter = metrics.NewRegisteredMeter("BHE/bandwidth/download", nil) // Sync bytes received
+	bandwidthUploadMeter   = metrics.NewRegisteredMeter("BHE/bandwidth/upload", nil)   // Sync bytes served
+	bandwidthDelayTimer    = metrics.NewRegisteredTimer("BHE/bandwidth/delay", nil)    // Time messages were held back
+)
+
+// BandwidthLimit is an allowance of sync traffic.
+type BandwidthLimit struct {
+	Download uint64 `json:"download"` // Bytes per second received, unlimited if zero
+	Upload   uint64 `json:"upload"`   // Bytes per second served, unlimited if zero
+}
+
+// BandwidthWindow is a time of day range with its own allowance, in local time
+// as HH:MM. Windows ending before they start wrap around midnight.
+type BandwidthWindow struct {
+	From  string
+	To    string
+	Limit BandwidthLimit
+}
+
+// BandwidthConfig contains the allowances of sync traffic, for nodes running on
+// metered or shared connections.
+type BandwidthConfig struct {
+	Limit    BandwidthLimit    // Allowance outside of the scheduled windows
+	Schedule []BandwidthWindow // Time of day windows overriding the allowance, the first match applies
+}
+
+// bandwidthWindow is a parsed schedule window, in minutes of the day.
+type bandwidthWindow struct {
+	from, to int
+	limit    BandwidthLimit
+}
+
+// parseTimeOfDay parses an HH:MM time of day into minutes.
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether the window covers a minute of the day.
+func (w bandwidthWindow) contains(minute int) bool {
+	if w.from <= w.to {
+		return minute >= w.from && minute < w.to
+	}
+	return minute >= w.from || minute < w.to
+}
+
+// byteBucket is a token bucket of bytes, refilled at the allowed rate up to one
+// second worth of traffic. Messages are never split, so the bucket may go into
+// debt, which later messages wait out.
+type byteBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes n bytes from the bucket, returning how long the caller has to
+// wait for them to be covered by the allowance.
+func (b *byteBucket) reserve(n int, rate uint64, now time.Time) time.Duration {
+	if rate == 0 {
+		b.tokens, b.last = 0, now
+		return 0
+	}
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	}
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+	b.last = now
+
+	if b.tokens -= float64(n); b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// bandwidthThrottle holds back sync traffic exceeding the allowance in effect:
+// the runtime override if set, the scheduled window covering the time of day,
+// or the default allowance. The protocol handler reports the sync messages
+// received and sent, blocking on them as needed.
+type bandwidthThrottle struct {
+	config   BandwidthConfig
+	windows  []bandwidthWindow
+	override *BandwidthLimit // Runtime allowance set through the admin API
+
+	down byteBucket
+	up   byteBucket
+	lock sync.Mutex
+	quit chan struct{}
+}
+
+func newBandwidthThrottle(config BandwidthConfig) *bandwidthThrottle {
+	t := &bandwidthThrottle{config: config, quit: make(chan struct{})}
+	for _, window := range config.Schedule {
+		from, err := parseTimeOfDay(window.From)
+		if err == nil {
+			var to int
+			if to, err = parseTimeOfDay(window.To); err == nil {
+				t.windows = append(t.windows, bandwidthWindow{from: from, to: to, limit: window.Limit})
+				continue
+			}
+		}
+		log.Warn("Ignoring invalid bandwidth window", "from", window.From, "to", window.To, "err", err)
+	}
+	return t
+}
+
+// limit returns the allowance in effect at the given time, and where it comes
+// from.
+func (t *bandwidthThrottle) limit(now time.Time) (BandwidthLimit, string) {
+	if t.override != nil {
+		return *t.override, "override"
+	}
+	minute := now.Hour()*60 + now.Minute()
+	for _, window := range t.windows {
+		if window.contains(minute) {
+			return window.limit, "schedule"
+		}
+	}
+	return t.config.Limit, "default"
+}
+
+// throttle accounts for a sync message of n bytes, blocking until it's covered
+// by the allowance or the throttle is closed.
+func (t *bandwidthThrottle) throttle(upload bool, n int) {
+	t.lock.Lock()
+	limit, _ := t.limit(time.Now())
+	var delay time.Duration
+	if upload {
+		bandwidthUploadMeter.Mark(int64(n))
+		delay = t.up.reserve(n, limit.Upload, time.Now())
+	} else {
+		bandwidthDownloadMeter.Mark(int64(n))
+		delay = t.down.reserve(n, limit.Download, time.Now())
+	}
+	t.lock.Unlock()
+
+	if delay <= 0 {
+		return
+	}
+	bandwidthDelayTimer.Update(delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-t.quit:
+	}
+}
+
+// received holds back a sync message received from a peer.
+func (t *bandwidthThrottle) received(n int) { t.throttle(false, n) }
+
+// sent holds back a sync message served to a peer.
+func (t *bandwidthThrottle) sent(n int) { t.throttle(true, n) }
+
+// close releases the messages held back.
+func (t *bandwidthThrottle) close() {
+	close(t.quit)
+}
+
+// BandwidthStatus is the sync traffic allowance in effect.
+type BandwidthStatus struct {
+	Limit    BandwidthLimit `json:"limit"`
+	Source   string         `json:"source"` // Where the allowance comes from: override, schedule or default
+	Download float64        `json:"download"`
+	Upload   float64        `json:"upload"`
+}
+
+// Bandwidth returns the sync traffic allowance in effect, along with the recent
+// traffic in bytes per second if metrics are enabled.
+func (api *PrivateAdminAPI) Bandwidth() *BandwidthStatus {
+	t := api.BHE.bandwidth
+
+	t.lock.Lock()
+	limit, source := t.limit(time.Now())
+	t.lock.Unlock()
+
+	return &BandwidthStatus{
+		Limit:    limit,
+		Source:   source,
+		Download: bandwidthDownloadMeter.Rate1(),
+		Upload:   bandwidthUploadMeter.Rate1(),
+	}
+}
+
+// SetBandwidthLimit overrides the scheduled sync traffic allowance until the
+// node is restarted, or until cleared with a null limit.
+func (api *PrivateAdminAPI) SetBandwidthLimit(limit *BandwidthLimit) *BandwidthStatus {
+	t := api.BHE.bandwidth
+
+	t.lock.Lock()
+	t.override = limit
+	t.lock.Unlock()
+
+	if limit == nil {
+		log.Info("Cleared bandwidth override")
+	} else {
+		log.Info("Overrode bandwidth limits", "download", limit.Download, "upload", limit.Upload)
+	}
+	return api.Bandwidth()
+}
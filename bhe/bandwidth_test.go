@@ -0,0 +1,89 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the byte bucket lets a second worth of traffic through right away
+// and holds back the excess until covered by the allowance.
+func TestByteBucket(t *testing.T) {
+	var (
+		bucket byteBucket
+		now    = time.Unix(1600000000, 0)
+	)
+	if delay := bucket.reserve(1000, 0, now); delay != 0 {
+		t.Fatalf("unlimited traffic delayed by %v", delay)
+	}
+	// A fresh bucket starts empty, so the first message waits for its bytes
+	if delay := bucket.reserve(500, 1000, now); delay != 500*time.Millisecond {
+		t.Fatalf("first message delay mismatch: have %v, want 500ms", delay)
+	}
+	// After a long pause the bucket refills up to a second worth of traffic only
+	now = now.Add(time.Minute)
+	if delay := bucket.reserve(1000, 1000, now); delay != 0 {
+		t.Fatalf("burst delayed by %v", delay)
+	}
+	if delay := bucket.reserve(2000, 1000, now); delay != 2*time.Second {
+		t.Fatalf("excess delay mismatch: have %v, want 2s", delay)
+	}
+	now = now.Add(2 * time.Second)
+	if delay := bucket.reserve(100, 1000, now); delay != 100*time.Millisecond {
+		t.Fatalf("post-debt delay mismatch: have %v, want 100ms", delay)
+	}
+}
+
+// Tests that the allowance in effect is the override, then the first scheduled
+// window covering the time of day, then the default one.
+func TestBandwidthLimit(t *testing.T) {
+	throttle := newBandwidthThrottle(BandwidthConfig{
+		Limit: BandwidthLimit{Download: 1},
+		Schedule: []BandwidthWindow{
+			{From: "22:00", To: "06:00", Limit: BandwidthLimit{Download: 2}},
+			{From: "09:00", To: "17:00", Limit: BandwidthLimit{Download: 3}},
+			{From: "12:00", To: "13:00", Limit: BandwidthLimit{Download: 4}},
+			{From: "25:00", To: "26:00", Limit: BandwidthLimit{Download: 5}},
+		},
+	})
+	if len(throttle.windows) != 3 {
+		t.Fatalf("window count mismatch: have %d, want 3", len(throttle.windows))
+	}
+	tests := []struct {
+		at     string
+		limit  uint64
+		source string
+	}{
+		{"23:30", 2, "schedule"},
+		{"00:00", 2, "schedule"},
+		{"06:00", 1, "default"},
+		{"12:30", 3, "schedule"},
+		{"17:00", 1, "default"},
+	}
+	for _, tt := range tests {
+		at, _ := time.ParseInLocation("15:04", tt.at, time.Local)
+		limit, source := throttle.limit(at)
+		if limit.Download != tt.limit || source != tt.source {
+			t.Errorf("%s: limit mismatch: have %d (%s), want %d (%s)", tt.at, limit.Download, source, tt.limit, tt.source)
+		}
+	}
+	throttle.override = &BandwidthLimit{Download: 9}
+	if limit, source := throttle.limit(time.Now()); limit.Download != 9 || source != "override" {
+		t.Errorf("override mismatch: have %d (%s), want 9 (override)", limit.Download, source)
+	}
+}
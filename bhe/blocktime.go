@@ -0,0 +1,138 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxClockSkew is the furthest the block clock may be skewed either way, well
+// within the range of time.Duration.
+const maxClockSkew = 100 * 365 * 24 * time.Hour
+
+var errClockSkewTooLarge = errors.New("block clock skew exceeds 100 years")
+
+// blockClock is the clock block timestamps are taken from on test networks,
+// skewed against the wall clock so time dependent contract logic can be tested
+// without waiting for it.
+type blockClock struct {
+	offset time.Duration
+	lock   sync.RWMutex
+}
+
+// now returns the skewed time.
+func (c *blockClock) now() time.Time {
+	return time.Now().Add(c.skew())
+}
+
+// skew returns the offset of the clock against the wall clock.
+func (c *blockClock) skew() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.offset
+}
+
+// set replaces the offset of the clock.
+func (c *blockClock) set(offset time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.offset = offset
+}
+
+// increase advances the clock, returning the new offset. The clock is left as
+// it was if the offset would exceed the maximum skew.
+func (c *blockClock) increase(delta time.Duration) (time.Duration, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if delta > maxClockSkew-c.offset {
+		return c.offset, errClockSkewTooLarge
+	}
+	c.offset += delta
+	return c.offset, nil
+}
+
+// skewableClock returns whether the consensus mode of a node tolerates skewed
+// block timestamps: developer chains and fully fake proof-of-work, which no real
+// network runs on. Fake and test proof-of-work still reject blocks too far in
+// the future, so peers would refuse every block sealed after a forward skew.
+func skewableClock(config *Config) bool {
+	return config.DeveloperMode || config.BHEash.PowMode == BHEash.ModeFullFake
+}
+
+// setClockSkew replaces the offset of the block clock.
+func (s *BHEereum) setClockSkew(offset time.Duration) {
+	s.clock.set(offset)
+	s.applyClockSkew(offset)
+}
+
+// applyClockSkew hands the offset of the block clock to the miner, whose block
+// timestamps stay ahead of the parent's regardless.
+func (s *BHEereum) applyClockSkew(offset time.Duration) {
+	s.miner.SetTimeOffset(offset)
+	log.Info("Skewed block clock", "offset", offset)
+}
+
+// PrivateClockAPI offers the block timestamp controls of test networks.
+type PrivateClockAPI struct {
+	BHE *BHEereum
+}
+
+// NewPrivateClockAPI creates a new block clock control API.
+func NewPrivateClockAPI(BHE *BHEereum) *PrivateClockAPI {
+	return &PrivateClockAPI{BHE: BHE}
+}
+
+// IncreaseTime advances the timestamps of all later blocks by the given number
+// of seconds, returning the total skew against the wall clock. The skew is
+// bounded to 100 years.
+func (api *PrivateClockAPI) IncreaseTime(seconds hexutil.Uint64) (int64, error) {
+	if uint64(seconds) > uint64(maxClockSkew/time.Second) {
+		return 0, errClockSkewTooLarge
+	}
+	offset, err := api.BHE.clock.increase(time.Duration(seconds) * time.Second)
+	if err != nil {
+		return 0, err
+	}
+	api.BHE.applyClockSkew(offset)
+	return int64(offset / time.Second), nil
+}
+
+// SetTime sets the block clock to the given unix timestamp, skewing the
+// timestamps of all later blocks by the difference to the wall clock. It returns
+// the skew in seconds, bounded to 100 years either way.
+func (api *PrivateClockAPI) SetTime(timestamp hexutil.Uint64) (int64, error) {
+	if uint64(timestamp) > math.MaxInt64 {
+		return 0, errClockSkewTooLarge
+	}
+	offset := time.Until(time.Unix(int64(timestamp), 0)).Round(time.Second)
+	if offset > maxClockSkew || offset < -maxClockSkew {
+		return 0, errClockSkewTooLarge
+	}
+	api.BHE.setClockSkew(offset)
+	return int64(offset / time.Second), nil
+}
+
+// Time returns the current time of the block clock as a unix timestamp.
+func (api *PrivateClockAPI) Time() hexutil.Uint64 {
+	return hexutil.Uint64(api.BHE.clock.now().Unix())
+}
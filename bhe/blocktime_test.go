@@ -0,0 +1,84 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Tests that the block clock is only skewable in consensus modes that don't
+// verify block timestamps.
+func TestSkewableClock(t *testing.T) {
+	tests := []struct {
+		config   Config
+		skewable bool
+	}{
+		{Config{}, false},
+		{Config{BHEash: BHEash.Config{PowMode: BHEash.ModeNormal}}, false},
+		{Config{BHEash: BHEash.Config{PowMode: BHEash.ModeShared}}, false},
+		{Config{BHEash: BHEash.Config{PowMode: BHEash.ModeTest}}, false},
+		{Config{BHEash: BHEash.Config{PowMode: BHEash.ModeFake}}, false},
+		{Config{BHEash: BHEash.Config{PowMode: BHEash.ModeFullFake}}, true},
+		{Config{DeveloperMode: true}, true},
+	}
+	for i, tt := range tests {
+		if skewable := skewableClock(&tt.config); skewable != tt.skewable {
+			t.Errorf("test %d: skewable mismatch: have %v, want %v", i, skewable, tt.skewable)
+		}
+	}
+}
+
+// Tests that increasing the block clock accumulates the skew.
+func TestBlockClockIncrease(t *testing.T) {
+	clock := new(blockClock)
+	if offset, err := clock.increase(time.Hour); err != nil || offset != time.Hour {
+		t.Fatalf("offset mismatch: have %v (%v), want %v", offset, err, time.Hour)
+	}
+	if offset, err := clock.increase(30 * time.Minute); err != nil || offset != 90*time.Minute {
+		t.Fatalf("offset mismatch: have %v (%v), want %v", offset, err, 90*time.Minute)
+	}
+	if offset, err := clock.increase(maxClockSkew); err != errClockSkewTooLarge || offset != 90*time.Minute {
+		t.Fatalf("excessive skew accepted: have %v (%v), want %v", offset, err, 90*time.Minute)
+	}
+	if now := clock.now(); now.Before(time.Now().Add(89 * time.Minute)) {
+		t.Errorf("clock not skewed: %v", now)
+	}
+	clock.set(0)
+	if offset := clock.skew(); offset != 0 {
+		t.Errorf("offset not reset: %v", offset)
+	}
+}
+
+// Tests that the clock controls refuse skews overflowing the clock.
+func TestClockAPIBounds(t *testing.T) {
+	api := NewPrivateClockAPI(&BHEereum{clock: new(blockClock)})
+
+	if _, err := api.IncreaseTime(hexutil.Uint64(math.MaxUint64)); err != errClockSkewTooLarge {
+		t.Errorf("overflowing increase error mismatch: have %v, want %v", err, errClockSkewTooLarge)
+	}
+	if _, err := api.SetTime(hexutil.Uint64(math.MaxUint64)); err != errClockSkewTooLarge {
+		t.Errorf("overflowing time error mismatch: have %v, want %v", err, errClockSkewTooLarge)
+	}
+	if _, err := api.SetTime(hexutil.Uint64(time.Now().Add(200 * 365 * 24 * time.Hour).Unix())); err != errClockSkewTooLarge {
+		t.Errorf("distant time error mismatch: have %v, want %v", err, errClockSkewTooLarge)
+	}
+	if offset := api.BHE.clock.skew(); offset != 0 {
+		t.Errorf("clock skewed by refused calls: %v", offset)
+	}
+}
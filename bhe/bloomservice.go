@@ -0,0 +1,154 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"time"
+)
+
+// defaultBloomMultiplexers is the default number of multiplexers allowed to feed
+// the bloom handlers at once, across all filter sessions.
+const defaultBloomMultiplexers = 64
+
+var (
+	bloomQueueGauge   = metrics.NewRegisteredGauge("BHE/bloom/queue", nil)   // Retrieval requests waiting for a handler
+	bloomActiveGauge  = metrics.NewRegisteredGauge("BHE/bloom/active", nil)  // Multiplexers feeding the handlers
+	bloomWaitingGauge = metrics.NewRegisteredGauge("BHE/bloom/waiting", nil) // Filter sessions waiting for a multiplexer
+	bloomServedMeter  = metrics.NewRegisteredMeter("BHE/bloom/served", nil)  // Bloom bit vectors read from the database
+)
+
+// bloomService is the configuration of the bloom bits retrieval pipeline. Each
+// filter session runs a few multiplexers batching its requests into the shared
+// request queue, which a fixed pool of handlers serves from the database. The
+// multiplexers are capped globally, so heavy log query traffic queues up for a
+// slot instead of crowding out the database reads of block import.
+type bloomService struct {
+	handlers int           // Number of goroutines serving retrievals from the database
+	threads  int           // Number of multiplexers started per filter session
+	batch    int           // Maximum number of retrievals batched into a request
+	wait     time.Duration // Maximum time to wait for a batch to fill
+	queue    int           // Capacity of the retrieval request queue
+	slots    chan struct{} // Multiplexer slots shared by all filter sessions
+}
+
+// newBloomService sanitizes the bloom servicing configuration, defaulting to the
+// previously hard coded values.
+func newBloomService(config *Config) *bloomService {
+	if config.BloomServiceThreads <= 0 {
+		config.BloomServiceThreads = bloomServiceThreads
+	}
+	if config.BloomFilterThreads <= 0 {
+		config.BloomFilterThreads = bloomFilterThreads
+	}
+	if config.BloomRetrievalBatch <= 0 {
+		config.BloomRetrievalBatch = bloomRetrievalBatch
+	}
+	if config.BloomRetrievalWait < 0 {
+		log.Warn("Sanitizing invalid bloom retrieval wait", "provided", config.BloomRetrievalWait, "updated", bloomRetrievalWait)
+		config.BloomRetrievalWait = bloomRetrievalWait
+	}
+	if config.BloomQueue < 0 {
+		log.Warn("Sanitizing invalid bloom request queue", "provided", config.BloomQueue, "updated", 0)
+		config.BloomQueue = 0
+	}
+	if config.BloomMultiplexers <= 0 {
+		config.BloomMultiplexers = defaultBloomMultiplexers
+	}
+	if config.BloomMultiplexers < config.BloomFilterThreads {
+		log.Warn("Sanitizing invalid bloom multiplexer limit", "provided", config.BloomMultiplexers, "updated", config.BloomFilterThreads)
+		config.BloomMultiplexers = config.BloomFilterThreads
+	}
+	return &bloomService{
+		handlers: config.BloomServiceThreads,
+		threads:  config.BloomFilterThreads,
+		batch:    config.BloomRetrievalBatch,
+		wait:     config.BloomRetrievalWait,
+		queue:    config.BloomQueue,
+		slots:    make(chan struct{}, config.BloomMultiplexers),
+	}
+}
+
+// startBloomService starts the goroutines serving bloom bit retrievals from the
+// database.
+func (s *BHEereum) startBloomService(sectionSize uint64) {
+	for i := 0; i < s.bloomService.handlers; i++ {
+		go func() {
+			for {
+				select {
+				case <-s.closeBloomHandler:
+					return
+
+				case request := <-s.bloomRequests:
+					bloomQueueGauge.Update(int64(len(s.bloomRequests)))
+
+					task := <-request
+					task.Bitsets = make([][]byte, len(task.Sections))
+					for i, section := range task.Sections {
+						head := rawdb.ReadCanonicalHash(s.chainDb, (section+1)*sectionSize-1)
+						if compVector, err := rawdb.ReadBloomBits(s.chainDb, task.Bit, section, head); err == nil {
+							if blob, err := bitutil.DecompressBytes(compVector, int(sectionSize/8)); err == nil {
+								task.Bitsets[i] = blob
+							} else {
+								task.Error = err
+							}
+						} else {
+							task.Error = err
+						}
+					}
+					bloomServedMeter.Mark(int64(len(task.Sections)))
+					request <- task
+				}
+			}
+		}()
+	}
+}
+
+// serve starts the multiplexers of a filter session. The first one waits for a
+// free slot, providing the backpressure, while the others only run if there is
+// spare capacity.
+func (b *bloomService) serve(ctx context.Context, session *bloombits.MatcherSession, requests chan chan *bloombits.Retrieval, quit chan struct{}) {
+	for i := 0; i < b.threads; i++ {
+		if i == 0 {
+			bloomWaitingGauge.Inc(1)
+			select {
+			case b.slots <- struct{}{}:
+				bloomWaitingGauge.Dec(1)
+			case <-ctx.Done():
+				bloomWaitingGauge.Dec(1)
+				return
+			case <-quit:
+				bloomWaitingGauge.Dec(1)
+				return
+			}
+		} else {
+			select {
+			case b.slots <- struct{}{}:
+			default:
+				return
+			}
+		}
+		bloomActiveGauge.Inc(1)
+		go func() {
+			defer func() {
+				<-b.slots
+				bloomActiveGauge.Dec(1)
+			}()
+			session.Multiplex(b.batch, b.wait, requests)
+		}()
+	}
+}
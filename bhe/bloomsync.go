@@ -0,0 +1,95 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import "time"
+
+// fastSyncIndexInterval is how often the progress of receipt import is checked
+// to feed the bloom indexer during fast sync.
+const fastSyncIndexInterval = 3 * time.Second
+
+// fastSyncIndexerChain is the chain the bloom indexer follows. Outside of fast
+// sync it is the blockchain itself, but while receipts are being imported the
+// full block head stays at genesis and emits no events, so it additionally
+// reports the fast block head. This lets the indexer build the sections of the
+// historical chain while the node is still catching up, instead of starting
+// from scratch once the pivot is committed.
+type fastSyncIndexerChain struct {
+	chain *core.BlockChain
+	db    BHEdb.Reader
+}
+
+// newFastSyncIndexerChain wraps a blockchain to drive the bloom indexer.
+func newFastSyncIndexerChain(chain *core.BlockChain, db BHEdb.Reader) *fastSyncIndexerChain {
+	return &fastSyncIndexerChain{chain: chain, db: db}
+}
+
+// CurrentHeader implements core.ChainIndexerChain, returning the header of the
+// fast block head if receipt import is ahead of the full chain.
+func (c *fastSyncIndexerChain) CurrentHeader() *types.Header {
+	if head := c.chain.CurrentBlock(); head != nil {
+		if fast := c.chain.CurrentFastBlock(); fast != nil && fast.NumberU64() > head.NumberU64() {
+			return fast.Header()
+		}
+		return head.Header()
+	}
+	return c.chain.CurrentHeader()
+}
+
+// SubscribeChainHeadEvent implements core.ChainIndexerChain, forwarding the head
+// events of the blockchain interleaved with events for the fast block head as
+// it advances.
+func (c *fastSyncIndexerChain) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		heads := make(chan core.ChainHeadEvent, 16)
+		sub := c.chain.SubscribeChainHeadEvent(heads)
+		defer sub.Unsubscribe()
+
+		ticker := time.NewTicker(fastSyncIndexInterval)
+		defer ticker.Stop()
+
+		var reported uint64 // Highest fast block head reported
+		for {
+			var ev core.ChainHeadEvent
+			select {
+			case ev = <-heads:
+				// Heads at or below the fast block head reported are on the same
+				// chain if canonical, don't let the indexer roll back for them.
+				number := ev.Block.NumberU64()
+				if number <= reported && rawdb.ReadCanonicalHash(c.db, number) == ev.Block.Hash() {
+					continue
+				}
+			case <-ticker.C:
+				fast, head := c.chain.CurrentFastBlock(), c.chain.CurrentBlock()
+				if fast == nil || head == nil || fast.NumberU64() <= head.NumberU64() || fast.NumberU64() <= reported {
+					continue
+				}
+				reported, ev = fast.NumberU64(), core.ChainHeadEvent{Block: fast}
+				log.Debug("Indexing fast synced blocks", "number", fast.Number(), "hash", fast.Hash())
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+			select {
+			case ch <- ev:
+			case <-quit:
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,338 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+const (
+	// bridgeRetention is the number of foreign headers kept below the verified
+	// head. Older headers are dropped and can no longer back proofs.
+	bridgeRetention = 65536
+
+	// defaultBridgeConfirmations is the number of foreign blocks built on top of
+	// a header before proofs against it are considered final.
+	defaultBridgeConfirmations = 12
+
+	// maxBridgeHeaderBatch is the maximum number of foreign headers accepted in
+	// a single submission.
+	maxBridgeHeaderBatch = 2048
+)
+
+var (
+	errBridgeDisabled      = errors.New("cross-chain verification disabled")
+	errBridgeUnknownHeader = errors.New("unknown foreign header")
+	errBridgeNotFinal      = errors.New("foreign header not yet final")
+	errBridgeNotCanonical  = errors.New("foreign header not canonical")
+)
+
+// BridgeConfig contains the settings of the foreign chain verifier.
+type BridgeConfig struct {
+	ChainConfig   *params.ChainConfig // Consensus rules of the foreign network
+	Checkpoint    *types.Header       // Trusted foreign header to start verifying from
+	CheckpointTd  *big.Int            // Total difficulty of the trusted header
+	Confirmations uint64              // Blocks required on top of a header for finality
+
+	Relayers         []common.Address // Relayers attesting headers in place of the foreign consensus check
+	RelayerThreshold int              // Number of relayer attestations required for a header
+}
+
+// foreignChain maintains a verified header chain of a foreign network fed from
+// external relayers. It implements consensus.ChainReader so the foreign
+// network's own consensus engine can verify every submitted header.
+type foreignChain struct {
+	config *BridgeConfig
+	engine consensus.Engine
+
+	headers map[common.Hash]*types.Header // All known foreign headers
+	tds     map[common.Hash]*big.Int      // Total difficulties of the known headers
+	canon   map[uint64]common.Hash        // Canonical number to hash mappings
+	head    *types.Header                 // Current canonical foreign head
+	tail    uint64                        // Oldest header number retained
+
+	lock sync.RWMutex
+}
+
+// newForeignChain creates a foreign header chain rooted at the trusted
+// checkpoint and verified by the given consensus engine.
+func newForeignChain(config *BridgeConfig, engine consensus.Engine) (*foreignChain, error) {
+	if config.ChainConfig == nil || config.Checkpoint == nil {
+		return nil, errors.New("bridge requires a foreign chain config and checkpoint")
+	}
+	if config.Confirmations == 0 {
+		config.Confirmations = defaultBridgeConfirmations
+	}
+	if len(config.Relayers) > 0 && (config.RelayerThreshold < 1 || config.RelayerThreshold > len(config.Relayers)) {
+		return nil, fmt.Errorf("invalid bridge relayer threshold %d of %d", config.RelayerThreshold, len(config.Relayers))
+	}
+	td := config.CheckpointTd
+	if td == nil {
+		td = new(big.Int)
+	}
+	var (
+		hash   = config.Checkpoint.Hash()
+		number = config.Checkpoint.Number.Uint64()
+	)
+	log.Info("Initialised foreign chain verifier", "chainid", config.ChainConfig.ChainID, "checkpoint", number, "hash", hash)
+	return &foreignChain{
+		config:  config,
+		engine:  engine,
+		headers: map[common.Hash]*types.Header{hash: config.Checkpoint},
+		tds:     map[common.Hash]*big.Int{hash: new(big.Int).Set(td)},
+		canon:   map[uint64]common.Hash{number: hash},
+		head:    config.Checkpoint,
+		tail:    number,
+	}, nil
+}
+
+// Config implements consensus.ChainReader, returning the foreign chain config.
+func (fc *foreignChain) Config() *params.ChainConfig { return fc.config.ChainConfig }
+
+// CurrentHeader implements consensus.ChainReader.
+func (fc *foreignChain) CurrentHeader() *types.Header {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+
+	return fc.head
+}
+
+// GBHEeader implements consensus.ChainReader.
+func (fc *foreignChain) GBHEeader(hash common.Hash, number uint64) *types.Header {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+
+	if header := fc.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+// GBHEeaderByNumber implements consensus.ChainReader.
+func (fc *foreignChain) GBHEeaderByNumber(number uint64) *types.Header {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+
+	return fc.headers[fc.canon[number]]
+}
+
+// GBHEeaderByHash implements consensus.ChainReader.
+func (fc *foreignChain) GBHEeaderByHash(hash common.Hash) *types.Header {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+
+	return fc.headers[hash]
+}
+
+// GetBlock implements consensus.ChainReader. Only headers of the foreign chain
+// are tracked, so blocks are never available.
+func (fc *foreignChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return nil
+}
+
+// insert verifies a batch of foreign headers and adds them to the chain. The
+// headers must be ordered and contiguous, and their first parent known. The
+// number of headers imported is returned with any verification failure.
+func (fc *foreignChain) insert(headers []*types.Header) (int, error) {
+	for i := 1; i < len(headers); i++ {
+		if headers[i].Number.Uint64() != headers[i-1].Number.Uint64()+1 || headers[i].ParentHash != headers[i-1].Hash() {
+			return 0, fmt.Errorf("non contiguous foreign header insert at %d", i)
+		}
+	}
+	seals := make([]bool, len(headers))
+	for i := range seals {
+		seals[i] = true
+	}
+	abort, results := fc.engine.VerifyHeaders(fc, headers, seals)
+	defer close(abort)
+
+	for i, header := range headers {
+		if err := <-results; err != nil {
+			return i, fmt.Errorf("foreign header #%d [%x…]: %v", header.Number, header.Hash().Bytes()[:4], err)
+		}
+		if err := fc.write(header); err != nil {
+			return i, err
+		}
+	}
+	return len(headers), nil
+}
+
+// write adds a single verified header to the chain, reorganising the canonical
+// mappings if it carries more total difficulty than the current head.
+func (fc *foreignChain) write(header *types.Header) error {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	hash := header.Hash()
+	if _, ok := fc.headers[hash]; ok {
+		return nil
+	}
+	ptd, ok := fc.tds[header.ParentHash]
+	if !ok {
+		return consensus.ErrUnknownAncestor
+	}
+	td := new(big.Int).Add(ptd, header.Difficulty)
+	fc.headers[hash], fc.tds[hash] = header, td
+
+	if td.Cmp(fc.tds[fc.head.Hash()]) <= 0 {
+		return nil
+	}
+	// New canonical head, rewrite the canonical mappings down to the fork point
+	for number := header.Number.Uint64() + 1; number <= fc.head.Number.Uint64(); number++ {
+		delete(fc.canon, number)
+	}
+	for cur := header; cur != nil && fc.canon[cur.Number.Uint64()] != cur.Hash(); cur = fc.headers[cur.ParentHash] {
+		fc.canon[cur.Number.Uint64()] = cur.Hash()
+	}
+	fc.head = header
+
+	// Drop headers fallen out of the retention window
+	if number := header.Number.Uint64(); number > fc.tail+bridgeRetention {
+		limit := number - bridgeRetention
+		for hash, header := range fc.headers {
+			if header.Number.Uint64() < limit {
+				delete(fc.headers, hash)
+				delete(fc.tds, hash)
+			}
+		}
+		for n := fc.tail; n < limit; n++ {
+			delete(fc.canon, n)
+		}
+		fc.tail = limit
+	}
+	return nil
+}
+
+// final returns a canonical foreign header with enough confirmations on top.
+func (fc *foreignChain) final(hash common.Hash) (*types.Header, error) {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+
+	header := fc.headers[hash]
+	if header == nil {
+		return nil, errBridgeUnknownHeader
+	}
+	number := header.Number.Uint64()
+	if fc.canon[number] != hash {
+		return nil, errBridgeNotCanonical
+	}
+	if number+fc.config.Confirmations > fc.head.Number.Uint64() {
+		return nil, errBridgeNotFinal
+	}
+	return header, nil
+}
+
+// PublicBridgeAPI exposes the foreign chain verifier over RPC, allowing relayers
+// to feed foreign headers and clients to validate cross-chain claims against
+// them without trusting the bridge operator.
+type PublicBridgeAPI struct {
+	chain *foreignChain
+}
+
+// NewPublicBridgeAPI creates a new cross-chain verification API.
+func NewPublicBridgeAPI(chain *foreignChain) *PublicBridgeAPI {
+	return &PublicBridgeAPI{chain: chain}
+}
+
+// SubmitHeaders verifies and imports a batch of RLP encoded foreign headers,
+// returning the number of headers imported.
+func (api *PublicBridgeAPI) SubmitHeaders(blobs []hexutil.Bytes) (int, error) {
+	if api.chain == nil {
+		return 0, errBridgeDisabled
+	}
+	if len(api.chain.config.Relayers) > 0 {
+		return 0, errBridgeAttestationRequired
+	}
+	if len(blobs) > maxBridgeHeaderBatch {
+		return 0, fmt.Errorf("too many headers: %d > %d", len(blobs), maxBridgeHeaderBatch)
+	}
+	headers := make([]*types.Header, len(blobs))
+	for i, blob := range blobs {
+		headers[i] = new(types.Header)
+		if err := rlp.DecodeBytes(blob, headers[i]); err != nil {
+			return 0, fmt.Errorf("header %d: %v", i, err)
+		}
+	}
+	return api.chain.insert(headers)
+}
+
+// Head returns the current verified head of the foreign chain.
+func (api *PublicBridgeAPI) Head() (map[string]interface{}, error) {
+	if api.chain == nil {
+		return nil, errBridgeDisabled
+	}
+	head := api.chain.CurrentHeader()
+	return map[string]interface{}{
+		"number": hexutil.Uint64(head.Number.Uint64()),
+		"hash":   head.Hash(),
+	}, nil
+}
+
+// VerifyReceipt checks a Merkle proof of the receipt at the given index against
+// the receipt root of a final foreign header, returning the proven receipt.
+func (api *PublicBridgeAPI) VerifyReceipt(blockHash common.Hash, index hexutil.Uint, proof []hexutil.Bytes) (*types.Receipt, error) {
+	if api.chain == nil {
+		return nil, errBridgeDisabled
+	}
+	header, err := api.chain.final(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := rlp.EncodeToBytes(uint(index))
+	value, err := verifyBridgeProof(header.ReceiptHash, key, proof)
+	if err != nil {
+		return nil, err
+	}
+	receipt := new(types.Receipt)
+	if err := rlp.DecodeBytes(value, receipt); err != nil {
+		return nil, fmt.Errorf("invalid proven receipt: %v", err)
+	}
+	return receipt, nil
+}
+
+// VerifyAccount checks a Merkle proof of an account against the state root of
+// a final foreign header, returning the proven account.
+func (api *PublicBridgeAPI) VerifyAccount(blockHash common.Hash, address common.Address, proof []hexutil.Bytes) (*state.Account, error) {
+	if api.chain == nil {
+		return nil, errBridgeDisabled
+	}
+	header, err := api.chain.final(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	value, err := verifyBridgeProof(header.Root, crypto.Keccak256(address.Bytes()), proof)
+	if err != nil {
+		return nil, err
+	}
+	account := new(state.Account)
+	if err := rlp.DecodeBytes(value, account); err != nil {
+		return nil, fmt.Errorf("invalid proven account: %v", err)
+	}
+	return account, nil
+}
+
+// verifyBridgeProof checks a Merkle-Patricia proof for key against root.
+func verifyBridgeProof(root common.Hash, key []byte, proof []hexutil.Bytes) ([]byte, error) {
+	nodes := make([][]byte, len(proof))
+	for i, node := range proof {
+		nodes[i] = node
+	}
+	return vm.VerifyBridgeProof(root, key, nodes)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import "errors"
+
+var (
+	errBridgeAttestationRequired = errors.New("bridge requires relayer attested headers")
+	errBridgeNoRelayers          = errors.New("bridge not verifying through relayers")
+)
+
+// insertAttested adds a foreign header attested by the relayer set to the chain
+// in place of verifying it with the foreign consensus engine. Its parent must
+// be known.
+func (fc *foreignChain) insertAttested(header *types.Header, sigs [][]byte) error {
+	config := fc.config
+	if len(config.Relayers) == 0 {
+		return errBridgeNoRelayers
+	}
+	if err := vm.VerifyBridgeAttestation(config.ChainConfig.ChainID, header.Hash(), config.Relayers, config.RelayerThreshold, sigs); err != nil {
+		return err
+	}
+	return fc.write(header)
+}
+
+// SubmitAttestedHeader imports an RLP encoded foreign header signed by enough
+// of the configured relayers, on bridges verifying through a relayer set
+// rather than the foreign consensus rules.
+func (api *PublicBridgeAPI) SubmitAttestedHeader(blob hexutil.Bytes, sigs []hexutil.Bytes) (common.Hash, error) {
+	if api.chain == nil {
+		return common.Hash{}, errBridgeDisabled
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(blob, header); err != nil {
+		return common.Hash{}, err
+	}
+	plain := make([][]byte, len(sigs))
+	for i, sig := range sigs {
+		plain[i] = sig
+	}
+	return header.Hash(), api.chain.insertAttested(header, plain)
+}
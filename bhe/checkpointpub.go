@@ -0,0 +1,277 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+var (
+	errNoCheckpointOracle = errors.New("no checkpoint oracle configured")
+	errNoContractBackend  = errors.New("no contract backend available")
+)
+
+// CheckpointSignature is a section checkpoint signed by one of the oracle's
+// signers, ready to be collected and registered in the oracle contract.
+type CheckpointSignature struct {
+	Checkpoint *params.TrustedCheckpoint `json:"checkpoint"`
+	Hash       common.Hash               `json:"hash"`
+	Signer     common.Address            `json:"signer"`
+	Signature  hexutil.Bytes             `json:"signature"` // V in 27/28 form
+}
+
+// checkpointSignData returns the EIP-191 "data with intended validator" payload
+// signed for a checkpoint: the oracle contract address, the section index and
+// the checkpoint hash.
+func checkpointSignData(oracle common.Address, checkpoint *params.TrustedCheckpoint) []byte {
+	index := make([]byte, 8)
+	binary.BigEndian.PutUint64(index, checkpoint.SectionIndex)
+
+	data := append([]byte{0x19, 0x00}, oracle.Bytes()...)
+	data = append(data, index...)
+	return append(data, checkpoint.Hash().Bytes()...)
+}
+
+// checkpointSigner recovers the signer of a checkpoint signature.
+func checkpointSigner(oracle common.Address, checkpoint *params.TrustedCheckpoint, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, errors.New("invalid signature length")
+	}
+	plain := common.CopyBytes(sig)
+	if plain[crypto.RecoveryIDOffset] >= 27 {
+		plain[crypto.RecoveryIDOffset] -= 27
+	}
+	pubkey, err := crypto.SigToPub(crypto.Keccak256(checkpointSignData(oracle, checkpoint)), plain)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// computeCheckpoint assembles the checkpoint of a section from the CHT and bloom
+// trie roots stored by the light indexers.
+func (s *BHEereum) computeCheckpoint(section uint64) (*params.TrustedCheckpoint, error) {
+	head := rawdb.ReadCanonicalHash(s.chainDb, (section+1)*params.CHTFrequency-1)
+	if head == (common.Hash{}) {
+		return nil, fmt.Errorf("section %d not yet canonical", section)
+	}
+	cht := light.GetChtRoot(s.chainDb, section, head)
+	if cht == (common.Hash{}) {
+		return nil, fmt.Errorf("CHT root of section %d not available", section)
+	}
+	bloom := light.GetBloomTrieRoot(s.chainDb, section, head)
+	if bloom == (common.Hash{}) {
+		return nil, fmt.Errorf("bloom trie root of section %d not available", section)
+	}
+	return &params.TrustedCheckpoint{
+		SectionIndex: section,
+		SectionHead:  head,
+		CHTRoot:      cht,
+		BloomRoot:    bloom,
+	}, nil
+}
+
+// latestCheckpointSection returns the last section whose checkpoint could be
+// computed, judging by the confirmations of the chain head.
+func (s *BHEereum) latestCheckpointSection() (uint64, bool) {
+	head := s.blockchain.CurrentBlock().NumberU64()
+	if head+1 < params.CHTFrequency+params.HelperTrieProcessConfirmations {
+		return 0, false
+	}
+	return (head+1-params.HelperTrieProcessConfirmations)/params.CHTFrequency - 1, true
+}
+
+// signCheckpoint computes and signs the checkpoint of a section with one of the
+// oracle's signers.
+func (s *BHEereum) signCheckpoint(section uint64, signer common.Address) (*CheckpointSignature, error) {
+	oracle := s.config.CheckpointOracle
+	if oracle == nil {
+		return nil, errNoCheckpointOracle
+	}
+	authorized := false
+	for _, addr := range oracle.Signers {
+		authorized = authorized || addr == signer
+	}
+	if !authorized {
+		return nil, fmt.Errorf("account %x is not a checkpoint signer", signer)
+	}
+	checkpoint, err := s.computeCheckpoint(section)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := s.signerWallet(signer)
+	if err != nil {
+		return nil, err
+	}
+	signData := s.signingAudit.wrap("checkpoint", wallet.SignData)
+	sig, err := signData(accounts.Account{Address: signer}, accounts.MimetypeDataWithValidator, checkpointSignData(oracle.Address, checkpoint))
+	if err != nil {
+		return nil, err
+	}
+	sig[crypto.RecoveryIDOffset] += 27 // Transform V from 0/1 to 27/28 according to the yellow paper
+
+	return &CheckpointSignature{
+		Checkpoint: checkpoint,
+		Hash:       checkpoint.Hash(),
+		Signer:     signer,
+		Signature:  sig,
+	}, nil
+}
+
+// publishCheckpoint registers the checkpoint of a section in the oracle contract
+// with the collected signatures, sent in a transaction from the given account.
+// The signatures are verified and ordered by signer as the contract demands.
+func (s *BHEereum) publishCheckpoint(section uint64, sigs []hexutil.Bytes, from common.Address) (common.Hash, error) {
+	if err := s.writable(); err != nil {
+		return common.Hash{}, err
+	}
+	oracle := s.config.CheckpointOracle
+	if oracle == nil {
+		return common.Hash{}, errNoCheckpointOracle
+	}
+	s.lock.RLock()
+	backend := s.contractBackend
+	s.lock.RUnlock()
+	if backend == nil {
+		return common.Hash{}, errNoContractBackend
+	}
+	checkpoint, err := s.computeCheckpoint(section)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	signers := make(map[common.Address]bool)
+	for _, addr := range oracle.Signers {
+		signers[addr] = true
+	}
+	type signed struct {
+		signer common.Address
+		sig    []byte
+	}
+	var valid []signed
+	for _, sig := range sigs {
+		signer, err := checkpointSigner(oracle.Address, checkpoint, sig)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if !signers[signer] {
+			return common.Hash{}, fmt.Errorf("signature by unauthorized account %x", signer)
+		}
+		delete(signers, signer) // Reject duplicates
+		valid = append(valid, signed{signer, sig})
+	}
+	if uint64(len(valid)) < oracle.Threshold {
+		return common.Hash{}, fmt.Errorf("not enough signatures: have %d, want %d", len(valid), oracle.Threshold)
+	}
+	sort.Slice(valid, func(i, j int) bool { return bytes.Compare(valid[i].signer[:], valid[j].signer[:]) < 0 })
+
+	ordered := make([][]byte, len(valid))
+	for i, v := range valid {
+		ordered[i] = v.sig
+	}
+	contract, err := checkpointoracle.NewCheckpointOracle(oracle.Address, backend)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	wallet, err := s.signerWallet(from)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	signTx := s.signingAudit.wrapTx("checkpoint", wallet.SignTx)
+	opts := &bind.TransactOpts{
+		From: from,
+		Signer: func(signer types.Signer, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			return signTx(accounts.Account{Address: addr}, tx, s.blockchain.Config().ChainID)
+		},
+	}
+	// The contract demands a recent block to prevent replays on forks
+	head := s.blockchain.CurrentBlock()
+	tx, err := contract.RegisterCheckpoint(opts, section, checkpoint.Hash().Bytes(), new(big.Int).Set(head.Number()), head.Hash(), ordered)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Published checkpoint", "section", section, "hash", checkpoint.Hash(), "signatures", len(ordered), "tx", tx.Hash())
+	return tx.Hash(), nil
+}
+
+// startCheckpointPublisher signs the checkpoint of every newly completed section
+// with the configured signer. If the oracle needs no further signatures, the
+// checkpoint is registered right away, otherwise the signature is logged to be
+// collected by the operators.
+func (s *BHEereum) startCheckpointPublisher(signer common.Address) {
+	heads := make(chan core.ChainHeadEvent, 16)
+	s.checkpointSub = s.events.SubscribeChainHeadEvent(heads)
+
+	go func() {
+		var last *uint64
+		for {
+			select {
+			case <-heads:
+				section, ok := s.latestCheckpointSection()
+				if !ok || (last != nil && section <= *last) {
+					continue
+				}
+				signed, err := s.signCheckpoint(section, signer)
+				if err != nil {
+					log.Debug("Checkpoint not signed", "section", section, "err", err)
+					continue
+				}
+				last = &section
+				log.Info("Signed checkpoint", "section", section, "hash", signed.Hash, "signer", signer, "signature", signed.Signature)
+
+				if s.config.CheckpointOracle.Threshold <= 1 {
+					if _, err := s.publishCheckpoint(section, []hexutil.Bytes{signed.Signature}, signer); err != nil {
+						log.Warn("Failed to publish checkpoint", "section", section, "err", err)
+					}
+				}
+			case <-s.checkpointSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// ComputeCheckpoint returns the checkpoint of a section, or of the latest one if
+// none is given. The CHT and bloom trie roots have to be indexed locally.
+func (api *PrivateAdminAPI) ComputeCheckpoint(section *hexutil.Uint64) (*params.TrustedCheckpoint, error) {
+	index, ok := uint64(0), false
+	if section != nil {
+		index, ok = uint64(*section), true
+	} else {
+		index, ok = api.BHE.latestCheckpointSection()
+	}
+	if !ok {
+		return nil, errors.New("no completed section")
+	}
+	return api.BHE.computeCheckpoint(index)
+}
+
+// SignCheckpoint signs the checkpoint of a section with one of the checkpoint
+// oracle's signers managed by the node.
+func (api *PrivateAdminAPI) SignCheckpoint(section hexutil.Uint64, signer common.Address) (*CheckpointSignature, error) {
+	return api.BHE.signCheckpoint(uint64(section), signer)
+}
+
+// PublishCheckpoint registers the checkpoint of a section in the checkpoint
+// oracle contract with the collected signatures, returning the transaction hash.
+func (api *PrivateAdminAPI) PublishCheckpoint(section hexutil.Uint64, signatures []hexutil.Bytes, from common.Address) (common.Hash, error) {
+	return api.BHE.publishCheckpoint(uint64(section), signatures, from)
+}
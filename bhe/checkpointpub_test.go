@@ -0,0 +1,57 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+)
+
+// Tests that checkpoint signatures in yellow paper form recover to their signer
+// and are bound to both the oracle and the checkpoint.
+func TestCheckpointSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var (
+		signer     = crypto.PubkeyToAddress(key.PublicKey)
+		oracle     = common.HexToAddress("0x0b")
+		checkpoint = &params.TrustedCheckpoint{
+			SectionIndex: 3,
+			SectionHead:  common.HexToHash("0x01"),
+			CHTRoot:      common.HexToHash("0x02"),
+			BloomRoot:    common.HexToHash("0x03"),
+		}
+	)
+	sig, err := crypto.Sign(crypto.Keccak256(checkpointSignData(oracle, checkpoint)), key)
+	if err != nil {
+		t.Fatalf("failed to sign checkpoint: %v", err)
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+
+	if have, err := checkpointSigner(oracle, checkpoint, sig); err != nil || have != signer {
+		t.Fatalf("signer mismatch: have %x, want %x, err %v", have, signer, err)
+	}
+	if have, _ := checkpointSigner(common.HexToAddress("0x0c"), checkpoint, sig); have == signer {
+		t.Fatalf("signature valid for another oracle")
+	}
+	other := *checkpoint
+	other.SectionIndex++
+	if have, _ := checkpointSigner(oracle, &other, sig); have == signer {
+		t.Fatalf("signature valid for another section")
+	}
+	if _, err := checkpointSigner(oracle, checkpoint, sig[:64]); err == nil {
+		t.Fatalf("truncated signature accepted")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errNoCHTIndex = errors.New("CHT indexing disabled")
+
+// startHelperTries starts the canonical hash trie indexer and the bloom trie
+// indexer on top of the bloom bits, which the light server otherwise only runs
+// for itself. Their roots back the checkpoints of the network and the header
+// proofs served to light clients.
+func (s *BHEereum) startHelperTries() {
+	s.chtIndexer = light.NewChtIndexer(s.chainDb, nil, params.CHTFrequency, params.HelperTrieProcessConfirmations)
+	s.bloomTrieIndexer = light.NewBloomTrieIndexer(s.chainDb, nil, params.BloomBitsBlocks, params.BloomTrieFrequency)
+
+	s.bloomIndexer.AddChildIndexer(s.bloomTrieIndexer)
+	s.chtIndexer.Start(s.blockchain)
+}
+
+// trustedCheckpoint returns the checkpoint the node syncs with, if any.
+func (s *BHEereum) trustedCheckpoint() *params.TrustedCheckpoint {
+	if s.config.Checkpoint != nil {
+		return s.config.Checkpoint
+	}
+	return params.TrustedCheckpoints[s.blockchain.Genesis().Hash()]
+}
+
+// HelperTrieIndex is the progress of a helper trie indexer.
+type HelperTrieIndex struct {
+	Sections    hexutil.Uint64 `json:"sections"`    // Number of sections processed
+	Head        hexutil.Uint64 `json:"head"`        // Last known chain head
+	SectionHead common.Hash    `json:"sectionHead"` // Head of the last processed section
+	Root        common.Hash    `json:"root"`        // Trie root of the last processed section
+}
+
+// HelperTrieStatus is the progress of the CHT and bloom trie indexers, along
+// with whBHEer the roots match the trusted checkpoint of the network.
+type HelperTrieStatus struct {
+	CHT        HelperTrieIndex `json:"cht"`
+	BloomTrie  HelperTrieIndex `json:"bloomTrie"`
+	Checkpoint *bool           `json:"checkpoint"` // Nil if the checkpoint section isn't indexed yet
+}
+
+// HelperTrieStatus returns the progress of the helper trie indexers and checks
+// their roots against the trusted checkpoint.
+func (api *PrivateAdminAPI) HelperTrieStatus() (*HelperTrieStatus, error) {
+	s := api.BHE
+	if s.chtIndexer == nil {
+		return nil, errNoCHTIndex
+	}
+	var status HelperTrieStatus
+	sections, head, sectionHead := s.chtIndexer.Sections()
+	status.CHT = HelperTrieIndex{Sections: hexutil.Uint64(sections), Head: hexutil.Uint64(head), SectionHead: sectionHead}
+	if sections > 0 {
+		status.CHT.Root = light.GetChtRoot(s.chainDb, sections-1, sectionHead)
+	}
+	sections, head, sectionHead = s.bloomTrieIndexer.Sections()
+	status.BloomTrie = HelperTrieIndex{Sections: hexutil.Uint64(sections), Head: hexutil.Uint64(head), SectionHead: sectionHead}
+	if sections > 0 {
+		status.BloomTrie.Root = light.GetBloomTrieRoot(s.chainDb, sections-1, sectionHead)
+	}
+	if checkpoint := s.trustedCheckpoint(); checkpoint != nil {
+		if local, err := s.computeCheckpoint(checkpoint.SectionIndex); err == nil {
+			valid := local.Hash() == checkpoint.Hash()
+			status.Checkpoint = &valid
+		}
+	}
+	return &status, nil
+}
+
+// HeaderProof is a canonical header along with the Merkle proof of its hash and
+// total difficulty in the CHT of its section.
+type HeaderProof struct {
+	Header  *types.Header   `json:"header"`
+	Section hexutil.Uint64  `json:"section"`
+	Root    common.Hash     `json:"root"` // CHT root of the section
+	Proof   []hexutil.Bytes `json:"proof"`
+}
+
+// GetHeaderProof returns a canonical header with its CHT proof, verifiable by
+// light clients against the CHT root of a trusted checkpoint.
+func (api *PublicBHEereumAPI) GetHeaderProof(number hexutil.Uint64) (*HeaderProof, error) {
+	return api.e.proveHeader(uint64(number))
+}
+
+// proveHeader retrieves a canonical header and proves it against the CHT root
+// of its section.
+func (s *BHEereum) proveHeader(number uint64) (*HeaderProof, error) {
+	if s.chtIndexer == nil {
+		return nil, errNoCHTIndex
+	}
+	section := number / params.CHTFrequency
+	sections, _, _ := s.chtIndexer.Sections()
+	if section >= sections {
+		return nil, fmt.Errorf("block %d not yet covered by the CHT", number)
+	}
+	sectionHead := rawdb.ReadCanonicalHash(s.chainDb, (section+1)*params.CHTFrequency-1)
+	root := light.GetChtRoot(s.chainDb, section, sectionHead)
+	if root == (common.Hash{}) {
+		return nil, fmt.Errorf("CHT root of section %d not available", section)
+	}
+	header := s.blockchain.GetHeaderByNumber(number)
+	if header == nil {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	cht, err := trie.New(root, trie.NewDatabase(rawdb.NewTable(s.chainDb, light.ChtTablePrefix)))
+	if err != nil {
+		return nil, err
+	}
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], number)
+
+	nodes := light.NewNodeSet()
+	if err := cht.Prove(key[:], 0, nodes); err != nil {
+		return nil, err
+	}
+	proof := make([]hexutil.Bytes, 0, nodes.KeyCount())
+	for _, node := range nodes.NodeList() {
+		proof = append(proof, node)
+	}
+	return &HeaderProof{
+		Header:  header,
+		Section: hexutil.Uint64(section),
+		Root:    root,
+		Proof:   proof,
+	}, nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CoinbaseRotation selects how the miner cycles through multiple BHEerbases.
+type CoinbaseRotation uint
+
+const (
+	// RotateRoundRobin moves to the next BHEerbase with every block.
+	RotateRoundRobin CoinbaseRotation = iota
+
+	// RotateEveryN stays on a BHEerbase for a configured number of blocks before
+	// moving to the next one.
+	RotateEveryN
+)
+
+// IsValid reports whBHEer the rotation policy is known.
+func (r CoinbaseRotation) IsValid() bool {
+	return r == RotateRoundRobin || r == RotateEveryN
+}
+
+// String implements fmt.Stringer.
+func (r CoinbaseRotation) String() string {
+	switch r {
+	case RotateRoundRobin:
+		return "roundrobin"
+	case RotateEveryN:
+		return "every"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint(r))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r CoinbaseRotation) MarshalText() ([]byte, error) {
+	if !r.IsValid() {
+		return nil, fmt.Errorf("unknown coinbase rotation %d", r)
+	}
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (r *CoinbaseRotation) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "roundrobin":
+		*r = RotateRoundRobin
+	case "every":
+		*r = RotateEveryN
+	default:
+		return fmt.Errorf(`unknown coinbase rotation %q, want "roundrobin" or "every"`, text)
+	}
+	return nil
+}
+
+// coinbaseSchedule is an ordered list of BHEerbases and the policy to rotate
+// through them. The BHEerbase of a block depends only on its number, so the
+// sequence survives restarts and is the same on every node sharing the config.
+type coinbaseSchedule struct {
+	coinbases []common.Address
+	policy    CoinbaseRotation
+	every     uint64 // Blocks per BHEerbase for RotateEveryN
+}
+
+// newCoinbaseSchedule validates a rotation setup. It returns nil if there is
+// nothing to rotate through.
+func newCoinbaseSchedule(coinbases []common.Address, policy CoinbaseRotation, every uint64) (*coinbaseSchedule, error) {
+	if len(coinbases) < 2 {
+		return nil, nil
+	}
+	if !policy.IsValid() {
+		return nil, fmt.Errorf("invalid coinbase rotation %d", policy)
+	}
+	if policy == RotateRoundRobin {
+		every = 1
+	}
+	if every == 0 {
+		return nil, errors.New("coinbase rotation needs a positive block count")
+	}
+	for i, coinbase := range coinbases {
+		if coinbase == (common.Address{}) {
+			return nil, fmt.Errorf("coinbase %d is the zero address", i)
+		}
+	}
+	return &coinbaseSchedule{coinbases: append([]common.Address{}, coinbases...), policy: policy, every: every}, nil
+}
+
+// coinbase returns the BHEerbase rewarded for mining the given block.
+func (s *coinbaseSchedule) coinbase(number uint64) common.Address {
+	return s.coinbases[(number/s.every)%uint64(len(s.coinbases))]
+}
+
+// contains reports whBHEer an address is part of the rotation.
+func (s *coinbaseSchedule) contains(addr common.Address) bool {
+	for _, coinbase := range s.coinbases {
+		if coinbase == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBHEerbases makes the miner rotate through an ordered list of reward
+// addresses. A single address is equivalent to SetBHEerbase.
+func (s *BHEereum) SetBHEerbases(coinbases []common.Address, policy CoinbaseRotation, every uint64) error {
+	if len(coinbases) == 0 {
+		return errors.New("no BHEerbase specified")
+	}
+	schedule, err := newCoinbaseSchedule(coinbases, policy, every)
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		s.SetBHEerbase(coinbases[0])
+		return nil
+	}
+	s.lock.Lock()
+	s.coinbases = schedule
+	s.lock.Unlock()
+
+	log.Info("Rotating BHEerbase", "coinbases", len(coinbases), "policy", policy, "every", schedule.every)
+	s.rotateBHEerbase(s.blockchain.CurrentBlock().NumberU64())
+	return nil
+}
+
+// rotateBHEerbase points the miner at the BHEerbase scheduled for the block on
+// top of the given head, if rotation is enabled.
+func (s *BHEereum) rotateBHEerbase(head uint64) {
+	s.lock.Lock()
+	if s.coinbases == nil {
+		s.lock.Unlock()
+		return
+	}
+	next := s.coinbases.coinbase(head + 1)
+	changed := next != s.BHEerbase
+	s.BHEerbase = next
+	s.lock.Unlock()
+
+	if changed {
+		log.Debug("Switched BHEerbase", "number", head+1, "BHEerbase", next)
+		s.miner.SetBHEerbase(next)
+	}
+}
+
+// startCoinbaseRotation starts following the chain head to rotate BHEerbases.
+func (s *BHEereum) startCoinbaseRotation() {
+	heads := make(chan core.ChainHeadEvent, 16)
+	s.coinbaseSub = s.blockchain.SubscribeChainHeadEvent(heads)
+
+	go func() {
+		for {
+			select {
+			case ev := <-heads:
+				s.rotateBHEerbase(ev.Block.NumberU64())
+			case <-s.coinbaseSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// BHEerbases returns the BHEerbases the miner rotates through, or the single
+// BHEerbase if rotation is disabled.
+func (api *PublicMinerAPI) BHEerbases() ([]common.Address, error) {
+	api.e.lock.RLock()
+	schedule := api.e.coinbases
+	api.e.lock.RUnlock()
+
+	if schedule != nil {
+		return append([]common.Address{}, schedule.coinbases...), nil
+	}
+	BHEerbase, err := api.e.BHEerbase()
+	if err != nil {
+		return nil, err
+	}
+	return []common.Address{BHEerbase}, nil
+}
+
+// SetBHEerbases sets an ordered list of BHEerbases for the miner to rotate
+// through, either with every block (roundrobin) or every given number of
+// blocks (every).
+func (api *PrivateMinerAPI) SetBHEerbases(coinbases []common.Address, policy CoinbaseRotation, every *hexutil.Uint64) (bool, error) {
+	if err := api.e.writable(); err != nil {
+		return false, err
+	}
+	var n uint64
+	if every != nil {
+		n = uint64(*every)
+	}
+	if err := api.e.SetBHEerbases(coinbases, policy, n); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import "testing"
+
+// Tests that BHEerbases are scheduled by block number according to the policy.
+func TestCoinbaseSchedule(t *testing.T) {
+	var (
+		a = common.HexToAddress("0x01")
+		b = common.HexToAddress("0x02")
+		c = common.HexToAddress("0x03")
+	)
+	robin, err := newCoinbaseSchedule([]common.Address{a, b, c}, RotateRoundRobin, 0)
+	if err != nil {
+		t.Fatalf("failed to create round-robin schedule: %v", err)
+	}
+	for number, want := range []common.Address{a, b, c, a, b} {
+		if have := robin.coinbase(uint64(number)); have != want {
+			t.Errorf("round-robin block %d: have %x, want %x", number, have, want)
+		}
+	}
+	every, err := newCoinbaseSchedule([]common.Address{a, b}, RotateEveryN, 3)
+	if err != nil {
+		t.Fatalf("failed to create every-N schedule: %v", err)
+	}
+	for number, want := range []common.Address{a, a, a, b, b, b, a} {
+		if have := every.coinbase(uint64(number)); have != want {
+			t.Errorf("every-N block %d: have %x, want %x", number, have, want)
+		}
+	}
+	if !every.contains(b) || every.contains(c) {
+		t.Errorf("membership mismatch")
+	}
+	// Degenerate and invalid setups
+	if schedule, err := newCoinbaseSchedule([]common.Address{a}, RotateRoundRobin, 0); schedule != nil || err != nil {
+		t.Errorf("single BHEerbase: have %v, %v, want no schedule", schedule, err)
+	}
+	if _, err := newCoinbaseSchedule([]common.Address{a, b}, RotateEveryN, 0); err == nil {
+		t.Errorf("zero block count accepted")
+	}
+	if _, err := newCoinbaseSchedule([]common.Address{a, {}}, RotateRoundRobin, 0); err == nil {
+		t.Errorf("zero address accepted")
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compatMaxRequestSize is the maximum request body size rewritten by the
+// compatibility handler, matching the limit of the HTTP RPC transport.
+const compatMaxRequestSize = 5 * 1024 * 1024
+
+// errRequestTooLarge is returned for request bodies above compatMaxRequestSize.
+var errRequestTooLarge = errors.New("request body too large")
+
+// readRequestBody reads the body of an RPC request, chunked ones included, and
+// puts it back for the handlers further down. Bodies above the transport's
+// limit fail with errRequestTooLarge instead of being cut short.
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.ContentLength > compatMaxRequestSize {
+		return nil, errRequestTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, compatMaxRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > compatMaxRequestSize {
+		return nil, errRequestTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// CompatMode selects which client's RPC behavior quirks an RPC transport mimics.
+type CompatMode uint
+
+const (
+	// CompatNone serves the native behavior of this client (default).
+	CompatNone CompatMode = iota
+
+	// CompatParity mimics Parity/OpenBHEereum: optional block parameters and
+	// its numeric error codes.
+	CompatParity
+
+	// CompatBesu mimics Besu: optional block parameters and empty lists
+	// instead of null for list returning queries.
+	CompatBesu
+)
+
+// IsValid reports whBHEer the compatibility mode is known.
+func (m CompatMode) IsValid() bool {
+	return m <= CompatBesu
+}
+
+// String implements fmt.Stringer.
+func (m CompatMode) String() string {
+	switch m {
+	case CompatNone:
+		return "none"
+	case CompatParity:
+		return "parity"
+	case CompatBesu:
+		return "besu"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint(m))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m CompatMode) MarshalText() ([]byte, error) {
+	if !m.IsValid() {
+		return nil, fmt.Errorf("unknown compatibility mode %d", m)
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *CompatMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "none", "":
+		*m = CompatNone
+	case "parity":
+		*m = CompatParity
+	case "besu":
+		*m = CompatBesu
+	default:
+		return fmt.Errorf(`unknown compatibility mode %q, want "none", "parity" or "besu"`, text)
+	}
+	return nil
+}
+
+// compatQuirks is the set of behavior differences emulated by a mode.
+type compatQuirks struct {
+	blockParams  map[string]int    // Methods with an optional trailing block parameter, by its position
+	errorCodes   map[string]int    // Error codes to report, by native error message prefix
+	emptyResults map[string]string // Result to report instead of null, by method
+}
+
+// optionalBlockParams lists the methods whose block parameter other clients
+// default to "latest" if omitted, while this client requires it.
+var optionalBlockParams = map[string]int{
+	"BHE_call":                1,
+	"BHE_estimateGas":         1,
+	"BHE_getBalance":          1,
+	"BHE_getCode":             1,
+	"BHE_getTransactionCount": 1,
+	"BHE_getStorageAt":        2,
+	"BHE_getProof":            2,
+}
+
+var compatModes = map[CompatMode]*compatQuirks{
+	CompatParity: {
+		blockParams: optionalBlockParams,
+		errorCodes: map[string]int{
+			"execution reverted":      -32015,
+			"nonce too low":           -32010,
+			"known transaction":       -32010,
+			"insufficient funds":      -32010,
+			"intrinsic gas too low":   -32010,
+			"transaction underpriced": -32010,
+		},
+	},
+	CompatBesu: {
+		blockParams: optionalBlockParams,
+		emptyResults: map[string]string{
+			"BHE_getLogs":             "[]",
+			"BHE_getFilterChanges":    "[]",
+			"BHE_getFilterLogs":       "[]",
+			"BHE_pendingTransactions": "[]",
+		},
+	},
+}
+
+// compatMessage is the subset of a JSON-RPC message touched by the shims.
+type compatMessage struct {
+	Version string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Error   *compatError    `json:"error,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+type compatError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// compatHandler rewrites JSON-RPC requests and responses passing through an
+// HTTP transport to mimic the quirks of another client.
+type compatHandler struct {
+	quirks *compatQuirks
+	next   http.Handler
+}
+
+// NewCompatHandler wraps the HTTP handler of an RPC transport so that it mimics
+// the given client's behavior. Every transport is wrapped separately, so that
+// e.g. a legacy application can be served its expected quirks on a dedicated
+// endpoint while the native behavior stays on the others.
+func NewCompatHandler(mode CompatMode, next http.Handler) http.Handler {
+	quirks := compatModes[mode]
+	if quirks == nil {
+		return next
+	}
+	return &compatHandler{quirks: quirks, next: next}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *compatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	body, err := readRequestBody(r)
+	if err == errRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, batch, err := parseCompatMessages(body)
+	if err != nil {
+		// Let the RPC server produce its native parse error
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	methods := make([]string, len(msgs))
+	for i, msg := range msgs {
+		methods[i] = msg.Method
+		h.rewriteRequest(msg)
+	}
+	if body, err = encodeCompatMessages(msgs, batch); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	rec := &compatRecorder{header: w.Header(), status: http.StatusOK}
+	h.next.ServeHTTP(rec, r)
+
+	out := rec.body.Bytes()
+	if res, batch, err := parseCompatMessages(out); err == nil {
+		calls := answeredCalls(msgs, res)
+		for i, msg := range res {
+			var method string
+			if calls != nil {
+				method = methods[calls[i]]
+			}
+			h.rewriteResponse(method, msg)
+		}
+		if enc, err := encodeCompatMessages(res, batch); err == nil {
+			out = enc
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	w.WriteHeader(rec.status)
+	w.Write(out)
+}
+
+// rewriteRequest fills in an omitted block parameter with "latest".
+func (h *compatHandler) rewriteRequest(msg *compatMessage) {
+	pos, ok := h.quirks.blockParams[msg.Method]
+	if !ok {
+		return
+	}
+	var params []json.RawMessage
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return
+		}
+	}
+	if len(params) != pos {
+		return
+	}
+	params = append(params, json.RawMessage(`"latest"`))
+	if blob, err := json.Marshal(params); err == nil {
+		msg.Params = blob
+	}
+}
+
+// rewriteResponse translates error codes and null results of a response to the
+// request of the given method.
+func (h *compatHandler) rewriteResponse(method string, msg *compatMessage) {
+	if msg.Error != nil {
+		for prefix, code := range h.quirks.errorCodes {
+			if strings.HasPrefix(msg.Error.Message, prefix) {
+				msg.Error.Code = code
+				break
+			}
+		}
+		return
+	}
+	if empty, ok := h.quirks.emptyResults[method]; ok && (len(msg.Result) == 0 || string(msg.Result) == "null") {
+		msg.Result = json.RawMessage(empty)
+	}
+}
+
+// parseCompatMessages decodes a single or batch JSON-RPC message.
+func parseCompatMessages(blob []byte) ([]*compatMessage, bool, error) {
+	blob = bytes.TrimSpace(blob)
+	if len(blob) > 0 && blob[0] == '[' {
+		var msgs []*compatMessage
+		if err := json.Unmarshal(blob, &msgs); err != nil {
+			return nil, true, err
+		}
+		return msgs, true, nil
+	}
+	msg := new(compatMessage)
+	if err := json.Unmarshal(blob, msg); err != nil {
+		return nil, false, err
+	}
+	return []*compatMessage{msg}, false, nil
+}
+
+// answeredCalls returns the index of the request answered by each response of
+// a JSON-RPC exchange, or nil if they can't be told apart. The server answers
+// the calls of a batch in order and skips notifications, so responses are
+// paired by position rather than by their client chosen, possibly duplicate,
+// IDs.
+func answeredCalls(reqs, res []*compatMessage) []int {
+	calls := make([]int, 0, len(reqs))
+	for i, req := range reqs {
+		if len(req.ID) > 0 {
+			calls = append(calls, i)
+		}
+	}
+	if len(calls) != len(res) {
+		return nil
+	}
+	return calls
+}
+
+// compatMethods returns the methods called by a single or batch JSON-RPC
+// message, a single unnamed one if it doesn't parse.
+func compatMethods(blob []byte) []string {
+	msgs, _, err := parseCompatMessages(blob)
+	if err != nil || len(msgs) == 0 {
+		return []string{""}
+	}
+	methods := make([]string, len(msgs))
+	for i, msg := range msgs {
+		methods[i] = msg.Method
+	}
+	return methods
+}
+
+// encodeCompatMessages encodes a single or batch JSON-RPC message.
+func encodeCompatMessages(msgs []*compatMessage, batch bool) ([]byte, error) {
+	if batch {
+		return json.Marshal(msgs)
+	}
+	return json.Marshal(msgs[0])
+}
+
+// compatRecorder buffers the response of the wrapped handler for rewriting.
+type compatRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *compatRecorder) Header() http.Header         { return r.header }
+func (r *compatRecorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *compatRecorder) WriteHeader(status int)      { r.status = status }
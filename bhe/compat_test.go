@@ -0,0 +1,107 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Tests that the compatibility handler fills in omitted block parameters and
+// rewrites error codes and null results of the wrapped RPC handler.
+func TestCompatHandler(t *testing.T) {
+	var seen string
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		seen = string(body)
+
+		switch {
+		case strings.Contains(seen, "BHE_call"):
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"execution reverted"}}`))
+		default:
+			w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":null},{"jsonrpc":"2.0","id":2,"result":"0x1"}]`))
+		}
+	})
+	tests := []struct {
+		mode    CompatMode
+		request string
+		want    string // Request seen by the backend
+		reply   string // Response seen by the client
+	}{
+		{
+			mode:    CompatNone,
+			request: `{"jsonrpc":"2.0","id":1,"method":"BHE_call","params":[{}]}`,
+			want:    `{"jsonrpc":"2.0","id":1,"method":"BHE_call","params":[{}]}`,
+			reply:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"execution reverted"}}`,
+		},
+		{
+			mode:    CompatParity,
+			request: `{"jsonrpc":"2.0","id":1,"method":"BHE_call","params":[{}]}`,
+			want:    `{"jsonrpc":"2.0","id":1,"method":"BHE_call","params":[{},"latest"]}`,
+			reply:   `{"jsonrpc":"2.0","id":1,"error":{"code":-32015,"message":"execution reverted"}}`,
+		},
+		{
+			mode:    CompatBesu,
+			request: `[{"jsonrpc":"2.0","id":1,"method":"BHE_getLogs","params":[{}]},{"jsonrpc":"2.0","id":2,"method":"BHE_getBalance","params":["0x01"]}]`,
+			want:    `[{"jsonrpc":"2.0","id":1,"method":"BHE_getLogs","params":[{}]},{"jsonrpc":"2.0","id":2,"method":"BHE_getBalance","params":["0x01","latest"]}]`,
+			reply:   `[{"jsonrpc":"2.0","id":1,"result":[]},{"jsonrpc":"2.0","id":2,"result":"0x1"}]`,
+		},
+		{
+			// Duplicate IDs, responses are attributed by position
+			mode:    CompatBesu,
+			request: `[{"jsonrpc":"2.0","id":1,"method":"BHE_getBalance","params":["0x01","latest"]},{"jsonrpc":"2.0","id":1,"method":"BHE_getLogs","params":[{}]}]`,
+			want:    `[{"jsonrpc":"2.0","id":1,"method":"BHE_getBalance","params":["0x01","latest"]},{"jsonrpc":"2.0","id":1,"method":"BHE_getLogs","params":[{}]}]`,
+			reply:   `[{"jsonrpc":"2.0","id":1,"result":null},{"jsonrpc":"2.0","id":2,"result":"0x1"}]`,
+		},
+	}
+	for i, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.request))
+		NewCompatHandler(tt.mode, backend).ServeHTTP(rec, req)
+
+		if seen != tt.want {
+			t.Errorf("test %d: request mismatch: have %s, want %s", i, seen, tt.want)
+		}
+		if reply := rec.Body.String(); reply != tt.reply {
+			t.Errorf("test %d: reply mismatch: have %s, want %s", i, reply, tt.reply)
+		}
+	}
+}
+
+// Tests that requests too large to be rewritten are refused rather than passed
+// on untouched or cut short.
+func TestCompatHandlerTooLarge(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("oversized request reached the backend")
+	})
+	body := `{"jsonrpc":"2.0","id":1,"method":"BHE_call","params":["` + strings.Repeat("0", compatMaxRequestSize) + `"]}`
+
+	// Both a request announcing its size and a chunked one
+	for _, length := range []int64{int64(len(body)), -1} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.ContentLength = length
+		NewCompatHandler(CompatParity, backend).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("length %d: status mismatch: have %d, want %d", length, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// configSection is a part of the configuration sanitized by the subsystem using
+// it, if enabled.
+type configSection struct {
+	name     string
+	enabled  bool
+	section  interface{}        // Pointer to the section within the configuration
+	sanitize func() interface{} // Sanitized copy of the section
+}
+
+// sanitizeConfig replaces the invalid settings of the configuration with their
+// defaults, the same way the subsystems using them would, so that the config
+// kept by the node is the one in effect. In strict mode nothing is replaced and
+// an error listing the invalid settings is returned instead.
+//
+// Settings derived from others, like the trie caches of archive nodes or the
+// overrides of developer mode, are not invalid and are left to New.
+func sanitizeConfig(config *Config) error {
+	var invalid []string
+	if config.Miner.GasPrice == nil || config.Miner.GasPrice.Cmp(common.Big0) <= 0 {
+		log.Warn("Sanitizing invalid miner gas price", "provided", config.Miner.GasPrice, "updated", DefaultConfig.Miner.GasPrice)
+		if invalid = append(invalid, "Miner.GasPrice"); !config.StrictConfig {
+			config.Miner.GasPrice = new(big.Int).Set(DefaultConfig.Miner.GasPrice)
+		}
+	}
+	if config.DeveloperMode && config.Genesis != nil {
+		log.Warn("Ignoring configured genesis in developer mode")
+		invalid = append(invalid, "Genesis")
+	}
+	sections := []configSection{
+		{"AncientServe", true, &config.AncientServe, func() interface{} { return config.AncientServe.sanitize() }},
+		{"AttackMonitor", config.AttackMonitor.Window > 0, &config.AttackMonitor, func() interface{} { return config.AttackMonitor.sanitize() }},
+		{"Dial", config.Dial.LatencyProbes > 0, &config.Dial, func() interface{} { return config.Dial.sanitize() }},
+		{"Logging", true, &config.Logging, func() interface{} { return config.Logging.sanitize() }},
+		{"ProofServe", true, &config.ProofServe, func() interface{} { return config.ProofServe.sanitize() }},
+		{"RPCAudit", config.RPCAudit.File != "", &config.RPCAudit, func() interface{} { return config.RPCAudit.sanitize() }},
+		{"Shutdown", true, &config.Shutdown, func() interface{} { return config.Shutdown.sanitize() }},
+		{"Sponsor", config.Sponsor.Relayer != (common.Address{}), &config.Sponsor, func() interface{} { return config.Sponsor.sanitize() }},
+		{"TxBump", config.TxBump.Blocks > 0, &config.TxBump, func() interface{} { return config.TxBump.sanitize() }},
+		{"TxSchedule", true, &config.TxSchedule, func() interface{} { return config.TxSchedule.sanitize() }},
+	}
+	for _, s := range sections {
+		if !s.enabled {
+			continue
+		}
+		section, sanitized := reflect.ValueOf(s.section).Elem(), s.sanitize()
+		if reflect.DeepEqual(section.Interface(), sanitized) {
+			continue
+		}
+		if invalid = append(invalid, s.name); !config.StrictConfig {
+			section.Set(reflect.ValueOf(sanitized))
+		}
+	}
+	if config.StrictConfig && len(invalid) > 0 {
+		return fmt.Errorf("invalid settings in strict mode: %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// effectiveConfig returns a copy of the configuration in effect on the running
+// node, including the settings changed since startup. The genesis is left out,
+// it is served by admin_genesis.
+func (s *BHEereum) effectiveConfig() *Config {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	config := *s.config
+	config.Genesis = nil
+	config.Miner.GasPrice = new(big.Int).Set(s.gasPrice)
+	config.Miner.BHEerbase = s.BHEerbase
+	return &config
+}
+
+// EffectiveConfig returns the configuration the node runs with, after the
+// sanitization of invalid settings, the reallocation of the caches and the
+// changes made at runtime.
+func (api *PrivateAdminAPI) EffectiveConfig() *Config {
+	return api.BHE.effectiveConfig()
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that invalid settings are replaced by the ones the subsystems would use,
+// leaving the sections of disabled subsystems alone.
+func TestSanitizeConfig(t *testing.T) {
+	config := &Config{TxSchedule: TxScheduleConfig{GlobalSlots: 0, AccountSlots: 16}}
+	if err := sanitizeConfig(config); err != nil {
+		t.Fatalf("failed to sanitize config: %v", err)
+	}
+	if config.Miner.GasPrice == nil || config.Miner.GasPrice.Cmp(DefaultConfig.Miner.GasPrice) != 0 {
+		t.Errorf("miner gas price mismatch: have %v, want %v", config.Miner.GasPrice, DefaultConfig.Miner.GasPrice)
+	}
+	want := DefaultTxScheduleConfig
+	want.AccountSlots = 16
+	if config.TxSchedule != want {
+		t.Errorf("transaction schedule mismatch: have %+v, want %+v", config.TxSchedule, want)
+	}
+	if config.AttackMonitor != (AttackMonitorConfig{}) {
+		t.Errorf("disabled attack monitor sanitized: %+v", config.AttackMonitor)
+	}
+}
+
+// Tests that strict mode refuses invalid settings instead of replacing them.
+func TestSanitizeConfigStrict(t *testing.T) {
+	config := &Config{StrictConfig: true}
+	err := sanitizeConfig(config)
+	if err == nil {
+		t.Fatalf("invalid config accepted in strict mode")
+	}
+	for _, setting := range []string{"Miner.GasPrice", "TxSchedule"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("invalid setting %s not reported: %v", setting, err)
+		}
+	}
+	if config.Miner.GasPrice != nil || config.TxSchedule != (TxScheduleConfig{}) {
+		t.Errorf("config changed in strict mode: %v, %+v", config.Miner.GasPrice, config.TxSchedule)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// encryptionCheckKey holds the encrypted list of encrypted tables, verifying the
+// key and the table selection when the database is opened.
+var encryptionCheckKey = []byte("BHE-encryption-check")
+
+// encryptableTables are the chain database tables values can be encrypted in.
+var encryptableTables = []string{"headers", "bodies", "receipts", "state", "preimages"}
+
+var (
+	errDecryptFailed    = errors.New("failed to decrypt database value")
+	errDatabaseNotEmpty = errors.New("can't enable encryption on an existing unencrypted database")
+)
+
+// DatabaseEncryptionConfig contains the settings of the encryption at rest of
+// the chain database. Only the key-value store is encrypted, the freezer writes
+// its tables itself and ancient data moved there is stored in the clear.
+type DatabaseEncryptionConfig struct {
+	Key    string   // File holding the hex encoded AES-256 key, or the name of a registered key source; disabled if empty
+	Tables []string // Tables to encrypt (headers, bodies, receipts, state, preimages), all if empty
+}
+
+var (
+	encryptionKeySources     = make(map[string]func() ([]byte, error))
+	encryptionKeySourcesLock sync.RWMutex
+)
+
+// RegisterEncryptionKeySource makes a source of database encryption keys, such
+// as a KMS client, available by name. It panics if the name is taken.
+func RegisterEncryptionKeySource(name string, source func() ([]byte, error)) {
+	encryptionKeySourcesLock.Lock()
+	defer encryptionKeySourcesLock.Unlock()
+
+	if _, ok := encryptionKeySources[name]; ok {
+		panic(fmt.Sprintf("encryption key source %q already registered", name))
+	}
+	encryptionKeySources[name] = source
+}
+
+// loadEncryptionKey retrieves the key from the registered source of the given
+// name, or from the key file at that path otherwise.
+func loadEncryptionKey(name string) ([]byte, error) {
+	encryptionKeySourcesLock.RLock()
+	source, ok := encryptionKeySources[name]
+	encryptionKeySourcesLock.RUnlock()
+
+	var (
+		key []byte
+		err error
+	)
+	if ok {
+		key, err = source()
+	} else {
+		var blob []byte
+		if blob, err = ioutil.ReadFile(name); err == nil {
+			key, err = hex.DecodeString(strings.TrimSpace(string(blob)))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database encryption key: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid database encryption key length %d, want 32", len(key))
+	}
+	return key, nil
+}
+
+// chainTable classifies a chain database key into the table it belongs to,
+// following the rawdb schema. Keys outside the encryptable tables (head
+// pointers, canonical and lookup indices, bloom bits, ...) are "meta".
+func chainTable(key []byte) string {
+	switch {
+	case len(key) == 32:
+		return "state" // Trie node or legacy code
+	case len(key) == 41 && key[0] == 'h', len(key) == 42 && key[0] == 'h' && key[41] == 't':
+		return "headers"
+	case len(key) == 41 && key[0] == 'b':
+		return "bodies"
+	case len(key) == 41 && key[0] == 'r':
+		return "receipts"
+	case len(key) == 33 && (key[0] == 'c' || key[0] == 'a'), len(key) == 65 && key[0] == 'o':
+		return "state" // Code and snapshot entries
+	case bytes.HasPrefix(key, []byte("secure-key-")):
+		return "preimages"
+	}
+	return "meta"
+}
+
+// encryptedStore encrypts the values of selected tables of a key-value store
+// with AES-GCM. Keys stay in the clear, so that the store can still order and
+// iterate them, and are authenticated along with the values they belong to,
+// so values can't be swapped between keys.
+type encryptedStore struct {
+	BHEdb.KeyValueStore
+	aead   cipher.AEAD
+	tables map[string]bool
+}
+
+// openEncryptedStore wraps a key-value store with encryption. A fresh store is
+// marked encrypted with the configured tables; an existing one must have been
+// encrypted with the same key and tables.
+func openEncryptedStore(db BHEdb.KeyValueStore, config DatabaseEncryptionConfig, readonly bool) (*encryptedStore, error) {
+	key, err := loadEncryptionKey(config.Key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	tables := config.Tables
+	if len(tables) == 0 {
+		tables = encryptableTables
+	}
+	store := &encryptedStore{KeyValueStore: db, aead: aead, tables: make(map[string]bool)}
+	for _, table := range tables {
+		known := false
+		for _, t := range encryptableTables {
+			known = known || t == table
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown encryptable table %q", table)
+		}
+		store.tables[table] = true
+	}
+	selection := make([]string, 0, len(store.tables))
+	for table := range store.tables {
+		selection = append(selection, table)
+	}
+	sort.Strings(selection)
+	want, _ := json.Marshal(selection)
+
+	sealed, err := db.Get(encryptionCheckKey)
+	if err != nil {
+		// No marker, only a fresh store may be encrypted
+		it := db.NewIterator(nil, nil)
+		empty := !it.Next()
+		it.Release()
+		if !empty {
+			return nil, errDatabaseNotEmpty
+		}
+		if readonly {
+			return store, nil
+		}
+		if err := db.Put(encryptionCheckKey, store.seal(encryptionCheckKey, want)); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	have, err := store.open(encryptionCheckKey, sealed)
+	if err != nil {
+		return nil, errors.New("wrong database encryption key")
+	}
+	if !bytes.Equal(have, want) {
+		return nil, fmt.Errorf("database encrypted tables %s, configured %s", have, want)
+	}
+	return store, nil
+}
+
+// encrypted reports whether the value of a key is stored encrypted.
+func (s *encryptedStore) encrypted(key []byte) bool {
+	return s.tables[chainTable(key)]
+}
+
+// seal encrypts a value with a random nonce, prepended to the ciphertext.
+func (s *encryptedStore) seal(key, value []byte) []byte {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(value)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("failed to generate nonce: %v", err))
+	}
+	return s.aead.Seal(nonce, nonce, value, key)
+}
+
+// open decrypts a sealed value.
+func (s *encryptedStore) open(key, sealed []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errDecryptFailed
+	}
+	value, err := s.aead.Open(nil, sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():], key)
+	if err != nil {
+		return nil, errDecryptFailed
+	}
+	return value, nil
+}
+
+// Get implements BHEdb.KeyValueReader, decrypting the value if needed.
+func (s *encryptedStore) Get(key []byte) ([]byte, error) {
+	value, err := s.KeyValueStore.Get(key)
+	if err != nil || !s.encrypted(key) {
+		return value, err
+	}
+	return s.open(key, value)
+}
+
+// Put implements BHEdb.KeyValueWriter, encrypting the value if needed.
+func (s *encryptedStore) Put(key []byte, value []byte) error {
+	if s.encrypted(key) {
+		value = s.seal(key, value)
+	}
+	return s.KeyValueStore.Put(key, value)
+}
+
+// NewBatch implements BHEdb.Batcher, encrypting the values written.
+func (s *encryptedStore) NewBatch() BHEdb.Batch {
+	return &encryptedBatch{Batch: s.KeyValueStore.NewBatch(), store: s}
+}
+
+// NewIterator implements BHEdb.Iteratee, decrypting the values iterated.
+func (s *encryptedStore) NewIterator(prefix []byte, start []byte) BHEdb.Iterator {
+	return &encryptedIterator{Iterator: s.KeyValueStore.NewIterator(prefix, start), store: s}
+}
+
+// encryptedBatch is a write batch of an encrypted store.
+type encryptedBatch struct {
+	BHEdb.Batch
+	store *encryptedStore
+}
+
+func (b *encryptedBatch) Put(key []byte, value []byte) error {
+	if b.store.encrypted(key) {
+		value = b.store.seal(key, value)
+	}
+	return b.Batch.Put(key, value)
+}
+
+// Replay replays the batch contents decrypted.
+func (b *encryptedBatch) Replay(w BHEdb.KeyValueWriter) error {
+	return b.Batch.Replay(&decryptingWriter{KeyValueWriter: w, store: b.store})
+}
+
+// decryptingWriter decrypts the values replayed into a writer.
+type decryptingWriter struct {
+	BHEdb.KeyValueWriter
+	store *encryptedStore
+}
+
+func (w *decryptingWriter) Put(key []byte, value []byte) error {
+	if w.store.encrypted(key) {
+		var err error
+		if value, err = w.store.open(key, value); err != nil {
+			return err
+		}
+	}
+	return w.KeyValueWriter.Put(key, value)
+}
+
+// encryptedIterator decrypts the values of an encrypted store while iterating.
+// Values failing to decrypt end the iteration with an error.
+type encryptedIterator struct {
+	BHEdb.Iterator
+	store *encryptedStore
+	value []byte
+	err   error
+}
+
+func (it *encryptedIterator) Next() bool {
+	if it.err != nil || !it.Iterator.Next() {
+		it.value = nil
+		return false
+	}
+	key, value := it.Iterator.Key(), it.Iterator.Value()
+	if !it.store.encrypted(key) {
+		it.value = value
+		return true
+	}
+	if it.value, it.err = it.store.open(key, value); it.err != nil {
+		it.value = nil
+		return false
+	}
+	return true
+}
+
+func (it *encryptedIterator) Value() []byte { return it.value }
+
+func (it *encryptedIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.Iterator.Error()
+}
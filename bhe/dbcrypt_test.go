@@ -0,0 +1,148 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestKey writes a hex encoded encryption key into a temporary directory,
+// returning the path of the key file.
+func writeTestKey(t *testing.T, dir string, name string, seed byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(bytes.Repeat([]byte{seed}, 32))+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Tests that chain database keys are classified into the right tables.
+func TestChainTable(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xaa}, 32)
+	num := make([]byte, 8)
+
+	tests := []struct {
+		key   []byte
+		table string
+	}{
+		{hash, "state"},
+		{append(append([]byte("h"), num...), hash...), "headers"},
+		{append(append(append([]byte("h"), num...), hash...), 't'), "headers"},
+		{append(append([]byte("h"), num...), 'n'), "meta"},
+		{append(append([]byte("b"), num...), hash...), "bodies"},
+		{append(append([]byte("r"), num...), hash...), "receipts"},
+		{append([]byte("c"), hash...), "state"},
+		{append([]byte("secure-key-"), hash...), "preimages"},
+		{[]byte("LastBlock"), "meta"},
+		{encryptionCheckKey, "meta"},
+	}
+	for i, tt := range tests {
+		if table := chainTable(tt.key); table != tt.table {
+			t.Errorf("test %d: table mismatch: have %s, want %s", i, table, tt.table)
+		}
+	}
+}
+
+// Tests that the values of the selected tables are stored encrypted, and read
+// back decrypted directly, through iterators and through replayed batches.
+func TestEncryptedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		config = DatabaseEncryptionConfig{Key: writeTestKey(t, dir, "key", 1), Tables: []string{"state"}}
+	)
+	store, err := openEncryptedStore(db, config, false)
+	if err != nil {
+		t.Fatalf("failed to open encrypted store: %v", err)
+	}
+	var (
+		node  = bytes.Repeat([]byte{0x01}, 32)
+		meta  = []byte("LastBlock")
+		value = []byte("secret trie node")
+	)
+	batch := store.NewBatch()
+	batch.Put(node, value)
+	batch.Put(meta, value)
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := db.Get(node); bytes.Contains(raw, value) {
+		t.Errorf("state value stored in the clear: %x", raw)
+	}
+	if raw, _ := db.Get(meta); !bytes.Equal(raw, value) {
+		t.Errorf("meta value mismatch: have %x, want %x", raw, value)
+	}
+	if have, err := store.Get(node); err != nil || !bytes.Equal(have, value) {
+		t.Errorf("decrypted value mismatch: have %x, %v, want %x", have, err, value)
+	}
+	it := store.NewIterator(nil, nil)
+	for it.Next() {
+		if bytes.Equal(it.Key(), encryptionCheckKey) {
+			continue
+		}
+		if !bytes.Equal(it.Value(), value) {
+			t.Errorf("iterated value of %x mismatch: have %x, want %x", it.Key(), it.Value(), value)
+		}
+	}
+	if err := it.Error(); err != nil {
+		t.Errorf("iteration failed: %v", err)
+	}
+	it.Release()
+
+	replayed := rawdb.NewMemoryDatabase()
+	if err := batch.Replay(replayed); err != nil {
+		t.Fatalf("failed to replay batch: %v", err)
+	}
+	if have, _ := replayed.Get(node); !bytes.Equal(have, value) {
+		t.Errorf("replayed value mismatch: have %x, want %x", have, value)
+	}
+	// Reopening must use the same key and tables
+	if _, err := openEncryptedStore(db, config, false); err != nil {
+		t.Errorf("failed to reopen encrypted store: %v", err)
+	}
+	if _, err := openEncryptedStore(db, DatabaseEncryptionConfig{Key: writeTestKey(t, dir, "other", 2)}, false); err == nil {
+		t.Errorf("store opened with the wrong key")
+	}
+	if _, err := openEncryptedStore(db, DatabaseEncryptionConfig{Key: config.Key}, false); err == nil {
+		t.Errorf("store opened with different tables")
+	}
+}
+
+// Tests that encryption can't be enabled on a database with unencrypted data.
+func TestEncryptedStoreExisting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := rawdb.NewMemoryDatabase()
+	db.Put([]byte("LastBlock"), []byte{0x01})
+	if _, err := openEncryptedStore(db, DatabaseEncryptionConfig{Key: writeTestKey(t, dir, "key", 1)}, false); err != errDatabaseNotEmpty {
+		t.Fatalf("error mismatch: have %v, want %v", err, errDatabaseNotEmpty)
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDatabaseEngine is the key-value store of the chain data unless
+	// configured otherwise.
+	DefaultDatabaseEngine = "leveldb"
+
+	// databaseEngineFile is the name of the file in a database directory that
+	// records the engine the data is stored with.
+	databaseEngineFile = "ENGINE"
+)
+
+var errMigrationBusy = errors.New("database migration already running")
+
+// databaseConfigError is returned if the chain database can't be opened as
+// configured, like with the wrong engine or encryption key, as opposed to the
+// data being damaged. Automatic resync never acts on it.
+type databaseConfigError struct {
+	err error
+}
+
+func (e *databaseConfigError) Error() string { return e.err.Error() }
+func (e *databaseConfigError) Unwrap() error { return e.err }
+
+// DatabaseDriver opens the key-value stores of a database engine, such as
+// Pebble or BadgerDB, for the chain data.
+type DatabaseDriver interface {
+	// Open opens or creates the store in the given directory. Cache is the memory
+	// allowance in megabytes and handles the number of open files, which drivers
+	// may interpret loosely. Metrics are to be reported under namespace.
+	Open(dir string, cache, handles int, namespace string, readonly bool) (BHEdb.KeyValueStore, error)
+}
+
+// levelDBDriver is the built-in LevelDB engine.
+type levelDBDriver struct{}
+
+func (levelDBDriver) Open(dir string, cache, handles int, namespace string, readonly bool) (BHEdb.KeyValueStore, error) {
+	return rawdb.NewLevelDBDatabase(dir, cache, handles, namespace, readonly)
+}
+
+var (
+	databaseDrivers     = map[string]DatabaseDriver{DefaultDatabaseEngine: levelDBDriver{}}
+	databaseDriversLock sync.RWMutex
+)
+
+// RegisterDatabaseDriver makes a database engine available by name, to be
+// selected by the DatabaseEngine option. It panics if the name is taken.
+func RegisterDatabaseDriver(name string, driver DatabaseDriver) {
+	databaseDriversLock.Lock()
+	defer databaseDriversLock.Unlock()
+
+	if _, ok := databaseDrivers[name]; ok {
+		panic(fmt.Sprintf("database driver %q already registered", name))
+	}
+	databaseDrivers[name] = driver
+}
+
+// lookupDatabaseDriver returns the driver of a database engine.
+func lookupDatabaseDriver(engine string) (DatabaseDriver, error) {
+	databaseDriversLock.RLock()
+	defer databaseDriversLock.RUnlock()
+
+	driver, ok := databaseDrivers[engine]
+	if !ok {
+		return nil, fmt.Errorf("unknown database engine %q", engine)
+	}
+	return driver, nil
+}
+
+// detectDatabaseEngine returns the engine the data in a directory is stored
+// with, empty if there is no data yet. Directories predating the engine file
+// are LevelDB ones.
+func detectDatabaseEngine(dir string) (string, error) {
+	blob, err := ioutil.ReadFile(filepath.Join(dir, databaseEngineFile))
+	if err == nil {
+		return strings.TrimSpace(string(blob)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "CURRENT")); err == nil {
+		return DefaultDatabaseEngine, nil
+	}
+	return "", nil
+}
+
+// writeDatabaseEngine records the engine of a database directory.
+func writeDatabaseEngine(dir, engine string) error {
+	return ioutil.WriteFile(filepath.Join(dir, databaseEngineFile), []byte(engine+"\n"), 0644)
+}
+
+// openChainStore opens the chain data with the configured database engine,
+// refusing to open data stored with another one, or from the configured remote
+// store. The freezer is engine agnostic and opened as usual on top of the
+// key-value store.
+func openChainStore(ctx *node.ServiceContext, config *Config) (BHEdb.Database, error) {
+	if config.RemoteDatabase != "" {
+		return openRemoteChainStore(ctx, config)
+	}
+	engine := config.DatabaseEngine
+	if engine == "" {
+		engine = DefaultDatabaseEngine
+	}
+	dir := ctx.ResolvePath("chaindata")
+	if dir == "" {
+		return rawdb.NewMemoryDatabase(), nil // Ephemeral node, nothing persisted
+	}
+	existing, err := detectDatabaseEngine(dir)
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" && existing != engine {
+		return nil, &databaseConfigError{fmt.Errorf("chain data stored with %s, configured engine is %s (migrate with admin_migrateDatabase)", existing, engine)}
+	}
+	var db BHEdb.Database
+	if engine == DefaultDatabaseEngine && config.DatabaseEncryption.Key == "" {
+		if db, err = ctx.OpenDatabaseWithFreezer("chaindata", config.DatabaseCache, config.DatabaseHandles, config.DatabaseFreezer, "BHE/db/chaindata/", config.ReadOnly); err != nil {
+			return nil, err
+		}
+	} else {
+		driver, err := lookupDatabaseDriver(engine)
+		if err != nil {
+			return nil, &databaseConfigError{err}
+		}
+		kvdb, err := driver.Open(dir, config.DatabaseCache, config.DatabaseHandles, "BHE/db/chaindata/", config.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		if config.DatabaseEncryption.Key != "" {
+			encrypted, err := openEncryptedStore(kvdb, config.DatabaseEncryption, config.ReadOnly)
+			if err != nil {
+				kvdb.Close()
+				return nil, &databaseConfigError{err}
+			}
+			kvdb = encrypted
+		}
+		freezer := config.DatabaseFreezer
+		switch {
+		case freezer == "":
+			freezer = filepath.Join(dir, "ancient")
+		case !filepath.IsAbs(freezer):
+			freezer = ctx.ResolvePath(freezer)
+		}
+		if db, err = rawdb.NewDatabaseWithFreezer(kvdb, freezer, "BHE/db/chaindata/"); err != nil {
+			kvdb.Close()
+			return nil, err
+		}
+		log.Info("Opened chain database", "engine", engine, "path", dir, "encrypted", config.DatabaseEncryption.Key != "")
+	}
+	if config.DatabaseEncryption.Key == "" {
+		if encrypted, _ := db.Has(encryptionCheckKey); encrypted {
+			db.Close()
+			return nil, &databaseConfigError{errors.New("chain data is encrypted, but no encryption key is configured")}
+		}
+	}
+	if existing == "" && !config.ReadOnly {
+		if err := writeDatabaseEngine(dir, engine); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// migrateDatabase copies every key-value pair of a store into another, counting
+// the items and bytes copied. Ancient data is not part of the key-value store
+// and isn't copied. Neither is the encryption marker: values are copied as the
+// source reads them, so an encrypted target seals a marker of its own.
+func migrateDatabase(src BHEdb.Iteratee, dst BHEdb.KeyValueStore, items, size *uint64, interrupt chan struct{}) error {
+	var (
+		it     = src.NewIterator(nil, nil)
+		batch  = dst.NewBatch()
+		start  = time.Now()
+		logged = time.Now()
+	)
+	defer it.Release()
+
+	for it.Next() {
+		if bytes.Equal(it.Key(), encryptionCheckKey) {
+			continue
+		}
+		if err := batch.Put(it.Key(), it.Value()); err != nil {
+			return err
+		}
+		atomic.AddUint64(items, 1)
+		atomic.AddUint64(size, uint64(len(it.Key())+len(it.Value())))
+
+		if batch.ValueSize() > BHEdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+
+			select {
+			case <-interrupt:
+				return errors.New("migration interrupted")
+			default:
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Migrating chain database", "items", atomic.LoadUint64(items), "size", common.StorageSize(atomic.LoadUint64(size)), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// DatabaseMigration is a copy of the chain data into another database engine.
+type DatabaseMigration struct {
+	Engine   string    `json:"engine"`
+	Path     string    `json:"path"`
+	Started  time.Time `json:"started"`
+	Items    uint64    `json:"items"`
+	Size     uint64    `json:"size"`
+	Finished bool      `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+
+	interrupt chan struct{}
+	done      chan struct{}
+}
+
+// startDatabaseMigration copies the chain data in the background into a new
+// store of the given engine. The copy is of a consistent snapshot of the data
+// when started. Encrypted chain data is encrypted in the new store with the
+// same key and tables.
+func (s *BHEereum) startDatabaseMigration(engine, dir string) error {
+	driver, err := lookupDatabaseDriver(engine)
+	if err != nil {
+		return err
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("migration target %s not empty", dir)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if job := s.dbMigration; job != nil && !job.Finished {
+		return errMigrationBusy
+	}
+	dst, err := driver.Open(dir, s.config.DatabaseCache, s.config.DatabaseHandles, "BHE/db/migration/", false)
+	if err != nil {
+		return err
+	}
+	if s.config.DatabaseEncryption.Key != "" {
+		encrypted, err := openEncryptedStore(dst, s.config.DatabaseEncryption, false)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+		dst = encrypted
+	}
+	job := &DatabaseMigration{
+		Engine:    engine,
+		Path:      dir,
+		Started:   time.Now(),
+		interrupt: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	s.dbMigration = job
+
+	go func() {
+		defer close(job.done)
+
+		err := migrateDatabase(s.chainDb, dst, &job.Items, &job.Size, job.interrupt)
+		if err == nil {
+			err = writeDatabaseEngine(dir, engine)
+		}
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		s.lock.Lock()
+		job.Finished = true
+		if err != nil {
+			job.Error = err.Error()
+		}
+		s.lock.Unlock()
+
+		if err != nil {
+			log.Error("Chain database migration failed", "engine", engine, "path", dir, "err", err)
+			return
+		}
+		log.Info("Migrated chain database", "engine", engine, "path", dir, "items", job.Items, "size", common.StorageSize(job.Size), "elapsed", common.PrettyDuration(time.Since(job.Started)))
+	}()
+	return nil
+}
+
+// stopDatabaseMigration interrupts the migration in progress, if any, and waits
+// for it to release the chain database.
+func (s *BHEereum) stopDatabaseMigration() {
+	s.lock.Lock()
+	job := s.dbMigration
+	s.lock.Unlock()
+
+	if job != nil && !job.Finished {
+		close(job.interrupt)
+		<-job.done
+	}
+}
+
+// MigrateDatabase copies the chain data in the background into an empty
+// directory using another database engine. Once finished, stop the node, swap
+// the new directory in for chaindata, moving the ancient directory along unless
+// the freezer is configured elsewhere, and set DatabaseEngine. Blocks imported
+// after the migration started are synced again.
+func (api *PrivateAdminAPI) MigrateDatabase(engine string, path string) (bool, error) {
+	if err := api.BHE.startDatabaseMigration(engine, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DatabaseMigration returns the progress of the last database migration, nil
+// if there was none.
+func (api *PrivateAdminAPI) DatabaseMigration() *DatabaseMigration {
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	job := api.BHE.dbMigration
+	if job == nil {
+		return nil
+	}
+	return &DatabaseMigration{
+		Engine:   job.Engine,
+		Path:     job.Path,
+		Started:  job.Started,
+		Items:    atomic.LoadUint64(&job.Items),
+		Size:     atomic.LoadUint64(&job.Size),
+		Finished: job.Finished,
+		Error:    job.Error,
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that the engine of a database directory is detected from the engine
+// file, falling back to LevelDB for directories predating it.
+func TestDetectDatabaseEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if engine, err := detectDatabaseEngine(dir); err != nil || engine != "" {
+		t.Fatalf("empty directory engine mismatch: have %q, %v, want none", engine, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "CURRENT"), []byte("MANIFEST-000001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if engine, err := detectDatabaseEngine(dir); err != nil || engine != DefaultDatabaseEngine {
+		t.Fatalf("legacy directory engine mismatch: have %q, %v, want %q", engine, err, DefaultDatabaseEngine)
+	}
+	if err := writeDatabaseEngine(dir, "pebble"); err != nil {
+		t.Fatal(err)
+	}
+	if engine, err := detectDatabaseEngine(dir); err != nil || engine != "pebble" {
+		t.Fatalf("recorded engine mismatch: have %q, %v, want %q", engine, err, "pebble")
+	}
+}
+
+// Tests that a migration copies every key-value pair across stores.
+func TestMigrateDatabase(t *testing.T) {
+	var (
+		src = rawdb.NewMemoryDatabase()
+		dst = rawdb.NewMemoryDatabase()
+	)
+	for i := 0; i < 10000; i++ {
+		key := []byte{byte(i >> 8), byte(i)}
+		if err := src.Put(key, bytes.Repeat(key, 64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var items, size uint64
+	if err := migrateDatabase(src, dst, &items, &size, make(chan struct{})); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if items != 10000 || size != 10000*(2+128) {
+		t.Errorf("migration progress mismatch: have %d items, %d bytes", items, size)
+	}
+	it := src.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		if have, err := dst.Get(it.Key()); err != nil || !bytes.Equal(have, it.Value()) {
+			t.Fatalf("key %x mismatch: have %x, %v", it.Key(), have, err)
+		}
+	}
+}
+
+// Tests that an encrypted store migrates into another encrypted with the same
+// key, the target sealing its own marker instead of taking the source's.
+func TestMigrateEncryptedDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := DatabaseEncryptionConfig{Key: writeTestKey(t, dir, "key", 1)}
+	src, err := openEncryptedStore(rawdb.NewMemoryDatabase(), config, false)
+	if err != nil {
+		t.Fatalf("failed to open source store: %v", err)
+	}
+	key := bytes.Repeat([]byte{0xaa}, 32)
+	if err := src.Put(key, []byte("node")); err != nil {
+		t.Fatal(err)
+	}
+	raw := rawdb.NewMemoryDatabase()
+	dst, err := openEncryptedStore(raw, config, false)
+	if err != nil {
+		t.Fatalf("failed to open target store: %v", err)
+	}
+	var items, size uint64
+	if err := migrateDatabase(src, dst, &items, &size, make(chan struct{})); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if items != 1 {
+		t.Errorf("migrated items mismatch: have %d, want 1", items)
+	}
+	reopened, err := openEncryptedStore(raw, config, false)
+	if err != nil {
+		t.Fatalf("failed to reopen target store: %v", err)
+	}
+	if value, err := reopened.Get(key); err != nil || string(value) != "node" {
+		t.Fatalf("migrated value mismatch: have %q, %v, want %q", value, err, "node")
+	}
+	if value, _ := raw.Get(key); bytes.Equal(value, []byte("node")) {
+		t.Fatalf("migrated value stored in the clear")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// databaseServerMaxItems is the maximum number of entries in one range.
+	databaseServerMaxItems = 4096
+
+	// databaseServerSoftLimit is the target maximum size of a range in bytes. A
+	// range is cut short once it is exceeded, even if below the item limit.
+	databaseServerSoftLimit = 4 * 1024 * 1024
+)
+
+// databaseEntry is a key-value pair of a served range.
+type databaseEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// databaseServer exposes the chain database of a synced node over plain HTTP,
+// for read replicas using it as their remote store. The data is public chain
+// data, but the server must only be reachable by the replicas, as it has no
+// access control and serves arbitrary ranges.
+type databaseServer struct {
+	db   BHEdb.Database
+	addr string
+
+	listener net.Listener
+	server   *http.Server
+}
+
+func newDatabaseServer(db BHEdb.Database, addr string) *databaseServer {
+	return &databaseServer{db: db, addr: addr}
+}
+
+// start opens the listener and starts serving requests in the background.
+func (s *databaseServer) start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("database server: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get", s.serveGet)
+	mux.HandleFunc("/range", s.serveRange)
+
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go s.server.Serve(listener)
+
+	log.Info("Database server started", "addr", listener.Addr())
+	return nil
+}
+
+// stop terminates the server, if it is running.
+func (s *databaseServer) stop() {
+	if s == nil || s.server == nil {
+		return
+	}
+	s.server.Close()
+	log.Info("Database server stopped", "addr", s.listener.Addr())
+}
+
+// serveGet serves the value of a single key.
+//
+//	GET /get?key=<hex>
+func (s *databaseServer) serveGet(w http.ResponseWriter, r *http.Request) {
+	key, err := hex.DecodeString(r.URL.Query().Get("key"))
+	if err != nil {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	if ok, err := s.db.Has(key); err != nil || !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	value, err := s.db.Get(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+// serveRange serves the RLP encoded entries with a prefix, in key order from
+// prefix+start on.
+//
+//	GET /range?prefix=<hex>&start=<hex>&limit=<n>
+func (s *databaseServer) serveRange(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, err := hex.DecodeString(query.Get("prefix"))
+	if err != nil {
+		http.Error(w, "invalid prefix", http.StatusBadRequest)
+		return
+	}
+	start, err := hex.DecodeString(query.Get("start"))
+	if err != nil {
+		http.Error(w, "invalid start", http.StatusBadRequest)
+		return
+	}
+	limit := databaseServerMaxItems
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		if limit > databaseServerMaxItems {
+			limit = databaseServerMaxItems
+		}
+	}
+	var (
+		it      = s.db.NewIterator(prefix, start)
+		entries []databaseEntry
+		size    int
+	)
+	defer it.Release()
+
+	for len(entries) < limit && size < databaseServerSoftLimit && it.Next() {
+		entries = append(entries, databaseEntry{Key: common.CopyBytes(it.Key()), Value: common.CopyBytes(it.Value())})
+		size += len(it.Key()) + len(it.Value())
+	}
+	if err := it.Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	blob, err := rlp.EncodeToBytes(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(blob)
+}
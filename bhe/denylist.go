@@ -0,0 +1,188 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// errDeniedAddress is returned if a transaction is sent from or to an address
+// on the node's deny-list.
+var errDeniedAddress = errors.New("address is denied")
+
+// DenyEntry describes a single address on the deny-list.
+type DenyEntry struct {
+	Reason string    `json:"reason"`
+	Added  time.Time `json:"added"`
+}
+
+// denyList is a persistent set of addresses which are not allowed to appear as
+// the sender or recipient of any transaction entering the pool. The list is
+// stored as JSON in the node's data directory and rewritten on every change.
+type denyList struct {
+	path    string
+	entries map[common.Address]DenyEntry
+	lock    sync.RWMutex
+}
+
+// loadDenyList reads the deny-list from the given file. A missing file yields
+// an empty list. An empty path yields an in-memory list which is never persisted.
+func loadDenyList(path string) (*denyList, error) {
+	d := &denyList{
+		path:    path,
+		entries: make(map[common.Address]DenyEntry),
+	}
+	if path == "" {
+		return d, nil
+	}
+	blob, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &d.entries); err != nil {
+		return nil, err
+	}
+	log.Info("Loaded address deny-list", "path", path, "addresses", len(d.entries))
+	return d, nil
+}
+
+// empty reports whBHEer there are no denied addresses at all, allowing callers
+// to skip sender recovery on the hot path.
+func (d *denyList) empty() bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return len(d.entries) == 0
+}
+
+// check returns an error if the sender or the recipient of the transaction is
+// denied. Every rejection is logged for audit.
+func (d *denyList) check(tx *types.Transaction, from common.Address, local bool) error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if _, ok := d.entries[from]; ok {
+		log.Warn("Rejected transaction from denied address", "hash", tx.Hash(), "from", from, "local", local)
+		return errDeniedAddress
+	}
+	if to := tx.To(); to != nil {
+		if _, ok := d.entries[*to]; ok {
+			log.Warn("Rejected transaction to denied address", "hash", tx.Hash(), "from", from, "to", *to, "local", local)
+			return errDeniedAddress
+		}
+	}
+	return nil
+}
+
+// add inserts an address into the deny-list and persists the change. If the
+// change can't be persisted, the list is left as it was.
+func (d *denyList) add(addr common.Address, reason string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	old, existed := d.entries[addr]
+	d.entries[addr] = DenyEntry{Reason: reason, Added: time.Now().UTC()}
+	if err := d.flush(); err != nil {
+		if existed {
+			d.entries[addr] = old
+		} else {
+			delete(d.entries, addr)
+		}
+		return err
+	}
+	return nil
+}
+
+// remove deletes an address from the deny-list, reporting whBHEer it was there.
+// If the change can't be persisted, the address stays denied.
+func (d *denyList) remove(addr common.Address) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	old, ok := d.entries[addr]
+	if !ok {
+		return false, nil
+	}
+	delete(d.entries, addr)
+	if err := d.flush(); err != nil {
+		d.entries[addr] = old
+		return false, err
+	}
+	return true, nil
+}
+
+// list returns a copy of the deny-list.
+func (d *denyList) list() map[common.Address]DenyEntry {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	entries := make(map[common.Address]DenyEntry, len(d.entries))
+	for addr, entry := range d.entries {
+		entries[addr] = entry
+	}
+	return entries
+}
+
+// flush atomically writes the deny-list to disk. The caller must hold the lock.
+func (d *denyList) flush() error {
+	if d.path == "" {
+		return nil
+	}
+	blob, err := json.MarshalIndent(d.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+// DenyAddress adds an address to the node-wide deny-list. Transactions sent from
+// or to the address are refused both over RPC and from the network, and those
+// already in the pool are dropped.
+func (api *PrivateAdminAPI) DenyAddress(addr common.Address, reason string) (bool, error) {
+	if err := api.BHE.denyList.add(addr, reason); err != nil {
+		return false, err
+	}
+	dropped := api.BHE.dropDeniedTxs(addr)
+	log.Warn("Address added to deny-list", "address", addr, "reason", reason, "dropped", dropped)
+	return true, nil
+}
+
+// AllowAddress removes an address from the node-wide deny-list.
+func (api *PrivateAdminAPI) AllowAddress(addr common.Address) (bool, error) {
+	removed, err := api.BHE.denyList.remove(addr)
+	if removed {
+		log.Warn("Address removed from deny-list", "address", addr)
+	}
+	return removed, err
+}
+
+// DeniedAddresses returns all the addresses on the node-wide deny-list.
+func (api *PrivateAdminAPI) DeniedAddresses() map[common.Address]DenyEntry {
+	return api.BHE.denyList.list()
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that the deny-list rejects both senders and recipients, and that it
+// survives a reload from disk.
+func TestDenyListPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "denylist.json")
+
+	list, err := loadDenyList(path)
+	if err != nil {
+		t.Fatalf("failed to load empty deny-list: %v", err)
+	}
+	if !list.empty() {
+		t.Fatalf("fresh deny-list not empty")
+	}
+	var (
+		denied = common.Address{0xde}
+		other  = common.Address{0x01}
+		toDeny = types.NewTransaction(0, denied, big.NewInt(1), 21000, big.NewInt(1), nil)
+		toOk   = types.NewTransaction(0, other, big.NewInt(1), 21000, big.NewInt(1), nil)
+	)
+	if err := list.add(denied, "test"); err != nil {
+		t.Fatalf("failed to deny address: %v", err)
+	}
+	if list, err = loadDenyList(path); err != nil {
+		t.Fatalf("failed to reload deny-list: %v", err)
+	}
+	if err := list.check(toOk, denied, true); err != errDeniedAddress {
+		t.Errorf("denied sender admitted: %v", err)
+	}
+	if err := list.check(toDeny, other, true); err != errDeniedAddress {
+		t.Errorf("denied recipient admitted: %v", err)
+	}
+	if err := list.check(toOk, other, true); err != nil {
+		t.Errorf("allowed transaction rejected: %v", err)
+	}
+	if removed, err := list.remove(denied); !removed || err != nil {
+		t.Fatalf("failed to remove address: removed %v, err %v", removed, err)
+	}
+	if list, err = loadDenyList(path); err != nil {
+		t.Fatalf("failed to reload deny-list: %v", err)
+	}
+	if !list.empty() {
+		t.Fatalf("removed address persisted: %v", list.list())
+	}
+}
+
+// Tests that changes which can't be persisted leave the deny-list as it was.
+func TestDenyListFailedFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	list, err := loadDenyList(filepath.Join(dir, "missing", "denylist.json"))
+	if err != nil {
+		t.Fatalf("failed to load empty deny-list: %v", err)
+	}
+	denied := common.Address{0xde}
+	if err := list.add(denied, "test"); err == nil {
+		t.Fatalf("unpersisted denial succeeded")
+	}
+	if !list.empty() {
+		t.Fatalf("unpersisted denial applied: %v", list.list())
+	}
+	list.entries[denied] = DenyEntry{Reason: "test"}
+	if removed, err := list.remove(denied); removed || err == nil {
+		t.Fatalf("unpersisted removal succeeded: removed %v, err %v", removed, err)
+	}
+	if entry, ok := list.list()[denied]; !ok || entry.Reason != "test" {
+		t.Errorf("unpersisted removal applied: %v", list.list())
+	}
+}
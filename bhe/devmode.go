@@ -0,0 +1,308 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// defaultDeveloperAccounts is the number of pre-funded accounts of a developer
+// chain if not configured.
+const defaultDeveloperAccounts = 10
+
+var (
+	errDeveloperMode      = errors.New("developer mode seals blocks on demand")
+	errUnknownDevSnapshot = errors.New("unknown snapshot")
+	errNothingToSeal      = errors.New("no executable transactions to seal")
+
+	// developerBalance is the genesis balance of the developer accounts.
+	developerBalance = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 256), big.NewInt(9))
+)
+
+// devSnapshot is a point of a developer chain to revert to.
+type devSnapshot struct {
+	number uint64        // Head block of the snapshot
+	depth  int           // Layer of the chain database written after the snapshot
+	offset time.Duration // Clock skew at the time of the snapshot
+}
+
+// developerChain is an in-memory chain sealed by a single clique signer held by
+// the node, producing a block whenever transactions arrive or one is requested,
+// without waiting for a sealing period. Its database is layered, every snapshot
+// stacking a new layer, so that reverting discards everything written since.
+type developerChain struct {
+	signer    *ecdsa.PrivateKey
+	accounts  []*ecdsa.PrivateKey // Pre-funded accounts, the signer first
+	db        *overlayStore       // Layered store of the chain database
+	snapshots []devSnapshot
+	lock      sync.Mutex // Protects the snapshots
+}
+
+// newDeveloperChain generates the signer and the pre-funded accounts of a
+// developer chain.
+func newDeveloperChain(accounts int) (*developerChain, error) {
+	if accounts <= 0 {
+		accounts = defaultDeveloperAccounts
+	}
+	dev := &developerChain{db: newOverlayStore()}
+	for i := 0; i < accounts; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		dev.accounts = append(dev.accounts, key)
+	}
+	dev.signer = dev.accounts[0]
+	return dev, nil
+}
+
+// address returns the address of the signer.
+func (d *developerChain) address() common.Address {
+	return crypto.PubkeyToAddress(d.signer.PublicKey)
+}
+
+// genesis creates the genesis block of the chain, authorizing the signer and
+// funding the accounts.
+func (d *developerChain) genesis() *core.Genesis {
+	genesis := core.DeveloperGenesisBlock(0, d.address())
+	for _, key := range d.accounts {
+		genesis.Alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: developerBalance}
+	}
+	return genesis
+}
+
+// signData implements clique.SignerFn with the key of the signer.
+func (d *developerChain) signData(account accounts.Account, mimeType string, message []byte) ([]byte, error) {
+	return crypto.Sign(crypto.Keccak256(message), d.signer)
+}
+
+// seal signs a clique header with the key of the signer.
+func (d *developerChain) seal(header *types.Header) error {
+	if len(header.Extra) < crypto.SignatureLength {
+		return errors.New("extra-data lacks the signature")
+	}
+	sig, err := crypto.Sign(clique.SealHash(header).Bytes(), d.signer)
+	if err != nil {
+		return err
+	}
+	copy(header.Extra[len(header.Extra)-crypto.SignatureLength:], sig)
+	return nil
+}
+
+// sealBlock assembles a block on top of the head from the executable pool
+// transactions, seals it and writes it to the chain. Unlike the miner it seals
+// right away, with the given timestamp, advanced past the parent's if needed.
+// Empty blocks are only sealed if requested.
+func (s *BHEereum) sealBlock(timestamp uint64, empty bool, seal func(*types.Header) error) (*types.Block, error) {
+	chain := s.blockchain
+	parent := chain.CurrentBlock()
+	if timestamp <= parent.Time() {
+		timestamp = parent.Time() + 1
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   core.CalcGasLimit(parent, s.config.Miner.GasFloor, s.config.Miner.GasCeil),
+		Time:       timestamp,
+	}
+	if err := s.engine.Prepare(chain, header); err != nil {
+		return nil, err
+	}
+	header.Time = timestamp // Prepare pulls the timestamp to the wall clock
+
+	statedb, err := chain.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.txPool.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		signer   = types.MakeSigner(chain.Config(), header.Number)
+		txs      = types.NewTransactionsByPriceAndNonce(signer, pending)
+		gp       = new(core.GasPool).AddGas(header.GasLimit)
+		included []*types.Transaction
+		receipts []*types.Receipt
+	)
+	for gp.Gas() >= params.TxGas {
+		tx := txs.Peek()
+		if tx == nil {
+			break
+		}
+		statedb.Prepare(tx.Hash(), common.Hash{}, len(included))
+		snap := statedb.Snapshot()
+
+		receipt, err := core.ApplyTransaction(chain.Config(), chain, &header.Coinbase, gp, statedb, header, tx, &header.GasUsed, *chain.GetVMConfig())
+		switch {
+		case errors.Is(err, core.ErrNonceTooLow):
+			statedb.RevertToSnapshot(snap)
+			txs.Shift()
+		case err != nil:
+			log.Debug("Skipping transaction of sender", "hash", tx.Hash(), "err", err)
+			statedb.RevertToSnapshot(snap)
+			txs.Pop()
+		default:
+			included = append(included, tx)
+			receipts = append(receipts, receipt)
+			txs.Shift()
+		}
+	}
+	if len(included) == 0 && !empty {
+		return nil, errNothingToSeal
+	}
+	if err := consensus.SplitFees(s.engine, chain, header, statedb, included, receipts); err != nil {
+		return nil, err
+	}
+	block, err := s.engine.FinalizeAndAssemble(chain, header, statedb, included, nil, receipts)
+	if err != nil {
+		return nil, err
+	}
+	sealed := block.Header()
+	if err := seal(sealed); err != nil {
+		return nil, err
+	}
+	block = block.WithSeal(sealed)
+
+	// Point the receipts and logs to the sealed block before writing them
+	var logs []*types.Log
+	for i, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = block.Number()
+		receipt.TransactionIndex = uint(i)
+		for _, log := range receipt.Logs {
+			log.BlockHash = block.Hash()
+		}
+		logs = append(logs, receipt.Logs...)
+	}
+	if _, err := chain.WriteBlockWithState(block, receipts, logs, statedb, true); err != nil {
+		return nil, err
+	}
+	s.eventMux.Post(core.NewMinedBlockEvent{Block: block})
+	log.Info("Sealed new block on demand", "number", block.Number(), "hash", block.Hash(), "txs", len(included), "gas", block.GasUsed())
+	return block, nil
+}
+
+// startDeveloperChain authorizes the signer with the consensus engine and starts
+// sealing a block whenever transactions arrive in the pool.
+func (s *BHEereum) startDeveloperChain() {
+	if engine, ok := s.engine.(*clique.Clique); ok {
+		engine.Authorize(s.dev.address(), s.dev.signData)
+	}
+	s.lock.Lock()
+	s.automine = s.startAutomine(s.dev.seal)
+	s.lock.Unlock()
+}
+
+// DeveloperAccount is a pre-funded account of a developer chain.
+type DeveloperAccount struct {
+	Address    common.Address `json:"address"`
+	PrivateKey hexutil.Bytes  `json:"privateKey"`
+}
+
+// DeveloperAPI offers the test node controls of a developer chain.
+type DeveloperAPI struct {
+	BHE *BHEereum
+}
+
+// NewDeveloperAPI creates a new developer chain control API.
+func NewDeveloperAPI(BHE *BHEereum) *DeveloperAPI {
+	return &DeveloperAPI{BHE: BHE}
+}
+
+// Accounts returns the pre-funded accounts of the chain along with their keys,
+// the first one being the signer.
+func (api *DeveloperAPI) Accounts() []DeveloperAccount {
+	accounts := make([]DeveloperAccount, 0, len(api.BHE.dev.accounts))
+	for _, key := range api.BHE.dev.accounts {
+		accounts = append(accounts, DeveloperAccount{
+			Address:    crypto.PubkeyToAddress(key.PublicKey),
+			PrivateKey: crypto.FromECDSA(key),
+		})
+	}
+	return accounts
+}
+
+// Mine seals a block right away with the pending transactions, which may be
+// none, optionally at the given timestamp. It returns the hash of the block.
+func (api *DeveloperAPI) Mine(timestamp *hexutil.Uint64) (common.Hash, error) {
+	var ts uint64
+	if timestamp != nil {
+		ts = uint64(*timestamp)
+	}
+	block, err := api.BHE.sealNow(ts, true, api.BHE.dev.seal)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return block.Hash(), nil
+}
+
+// Snapshot records the chain, its state and its clock at the head block, stacking
+// a new layer on the chain database for the writes to come. It returns the id to
+// revert to it with evm_revert.
+func (api *DeveloperAPI) Snapshot() hexutil.Uint64 {
+	api.BHE.sealLock.Lock()
+	defer api.BHE.sealLock.Unlock()
+
+	dev := api.BHE.dev
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	dev.snapshots = append(dev.snapshots, devSnapshot{
+		number: api.BHE.blockchain.CurrentBlock().NumberU64(),
+		depth:  dev.db.push(),
+		offset: api.BHE.clock.skew(),
+	})
+	return hexutil.Uint64(len(dev.snapshots))
+}
+
+// Revert restores the chain, its state and its clock to a snapshot, discarding
+// the database layers written since along with the snapshot and all later ones.
+// The pool is reset to the restored head, dropping the transactions included
+// after the snapshot.
+func (api *DeveloperAPI) Revert(id hexutil.Uint64) (bool, error) {
+	api.BHE.sealLock.Lock()
+	defer api.BHE.sealLock.Unlock()
+
+	dev := api.BHE.dev
+	dev.lock.Lock()
+	defer dev.lock.Unlock()
+
+	if id == 0 || int(id) > len(dev.snapshots) {
+		return false, errUnknownDevSnapshot
+	}
+	snap := dev.snapshots[id-1]
+
+	// Rewind the chain first to flush its caches, then drop whatever it and the
+	// indexers wrote since, leaving the database as it was at the snapshot
+	if err := api.BHE.blockchain.SetHead(snap.number); err != nil {
+		return false, err
+	}
+	if err := dev.db.pop(snap.depth); err != nil {
+		return false, err
+	}
+	api.BHE.txPool.ResetHead(api.BHE.blockchain.CurrentBlock().Header())
+
+	api.BHE.setClockSkew(snap.offset)
+	dev.snapshots = dev.snapshots[:id-1]
+	log.Info("Reverted developer chain", "snapshot", uint64(id), "number", snap.number)
+	return true, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that the developer genesis authorizes the signer and funds all the
+// developer accounts.
+func TestDeveloperGenesis(t *testing.T) {
+	dev, err := newDeveloperChain(0)
+	if err != nil {
+		t.Fatalf("failed to create developer chain: %v", err)
+	}
+	if len(dev.accounts) != defaultDeveloperAccounts {
+		t.Fatalf("account count mismatch: have %d, want %d", len(dev.accounts), defaultDeveloperAccounts)
+	}
+	genesis := dev.genesis()
+	if genesis.Config.Clique == nil || genesis.Config.Clique.Period != 0 {
+		t.Fatalf("developer genesis not sealing on demand: %v", genesis.Config.Clique)
+	}
+	for i, key := range dev.accounts {
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		if account, ok := genesis.Alloc[addr]; !ok || account.Balance.Cmp(developerBalance) != 0 {
+			t.Errorf("account %d (%x) not funded", i, addr)
+		}
+	}
+}
+
+// Tests that developer blocks are sealed by the signer.
+func TestDeveloperSeal(t *testing.T) {
+	dev, err := newDeveloperChain(1)
+	if err != nil {
+		t.Fatalf("failed to create developer chain: %v", err)
+	}
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(2), Extra: make([]byte, 32+crypto.SignatureLength)}
+	if err := dev.seal(header); err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	pubkey, err := crypto.SigToPub(clique.SealHash(header).Bytes(), header.Extra[32:])
+	if err != nil {
+		t.Fatalf("failed to recover signer: %v", err)
+	}
+	if signer := crypto.PubkeyToAddress(*pubkey); signer != dev.address() {
+		t.Errorf("signer mismatch: have %x, want %x", signer, dev.address())
+	}
+	if err := dev.seal(&types.Header{Number: big.NewInt(1)}); err == nil {
+		t.Errorf("sealed header without room for the signature")
+	}
+}
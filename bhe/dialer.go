@@ -0,0 +1,233 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	dialLatencyTimer      = metrics.NewRegisteredTimer("BHE/dial/latency", nil)
+	dialUnreachableMeter  = metrics.NewRegisteredMeter("BHE/dial/unreachable", nil)
+	dialDistantQuotaMeter = metrics.NewRegisteredMeter("BHE/dial/distant", nil)
+)
+
+// DialConfig contains the preferences of latency-aware peer dialing.
+type DialConfig struct {
+	LatencyProbes  int           // Discovered candidates probed ahead of dialing, zero disables latency-aware dialing
+	ProbeTimeout   time.Duration // Maximum connection setup time of a probe, slower candidates are dropped
+	DistantPercent int           // Percentage of dials reserved for the slowest candidates, for partition resistance
+}
+
+// DefaultDialConfig contains the default latency-aware dialing preferences.
+var DefaultDialConfig = DialConfig{
+	LatencyProbes:  0,
+	ProbeTimeout:   time.Second,
+	DistantPercent: 20,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *DialConfig) sanitize() DialConfig {
+	conf := *config
+	if conf.ProbeTimeout <= 0 {
+		log.Warn("Sanitizing invalid dial probe timeout", "provided", conf.ProbeTimeout, "updated", DefaultDialConfig.ProbeTimeout)
+		conf.ProbeTimeout = DefaultDialConfig.ProbeTimeout
+	}
+	if conf.DistantPercent < 0 || conf.DistantPercent > 100 {
+		log.Warn("Sanitizing invalid distant dial percentage", "provided", conf.DistantPercent, "updated", DefaultDialConfig.DistantPercent)
+		conf.DistantPercent = DefaultDialConfig.DistantPercent
+	}
+	return conf
+}
+
+// probedNode is a dial candidate with its measured round trip time.
+type probedNode struct {
+	node *enode.Node
+	rtt  time.Duration
+}
+
+// dialQuota spreads the dials reserved for distant candidates evenly among the
+// others.
+type dialQuota struct {
+	percent int
+	credit  int
+}
+
+// distant reports whether the next dial should go to a distant candidate.
+func (q *dialQuota) distant() bool {
+	if q.credit += q.percent; q.credit >= 100 {
+		q.credit -= 100
+		return true
+	}
+	return false
+}
+
+// pickCandidate returns the index of the fastest probed candidate, or of the
+// slowest one if a distant candidate is wanted.
+func pickCandidate(ready []probedNode, distant bool) int {
+	best := 0
+	for i := 1; i < len(ready); i++ {
+		if distant && ready[i].rtt > ready[best].rtt || !distant && ready[i].rtt < ready[best].rtt {
+			best = i
+		}
+	}
+	return best
+}
+
+// probeLatency measures the TCP connection setup time of a node.
+func probeLatency(node *enode.Node, timeout time.Duration) (time.Duration, error) {
+	addr := &net.TCPAddr{IP: node.IP(), Port: node.TCP()}
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr.String(), timeout)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return rtt, nil
+}
+
+// latencyIterator reorders the dial candidates of another iterator by their
+// latency. Candidates are probed ahead of being asked for, and handed out
+// fastest first to speed up block propagation, except for a quota of the
+// slowest ones, which keeps the node connected to distant parts of the network.
+// Probing opens and immediately drops a TCP connection to every candidate.
+type latencyIterator struct {
+	source enode.Iterator
+	config DialConfig
+	probe  func(*enode.Node, time.Duration) (time.Duration, error)
+	quota  dialQuota
+
+	ready    []probedNode // Probed candidates waiting to be dialed
+	inflight int          // Number of probes in progress
+	current  *enode.Node
+	drained  bool // Whether the source ran dry
+	closed   bool
+	lock     sync.Mutex
+	cond     *sync.Cond
+}
+
+func newLatencyIterator(source enode.Iterator, config DialConfig) *latencyIterator {
+	config = config.sanitize()
+	it := &latencyIterator{
+		source: source,
+		config: config,
+		probe:  probeLatency,
+		quota:  dialQuota{percent: config.DistantPercent},
+	}
+	it.cond = sync.NewCond(&it.lock)
+	go it.loop()
+	return it
+}
+
+// loop pulls candidates from the source, keeping up to the configured number
+// of them probed or being probed.
+func (it *latencyIterator) loop() {
+	for it.source.Next() {
+		node := it.source.Node()
+
+		it.lock.Lock()
+		for !it.closed && len(it.ready)+it.inflight >= it.config.LatencyProbes {
+			it.cond.Wait()
+		}
+		if it.closed {
+			it.lock.Unlock()
+			return
+		}
+		it.inflight++
+		it.lock.Unlock()
+
+		go it.measure(node)
+	}
+	it.lock.Lock()
+	it.drained = true
+	it.cond.Broadcast()
+	it.lock.Unlock()
+}
+
+// measure probes a candidate, queueing it for dialing if it responds in time.
+// Candidates without a TCP endpoint are handed to the dialer unmeasured, as
+// the slowest ones.
+func (it *latencyIterator) measure(node *enode.Node) {
+	var (
+		rtt = it.config.ProbeTimeout
+		err error
+	)
+	if node.TCP() != 0 {
+		if rtt, err = it.probe(node, it.config.ProbeTimeout); err != nil {
+			dialUnreachableMeter.Mark(1)
+			log.Trace("Dropping unreachable dial candidate", "id", node.ID(), "err", err)
+		} else {
+			dialLatencyTimer.Update(rtt)
+		}
+	}
+	it.lock.Lock()
+	defer it.lock.Unlock()
+
+	it.inflight--
+	if err == nil && !it.closed {
+		it.ready = append(it.ready, probedNode{node: node, rtt: rtt})
+	}
+	it.cond.Broadcast()
+}
+
+// Next implements enode.Iterator, blocking until a probed candidate is ready.
+func (it *latencyIterator) Next() bool {
+	it.lock.Lock()
+	defer it.lock.Unlock()
+
+	for !it.closed && len(it.ready) == 0 && (!it.drained || it.inflight > 0) {
+		it.cond.Wait()
+	}
+	if it.closed || len(it.ready) == 0 {
+		it.current = nil
+		return false
+	}
+	distant := it.quota.distant()
+	if distant {
+		dialDistantQuotaMeter.Mark(1)
+	}
+	i := pickCandidate(it.ready, distant)
+	it.current = it.ready[i].node
+
+	it.ready[i] = it.ready[len(it.ready)-1]
+	it.ready = it.ready[:len(it.ready)-1]
+	it.cond.Broadcast()
+	return true
+}
+
+// Node implements enode.Iterator, returning the current candidate.
+func (it *latencyIterator) Node() *enode.Node {
+	it.lock.Lock()
+	defer it.lock.Unlock()
+
+	return it.current
+}
+
+// Close implements enode.Iterator, ending the iteration.
+func (it *latencyIterator) Close() {
+	it.lock.Lock()
+	it.closed = true
+	it.ready = nil
+	it.cond.Broadcast()
+	it.lock.Unlock()
+
+	it.source.Close()
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the distant dial quota is spread evenly among the dials.
+func TestDialQuota(t *testing.T) {
+	tests := []struct {
+		percent int
+		want    string
+	}{
+		{0, "----------"},
+		{20, "----D----D"},
+		{50, "-D-D-D-D-D"},
+		{100, "DDDDDDDDDD"},
+	}
+	for _, tt := range tests {
+		quota := dialQuota{percent: tt.percent}
+
+		var have string
+		for i := 0; i < len(tt.want); i++ {
+			if quota.distant() {
+				have += "D"
+			} else {
+				have += "-"
+			}
+		}
+		if have != tt.want {
+			t.Errorf("percent %d: dial sequence mismatch: have %s, want %s", tt.percent, have, tt.want)
+		}
+	}
+}
+
+// Tests that the fastest candidate is dialed first, unless a distant one is
+// wanted.
+func TestPickCandidate(t *testing.T) {
+	ready := []probedNode{
+		{rtt: 80 * time.Millisecond},
+		{rtt: 20 * time.Millisecond},
+		{rtt: 300 * time.Millisecond},
+		{rtt: 20 * time.Millisecond},
+	}
+	if i := pickCandidate(ready, false); i != 1 {
+		t.Errorf("fastest candidate mismatch: have %d, want 1", i)
+	}
+	if i := pickCandidate(ready, true); i != 2 {
+		t.Errorf("distant candidate mismatch: have %d, want 2", i)
+	}
+	if i := pickCandidate(ready[:1], true); i != 0 {
+		t.Errorf("single candidate mismatch: have %d, want 0", i)
+	}
+}
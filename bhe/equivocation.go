@@ -0,0 +1,202 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// equivocationWindow is the number of heights below the highest observed
+	// block whose signers are remembered.
+	equivocationWindow = 256
+
+	// maxEquivocations is the number of detected equivocations retained for
+	// the admin API.
+	maxEquivocations = 128
+)
+
+var equivocationMeter = metrics.NewRegisteredMeter("BHE/equivocation/detected", nil)
+
+// Equivocation describes two different blocks sealed at the same height by the
+// same signer, which is a misconfigured redundant validator at best.
+type Equivocation struct {
+	Signer   common.Address `json:"signer"`
+	Number   hexutil.Uint64 `json:"number"`
+	First    common.Hash    `json:"first"`
+	Second   common.Hash    `json:"second"`
+	Local    bool           `json:"local"` // Whether the signer is one of our own accounts
+	Detected time.Time      `json:"detected"`
+}
+
+// equivocationDetector remembers the signer of every block observed at recent
+// heights, side chains and blocks announced by peers included, and reports any
+// signer sealing two different blocks at the same height.
+type equivocationDetector struct {
+	seen    map[uint64]map[common.Address]common.Hash // Block hashes by height and signer
+	highest uint64                                    // Highest height observed
+	recent  []*Equivocation                           // Latest detected equivocations
+
+	feed  event.Feed
+	scope event.SubscriptionScope
+	lock  sync.Mutex
+}
+
+func newEquivocationDetector() *equivocationDetector {
+	return &equivocationDetector{seen: make(map[uint64]map[common.Address]common.Hash)}
+}
+
+// observe records the signer of a block, returning the equivocation if the same
+// signer already sealed a different block at that height.
+func (d *equivocationDetector) observe(number uint64, hash common.Hash, signer common.Address) *Equivocation {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if number+equivocationWindow < d.highest {
+		return nil // Too old to be tracked
+	}
+	if number > d.highest {
+		d.highest = number
+		for height := range d.seen {
+			if height+equivocationWindow < d.highest {
+				delete(d.seen, height)
+			}
+		}
+	}
+	signers := d.seen[number]
+	if signers == nil {
+		signers = make(map[common.Address]common.Hash)
+		d.seen[number] = signers
+	}
+	first, ok := signers[signer]
+	if !ok {
+		signers[signer] = hash
+		return nil
+	}
+	if first == hash {
+		return nil
+	}
+	ev := &Equivocation{Signer: signer, Number: hexutil.Uint64(number), First: first, Second: hash, Detected: time.Now()}
+	if d.recent = append(d.recent, ev); len(d.recent) > maxEquivocations {
+		d.recent = d.recent[1:]
+	}
+	return ev
+}
+
+// SubscribeEquivocation registers a subscription of detected equivocations.
+func (d *equivocationDetector) SubscribeEquivocation(ch chan<- Equivocation) event.Subscription {
+	return d.scope.Track(d.feed.Subscribe(ch))
+}
+
+// isLocalCoinbase reports whBHEer an address is one of the node's own BHEerbases.
+func (s *BHEereum) isLocalCoinbase(addr common.Address) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return addr == s.BHEerbase || (s.coinbases != nil && s.coinbases.contains(addr))
+}
+
+// checkEquivocation runs the equivocation detection on a block header. On clique
+// networks every signer is watched, on others only our own BHEerbases are, as
+// reward addresses are freely shared between miners. The protocol manager calls
+// it for every block announced by a peer, before import.
+func (s *BHEereum) checkEquivocation(header *types.Header) {
+	_, isClique := s.engine.(*clique.Clique)
+	signer, err := s.engine.Author(header)
+	if err != nil {
+		return
+	}
+	local := s.isLocalCoinbase(signer)
+	if !isClique && !local {
+		return
+	}
+	ev := s.equivocations.observe(header.Number.Uint64(), header.Hash(), signer)
+	if ev == nil {
+		return
+	}
+	ev.Local = local
+	equivocationMeter.Mark(1)
+	log.Error("Equivocation detected, conflicting blocks sealed by the same signer", "signer", signer, "number", ev.Number, "first", ev.First, "second", ev.Second, "local", local)
+	s.equivocations.feed.Send(*ev)
+}
+
+// startEquivocationDetection checks every imported block, canonical and side
+// chain alike, for equivocating signers.
+func (s *BHEereum) startEquivocationDetection() {
+	var (
+		chain = make(chan core.ChainEvent, 64)
+		side  = make(chan core.ChainSideEvent, 64)
+	)
+	s.equivocationSub = s.blockchain.SubscribeChainEvent(chain)
+	sideSub := s.blockchain.SubscribeChainSideEvent(side)
+
+	go func() {
+		defer sideSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-chain:
+				s.checkEquivocation(ev.Block.Header())
+			case ev := <-side:
+				s.checkEquivocation(ev.Block.Header())
+			case <-s.equivocationSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// RecentEquivocations returns the latest detected equivocations.
+func (api *PrivateAdminAPI) RecentEquivocations() []*Equivocation {
+	d := api.BHE.equivocations
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]*Equivocation{}, d.recent...)
+}
+
+// Equivocations creates a subscription notified whenever a signer is caught
+// sealing two different blocks at the same height.
+func (api *PrivateAdminAPI) Equivocations(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan Equivocation, 16)
+		eventSub := api.BHE.equivocations.SubscribeEquivocation(events)
+		defer eventSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(sub.ID, ev)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			case <-eventSub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+)
+
+// Tests that equivocations are reported once a signer seals a second, different
+// block at a tracked height.
+func TestEquivocationDetector(t *testing.T) {
+	var (
+		alice = common.HexToAddress("0xa")
+		bob   = common.HexToAddress("0xb")
+		d     = newEquivocationDetector()
+	)
+	if ev := d.observe(100, common.HexToHash("0x01"), alice); ev != nil {
+		t.Fatalf("first block reported: %+v", ev)
+	}
+	if ev := d.observe(100, common.HexToHash("0x01"), alice); ev != nil {
+		t.Fatalf("repeated block reported: %+v", ev)
+	}
+	if ev := d.observe(100, common.HexToHash("0x02"), bob); ev != nil {
+		t.Fatalf("sibling by another signer reported: %+v", ev)
+	}
+	ev := d.observe(100, common.HexToHash("0x03"), alice)
+	if ev == nil || ev.Signer != alice || ev.First != common.HexToHash("0x01") || ev.Second != common.HexToHash("0x03") {
+		t.Fatalf("equivocation mismatch: %+v", ev)
+	}
+	// Heights falling out of the window are forgotten
+	d.observe(100+equivocationWindow+1, common.HexToHash("0x04"), bob)
+	if _, ok := d.seen[100]; ok {
+		t.Fatalf("height outside of window retained")
+	}
+	if ev := d.observe(100, common.HexToHash("0x05"), alice); ev != nil {
+		t.Fatalf("equivocation outside of window reported: %+v", ev)
+	}
+	if len(d.recent) != 1 {
+		t.Fatalf("recent equivocation count mismatch: have %d, want 1", len(d.recent))
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+// chainEventSource is the part of the blockchain the event sequencer derives
+// its events from.
+type chainEventSource interface {
+	CurrentBlock() *types.Block
+	GetBlock(hash common.Hash, number uint64) *types.Block
+	GetReceiptsByHash(hash common.Hash) types.Receipts
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// eventSequencer re-derives the chain, log and head events served to API
+// subscribers from the sequence of chain head changes, so that they follow a
+// strict order regardless of how the blockchain interleaves its own feeds.
+// For every head change, subscribers observe:
+//
+//  1. A RemovedLogsEvent for every block dropped from the canonical chain,
+//     newest block first.
+//  2. A LogsEvent (if the block has logs) followed by a ChainEvent for every
+//     block added to the canonical chain, oldest block first.
+//  3. A single ChainHeadEvent for the new head, last.
+//
+// A subscriber hence never sees logs of a new chain before the removal of the
+// logs it replaces, nor a head it hasn't seen the chain event of.
+type eventSequencer struct {
+	source chainEventSource
+	head   *types.Block // Last head the events were emitted for
+
+	chainFeed  event.Feed
+	headFeed   event.Feed
+	logsFeed   event.Feed
+	rmLogsFeed event.Feed
+	scope      event.SubscriptionScope
+
+	sub event.Subscription // Head subscription of the source, nil if not started
+}
+
+func newEventSequencer(source chainEventSource) *eventSequencer {
+	return &eventSequencer{source: source, head: source.CurrentBlock()}
+}
+
+// start begins sequencing the head events of the source in the background.
+func (s *eventSequencer) start() {
+	heads := make(chan core.ChainHeadEvent, 16)
+	s.sub = s.source.SubscribeChainHeadEvent(heads)
+
+	go func() {
+		for {
+			select {
+			case ev := <-heads:
+				s.advance(ev.Block)
+			case <-s.sub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// stop terminates the sequencer and all subscriptions to it.
+func (s *eventSequencer) stop() {
+	if s.sub != nil {
+		s.sub.Unsubscribe()
+	}
+	s.scope.Close()
+}
+
+// advance emits the ordered events moving the canonical chain from the last
+// emitted head to the given one.
+func (s *eventSequencer) advance(head *types.Block) {
+	if head.Hash() == s.head.Hash() {
+		return
+	}
+	var (
+		oldChain, newChain []*types.Block
+		oldBlock, newBlock = s.head, head
+	)
+	for oldBlock != nil && newBlock != nil && oldBlock.NumberU64() > newBlock.NumberU64() {
+		oldChain = append(oldChain, oldBlock)
+		oldBlock = s.source.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1)
+	}
+	for oldBlock != nil && newBlock != nil && newBlock.NumberU64() > oldBlock.NumberU64() {
+		newChain = append(newChain, newBlock)
+		newBlock = s.source.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1)
+	}
+	for oldBlock != nil && newBlock != nil && oldBlock.Hash() != newBlock.Hash() {
+		oldChain = append(oldChain, oldBlock)
+		newChain = append(newChain, newBlock)
+		oldBlock = s.source.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1)
+		newBlock = s.source.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1)
+	}
+	if oldBlock == nil || newBlock == nil {
+		// The chains couldn't be linked (e.g. pruned by a SBHEead), only announce
+		// the new head as there's no way to tell what changed
+		log.Warn("Event sequencer lost track of the chain", "old", s.head.Number(), "new", head.Number())
+		oldChain, newChain = nil, []*types.Block{head}
+	}
+	for _, block := range oldChain {
+		if logs := s.logs(block, true); len(logs) > 0 {
+			s.rmLogsFeed.Send(core.RemovedLogsEvent{Logs: logs})
+		}
+	}
+	for i := len(newChain) - 1; i >= 0; i-- {
+		block := newChain[i]
+		logs := s.logs(block, false)
+		if len(logs) > 0 {
+			s.logsFeed.Send(logs)
+		}
+		s.chainFeed.Send(core.ChainEvent{Block: block, Hash: block.Hash(), Logs: logs})
+	}
+	s.headFeed.Send(core.ChainHeadEvent{Block: head})
+	s.head = head
+}
+
+// logs collects the logs of a block, flagged as removed if requested.
+func (s *eventSequencer) logs(block *types.Block, removed bool) []*types.Log {
+	var logs []*types.Log
+	for _, receipt := range s.source.GetReceiptsByHash(block.Hash()) {
+		for _, l := range receipt.Logs {
+			if removed {
+				cpy := *l
+				cpy.Removed = true
+				l = &cpy
+			}
+			logs = append(logs, l)
+		}
+	}
+	return logs
+}
+
+func (s *eventSequencer) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
+	return s.scope.Track(s.chainFeed.Subscribe(ch))
+}
+
+func (s *eventSequencer) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return s.scope.Track(s.headFeed.Subscribe(ch))
+}
+
+func (s *eventSequencer) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
+	return s.scope.Track(s.logsFeed.Subscribe(ch))
+}
+
+func (s *eventSequencer) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
+	return s.scope.Track(s.rmLogsFeed.Subscribe(ch))
+}
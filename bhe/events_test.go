@@ -0,0 +1,150 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+// testEventSource is a fake chain serving blocks and receipts from memory.
+type testEventSource struct {
+	blocks   map[common.Hash]*types.Block
+	receipts map[common.Hash]types.Receipts
+	head     *types.Block
+	feed     event.Feed
+}
+
+func newTestEventSource() *testEventSource {
+	genesis := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0)})
+	return &testEventSource{
+		blocks:   map[common.Hash]*types.Block{genesis.Hash(): genesis},
+		receipts: make(map[common.Hash]types.Receipts),
+		head:     genesis,
+	}
+}
+
+// extend creates a chain of blocks on top of parent, each with a single log
+// tagged with the given fork name.
+func (s *testEventSource) extend(parent *types.Block, n int, fork string) []*types.Block {
+	var blocks []*types.Block
+	for i := 0; i < n; i++ {
+		block := types.NewBlockWithHeader(&types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			Extra:      []byte(fork),
+		})
+		s.blocks[block.Hash()] = block
+		s.receipts[block.Hash()] = types.Receipts{{
+			Logs: []*types.Log{{BlockNumber: block.NumberU64(), BlockHash: block.Hash(), Data: []byte(fork)}},
+		}}
+		blocks = append(blocks, block)
+		parent = block
+	}
+	return blocks
+}
+
+func (s *testEventSource) CurrentBlock() *types.Block { return s.head }
+
+func (s *testEventSource) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return s.blocks[hash]
+}
+
+func (s *testEventSource) GetReceiptsByHash(hash common.Hash) types.Receipts {
+	return s.receipts[hash]
+}
+
+func (s *testEventSource) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return s.feed.Subscribe(ch)
+}
+
+// collectEvents advances the sequencer to head and returns a textual trace of
+// the events in the exact order they were delivered.
+func collectEvents(t *testing.T, seq *eventSequencer, head *types.Block) []string {
+	var (
+		chainCh  = make(chan core.ChainEvent)
+		headCh   = make(chan core.ChainHeadEvent)
+		logsCh   = make(chan []*types.Log)
+		rmLogsCh = make(chan core.RemovedLogsEvent)
+	)
+	subs := []event.Subscription{
+		seq.SubscribeChainEvent(chainCh),
+		seq.SubscribeChainHeadEvent(headCh),
+		seq.SubscribeLogsEvent(logsCh),
+		seq.SubscribeRemovedLogsEvent(rmLogsCh),
+	}
+	defer func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+	}()
+	go seq.advance(head)
+
+	// Feeds deliver synchronously to unbuffered channels, so only a single event
+	// is ever pending and the select observes the true emission order
+	var trace []string
+	for {
+		select {
+		case ev := <-chainCh:
+			trace = append(trace, fmt.Sprintf("chain %d", ev.Block.NumberU64()))
+		case ev := <-logsCh:
+			trace = append(trace, fmt.Sprintf("logs %d %s", ev[0].BlockNumber, ev[0].Data))
+		case ev := <-rmLogsCh:
+			if !ev.Logs[0].Removed {
+				t.Errorf("removed log not flagged: %v", ev.Logs[0])
+			}
+			trace = append(trace, fmt.Sprintf("removed %d %s", ev.Logs[0].BlockNumber, ev.Logs[0].Data))
+		case ev := <-headCh:
+			return append(trace, fmt.Sprintf("head %d", ev.Block.NumberU64()))
+		}
+	}
+}
+
+// Tests that subscription events are emitted in canonical order: on reorgs the
+// removed logs come first (newest first), then the logs and chain events of the
+// new blocks (oldest first), and the head event last.
+func TestEventSequencerOrdering(t *testing.T) {
+	source := newTestEventSource()
+	seq := newEventSequencer(source)
+
+	// Plain extension of the chain
+	main := source.extend(source.head, 3, "a")
+	trace := collectEvents(t, seq, main[2])
+	want := []string{"logs 1 a", "chain 1", "logs 2 a", "chain 2", "logs 3 a", "chain 3", "head 3"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("extension trace mismatch:\nhave %v\nwant %v", trace, want)
+	}
+	// Reorg to a longer side chain forking off block 1
+	side := source.extend(main[0], 3, "b")
+	trace = collectEvents(t, seq, side[2])
+	want = []string{"removed 3 a", "removed 2 a", "logs 2 b", "chain 2", "logs 3 b", "chain 3", "logs 4 b", "chain 4", "head 4"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("reorg trace mismatch:\nhave %v\nwant %v", trace, want)
+	}
+	// Rewind of the head without a replacement chain
+	trace = collectEvents(t, seq, side[0])
+	want = []string{"removed 4 b", "removed 3 b", "head 2"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("rewind trace mismatch:\nhave %v\nwant %v", trace, want)
+	}
+	// Re-announcing the same head must not emit anything
+	if seq.advance(side[0]); seq.head != side[0] {
+		t.Fatalf("head changed on re-announcement")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// expandExtraData fills in the placeholders of a miner extra-data template:
+// {name} is the node name, {pool} the pool tag, {version} the client version,
+// {os} the operating system and {go} the Go version. Unknown placeholders are
+// left as they are.
+func expandExtraData(template string, name string, pool string) []byte {
+	return []byte(strings.NewReplacer(
+		"{name}", name,
+		"{pool}", pool,
+		"{version}", params.Version,
+		"{os}", runtime.GOOS,
+		"{go}", runtime.Version(),
+	).Replace(template))
+}
+
+// checkExtraData rejects miner extra-data violating the policy of the chain,
+// which would get the sealed blocks rejected by the network. On clique the
+// miner extra-data is the vanity, the engine appends the signers and the seal.
+// Reserved prefixes are refused before the policy fork too, so the miner isn't
+// left sealing invalid blocks once it activates.
+func checkExtraData(config *params.ChainConfig, extra []byte) error {
+	if uint64(len(extra)) > params.MaximumExtraDataSize {
+		return fmt.Errorf("extra-data too long: %d > %d", len(extra), params.MaximumExtraDataSize)
+	}
+	return consensus.CheckExtraDataPrefixes(config, extra)
+}
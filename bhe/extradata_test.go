@@ -0,0 +1,60 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Tests that the placeholders of extra-data templates are filled in.
+func TestExpandExtraData(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"{pool}/{name}", "pool-7/node-1"},
+		{"{os}", runtime.GOOS},
+		{"v{version}", "v" + params.Version},
+		{"{unknown}", "{unknown}"},
+	}
+	for i, tt := range tests {
+		if have := expandExtraData(tt.template, "node-1", "pool-7"); string(have) != tt.want {
+			t.Errorf("test %d: extra-data mismatch: have %q, want %q", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that miner extra-data is checked against the size limit and the reserved
+// prefixes of the chain.
+func TestCheckExtraData(t *testing.T) {
+	config := &params.ChainConfig{ReservedExtraPrefixes: []hexutil.Bytes{[]byte("pool:")}}
+
+	if err := checkExtraData(config, []byte("mined by pool:")); err != nil {
+		t.Errorf("valid extra-data rejected: %v", err)
+	}
+	if err := checkExtraData(config, []byte("pool:fake")); err == nil || !strings.HasPrefix(err.Error(), consensus.ErrReservedExtraData.Error()) {
+		t.Errorf("reserved prefix accepted: %v", err)
+	}
+	if err := checkExtraData(config, bytes.Repeat([]byte{1}, int(params.MaximumExtraDataSize)+1)); err == nil {
+		t.Errorf("oversized extra-data accepted")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+)
+
+// openExternalSigner connects to an external signer (e.g. clef) and registers
+// it with the account manager, so that the personal API and every other user
+// of the manager can reach its accounts. It returns nil if no signer URL is
+// configured.
+func openExternalSigner(manager *accounts.Manager, url string) (*external.ExternalBackend, error) {
+	if url == "" {
+		return nil, nil
+	}
+	backend, err := external.NewExternalBackend(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to external signer %s: %v", url, err)
+	}
+	manager.AddBackend(backend)
+
+	var accs int
+	for _, wallet := range backend.Wallets() {
+		accs += len(wallet.Accounts())
+	}
+	log.Info("Connected to external signer", "url", url, "accounts", accs)
+	return backend, nil
+}
+
+// signerWallet returns the wallet to sign blocks with on behalf of an account.
+// With an external signer configured, only its accounts are eligible, so that
+// sealing keys never have to be loaded into the node process.
+func (s *BHEereum) signerWallet(account common.Address) (accounts.Wallet, error) {
+	if s.extSigner == nil {
+		wallet, err := s.accountManager.Find(accounts.Account{Address: account})
+		if err != nil {
+			// Not a known account, but it may live on a hardware wallet
+			if hw, hwErr := s.findHardwareSigner(account); hwErr == nil {
+				return hw, nil
+			}
+		}
+		return wallet, err
+	}
+	for _, wallet := range s.extSigner.Wallets() {
+		if wallet.Contains(accounts.Account{Address: account}) {
+			return wallet, nil
+		}
+	}
+	return nil, fmt.Errorf("account %x not managed by external signer", account)
+}
+
+// signerAccount returns the first account of the first wallet, taken from the
+// external signer if one is configured.
+func (s *BHEereum) signerAccount() (common.Address, bool) {
+	wallets := s.AccountManager().Wallets()
+	if s.extSigner != nil {
+		wallets = s.extSigner.Wallets()
+	}
+	if len(wallets) > 0 {
+		if accounts := wallets[0].Accounts(); len(accounts) > 0 {
+			return accounts[0].Address, true
+		}
+	}
+	return common.Address{}, false
+}
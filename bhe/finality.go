@@ -0,0 +1,197 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// maxFinalityDepth is the number of headers walked back from the head at most
+// looking for the signer quorum finalizing a block.
+const maxFinalityDepth = 1024
+
+// errReorgPastFinalized is returned if a reorg would unwind a finalized block.
+var errReorgPastFinalized = errors.New("reorg past finalized block")
+
+// FinalizedEvent is posted when the finalized head of the chain advances.
+type FinalizedEvent struct {
+	Number hexutil.Uint64 `json:"number"`
+	Hash   common.Hash    `json:"hash"`
+}
+
+// finalizedIndex returns the index of the highest finalized block in a run of
+// headers ordered from the chain head downwards, given their signers: a block
+// is final once more than half of the signer set sealed blocks on top of it.
+// It returns -1 if no block in the run is final.
+func finalizedIndex(authors []common.Address, signers int) int {
+	built := make(map[common.Address]struct{})
+	for i, author := range authors {
+		if len(built) > signers/2 {
+			return i
+		}
+		built[author] = struct{}{}
+	}
+	return -1
+}
+
+// cliqueFinality derives a deterministic finalized head on clique networks from
+// the signer quorum building on top of blocks, and refuses reorgs unwinding it.
+type cliqueFinality struct {
+	chain   *core.BlockChain
+	engine  consensus.Engine
+	signers func(hash common.Hash) ([]common.Address, error)
+
+	finalized *types.Header
+	feed      event.Feed
+	scope     event.SubscriptionScope
+	lock      sync.RWMutex
+}
+
+func newCliqueFinality(chain *core.BlockChain, engine *clique.Clique) *cliqueFinality {
+	api := engine.APIs(chain)[0].Service.(*clique.API)
+	return &cliqueFinality{
+		chain:   chain,
+		engine:  engine,
+		signers: api.GetSignersAtHash,
+	}
+}
+
+// current returns the finalized head, nil if none yet.
+func (f *cliqueFinality) current() *types.Header {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.finalized
+}
+
+// update walks back from a new chain head until the signer quorum is reached,
+// advancing the finalized head if it moved.
+func (f *cliqueFinality) update(head *types.Header) {
+	signers, err := f.signers(head.Hash())
+	if err != nil || len(signers) == 0 {
+		return
+	}
+	var floor uint64
+	if finalized := f.current(); finalized != nil {
+		floor = finalized.Number.Uint64()
+	}
+	var (
+		headers []*types.Header
+		authors []common.Address
+	)
+	for header := head; header != nil && len(headers) < maxFinalityDepth; header = f.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1) {
+		if header.Number.Uint64() <= floor {
+			break
+		}
+		author, err := f.engine.Author(header)
+		if err != nil {
+			return
+		}
+		headers, authors = append(headers, header), append(authors, author)
+		if header.Number.Sign() == 0 {
+			break
+		}
+	}
+	index := finalizedIndex(authors, len(signers))
+	if index < 0 {
+		return
+	}
+	finalized := headers[index]
+
+	f.lock.Lock()
+	f.finalized = finalized
+	f.lock.Unlock()
+
+	log.Debug("Finalized chain segment", "number", finalized.Number, "hash", finalized.Hash(), "signers", len(signers))
+	f.feed.Send(FinalizedEvent{Number: hexutil.Uint64(finalized.Number.Uint64()), Hash: finalized.Hash()})
+}
+
+// checkReorg is installed into the blockchain to veto reorgs whose common
+// ancestor lies below the finalized head.
+func (f *cliqueFinality) checkReorg(ancestor *types.Header) error {
+	if finalized := f.current(); finalized != nil && ancestor.Number.Cmp(finalized.Number) < 0 {
+		return errReorgPastFinalized
+	}
+	return nil
+}
+
+// SubscribeFinalizedEvent registers a subscription of FinalizedEvent.
+func (f *cliqueFinality) SubscribeFinalizedEvent(ch chan<- FinalizedEvent) event.Subscription {
+	return f.scope.Track(f.feed.Subscribe(ch))
+}
+
+// startFinality keeps the finalized head updated along the chain head.
+func (s *BHEereum) startFinality() {
+	heads := make(chan core.ChainHeadEvent, 16)
+	s.finalitySub = s.events.SubscribeChainHeadEvent(heads)
+
+	s.finality.update(s.blockchain.CurrentHeader())
+	go func() {
+		for {
+			select {
+			case ev := <-heads:
+				s.finality.update(ev.Block.Header())
+			case <-s.finalitySub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// FinalizedBlock returns the header of the latest block finalized by the clique
+// signer quorum, nil if none is final yet.
+func (api *PublicBHEereumAPI) FinalizedBlock() (*types.Header, error) {
+	if api.e.finality == nil {
+		return nil, errors.New("finality tracking disabled")
+	}
+	return api.e.finality.current(), nil
+}
+
+// FinalizedHeads creates a subscription notified whenever the finalized head of
+// the chain advances.
+func (api *PublicBHEereumAPI) FinalizedHeads(ctx context.Context) (*rpc.Subscription, error) {
+	if api.e.finality == nil {
+		return nil, errors.New("finality tracking disabled")
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan FinalizedEvent, 16)
+		eventSub := api.e.finality.SubscribeFinalizedEvent(events)
+		defer eventSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(sub.ID, ev)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			case <-eventSub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+)
+
+// Tests that blocks are only final once more than half of the signers sealed
+// blocks on top of them.
+func TestFinalizedIndex(t *testing.T) {
+	var (
+		a = common.HexToAddress("0xa")
+		b = common.HexToAddress("0xb")
+		c = common.HexToAddress("0xc")
+		d = common.HexToAddress("0xd")
+	)
+	tests := []struct {
+		authors []common.Address // Signers from the head downwards
+		signers int
+		want    int
+	}{
+		{nil, 3, -1},
+		{[]common.Address{a, b, c}, 3, 2},       // a and b built on c's block
+		{[]common.Address{a, a, b, c}, 3, 3},    // Repeated signers count once
+		{[]common.Address{a, b}, 3, -1},         // Quorum reached, but no block below
+		{[]common.Address{a, b, c, d}, 4, 3},    // Half of the signers isn't enough
+		{[]common.Address{a, b, c, d, a}, 4, 3}, // The highest final block is reported
+		{[]common.Address{a, b}, 1, 1},
+	}
+	for i, tt := range tests {
+		if have := finalizedIndex(tt.authors, tt.signers); have != tt.want {
+			t.Errorf("test %d: finalized index mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"sort"
+)
+
+// peerHead is the best head a peer advertised.
+type peerHead struct {
+	id   string
+	hash common.Hash
+	td   *big.Int
+}
+
+// ForkHead is a distinct head advertised by peers.
+type ForkHead struct {
+	Hash      common.Hash     `json:"hash"`
+	Number    *hexutil.Uint64 `json:"number,omitempty"` // Only known if the header is held locally
+	Td        *hexutil.Big    `json:"td"`
+	Peers     []string        `json:"peers"`
+	Known     bool            `json:"known"`     // Whether the header is held locally
+	Canonical bool            `json:"canonical"` // Whether the head is on the local canonical chain
+}
+
+// ForkStatus lists the heads advertised by the peers against the local one.
+type ForkStatus struct {
+	Hash   common.Hash    `json:"hash"`
+	Number hexutil.Uint64 `json:"number"`
+	Td     *hexutil.Big   `json:"td"`
+	Heads  []*ForkHead    `json:"heads"`
+	Split  bool           `json:"split"` // Whether any peer follows a known side chain
+}
+
+// groupPeerHeads groups the peers by their advertised head, heaviest head first
+// and ties broken by the number of peers following them.
+func groupPeerHeads(peers []peerHead) []*ForkHead {
+	var (
+		heads  []*ForkHead
+		byHash = make(map[common.Hash]*ForkHead)
+	)
+	for _, peer := range peers {
+		head := byHash[peer.hash]
+		if head == nil {
+			head = &ForkHead{Hash: peer.hash, Td: (*hexutil.Big)(new(big.Int).Set(peer.td))}
+			byHash[peer.hash] = head
+			heads = append(heads, head)
+		}
+		head.Peers = append(head.Peers, peer.id)
+	}
+	for _, head := range heads {
+		sort.Strings(head.Peers)
+	}
+	sort.Slice(heads, func(i, j int) bool {
+		if cmp := heads[i].Td.ToInt().Cmp(heads[j].Td.ToInt()); cmp != 0 {
+			return cmp > 0
+		}
+		if len(heads[i].Peers) != len(heads[j].Peers) {
+			return len(heads[i].Peers) > len(heads[j].Peers)
+		}
+		return heads[i].Hash.Hex() < heads[j].Hash.Hex()
+	})
+	return heads
+}
+
+// peerHeads snapshots the best head advertised by every connected peer, which
+// the protocol manager updates on every handshake and block announcement.
+func (s *BHEereum) peerHeads() []peerHead {
+	ps := s.protocolManager.peers
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	heads := make([]peerHead, 0, len(ps.peers))
+	for id, peer := range ps.peers {
+		hash, td := peer.Head()
+		heads = append(heads, peerHead{id: id, hash: hash, td: td})
+	}
+	return heads
+}
+
+// ForkHeads lists the distinct heads advertised by the peers, so that network
+// splits show as peers following side chains before they cause a reorg.
+func (api *PrivateAdminAPI) ForkHeads() *ForkStatus {
+	var (
+		chain = api.BHE.blockchain
+		local = chain.CurrentHeader()
+	)
+	status := &ForkStatus{
+		Hash:   local.Hash(),
+		Number: hexutil.Uint64(local.Number.Uint64()),
+		Td:     (*hexutil.Big)(chain.GetTd(local.Hash(), local.Number.Uint64())),
+		Heads:  groupPeerHeads(api.BHE.peerHeads()),
+	}
+	for _, head := range status.Heads {
+		header := chain.GetHeaderByHash(head.Hash)
+		if header == nil {
+			continue // Ahead of us or on a chain never seen
+		}
+		number := hexutil.Uint64(header.Number.Uint64())
+		head.Number, head.Known = &number, true
+		head.Canonical = rawdb.ReadCanonicalHash(api.BHE.chainDb, header.Number.Uint64()) == head.Hash
+		if !head.Canonical {
+			status.Split = true
+		}
+	}
+	return status
+}
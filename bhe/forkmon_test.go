@@ -0,0 +1,57 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+// Tests that peers are grouped by their advertised head, heaviest head first.
+func TestGroupPeerHeads(t *testing.T) {
+	var (
+		a = common.HexToHash("0xa")
+		b = common.HexToHash("0xb")
+		c = common.HexToHash("0xc")
+	)
+	heads := groupPeerHeads([]peerHead{
+		{id: "p4", hash: b, td: big.NewInt(100)},
+		{id: "p1", hash: a, td: big.NewInt(90)},
+		{id: "p3", hash: c, td: big.NewInt(100)},
+		{id: "p2", hash: b, td: big.NewInt(100)},
+	})
+	if len(heads) != 3 {
+		t.Fatalf("head count mismatch: have %d, want %d", len(heads), 3)
+	}
+	want := []struct {
+		hash  common.Hash
+		peers []string
+	}{
+		{b, []string{"p2", "p4"}}, // Same weight as c, but followed by more peers
+		{c, []string{"p3"}},
+		{a, []string{"p1"}},
+	}
+	for i, w := range want {
+		if heads[i].Hash != w.hash || !reflect.DeepEqual(heads[i].Peers, w.peers) {
+			t.Errorf("head %d mismatch: have %x %v, want %x %v", i, heads[i].Hash, heads[i].Peers, w.hash, w.peers)
+		}
+	}
+	if groupPeerHeads(nil) != nil {
+		t.Errorf("heads reported without peers")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// forkScheduleMargin is the minimum number of blocks between the current head
+// and any fork block being scheduled, leaving operators time to restart their
+// nodes with the updated schedule before the fork triggers.
+const forkScheduleMargin = 1000
+
+// rescheduleForks returns a copy of config with the named fork blocks replaced.
+// Fork names are the JSON field names of the chain config (e.g. istanbulBlock),
+// and a nil number unschedules the fork.
+func rescheduleForks(config *params.ChainConfig, forks map[string]*hexutil.Big) (*params.ChainConfig, error) {
+	blob, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(blob, &fields); err != nil {
+		return nil, err
+	}
+	known := forkBlockFields()
+	for name, number := range forks {
+		if !strings.HasSuffix(name, "Block") {
+			return nil, fmt.Errorf("%q is not a fork block", name)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown fork %q", name)
+		}
+		if number == nil {
+			delete(fields, name)
+			continue
+		}
+		fields[name], _ = json.Marshal((*big.Int)(number))
+	}
+	if blob, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	updated := new(params.ChainConfig)
+	if err := json.Unmarshal(blob, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// forkBlockFields returns the JSON field names of the fork blocks of the chain
+// config. Names are checked against it whBHEer a fork is scheduled or not, as
+// an unknown one would otherwise be silently dropped.
+func forkBlockFields() map[string]bool {
+	var (
+		typ    = reflect.TypeOf(params.ChainConfig{})
+		number = reflect.TypeOf((*big.Int)(nil))
+		fields = make(map[string]bool)
+	)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Type == number && strings.HasSuffix(name, "Block") {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// ScheduleForks updates the block numbers of upcoming forks in the chain config
+// stored in the database, so that config-only forks can be coordinated without
+// a new release. The update is rejected if any fork changes at or below the
+// current head (the same compatibility check as at startup) or too close above
+// it. The stored config is picked up on the next restart; the updated config
+// is returned.
+func (api *PrivateAdminAPI) ScheduleForks(forks map[string]*hexutil.Big) (*params.ChainConfig, error) {
+	if err := api.BHE.writable(); err != nil {
+		return nil, err
+	}
+	if len(forks) == 0 {
+		return nil, errors.New("no forks specified")
+	}
+	var (
+		db          = api.BHE.ChainDb()
+		genesisHash = rawdb.ReadCanonicalHash(db, 0)
+		head        = api.BHE.BlockChain().CurrentHeader().Number.Uint64()
+	)
+	stored := rawdb.ReadChainConfig(db, genesisHash)
+	if stored == nil {
+		return nil, errors.New("stored chain config not found")
+	}
+	updated, err := rescheduleForks(stored, forks)
+	if err != nil {
+		return nil, err
+	}
+	if compatErr := stored.CheckCompatible(updated, head); compatErr != nil {
+		return nil, compatErr
+	}
+	if compatErr := stored.CheckCompatible(updated, head+forkScheduleMargin); compatErr != nil {
+		return nil, fmt.Errorf("fork too close to head %d (margin %d blocks): %v", head, forkScheduleMargin, compatErr)
+	}
+	if err := updated.CheckConfigForkOrder(); err != nil {
+		return nil, err
+	}
+	rawdb.WriteChainConfig(db, genesisHash, updated)
+	log.Warn("Rescheduled forks in stored chain config, restart to apply", "forks", len(forks), "config", updated)
+	return updated, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that fork blocks can be rescheduled by name and that the compatibility
+// check rejects changes to forks that already passed.
+func TestRescheduleForks(t *testing.T) {
+	config := &params.ChainConfig{
+		ChainID:             big.NewInt(1337),
+		HomesteadBlock:      big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(100),
+		ConstantinopleBlock: big.NewInt(200),
+	}
+	updated, err := rescheduleForks(config, map[string]*hexutil.Big{
+		"constantinopleBlock": (*hexutil.Big)(big.NewInt(300)),
+		"petersburgBlock":     (*hexutil.Big)(big.NewInt(300)),
+	})
+	if err != nil {
+		t.Fatalf("failed to reschedule forks: %v", err)
+	}
+	if updated.ConstantinopleBlock.Uint64() != 300 || updated.PetersburgBlock == nil || updated.PetersburgBlock.Uint64() != 300 {
+		t.Fatalf("forks not rescheduled: %v", updated)
+	}
+	if config.ConstantinopleBlock.Uint64() != 200 {
+		t.Fatalf("original config modified: %v", config)
+	}
+	if err := config.CheckCompatible(updated, 150); err != nil {
+		t.Fatalf("upcoming fork change rejected: %v", err)
+	}
+	if err := config.CheckCompatible(updated, 250); err == nil {
+		t.Fatalf("passed fork change accepted")
+	}
+	// Unknown fork names and non-fork fields must be rejected
+	if _, err := rescheduleForks(config, map[string]*hexutil.Big{"fooBlock": (*hexutil.Big)(big.NewInt(1))}); err == nil {
+		t.Fatalf("unknown fork accepted")
+	}
+	if _, err := rescheduleForks(config, map[string]*hexutil.Big{"istanbullBlock": nil}); err == nil {
+		t.Fatalf("unknown fork accepted when unscheduled")
+	}
+	if _, err := rescheduleForks(config, map[string]*hexutil.Big{"chainId": (*hexutil.Big)(big.NewInt(1))}); err == nil {
+		t.Fatalf("chain id change accepted")
+	}
+}
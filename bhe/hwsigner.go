@@ -0,0 +1,111 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+)
+
+// hardwareDerivationLimit is the number of accounts derived along each base
+// path when looking for the sealing account on a hardware wallet.
+const hardwareDerivationLimit = 32
+
+// isHardwareWallet reports whBHEer a wallet is a USB hardware wallet.
+func isHardwareWallet(wallet accounts.Wallet) bool {
+	scheme := wallet.URL().Scheme
+	return scheme == usbwallet.LedgerScheme || scheme == usbwallet.TrezorScheme
+}
+
+// findHardwareSigner looks for an account on the connected hardware wallets,
+// opening them and deriving accounts along the default and legacy Ledger paths.
+// Unlike keystores, hardware wallets only expose accounts once derived.
+func (s *BHEereum) findHardwareSigner(account common.Address) (accounts.Wallet, error) {
+	for _, wallet := range s.accountManager.Wallets() {
+		if !isHardwareWallet(wallet) {
+			continue
+		}
+		if err := wallet.Open(""); err != nil && err != accounts.ErrWalletAlreadyOpen {
+			log.Warn("Failed to open hardware wallet", "url", wallet.URL(), "err", err)
+			continue
+		}
+		if wallet.Contains(accounts.Account{Address: account}) {
+			return wallet, nil
+		}
+		for _, base := range []accounts.DerivationPath{accounts.DefaultBaseDerivationPath, accounts.LegacyLedgerBaseDerivationPath} {
+			path := make(accounts.DerivationPath, len(base))
+			copy(path, base)
+
+			for i := uint32(0); i < hardwareDerivationLimit; i++ {
+				path[len(path)-1] = base[len(base)-1] + i
+				derived, err := wallet.Derive(path, true)
+				if err != nil {
+					log.Debug("Failed to derive hardware wallet account", "url", wallet.URL(), "path", path, "err", err)
+					break
+				}
+				if derived.Address == account {
+					log.Info("Found sealing account on hardware wallet", "url", wallet.URL(), "path", path)
+					return wallet, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("account %x not found on any hardware wallet", account)
+}
+
+// watchHardwareSigner keeps clique authorized with the sealing account across
+// disconnects of its hardware wallet: when a hardware wallet (re)appears, the
+// account is looked up again and the engine re-authorized with the new wallet
+// handle, the old one being unusable after a drop.
+func (s *BHEereum) watchHardwareSigner(engine *clique.Clique, signer common.Address, wallet accounts.Wallet) {
+	events := make(chan accounts.WalletEvent, 16)
+	sub := s.accountManager.Subscribe(events)
+
+	s.lock.Lock()
+	if s.hwSignerSub != nil {
+		s.hwSignerSub.Unsubscribe()
+	}
+	s.hwSignerSub = sub
+	s.lock.Unlock()
+
+	go func() {
+		url := wallet.URL()
+		for {
+			select {
+			case ev := <-events:
+				if !isHardwareWallet(ev.Wallet) {
+					continue
+				}
+				switch ev.Kind {
+				case accounts.WalletDropped:
+					if ev.Wallet.URL() == url {
+						log.Warn("Sealing hardware wallet disconnected", "url", url, "signer", signer)
+					}
+				case accounts.WalletArrived:
+					found, err := s.findHardwareSigner(signer)
+					if err != nil {
+						continue // Some other wallet, or not unlocked yet
+					}
+					url = found.URL()
+					engine.Authorize(signer, s.signingAudit.wrap("clique", found.SignData))
+					log.Info("Re-authorized sealing with hardware wallet", "url", url, "signer", signer)
+				}
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,239 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// issuancePrefix is the database key prefix of the cumulative issuance index:
+// issuancePrefix + block hash -> total supply after the block (big endian).
+var issuancePrefix = []byte("BHE-issuance-")
+
+var errIssuanceNotIndexed = errors.New("issuance not indexed for block, enable the issuance index or wait for it to catch up")
+
+// BlockReward is the breakdown of the value credited for mining a block.
+type BlockReward struct {
+	Number       hexutil.Uint64   `json:"number"`
+	Hash         common.Hash      `json:"hash"`
+	Miner        common.Address   `json:"miner"`
+	MinerReward  *hexutil.Big     `json:"minerReward"`  // Static reward plus uncle inclusion bonuses
+	UncleRewards []*hexutil.Big   `json:"uncleRewards"` // Rewards of the uncle miners, in uncle order
+	Fees         *hexutil.Big     `json:"fees"`         // Transaction fees, transferred rather than issued
+	Burnt        *hexutil.Big     `json:"burnt"`        // Share of the fees destroyed by the fee split
+	Treasury     *hexutil.Big     `json:"treasury"`     // Share of the fees paid to the treasury by the fee split
+	Issued       *hexutil.Big     `json:"issued"`       // Newly minted by the block (miner and uncle rewards)
+	Uncles       []common.Address `json:"uncles"`
+}
+
+// mintedBy returns the value newly created by a block.
+func (s *BHEereum) mintedBy(header *types.Header, uncles []*types.Header) *big.Int {
+	reward, uncleRewards := s.blockRewards(header, uncles)
+	minted := new(big.Int).Set(reward)
+	for _, r := range uncleRewards {
+		minted.Add(minted, r)
+	}
+	return minted
+}
+
+// burntBy returns the fees destroyed by a block, which requires its receipts
+// once the fee split fork is active.
+func (s *BHEereum) burntBy(block *types.Block) (*big.Int, error) {
+	config := s.blockchain.Config()
+	if !config.IsFeeSplit(block.Number()) || len(block.Transactions()) == 0 {
+		return new(big.Int), nil
+	}
+	receipts := s.blockchain.GetReceiptsByHash(block.Hash())
+	if receipts == nil {
+		return nil, fmt.Errorf("receipts of block %d unavailable", block.NumberU64())
+	}
+	burnt, _ := consensus.FeeSplit(config, block.Header(), consensus.BlockFees(block.Transactions(), receipts))
+	return burnt, nil
+}
+
+// genesisSupply sums the balances allocated in the genesis state.
+func (s *BHEereum) genesisSupply() (*big.Int, error) {
+	genesis := s.blockchain.Genesis()
+	tr, err := s.blockchain.StateCache().OpenTrie(genesis.Root())
+	if err != nil {
+		return nil, err
+	}
+	supply := new(big.Int)
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		var acc state.Account
+		if err := rlp.DecodeBytes(it.Value, &acc); err != nil {
+			return nil, err
+		}
+		supply.Add(supply, acc.Balance)
+	}
+	return supply, it.Err
+}
+
+func readIssuance(db BHEdb.KeyValueReader, hash common.Hash) *big.Int {
+	blob, err := db.Get(append(issuancePrefix, hash.Bytes()...))
+	if err != nil || len(blob) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(blob)
+}
+
+func writeIssuance(db BHEdb.KeyValueWriter, hash common.Hash, supply *big.Int) {
+	if err := db.Put(append(issuancePrefix, hash.Bytes()...), supply.Bytes()); err != nil {
+		log.Crit("Failed to store issuance index", "err", err)
+	}
+}
+
+// indexIssuance computes and stores the total supply after a block, which is
+// only possible if the parent is already indexed.
+func (s *BHEereum) indexIssuance(block *types.Block) (*big.Int, error) {
+	if supply := readIssuance(s.chainDb, block.Hash()); supply != nil {
+		return supply, nil
+	}
+	var supply *big.Int
+	if block.NumberU64() == 0 {
+		genesis, err := s.genesisSupply()
+		if err != nil {
+			return nil, fmt.Errorf("failed to sum genesis allocation: %v", err)
+		}
+		supply = genesis
+	} else {
+		parent := readIssuance(s.chainDb, block.ParentHash())
+		if parent == nil {
+			return nil, errIssuanceNotIndexed
+		}
+		burnt, err := s.burntBy(block)
+		if err != nil {
+			return nil, err
+		}
+		supply = parent.Add(parent, s.mintedBy(block.Header(), block.Uncles()))
+		supply.Sub(supply, burnt)
+	}
+	writeIssuance(s.chainDb, block.Hash(), supply)
+	return supply, nil
+}
+
+// startIssuanceIndex backfills the issuance index up to the current head and
+// then keeps it updated as blocks become canonical. It follows the event
+// sequencer, as the blockchain doesn't announce side chain blocks promoted by
+// a reorg, which would leave their descendants without an indexed parent.
+func (s *BHEereum) startIssuanceIndex() {
+	events := make(chan core.ChainEvent, 64)
+	s.issuanceSub = s.events.SubscribeChainEvent(events)
+
+	go func() {
+		var (
+			start  = time.Now()
+			logged = time.Now()
+			head   = s.blockchain.CurrentBlock().NumberU64()
+			number uint64
+		)
+		for ; number <= head; number++ {
+			select {
+			case <-s.issuanceSub.Err():
+				return
+			default:
+			}
+			block := s.blockchain.GetBlockByNumber(number)
+			if block == nil {
+				log.Warn("Issuance index backfill interrupted", "number", number)
+				break
+			}
+			if _, err := s.indexIssuance(block); err != nil {
+				log.Error("Failed to index issuance", "number", number, "err", err)
+				break
+			}
+			if time.Since(logged) > 8*time.Second {
+				log.Info("Indexing issuance", "number", number, "head", head, "elapsed", common.PrettyDuration(time.Since(start)))
+				logged = time.Now()
+			}
+		}
+		if number > head {
+			log.Info("Issuance index up to date", "head", head, "elapsed", common.PrettyDuration(time.Since(start)))
+		}
+
+		for {
+			select {
+			case ev := <-events:
+				if _, err := s.indexIssuance(ev.Block); err != nil {
+					log.Warn("Failed to index issuance", "number", ev.Block.Number(), "hash", ev.Hash, "err", err)
+				}
+			case <-s.issuanceSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// GetBlockReward returns the miner and uncle rewards credited for a block, as
+// computed by the consensus engine, along with the transaction fees collected
+// and their split.
+func (api *PublicBHEereumAPI) GetBlockReward(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*BlockReward, error) {
+	block, err := api.e.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %v not found", blockNrOrHash)
+	}
+	receipts, err := api.e.APIBackend.GetReceipts(ctx, block.Hash())
+	if err != nil {
+		return nil, err
+	}
+	fees := consensus.BlockFees(block.Transactions(), receipts)
+	burnt, treasury := consensus.FeeSplit(api.e.blockchain.Config(), block.Header(), fees)
+	reward, uncleRewards := api.e.blockRewards(block.Header(), block.Uncles())
+	res := &BlockReward{
+		Number:       hexutil.Uint64(block.NumberU64()),
+		Hash:         block.Hash(),
+		Miner:        block.Coinbase(),
+		MinerReward:  (*hexutil.Big)(reward),
+		UncleRewards: make([]*hexutil.Big, len(uncleRewards)),
+		Fees:         (*hexutil.Big)(fees),
+		Burnt:        (*hexutil.Big)(burnt),
+		Treasury:     (*hexutil.Big)(treasury),
+		Issued:       (*hexutil.Big)(api.e.mintedBy(block.Header(), block.Uncles())),
+		Uncles:       make([]common.Address, len(block.Uncles())),
+	}
+	for i, uncle := range block.Uncles() {
+		res.UncleRewards[i] = (*hexutil.Big)(uncleRewards[i])
+		res.Uncles[i] = uncle.Coinbase
+	}
+	return res, nil
+}
+
+// TotalIssuance returns the total supply after the given block, that is the
+// genesis allocation plus everything minted since, less the burnt fees. It is an index lookup and
+// requires the issuance index to be enabled.
+func (api *PublicBHEereumAPI) TotalIssuance(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	header, err := api.e.APIBackend.HeaderByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, fmt.Errorf("block %v not found", blockNrOrHash)
+	}
+	supply := readIssuance(api.e.chainDb, header.Hash())
+	if supply == nil {
+		return nil, errIssuanceNotIndexed
+	}
+	return (*hexutil.Big)(supply), nil
+}
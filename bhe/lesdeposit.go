@@ -0,0 +1,212 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+// depositTopic is the signature of the event emitted by the light client deposit
+// contract, Deposit(address indexed depositor, bytes32 indexed nodeID, uint256 amount),
+// crediting the deposit to the light client with the given node ID.
+var depositTopic = crypto.Keccak256Hash([]byte("Deposit(address,bytes32,uint256)"))
+
+// decodeDeposit extracts the credited client and amount from a deposit event,
+// returning nil if the log isn't one.
+func decodeDeposit(contract common.Address, l *types.Log) (enode.ID, *big.Int) {
+	if l.Address != contract || len(l.Topics) != 3 || l.Topics[0] != depositTopic || len(l.Data) != 32 {
+		return enode.ID{}, nil
+	}
+	return enode.ID(l.Topics[2]), new(big.Int).SetBytes(l.Data)
+}
+
+// depositTracker sums up the deposits made to the light client deposit contract
+// on the canonical chain. Clients with deposits of at least the threshold get
+// priority service from the light server.
+type depositTracker struct {
+	contract  common.Address
+	threshold *big.Int
+	deposits  map[enode.ID]*big.Int
+}
+
+func newDepositTracker(contract common.Address, threshold *big.Int) *depositTracker {
+	if threshold == nil || threshold.Sign() <= 0 {
+		threshold = big.NewInt(1)
+	}
+	return &depositTracker{
+		contract:  contract,
+		threshold: threshold,
+		deposits:  make(map[enode.ID]*big.Int),
+	}
+}
+
+// apply credits the deposits among the logs, or for logs of reorged blocks
+// debits them, returning whether any priority was gained or lost. Balances may
+// dip below zero transiently, as additions and removals are only applied in
+// order per event source.
+func (t *depositTracker) apply(logs []*types.Log, removed bool) bool {
+	var changed bool
+	for _, l := range logs {
+		id, amount := decodeDeposit(t.contract, l)
+		if amount == nil {
+			continue
+		}
+		balance := t.deposits[id]
+		if balance == nil {
+			balance = new(big.Int)
+		}
+		before := balance.Cmp(t.threshold) >= 0
+		if removed {
+			balance = new(big.Int).Sub(balance, amount)
+		} else {
+			balance = new(big.Int).Add(balance, amount)
+		}
+		if balance.Sign() == 0 {
+			delete(t.deposits, id)
+		} else {
+			t.deposits[id] = balance
+		}
+		if after := balance.Cmp(t.threshold) >= 0; after != before {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// prioritized returns the clients whose deposits reach the threshold.
+func (t *depositTracker) prioritized() []enode.ID {
+	var ids []enode.ID
+	for id, balance := range t.deposits {
+		if balance.Cmp(t.threshold) >= 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// startDepositTracking replays the deposit contract's events from the configured
+// block and keeps following the canonical chain, updating the light server's
+// priority clients as deposits reach or fall below the threshold.
+//
+// The replay runs alongside the live events instead of before them, so that a
+// long replay doesn't hold up the event sequencer. Credits and debits commute,
+// hence the tally converges regardless of the interleaving.
+func (s *BHEereum) startDepositTracking() {
+	var (
+		chainCh   = make(chan core.ChainEvent, 64)
+		removedCh = make(chan core.RemovedLogsEvent, 64)
+		head      = s.blockchain.CurrentBlock().NumberU64()
+		replayed  = make(chan struct{})
+	)
+	s.depositSub = s.events.SubscribeChainEvent(chainCh)
+	removedSub := s.events.SubscribeRemovedLogsEvent(removedCh)
+
+	go func() {
+		defer close(replayed)
+
+		start, logged := time.Now(), time.Now()
+		for number := s.config.LightDepositFromBlock; number <= head; number++ {
+			select {
+			case <-s.depositSub.Err():
+				return
+			default:
+			}
+			header := s.blockchain.GetHeaderByNumber(number)
+			if header == nil {
+				break
+			}
+			if types.BloomLookup(header.Bloom, s.deposits.contract) {
+				var logs []*types.Log
+				for _, receipt := range s.blockchain.GetReceiptsByHash(header.Hash()) {
+					logs = append(logs, receipt.Logs...)
+				}
+				s.lock.Lock()
+				s.deposits.apply(logs, false)
+				s.lock.Unlock()
+			}
+			if time.Since(logged) > 8*time.Second {
+				log.Info("Replaying light client deposits", "number", number, "head", head, "elapsed", common.PrettyDuration(time.Since(start)))
+				logged = time.Now()
+			}
+		}
+		s.updateDepositPriority()
+	}()
+
+	go func() {
+		defer removedSub.Unsubscribe()
+
+		for {
+			var changed bool
+			select {
+			case ev := <-chainCh:
+				if ev.Block.NumberU64() <= head {
+					continue // Covered by the replay
+				}
+				s.lock.Lock()
+				changed = s.deposits.apply(ev.Logs, false)
+				s.lock.Unlock()
+			case ev := <-removedCh:
+				s.lock.Lock()
+				changed = s.deposits.apply(ev.Logs, true)
+				s.lock.Unlock()
+			case <-s.depositSub.Err():
+				return
+			}
+			select {
+			case <-replayed:
+				if changed {
+					s.updateDepositPriority()
+				}
+			default:
+			}
+		}
+	}()
+}
+
+// updateDepositPriority pushes the clients prioritized by deposits into the
+// light server, alongside the ones configured explicitly.
+func (s *BHEereum) updateDepositPriority() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lesPolicy.deposited = s.deposits.prioritized()
+	if s.lesServer == nil {
+		return
+	}
+	if err := s.lesServer.SetPriorityClients(s.lesPolicy.prioritized()); err != nil {
+		log.Warn("Failed to update light client priorities", "err", err)
+		return
+	}
+	log.Debug("Updated light client priorities", "deposited", len(s.lesPolicy.deposited))
+}
+
+// Deposits returns the light client deposits made on the canonical chain.
+func (api *PrivateLesAPI) Deposits() (map[enode.ID]*hexutil.Big, error) {
+	if api.BHE.deposits == nil {
+		return nil, errors.New("deposit contract not configured")
+	}
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	deposits := make(map[enode.ID]*hexutil.Big, len(api.BHE.deposits.deposits))
+	for id, balance := range api.BHE.deposits.deposits {
+		deposits[id] = (*hexutil.Big)(new(big.Int).Set(balance))
+	}
+	return deposits, nil
+}
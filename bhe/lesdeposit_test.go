@@ -0,0 +1,64 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that deposits are tallied per client, that reorged deposits are debited
+// in any order and that priority follows the threshold.
+func TestDepositTracker(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		client   = common.HexToHash("0xc1")
+		tracker  = newDepositTracker(contract, big.NewInt(100))
+	)
+	deposit := func(amount int64) *types.Log {
+		return &types.Log{
+			Address: contract,
+			Topics:  []common.Hash{depositTopic, common.HexToHash("0xd"), client},
+			Data:    common.BigToHash(big.NewInt(amount)).Bytes(),
+		}
+	}
+	if tracker.apply([]*types.Log{deposit(60)}, false) {
+		t.Errorf("priority changed below threshold")
+	}
+	// A deposit from another contract must be ignored
+	foreign := deposit(1000)
+	foreign.Address = common.HexToAddress("0x2")
+	if tracker.apply([]*types.Log{foreign}, false) {
+		t.Errorf("priority changed by foreign contract")
+	}
+	if !tracker.apply([]*types.Log{deposit(40)}, false) {
+		t.Errorf("priority not gained at threshold")
+	}
+	if ids := tracker.prioritized(); len(ids) != 1 || ids[0] != enode.ID(client) {
+		t.Errorf("prioritized mismatch: have %v, want [%x]", ids, client)
+	}
+	// Debit a reorged deposit before it's credited, the tally must converge
+	if !tracker.apply([]*types.Log{deposit(500)}, true) {
+		t.Errorf("priority not lost on removal")
+	}
+	if !tracker.apply([]*types.Log{deposit(500)}, false) {
+		t.Errorf("priority not regained on credit")
+	}
+	if balance := tracker.deposits[enode.ID(client)]; balance.Int64() != 100 {
+		t.Errorf("balance mismatch: have %v, want 100", balance)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errNoLesServer = errors.New("light server not running")
+
+// LesServingLimits is the request serving budget of the light server. Costs are
+// measured in the les request cost units.
+type LesServingLimits struct {
+	Bandwidth   uint64 `json:"bandwidth"`   // Total serving capacity shared by all clients per second, zero if unlimited
+	ClientRate  uint64 `json:"clientRate"`  // Token bucket refill rate of a client per second
+	ClientBurst uint64 `json:"clientBurst"` // Token bucket capacity of a client
+}
+
+// LesClientLimit overrides the token bucket of a single light client.
+type LesClientLimit struct {
+	Rate  uint64 `json:"rate"`
+	Burst uint64 `json:"burst"`
+}
+
+// lesPolicy is the serving policy pushed into the light server, retained so it
+// can be reported and applied when the server is registered after startup.
+type lesPolicy struct {
+	limits    LesServingLimits
+	clients   map[enode.ID]LesClientLimit
+	priority  []enode.ID // Paid clients served ahead of the free ones
+	deposited []enode.ID // Clients prioritized by their on-chain deposits
+}
+
+// newLesPolicy assembles the configured light server serving policy.
+func newLesPolicy(config *Config) (*lesPolicy, error) {
+	policy := &lesPolicy{
+		limits: LesServingLimits{
+			Bandwidth:   config.LightBandwidth,
+			ClientRate:  config.LightClientRate,
+			ClientBurst: config.LightClientBurst,
+		},
+		clients: make(map[enode.ID]LesClientLimit),
+	}
+	if policy.limits.ClientBurst < policy.limits.ClientRate {
+		log.Warn("Sanitizing invalid light client burst", "provided", policy.limits.ClientBurst, "updated", policy.limits.ClientRate)
+		policy.limits.ClientBurst = policy.limits.ClientRate
+	}
+	for _, id := range config.LightPriorityClients {
+		parsed, err := enode.ParseID(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid light priority client %q: %v", id, err)
+		}
+		policy.priority = append(policy.priority, parsed)
+	}
+	return policy, nil
+}
+
+// prioritized returns the configured priority clients along with the ones
+// prioritized by deposits.
+func (p *lesPolicy) prioritized() []enode.ID {
+	ids := append([]enode.ID{}, p.priority...)
+	for _, id := range p.deposited {
+		var dup bool
+		for _, have := range p.priority {
+			if have == id {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// apply pushes the whole policy into a light server.
+func (p *lesPolicy) apply(ls LesServer) error {
+	if err := ls.SetServingLimits(p.limits); err != nil {
+		return err
+	}
+	for id, limit := range p.clients {
+		limit := limit
+		if err := ls.SetClientLimit(id, &limit); err != nil {
+			return err
+		}
+	}
+	return ls.SetPriorityClients(p.prioritized())
+}
+
+// PrivateLesAPI adjusts the request serving policy of the light server.
+type PrivateLesAPI struct {
+	BHE *BHEereum
+}
+
+// NewPrivateLesAPI creates a new API definition for the light server policy.
+func NewPrivateLesAPI(BHE *BHEereum) *PrivateLesAPI {
+	return &PrivateLesAPI{BHE: BHE}
+}
+
+// ServingLimits returns the serving budget of the light server.
+func (api *PrivateLesAPI) ServingLimits() LesServingLimits {
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	return api.BHE.lesPolicy.limits
+}
+
+// SetServingLimits replaces the serving budget of the light server.
+func (api *PrivateLesAPI) SetServingLimits(limits LesServingLimits) (bool, error) {
+	if limits.ClientBurst < limits.ClientRate {
+		return false, fmt.Errorf("client burst %d below rate %d", limits.ClientBurst, limits.ClientRate)
+	}
+	api.BHE.lock.Lock()
+	defer api.BHE.lock.Unlock()
+
+	if api.BHE.lesServer == nil {
+		return false, errNoLesServer
+	}
+	if err := api.BHE.lesServer.SetServingLimits(limits); err != nil {
+		return false, err
+	}
+	api.BHE.lesPolicy.limits = limits
+	return true, nil
+}
+
+// ClientLimits returns the light clients with a token bucket of their own.
+func (api *PrivateLesAPI) ClientLimits() map[enode.ID]LesClientLimit {
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	clients := make(map[enode.ID]LesClientLimit, len(api.BHE.lesPolicy.clients))
+	for id, limit := range api.BHE.lesPolicy.clients {
+		clients[id] = limit
+	}
+	return clients
+}
+
+// SetClientLimit gives a light client a token bucket of its own, or reverts it
+// to the default one if limit is nil.
+func (api *PrivateLesAPI) SetClientLimit(id enode.ID, limit *LesClientLimit) (bool, error) {
+	if limit != nil && limit.Burst < limit.Rate {
+		return false, fmt.Errorf("client burst %d below rate %d", limit.Burst, limit.Rate)
+	}
+	api.BHE.lock.Lock()
+	defer api.BHE.lock.Unlock()
+
+	if api.BHE.lesServer == nil {
+		return false, errNoLesServer
+	}
+	if err := api.BHE.lesServer.SetClientLimit(id, limit); err != nil {
+		return false, err
+	}
+	if limit == nil {
+		delete(api.BHE.lesPolicy.clients, id)
+	} else {
+		api.BHE.lesPolicy.clients[id] = *limit
+	}
+	return true, nil
+}
+
+// PriorityClients returns the paid clients served ahead of the free ones, both
+// the ones set explicitly and the ones gaining priority by deposits.
+func (api *PrivateLesAPI) PriorityClients() []enode.ID {
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	return api.BHE.lesPolicy.prioritized()
+}
+
+// SetPriorityClients replaces the list of paid clients, which are admitted and
+// served ahead of the free ones. Clients prioritized by deposits keep their
+// priority regardless.
+func (api *PrivateLesAPI) SetPriorityClients(ids []enode.ID) (bool, error) {
+	api.BHE.lock.Lock()
+	defer api.BHE.lock.Unlock()
+
+	if api.BHE.lesServer == nil {
+		return false, errNoLesServer
+	}
+	policy := *api.BHE.lesPolicy
+	policy.priority = append([]enode.ID{}, ids...)
+	if err := api.BHE.lesServer.SetPriorityClients(policy.prioritized()); err != nil {
+		return false, err
+	}
+	api.BHE.lesPolicy.priority = policy.priority
+	return true, nil
+}
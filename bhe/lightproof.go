@@ -0,0 +1,204 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// proofServeMaxRequests is the maximum number of proofs served in response to
+	// a single request message.
+	proofServeMaxRequests = 64
+
+	// proofServeSoftLimit is the target maximum size of a proof response in bytes.
+	// A response is cut short once it is exceeded, even if below the item limit.
+	proofServeSoftLimit = 2 * 1024 * 1024
+)
+
+var (
+	stateProofMeter     = metrics.NewRegisteredMeter("BHE/proofs/state", nil)     // State proofs served
+	headerProofMeter    = metrics.NewRegisteredMeter("BHE/proofs/headers", nil)   // Header proofs served
+	proofThrottledMeter = metrics.NewRegisteredMeter("BHE/proofs/throttled", nil) // Requests cut short by the rate limit
+)
+
+// ProofServeConfig contains the settings of serving Merkle proofs to embedded
+// and light consumers over the BHE protocol.
+type ProofServeConfig struct {
+	Enabled bool    // Advertise and serve state and header proofs
+	Rate    float64 // Proofs served per second to a peer
+	Burst   int     // Proofs served to a peer in a burst
+}
+
+// DefaultProofServeConfig contains the default proof serving settings.
+var DefaultProofServeConfig = ProofServeConfig{
+	Rate:  64,
+	Burst: 256,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *ProofServeConfig) sanitize() ProofServeConfig {
+	conf := *config
+	if conf.Rate <= 0 {
+		log.Warn("Sanitizing invalid proof serving rate", "provided", conf.Rate, "updated", DefaultProofServeConfig.Rate)
+		conf.Rate = DefaultProofServeConfig.Rate
+	}
+	if conf.Burst < 1 {
+		log.Warn("Sanitizing invalid proof serving burst", "provided", conf.Burst, "updated", DefaultProofServeConfig.Burst)
+		conf.Burst = DefaultProofServeConfig.Burst
+	}
+	return conf
+}
+
+// stateProofRequest is a single request of a state proof message: an account
+// and any of its storage slots, proven against the state root of a block.
+type stateProofRequest struct {
+	BlockHash   common.Hash
+	Account     common.Address
+	StorageKeys []common.Hash
+}
+
+// proofServer answers the state and header proof requests of remote peers, so
+// embedded and light consumers can verify chain data against headers without
+// connecting to a full light server. State proofs are served for recent blocks
+// only, as older states may already be pruned; header proofs are served against
+// the CHT roots of the indexed sections. The protocol handler advertises proof
+// serving in the status handshake and routes the request messages here.
+type proofServer struct {
+	config  ProofServeConfig
+	chain   *core.BlockChain
+	headers func(number uint64) (*HeaderProof, error) // CHT proof of a canonical header
+	limiter *rateLimiter
+}
+
+// newProofServer creates a proof server on top of the local chain.
+func newProofServer(config ProofServeConfig, chain *core.BlockChain, headers func(uint64) (*HeaderProof, error)) *proofServer {
+	config = config.sanitize()
+	return &proofServer{
+		config:  config,
+		chain:   chain,
+		headers: headers,
+		limiter: newRateLimiter(config.Rate, config.Burst),
+	}
+}
+
+// advertised returns whether the node announces proof serving in the status
+// handshake.
+func (p *proofServer) advertised() bool {
+	return p.config.Enabled
+}
+
+// recentState returns the state of a recent canonical block.
+func (p *proofServer) recentState(hash common.Hash) (*state.StateDB, error) {
+	header := p.chain.GBHEeaderByHash(hash)
+	if header == nil || p.chain.GetCanonicalHash(header.Number.Uint64()) != hash {
+		return nil, errors.New("block not canonical")
+	}
+	if head := p.chain.CurrentBlock().NumberU64(); head > header.Number.Uint64()+stateServerRecentBlocks {
+		return nil, errStateRootTooOld
+	}
+	return p.chain.StateAt(header.Root)
+}
+
+// serveStateProofs proves the requested accounts and storage slots, merging all
+// proofs into a single deduplicated node set. Serving stops at the first request
+// that can't be answered or once the peer runs out of allowance. The number of
+// requests handled is returned along with the nodes.
+func (p *proofServer) serveStateProofs(peer string, reqs []*stateProofRequest) (light.NodeList, int) {
+	if !p.config.Enabled {
+		return nil, 0
+	}
+	if len(reqs) > proofServeMaxRequests {
+		reqs = reqs[:proofServeMaxRequests]
+	}
+	var (
+		nodes  = light.NewNodeSet()
+		states = make(map[common.Hash]*state.StateDB)
+		now    = time.Now()
+	)
+	for i, req := range reqs {
+		if nodes.DataSize() >= proofServeSoftLimit {
+			return nodes.NodeList(), i
+		}
+		if !p.limiter.allow(peer, now) {
+			proofThrottledMeter.Mark(1)
+			return nodes.NodeList(), i
+		}
+		statedb, ok := states[req.BlockHash]
+		if !ok {
+			var err error
+			if statedb, err = p.recentState(req.BlockHash); err != nil {
+				return nodes.NodeList(), i
+			}
+			states[req.BlockHash] = statedb
+		}
+		proof, err := statedb.GetProof(req.Account)
+		if err != nil {
+			return nodes.NodeList(), i
+		}
+		for _, key := range req.StorageKeys {
+			slot, err := statedb.GetStorageProof(req.Account, key)
+			if err != nil {
+				return nodes.NodeList(), i
+			}
+			proof = append(proof, slot...)
+		}
+		for _, node := range proof {
+			nodes.Put(crypto.Keccak256(node), node)
+		}
+		stateProofMeter.Mark(1)
+	}
+	return nodes.NodeList(), len(reqs)
+}
+
+// serveHeaderProofs proves the requested canonical headers against the CHT roots
+// of their sections. Serving stops at the first header not yet covered by the
+// CHT or once the peer runs out of allowance.
+func (p *proofServer) serveHeaderProofs(peer string, numbers []uint64) ([]*HeaderProof, int) {
+	if !p.config.Enabled {
+		return nil, 0
+	}
+	if len(numbers) > proofServeMaxRequests {
+		numbers = numbers[:proofServeMaxRequests]
+	}
+	var (
+		proofs []*HeaderProof
+		size   int
+		now    = time.Now()
+	)
+	for i, number := range numbers {
+		if size >= proofServeSoftLimit {
+			return proofs, i
+		}
+		if !p.limiter.allow(peer, now) {
+			proofThrottledMeter.Mark(1)
+			return proofs, i
+		}
+		proof, err := p.headers(number)
+		if err != nil {
+			return proofs, i
+		}
+		for _, node := range proof.Proof {
+			size += len(node)
+		}
+		proofs = append(proofs, proof)
+		headerProofMeter.Mark(1)
+	}
+	return proofs, len(numbers)
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// LoggingConfig contains the settings of the structured log output.
+type LoggingConfig struct {
+	Format    string // Output format (terminal, logfmt or json), the node's own logging is kept if empty
+	File      string // File the logs are appended to, standard error if empty
+	Verbosity int    // Maximum level logged (0=critical, 5=trace)
+}
+
+// DefaultLoggingConfig contains the default structured logging settings.
+var DefaultLoggingConfig = LoggingConfig{
+	Verbosity: 3,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *LoggingConfig) sanitize() LoggingConfig {
+	conf := *config
+	if conf.Verbosity < 0 || conf.Verbosity > 5 {
+		log.Warn("Sanitizing invalid log verbosity", "provided", conf.Verbosity, "updated", DefaultLoggingConfig.Verbosity)
+		conf.Verbosity = DefaultLoggingConfig.Verbosity
+	}
+	return conf
+}
+
+// logComponents maps the source paths of the logging call sites to the
+// subsystem they belong to, the most specific paths first.
+var logComponents = []struct {
+	path      string
+	component string
+}{
+	{"core/tx_pool.go", "txpool"},
+	{"core/tx_list.go", "txpool"},
+	{"core/tx_journal.go", "txpool"},
+	{"core/state/", "state"},
+	{"core/rawdb/", "database"},
+	{"core/vm/", "evm"},
+	{"core/", "blockchain"},
+	{"bhe/downloader/", "downloader"},
+	{"bhe/fetcher/", "fetcher"},
+	{"bhe/gasprice/", "gasprice"},
+	{"bhe/", "BHE"},
+	{"BHEdb/", "database"},
+	{"miner/", "miner"},
+	{"consensus/", "consensus"},
+	{"trie/", "trie"},
+	{"p2p/", "p2p"},
+	{"les/", "les"},
+	{"light/", "light"},
+	{"rpc/", "rpc"},
+	{"accounts/", "accounts"},
+	{"node/", "node"},
+}
+
+// logComponent derives the subsystem of a logging call site from its source
+// path, e.g. "github.com/bheworld/block-chains/core/tx_pool.go:512" is txpool.
+func logComponent(call string) string {
+	for _, c := range logComponents {
+		if strings.Contains(call, "/"+c.path) || strings.HasPrefix(call, c.path) {
+			return c.component
+		}
+	}
+	return "other"
+}
+
+// setupLogging replaces the root log handler with one writing the configured
+// format, tagging every record with the subsystem it came from and the chain
+// head it was logged at.
+func (s *BHEereum) setupLogging(config LoggingConfig) error {
+	config = config.sanitize()
+
+	var format log.Format
+	switch config.Format {
+	case "":
+		return nil
+	case "terminal":
+		format = log.TerminalFormat(false)
+	case "logfmt":
+		format = log.LogfmtFormat()
+	case "json":
+		format = log.JSONFormat()
+	default:
+		return fmt.Errorf("unknown log format %q", config.Format)
+	}
+	var output io.Writer = os.Stderr
+	if config.File != "" {
+		file, err := os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		output = file
+	}
+	sink := log.StreamHandler(output, format)
+
+	handler := log.FuncHandler(func(r *log.Record) error {
+		ctx := make([]interface{}, 0, len(r.Ctx)+4)
+		ctx = append(ctx, "component", logComponent(fmt.Sprintf("%+v", r.Call)))
+		if head := s.blockchain.CurrentBlock(); head != nil {
+			ctx = append(ctx, "head", head.NumberU64())
+		}
+		record := *r
+		record.Ctx = append(ctx, r.Ctx...)
+		return sink.Log(&record)
+	})
+	log.Root().SetHandler(log.LvlFilterHandler(log.Lvl(config.Verbosity), handler))
+	log.Info("Switched to structured logging", "format", config.Format, "file", config.File)
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import "testing"
+
+// Tests that logging call sites are attributed to the right subsystems.
+func TestLogComponent(t *testing.T) {
+	tests := []struct {
+		call      string
+		component string
+	}{
+		{"github.com/bheworld/block-chains/core/tx_pool.go:512", "txpool"},
+		{"github.com/bheworld/block-chains/core/blockchain.go:1650", "blockchain"},
+		{"github.com/bheworld/block-chains/core/state/statedb.go:88", "state"},
+		{"github.com/bheworld/block-chains/bhe/downloader/downloader.go:401", "downloader"},
+		{"github.com/bheworld/block-chains/bhe/backend.go:231", "BHE"},
+		{"github.com/bheworld/block-chains/miner/worker.go:977", "miner"},
+		{"github.com/bheworld/block-chains/consensus/bhehash/bhehash.go:242", "consensus"},
+		{"miner/worker.go:977", "miner"},
+		{"main.go:12", "other"},
+	}
+	for _, tt := range tests {
+		if component := logComponent(tt.call); component != tt.component {
+			t.Errorf("%s: component mismatch: have %s, want %s", tt.call, component, tt.component)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultMinerLockTTL is the lifetime of a miner lock lease if not configured.
+const defaultMinerLockTTL = 15 * time.Second
+
+var (
+	errMinerLockBusy = errors.New("miner lock busy")
+
+	minerLockAcquiredMeter = metrics.NewRegisteredMeter("BHE/minerlock/acquired", nil)
+	minerLockLostMeter     = metrics.NewRegisteredMeter("BHE/minerlock/lost", nil)
+)
+
+// MinerLock is a lease shared by redundant validator nodes, only the holder of
+// which is allowed to seal. Leases expire unless renewed, so a standby takes
+// over once the primary stops heart-beating. Locks backed by a coordination
+// service (e.g. etcd or consul) can be made available with RegisterMinerLock.
+type MinerLock interface {
+	// Acquire takes or renews the lease on behalf of holder for ttl, reporting
+	// whether holder owns it afterwards. On error the lease state is unknown.
+	Acquire(holder string, ttl time.Duration) (bool, error)
+
+	// Release gives up the lease if owned by holder.
+	Release(holder string) error
+}
+
+var (
+	minerLocks     = make(map[string]MinerLock)
+	minerLocksLock sync.RWMutex
+)
+
+// RegisterMinerLock makes a miner lock available by name, to be selected by the
+// node configuration. It panics if the name is taken.
+func RegisterMinerLock(name string, lock MinerLock) {
+	minerLocksLock.Lock()
+	defer minerLocksLock.Unlock()
+
+	if _, ok := minerLocks[name]; ok {
+		panic(fmt.Sprintf("miner lock %q already registered", name))
+	}
+	minerLocks[name] = lock
+}
+
+// lookupMinerLock returns the miner lock registered by name.
+func lookupMinerLock(name string) (MinerLock, bool) {
+	minerLocksLock.RLock()
+	defer minerLocksLock.RUnlock()
+
+	lock, ok := minerLocks[name]
+	return lock, ok
+}
+
+// minerLease is the content of a file based miner lock.
+type minerLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// claimable reports whether holder may take the lease: it's free, expired or
+// already owned by holder.
+func (l *minerLease) claimable(holder string, now time.Time) bool {
+	return l == nil || l.Holder == holder || !now.Before(l.Expires)
+}
+
+// fileMinerLock is a miner lock kept in a file on storage shared by the nodes,
+// such as an NFS mount. The lease is rewritten while holding an advisory lock
+// on a guard file, which the operating system drops if the node crashes, so
+// the nodes must agree on the time within a fraction of the lease lifetime.
+type fileMinerLock struct {
+	path string
+	now  func() time.Time
+}
+
+func newFileMinerLock(path string) *fileMinerLock {
+	return &fileMinerLock{path: path, now: time.Now}
+}
+
+// guard locks the guard file for a read-modify-write of the lease.
+func (l *fileMinerLock) guard() (func(), error) {
+	lock, _, err := fileutil.Flock(l.path + ".guard")
+	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			return nil, err
+		}
+		return nil, errMinerLockBusy
+	}
+	return func() { lock.Release() }, nil
+}
+
+// read loads the current lease, nil if there is none.
+func (l *fileMinerLock) read() (*minerLease, error) {
+	blob, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lease := new(minerLease)
+	if err := json.Unmarshal(blob, lease); err != nil {
+		return nil, fmt.Errorf("corrupt miner lock %s: %v", l.path, err)
+	}
+	return lease, nil
+}
+
+// Acquire implements MinerLock, taking the lease if claimable.
+func (l *fileMinerLock) Acquire(holder string, ttl time.Duration) (bool, error) {
+	release, err := l.guard()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	lease, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	now := l.now()
+	if !lease.claimable(holder, now) {
+		return false, nil
+	}
+	blob, err := json.Marshal(&minerLease{Holder: holder, Expires: now.Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	tmp := l.path + ".new"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Release implements MinerLock, removing the lease if owned by holder.
+func (l *fileMinerLock) Release(holder string) error {
+	release, err := l.guard()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	lease, err := l.read()
+	if err != nil || lease == nil || lease.Holder != holder {
+		return err
+	}
+	return os.Remove(l.path)
+}
+
+// minerFailover runs the miner of a validator shared with redundant nodes. After
+// StartMining it stands by, renewing the miner lock every third of its lifetime
+// and sealing while holding it. Sealing stops as soon as the lock is taken by
+// another node, or a third of the lifetime before the lease runs out if it
+// can't be renewed, however long the lock takes to answer.
+type minerFailover struct {
+	name   string
+	lock   MinerLock
+	holder string
+	ttl    time.Duration
+
+	threads int  // Mining threads requested by StartMining
+	leader  bool // Whether the lock is held and the miner running
+	quit    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// minerLockRenewal is the outcome of an attempt to take or keep the lock.
+type minerLockRenewal struct {
+	start time.Time // When the attempt began, the earliest the lease may start
+	held  bool
+	err   error
+}
+
+func newMinerFailover(name string, lock MinerLock, ttl time.Duration) *minerFailover {
+	if ttl <= 0 {
+		ttl = defaultMinerLockTTL
+	}
+	host, _ := os.Hostname()
+	return &minerFailover{
+		name:   name,
+		lock:   lock,
+		holder: fmt.Sprintf("%s/%d", host, os.Getpid()),
+		ttl:    ttl,
+	}
+}
+
+// start begins standing by for the lock, or adjusts the mining threads if
+// already doing so.
+func (f *minerFailover) start(s *BHEereum, threads int) error {
+	if _, err := s.BHEerbase(); err != nil {
+		log.Error("Cannot start mining without BHEerbase", "err", err)
+		return fmt.Errorf("BHEerbase missing: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.threads = threads
+	if f.quit != nil {
+		if f.leader {
+			return s.startMining(threads)
+		}
+		return nil
+	}
+	f.quit, f.done = make(chan struct{}), make(chan struct{})
+	go f.loop(s, f.quit, f.done)
+
+	log.Info("Standing by for the miner lock", "lock", f.name, "holder", f.holder, "ttl", common.PrettyDuration(f.ttl))
+	return nil
+}
+
+// stop stops standing by, halting the miner and releasing the lock if held.
+func (f *minerFailover) stop() {
+	f.mu.Lock()
+	quit, done := f.quit, f.done
+	f.quit, f.done = nil, nil
+	f.mu.Unlock()
+
+	if quit != nil {
+		close(quit)
+		<-done
+	}
+}
+
+// loop renews the lock in the background, so that a lock stuck answering can't
+// keep the miner sealing past the lease: that's stopped on a timer of its own.
+func (f *minerFailover) loop(s *BHEereum, quit, done chan struct{}) {
+	defer close(done)
+
+	var (
+		renewal  = time.NewTimer(0)
+		expiry   = time.NewTimer(0)
+		results  = make(chan minerLockRenewal, 1)
+		pending  bool
+		leaseEnd time.Time
+	)
+	defer renewal.Stop()
+	defer expiry.Stop()
+	<-expiry.C
+
+	for {
+		select {
+		case <-renewal.C:
+			pending = true
+			go func(start time.Time) {
+				held, err := f.lock.Acquire(f.holder, f.ttl)
+				results <- minerLockRenewal{start: start, held: held, err: err}
+			}(time.Now())
+
+		case res := <-results:
+			pending = false
+			if leaseEnd = f.renew(s, res, leaseEnd); !leaseEnd.IsZero() {
+				expiry.Stop()
+				select {
+				case <-expiry.C:
+				default:
+				}
+				expiry.Reset(time.Until(leaseEnd) - f.ttl/3)
+			}
+			renewal.Reset(f.ttl / 3)
+
+		case <-expiry.C:
+			f.mu.Lock()
+			if f.leader {
+				s.stopMining()
+				f.leader = false
+				minerLockLostMeter.Mark(1)
+				log.Error("Failed to renew the miner lock in time, stopped sealing", "lock", f.name)
+			}
+			f.mu.Unlock()
+			leaseEnd = time.Time{}
+
+		case <-quit:
+			f.mu.Lock()
+			if f.leader {
+				s.stopMining()
+				f.leader = false
+			}
+			f.mu.Unlock()
+
+			// An attempt still running may take the lock after the release, wait
+			// for it a lease lifetime at most, the lease expiring on its own after
+			if pending {
+				select {
+				case <-results:
+				case <-time.After(f.ttl):
+				}
+			}
+			if err := f.lock.Release(f.holder); err != nil {
+				log.Warn("Failed to release the miner lock", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// renew starts or stops the miner if the leadership changed with an attempt to
+// take or keep the lock. It returns the expiry of the lease held, zero if none.
+// Leases are counted from when the attempt began, as the lock may have taken
+// any part of it to write the lease.
+func (f *minerFailover) renew(s *BHEereum, res minerLockRenewal, leaseEnd time.Time) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case res.err == nil && res.held:
+		if !f.leader {
+			if err := s.startMining(f.threads); err != nil {
+				log.Error("Failed to start mining with the miner lock held", "err", err)
+				f.lock.Release(f.holder)
+				return time.Time{}
+			}
+			f.leader = true
+			minerLockAcquiredMeter.Mark(1)
+			log.Info("Acquired the miner lock, sealing", "lock", f.name, "holder", f.holder)
+		}
+		return res.start.Add(f.ttl)
+
+	case res.err == nil:
+		if f.leader {
+			s.stopMining()
+			f.leader = false
+			minerLockLostMeter.Mark(1)
+			log.Warn("Miner lock taken over, standing by", "lock", f.name)
+		}
+		return time.Time{}
+
+	default:
+		log.Warn("Failed to renew the miner lock", "lock", f.name, "err", res.err)
+		if !f.leader {
+			return time.Time{}
+		}
+		return leaseEnd
+	}
+}
+
+// MinerFailoverStatus is the miner lock state of a node.
+type MinerFailoverStatus struct {
+	Lock    string `json:"lock"`
+	Holder  string `json:"holder"`
+	TTL     uint64 `json:"ttl"`
+	Standby bool   `json:"standby"`
+	Leader  bool   `json:"leader"`
+}
+
+// FailoverStatus reports whether the node is standing by for the miner lock and
+// whether it holds it, nil if mining isn't coordinated through a lock.
+func (api *PrivateMinerAPI) FailoverStatus() *MinerFailoverStatus {
+	f := api.e.failover
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &MinerFailoverStatus{
+		Lock:    f.name,
+		Holder:  f.holder,
+		TTL:     uint64(f.ttl / time.Second),
+		Standby: f.quit != nil,
+		Leader:  f.leader,
+	}
+}
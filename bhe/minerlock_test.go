@@ -0,0 +1,102 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that the file based miner lock is exclusive while the lease lives, can
+// be renewed by its holder and is taken over once the holder stops renewing.
+func TestFileMinerLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minerlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1600000000, 0)
+	lock := newFileMinerLock(filepath.Join(dir, "miner.lock"))
+	lock.now = func() time.Time { return now }
+
+	acquire := func(holder string, want bool) {
+		t.Helper()
+		held, err := lock.Acquire(holder, 10*time.Second)
+		if err != nil {
+			t.Fatalf("%s: failed to acquire: %v", holder, err)
+		}
+		if held != want {
+			t.Fatalf("%s: lock held mismatch: have %v, want %v", holder, held, want)
+		}
+	}
+	acquire("primary", true)
+	acquire("standby", false)
+
+	now = now.Add(8 * time.Second)
+	acquire("primary", true)
+	now = now.Add(8 * time.Second)
+	acquire("standby", false)
+
+	// The primary stops heart-beating, the standby takes over
+	now = now.Add(3 * time.Second)
+	acquire("standby", true)
+	acquire("primary", false)
+
+	// Releasing by a non-holder is a noop, by the holder frees the lock
+	if err := lock.Release("primary"); err != nil {
+		t.Fatalf("failed to release foreign lock: %v", err)
+	}
+	acquire("primary", false)
+	if err := lock.Release("standby"); err != nil {
+		t.Fatalf("failed to release lock: %v", err)
+	}
+	acquire("primary", true)
+}
+
+// Tests that the lease can't be rewritten while another node holds the guard,
+// and that a guard file left behind by a crashed node doesn't block it.
+func TestFileMinerLockGuard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minerlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "miner.lock")
+	if err := ioutil.WriteFile(path+".guard", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock := newFileMinerLock(path)
+	if held, err := lock.Acquire("primary", 10*time.Second); err != nil || !held {
+		t.Fatalf("failed to acquire lock past left over guard: held %v, err %v", held, err)
+	}
+	release, err := newFileMinerLock(path).guard()
+	if err != nil {
+		t.Fatalf("failed to take the guard: %v", err)
+	}
+	if _, err := lock.Acquire("primary", 10*time.Second); err != errMinerLockBusy {
+		t.Fatalf("guarded lock error mismatch: have %v, want %v", err, errMinerLockBusy)
+	}
+	release()
+	if held, err := lock.Acquire("primary", 10*time.Second); err != nil || !held {
+		t.Fatalf("failed to acquire lock past released guard: held %v, err %v", held, err)
+	}
+}
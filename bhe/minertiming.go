@@ -0,0 +1,115 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"time"
+)
+
+// minRecommitInterval is the shortest recommit interval the miner honours.
+const minRecommitInterval = time.Second
+
+// MinerTiming is the block building schedule of the miner, trading the latency
+// of sealing against the fees captured. Durations are in milliseconds.
+type MinerTiming struct {
+	Recommit  uint64 `json:"recommit"`  // Interval of rebuilding the pending block with fresh transactions
+	Deadline  uint64 `json:"deadline"`  // Time allowed for building a new block before it is sealed, unlimited if zero
+	SealEmpty bool   `json:"sealEmpty"` // Whether an empty block is sealed while the full one is being built
+}
+
+// minerTiming tracks the schedule last applied to the miner, which doesn't
+// report its settings back.
+type minerTiming struct {
+	recommit  time.Duration
+	deadline  time.Duration
+	sealEmpty bool
+}
+
+// newMinerTiming sanitizes the configured block building schedule.
+func newMinerTiming(config *Config) minerTiming {
+	timing := minerTiming{
+		recommit:  config.Miner.Recommit,
+		deadline:  config.MinerDeadline,
+		sealEmpty: !config.MinerNoEmpty,
+	}
+	if timing.recommit < minRecommitInterval {
+		log.Warn("Sanitizing invalid miner recommit interval", "provided", timing.recommit, "updated", minRecommitInterval)
+		timing.recommit = minRecommitInterval
+	}
+	if timing.deadline < 0 {
+		log.Warn("Sanitizing invalid miner build deadline", "provided", timing.deadline, "updated", "unlimited")
+		timing.deadline = 0
+	}
+	return timing
+}
+
+// apply pushes the schedule into the miner.
+func (t minerTiming) apply(m *miner.Miner) {
+	m.SetRecommitInterval(t.recommit)
+	m.SetBuildDeadline(t.deadline)
+	if t.sealEmpty {
+		m.EnablePreseal()
+	} else {
+		m.DisablePreseal()
+	}
+}
+
+// updateMinerTiming modifies the current schedule and applies it to the miner.
+func (s *BHEereum) updateMinerTiming(update func(timing *minerTiming)) {
+	s.lock.Lock()
+	update(&s.minerTiming)
+	timing := s.minerTiming
+	s.lock.Unlock()
+
+	timing.apply(s.miner)
+	log.Info("Updated miner timing", "recommit", timing.recommit, "deadline", timing.deadline, "sealempty", timing.sealEmpty)
+}
+
+// Timing returns the block building schedule of the miner.
+func (api *PrivateMinerAPI) Timing() MinerTiming {
+	api.e.lock.RLock()
+	defer api.e.lock.RUnlock()
+
+	return MinerTiming{
+		Recommit:  uint64(api.e.minerTiming.recommit / time.Millisecond),
+		Deadline:  uint64(api.e.minerTiming.deadline / time.Millisecond),
+		SealEmpty: api.e.minerTiming.sealEmpty,
+	}
+}
+
+// SetBuildDeadline limits the time in milliseconds spent on filling a new block
+// with transactions before it is sealed. Zero removes the limit.
+func (api *PrivateMinerAPI) SetBuildDeadline(deadline int) (bool, error) {
+	if deadline < 0 {
+		return false, fmt.Errorf("invalid build deadline %dms", deadline)
+	}
+	api.e.updateMinerTiming(func(timing *minerTiming) {
+		timing.deadline = time.Duration(deadline) * time.Millisecond
+	})
+	return true, nil
+}
+
+// SetSealEmpty selects whBHEer an empty block is sealed right away on a new
+// head while the one with transactions is being built. Disabling it captures
+// more fees at the price of starting to seal later.
+func (api *PrivateMinerAPI) SetSealEmpty(enabled bool) bool {
+	api.e.updateMinerTiming(func(timing *minerTiming) {
+		timing.sealEmpty = enabled
+	})
+	return true
+}
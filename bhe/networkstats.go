@@ -0,0 +1,117 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+const (
+	// defaultNetworkStatsBlocks is the number of recent blocks analysed if the
+	// caller does not specify a count.
+	defaultNetworkStatsBlocks = 256
+
+	// maxNetworkStatsBlocks is the maximum number of recent blocks analysed.
+	maxNetworkStatsBlocks = 8192
+)
+
+// NetworkStats summarises the health of the network over recent blocks.
+type NetworkStats struct {
+	From            hexutil.Uint64 `json:"from"`
+	To              hexutil.Uint64 `json:"to"`
+	Uncles          int            `json:"uncles"`
+	UncleRate       float64        `json:"uncleRate"`       // Uncles per block
+	MeanBlockTime   float64        `json:"meanBlockTime"`   // Average block interval in seconds
+	GasUsed         hexutil.Uint64 `json:"gasUsed"`         // Total gas used by the blocks
+	GasUtilization  float64        `json:"gasUtilization"`  // Gas used relative to the gas limits
+	Difficulty      *hexutil.Big   `json:"difficulty"`      // Difficulty of the latest block
+	DifficultyTrend float64        `json:"difficultyTrend"` // Relative change of the difficulty across the blocks
+}
+
+// networkStats summarises a run of consecutive headers, oldest first, counting
+// the uncles of each with the given function.
+func networkStats(headers []*types.Header, uncles func(*types.Header) int) *NetworkStats {
+	var (
+		first, last = headers[0], headers[len(headers)-1]
+		gasUsed     uint64
+		gasLimit    uint64
+	)
+	stats := &NetworkStats{
+		From:       hexutil.Uint64(first.Number.Uint64()),
+		To:         hexutil.Uint64(last.Number.Uint64()),
+		Difficulty: (*hexutil.Big)(new(big.Int).Set(last.Difficulty)),
+	}
+	for _, header := range headers {
+		if header.UncleHash != types.EmptyUncleHash {
+			stats.Uncles += uncles(header)
+		}
+		gasUsed += header.GasUsed
+		gasLimit += header.GasLimit
+	}
+	stats.UncleRate = float64(stats.Uncles) / float64(len(headers))
+	stats.GasUsed = hexutil.Uint64(gasUsed)
+	if gasLimit > 0 {
+		stats.GasUtilization = float64(gasUsed) / float64(gasLimit)
+	}
+	stats.DifficultyTrend, stats.MeanBlockTime, _ = hashrateStats(headers)
+	return stats
+}
+
+// networkStatsCache holds the last network statistics computed, which stay
+// valid until the head changes, so that polling dashboards share the work.
+type networkStatsCache struct {
+	head   common.Hash
+	blocks int
+	stats  *NetworkStats
+	lock   sync.Mutex
+}
+
+// NetworkStats returns the uncle rate, average block time, gas utilization and
+// difficulty trend of the given number of recent canonical blocks.
+func (api *PublicBHEereumAPI) NetworkStats(blocks *hexutil.Uint64) (*NetworkStats, error) {
+	count := defaultNetworkStatsBlocks
+	if blocks != nil {
+		if *blocks < 2 || *blocks > maxNetworkStatsBlocks {
+			return nil, fmt.Errorf("block count out of range [2, %d]", maxNetworkStatsBlocks)
+		}
+		count = int(*blocks)
+	}
+	var (
+		cache = &api.e.netStats
+		head  = api.e.blockchain.CurrentHeader().Hash()
+	)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.stats != nil && cache.head == head && cache.blocks == count {
+		return cache.stats, nil
+	}
+	headers := api.e.recentHeaders(count)
+	if len(headers) < 2 {
+		return nil, fmt.Errorf("not enough blocks: have %d, want at least 2", len(headers))
+	}
+	stats := networkStats(headers, func(header *types.Header) int {
+		if body := api.e.blockchain.GetBody(header.Hash()); body != nil {
+			return len(body.Uncles)
+		}
+		return 0
+	})
+	cache.head, cache.blocks, cache.stats = head, count, stats
+	return stats, nil
+}
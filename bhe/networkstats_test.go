@@ -0,0 +1,65 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that the network statistics are aggregated over all the headers.
+func TestNetworkStats(t *testing.T) {
+	var headers []*types.Header
+	for i := 0; i < 4; i++ {
+		header := &types.Header{
+			Number:     big.NewInt(int64(10 + i)),
+			Difficulty: big.NewInt(int64(100 * (i + 1))),
+			Time:       uint64(i * 12),
+			GasLimit:   1000,
+			GasUsed:    uint64(250 * i),
+			UncleHash:  types.EmptyUncleHash,
+		}
+		if i%2 == 1 {
+			header.UncleHash = common.HexToHash("0x01")
+		}
+		headers = append(headers, header)
+	}
+	counted := 0
+	stats := networkStats(headers, func(*types.Header) int { counted++; return 2 })
+
+	if counted != 2 {
+		t.Errorf("uncle lookups mismatch: have %d, want %d", counted, 2)
+	}
+	if stats.From != 10 || stats.To != 13 {
+		t.Errorf("range mismatch: have %d-%d, want 10-13", stats.From, stats.To)
+	}
+	if stats.Uncles != 4 || stats.UncleRate != 1 {
+		t.Errorf("uncles mismatch: have %d (rate %v), want 4 (rate 1)", stats.Uncles, stats.UncleRate)
+	}
+	if stats.MeanBlockTime != 12 {
+		t.Errorf("block time mismatch: have %v, want %v", stats.MeanBlockTime, 12)
+	}
+	if stats.GasUsed != 1500 || stats.GasUtilization != 0.375 {
+		t.Errorf("gas mismatch: have %d (utilization %v), want 1500 (utilization 0.375)", stats.GasUsed, stats.GasUtilization)
+	}
+	if stats.Difficulty.ToInt().Int64() != 400 {
+		t.Errorf("difficulty mismatch: have %v, want %v", stats.Difficulty, 400)
+	}
+	if want := (350.0 - 150.0) / 150.0; stats.DifficultyTrend != want {
+		t.Errorf("trend mismatch: have %v, want %v", stats.DifficultyTrend, want)
+	}
+}
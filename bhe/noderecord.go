@@ -0,0 +1,244 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// nodeKeyFile is the name of the node key within the data directory, as loaded
+// by the node on startup.
+const nodeKeyFile = "nodekey"
+
+// maxNodeRecordEntry is the maximum size of a custom node record entry. Records
+// are capped at 300 bytes in total, so entries must stay small, and are checked
+// against the cap together.
+const maxNodeRecordEntry = 64
+
+var (
+	errNodeNotStarted  = errors.New("node not started")
+	errEphemeralKey    = errors.New("node key not loaded from the data directory")
+	errReservedENRKey  = errors.New("reserved node record key")
+	errInvalidENRKey   = errors.New("invalid node record key")
+	errENREntryTooLong = fmt.Errorf("node record entry exceeds %d bytes", maxNodeRecordEntry)
+	errENRTooLarge     = fmt.Errorf("node record would exceed %d bytes", enr.SizeLimit)
+)
+
+// reservedENRKeys are the node record keys managed by the p2p stack and the
+// protocols, which can't be overridden by custom entries.
+var reservedENRKeys = map[string]bool{
+	"id": true, "secp256k1": true,
+	"ip": true, "ip6": true, "tcp": true, "tcp6": true, "udp": true, "udp6": true,
+	"BHE": true, "les": true, "snap": true,
+}
+
+// validateENREntry checks that a custom node record entry has a printable key
+// not managed by the node, and fits the record.
+func validateENREntry(key, value string) error {
+	if key == "" || len(key) > maxNodeRecordEntry {
+		return errInvalidENRKey
+	}
+	for _, c := range key {
+		if c <= ' ' || c > '~' {
+			return errInvalidENRKey
+		}
+	}
+	if reservedENRKeys[key] {
+		return errReservedENRKey
+	}
+	if len(value) > maxNodeRecordEntry {
+		return errENREntryTooLong
+	}
+	return nil
+}
+
+// checkNodeRecordSize checks that the local node record stays within the size
+// limit with an entry set, by signing a copy of it the way the local node will.
+// Signing an oversized record makes the local node panic.
+func checkNodeRecordSize(node *enode.Node, key *ecdsa.PrivateKey, entry enr.Entry) error {
+	blob, err := rlp.EncodeToBytes(node.Record())
+	if err != nil {
+		return err
+	}
+	record := new(enr.Record) // Decoded copy, not sharing entries with the node
+	if err := rlp.DecodeBytes(blob, record); err != nil {
+		return err
+	}
+	record.Set(entry)
+	record.SetSeq(record.Seq() + 1)
+	if err := enode.SignV4(record, key); err != nil {
+		return errENRTooLarge
+	}
+	return nil
+}
+
+// setNodeRecordEntry advertises a custom entry in the local node record, or
+// removes it if value is nil.
+func (s *BHEereum) setNodeRecordEntry(key string, value *string) error {
+	if value != nil {
+		if err := validateENREntry(key, *value); err != nil {
+			return err
+		}
+	} else if reservedENRKeys[key] {
+		return errReservedENRKey
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.localNode == nil {
+		return errNodeNotStarted
+	}
+	if value == nil {
+		delete(s.enrEntries, key)
+		s.localNode.Delete(enr.WithEntry(key, nil))
+		return nil
+	}
+	entry := enr.WithEntry(key, *value)
+	if err := checkNodeRecordSize(s.localNode.Node(), s.nodeKey, entry); err != nil {
+		return err
+	}
+	if s.enrEntries == nil {
+		s.enrEntries = make(map[string]string)
+	}
+	s.enrEntries[key] = *value
+	s.localNode.Set(entry)
+	return nil
+}
+
+// startNodeRecord advertises the custom record entries of the configuration.
+func (s *BHEereum) startNodeRecord(srvr *p2p.Server) {
+	s.lock.Lock()
+	s.localNode, s.nodeKey = srvr.LocalNode(), srvr.PrivateKey
+	s.lock.Unlock()
+
+	keys := make([]string, 0, len(s.config.ENREntries))
+	for key := range s.config.ENREntries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := s.config.ENREntries[key]
+		if err := s.setNodeRecordEntry(key, &value); err != nil {
+			log.Warn("Skipping invalid node record entry", "key", key, "err", err)
+		}
+	}
+}
+
+// regenerateNodeKey replaces the node key in the data directory with a fresh
+// one, keeping the previous key alongside. The running node keeps its identity
+// until restarted.
+func (s *BHEereum) regenerateNodeKey() (*ecdsa.PrivateKey, error) {
+	s.lock.RLock()
+	current := s.nodeKey
+	s.lock.RUnlock()
+
+	if current == nil {
+		return nil, errNodeNotStarted
+	}
+	// Refuse to touch the data directory unless it holds the key in use
+	if s.nodeKeyPath == "" {
+		return nil, errEphemeralKey
+	}
+	stored, err := crypto.LoadECDSA(s.nodeKeyPath)
+	if err != nil || stored.D.Cmp(current.D) != 0 {
+		return nil, errEphemeralKey
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	tmp := s.nodeKeyPath + ".new"
+	if err := crypto.SaveECDSA(tmp, key); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(s.nodeKeyPath, s.nodeKeyPath+".old"); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, s.nodeKeyPath); err != nil {
+		return nil, err
+	}
+	log.Warn("Regenerated node key, restart to apply", "id", enode.PubkeyToIDV4(&key.PublicKey), "previous", s.nodeKeyPath+".old")
+	return key, nil
+}
+
+// NodeRecord is the local node record along with the custom entries set on it.
+type NodeRecord struct {
+	ENR     string            `json:"enr"`
+	Enode   string            `json:"enode"`
+	ID      enode.ID          `json:"id"`
+	Seq     uint64            `json:"seq"`
+	Entries map[string]string `json:"entries"`
+}
+
+// NodeRecord returns the record the node currently advertises to its peers.
+func (api *PrivateAdminAPI) NodeRecord() (*NodeRecord, error) {
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	if api.BHE.localNode == nil {
+		return nil, errNodeNotStarted
+	}
+	node := api.BHE.localNode.Node()
+	entries := make(map[string]string, len(api.BHE.enrEntries))
+	for key, value := range api.BHE.enrEntries {
+		entries[key] = value
+	}
+	return &NodeRecord{
+		ENR:     node.String(),
+		Enode:   node.URLv4(),
+		ID:      node.ID(),
+		Seq:     node.Seq(),
+		Entries: entries,
+	}, nil
+}
+
+// SetNodeRecordEntry advertises custom metadata to peers in the node record,
+// e.g. the region of the node or whether it serves archive data. Entries set
+// through the API last until the node is stopped; configure ENREntries to keep
+// them across restarts.
+func (api *PrivateAdminAPI) SetNodeRecordEntry(key, value string) (*NodeRecord, error) {
+	if err := api.BHE.setNodeRecordEntry(key, &value); err != nil {
+		return nil, err
+	}
+	log.Info("Updated node record entry", "key", key, "value", value)
+	return api.NodeRecord()
+}
+
+// DeleteNodeRecordEntry stops advertising a custom node record entry.
+func (api *PrivateAdminAPI) DeleteNodeRecordEntry(key string) (*NodeRecord, error) {
+	if err := api.BHE.setNodeRecordEntry(key, nil); err != nil {
+		return nil, err
+	}
+	log.Info("Removed node record entry", "key", key)
+	return api.NodeRecord()
+}
+
+// RegenerateNodeKey replaces the node key with a fresh one, returning the node
+// ID taking effect on the next restart. The previous key is kept with an .old
+// suffix.
+func (api *PrivateAdminAPI) RegenerateNodeKey() (enode.ID, error) {
+	key, err := api.BHE.regenerateNodeKey()
+	if err != nil {
+		return enode.ID{}, err
+	}
+	return enode.PubkeyToIDV4(&key.PublicKey), nil
+}
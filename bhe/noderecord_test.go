@@ -0,0 +1,77 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Tests that custom node record entries are rejected if they'd override the
+// entries managed by the node or bloat the record.
+func TestValidateENREntry(t *testing.T) {
+	tests := []struct {
+		key, value string
+		err        error
+	}{
+		{"region", "eu-west", nil},
+		{"archive", "", nil},
+		{"", "x", errInvalidENRKey},
+		{"my key", "x", errInvalidENRKey},
+		{"r\u00e9gion", "x", errInvalidENRKey},
+		{strings.Repeat("k", maxNodeRecordEntry+1), "x", errInvalidENRKey},
+		{"ip", "10.0.0.1", errReservedENRKey},
+		{"BHE", "x", errReservedENRKey},
+		{"secp256k1", "x", errReservedENRKey},
+		{"region", strings.Repeat("v", maxNodeRecordEntry+1), errENREntryTooLong},
+	}
+	for i, tt := range tests {
+		if err := validateENREntry(tt.key, tt.value); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that custom entries are refused once together they'd take the record
+// past its size limit, instead of making the local node fail to sign it.
+func TestCheckNodeRecordSize(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+	ln := enode.NewLocalNode(db, key)
+
+	value := strings.Repeat("v", maxNodeRecordEntry)
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		entry := enr.WithEntry(fmt.Sprintf("key%d", i), value)
+		if err := checkNodeRecordSize(ln.Node(), key, entry); err != nil {
+			if err != errENRTooLarge {
+				t.Fatalf("entry %d: error mismatch: have %v, want %v", i, err, errENRTooLarge)
+			}
+			break
+		}
+		ln.Set(entry)
+		accepted++
+	}
+	if accepted == 0 || accepted == 10 {
+		t.Fatalf("entries accepted mismatch: have %d, want some but not all", accepted)
+	}
+	if _, err := enode.New(enode.ValidSchemes, ln.Node().Record()); err != nil {
+		t.Errorf("local node record invalid: %v", err)
+	}
+}
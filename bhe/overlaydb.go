@@ -0,0 +1,295 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errOverlayClosed = errors.New("overlay database closed")
+
+// overlayEntry is a value written or deleted in a layer of an overlay store.
+type overlayEntry struct {
+	value   []byte
+	deleted bool
+}
+
+// overlayStore is an in-memory key-value store made of layers stacked on top of
+// each other. Writes go to the topmost layer, reads go through the layers from
+// the top down, so that discarding the layers above a point restores the store
+// to its exact contents at the time the point was marked. The bottom layer is
+// never discarded.
+type overlayStore struct {
+	layers []map[string]overlayEntry
+	lock   sync.RWMutex
+}
+
+// newOverlayStore creates an overlay store with just its bottom layer.
+func newOverlayStore() *overlayStore {
+	return &overlayStore{layers: []map[string]overlayEntry{make(map[string]overlayEntry)}}
+}
+
+// push stacks a new layer on top of the store, returning its depth to pop it.
+func (s *overlayStore) push() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.layers = append(s.layers, make(map[string]overlayEntry))
+	return len(s.layers) - 1
+}
+
+// pop discards the layer of the given depth along with the ones above it.
+func (s *overlayStore) pop(depth int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if depth < 1 || depth >= len(s.layers) {
+		return errUnknownDevSnapshot
+	}
+	s.layers = s.layers[:depth]
+	return nil
+}
+
+// lookup finds the visible entry of a key, the lock being held.
+func (s *overlayStore) lookup(key string) ([]byte, bool) {
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		if entry, ok := s.layers[i][key]; ok {
+			return entry.value, !entry.deleted
+		}
+	}
+	return nil, false
+}
+
+// write applies a write or deletion to the top layer, the lock being held. The
+// bottom layer doesn't need to keep deletions around.
+func (s *overlayStore) write(key string, value []byte, deleted bool) {
+	top := s.layers[len(s.layers)-1]
+	if deleted && len(s.layers) == 1 {
+		delete(top, key)
+		return
+	}
+	top[key] = overlayEntry{value: common.CopyBytes(value), deleted: deleted}
+}
+
+// Has implements BHEdb.KeyValueReader.
+func (s *overlayStore) Has(key []byte) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.layers == nil {
+		return false, errOverlayClosed
+	}
+	_, ok := s.lookup(string(key))
+	return ok, nil
+}
+
+// Get implements BHEdb.KeyValueReader.
+func (s *overlayStore) Get(key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.layers == nil {
+		return nil, errOverlayClosed
+	}
+	if value, ok := s.lookup(string(key)); ok {
+		return common.CopyBytes(value), nil
+	}
+	return nil, errors.New("not found")
+}
+
+// Put implements BHEdb.KeyValueWriter.
+func (s *overlayStore) Put(key []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.layers == nil {
+		return errOverlayClosed
+	}
+	s.write(string(key), value, false)
+	return nil
+}
+
+// Delete implements BHEdb.KeyValueWriter.
+func (s *overlayStore) Delete(key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.layers == nil {
+		return errOverlayClosed
+	}
+	s.write(string(key), nil, true)
+	return nil
+}
+
+// NewBatch implements BHEdb.Batcher.
+func (s *overlayStore) NewBatch() BHEdb.Batch {
+	return &overlayBatch{store: s}
+}
+
+// NewIterator implements BHEdb.Iteratee, iterating over a snapshot of the keys
+// visible through all the layers at the time of the call.
+func (s *overlayStore) NewIterator(prefix []byte, start []byte) BHEdb.Iterator {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var (
+		pr     = string(prefix)
+		st     = string(append(prefix, start...))
+		keys   []string
+		values = make(map[string][]byte)
+		seen   = make(map[string]bool)
+	)
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		for key, entry := range s.layers[i] {
+			if seen[key] || !strings.HasPrefix(key, pr) || key < st {
+				continue
+			}
+			seen[key] = true
+			if !entry.deleted {
+				keys = append(keys, key)
+				values[key] = entry.value
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	it := &overlayIterator{index: -1}
+	for _, key := range keys {
+		it.keys = append(it.keys, []byte(key))
+		it.values = append(it.values, common.CopyBytes(values[key]))
+	}
+	return it
+}
+
+// Stat implements BHEdb.Stater.
+func (s *overlayStore) Stat(property string) (string, error) {
+	return "", errors.New("unknown property")
+}
+
+// Compact implements BHEdb.Compacter, there being nothing to compact.
+func (s *overlayStore) Compact(start []byte, limit []byte) error {
+	return nil
+}
+
+// Close implements io.Closer, dropping all the layers.
+func (s *overlayStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.layers = nil
+	return nil
+}
+
+// overlayWrite is a write or deletion queued in a batch.
+type overlayWrite struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// overlayBatch is a write batch of an overlay store, applied to its top layer.
+type overlayBatch struct {
+	store  *overlayStore
+	writes []overlayWrite
+	size   int
+}
+
+func (b *overlayBatch) Put(key, value []byte) error {
+	b.writes = append(b.writes, overlayWrite{key: common.CopyBytes(key), value: common.CopyBytes(value)})
+	b.size += len(value)
+	return nil
+}
+
+func (b *overlayBatch) Delete(key []byte) error {
+	b.writes = append(b.writes, overlayWrite{key: common.CopyBytes(key), deleted: true})
+	b.size++
+	return nil
+}
+
+func (b *overlayBatch) ValueSize() int { return b.size }
+
+func (b *overlayBatch) Write() error {
+	b.store.lock.Lock()
+	defer b.store.lock.Unlock()
+
+	if b.store.layers == nil {
+		return errOverlayClosed
+	}
+	for _, w := range b.writes {
+		b.store.write(string(w.key), w.value, w.deleted)
+	}
+	return nil
+}
+
+func (b *overlayBatch) Reset() {
+	b.writes = b.writes[:0]
+	b.size = 0
+}
+
+func (b *overlayBatch) Replay(w BHEdb.KeyValueWriter) error {
+	for _, write := range b.writes {
+		if write.deleted {
+			if err := w.Delete(write.key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.Put(write.key, write.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overlayIterator iterates over the keys and values visible in an overlay store
+// when it was created.
+type overlayIterator struct {
+	keys   [][]byte
+	values [][]byte
+	index  int
+}
+
+func (it *overlayIterator) Next() bool {
+	if it.index >= len(it.keys) {
+		return false
+	}
+	it.index++
+	return it.index < len(it.keys)
+}
+
+func (it *overlayIterator) Error() error { return nil }
+
+func (it *overlayIterator) Key() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+	return it.keys[it.index]
+}
+
+func (it *overlayIterator) Value() []byte {
+	if it.index < 0 || it.index >= len(it.values) {
+		return nil
+	}
+	return it.values[it.index]
+}
+
+func (it *overlayIterator) Release() {
+	it.keys, it.values = nil, nil
+}
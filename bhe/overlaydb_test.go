@@ -0,0 +1,103 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Tests that popping the layers of an overlay store restores its exact contents
+// at the time they were pushed, writes and deletions alike.
+func TestOverlayStoreLayers(t *testing.T) {
+	db := newOverlayStore()
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("2"))
+
+	first := db.push()
+	db.Put([]byte("a"), []byte("3"))
+	db.Delete([]byte("b"))
+
+	second := db.push()
+	batch := db.NewBatch()
+	batch.Put([]byte("b"), []byte("4"))
+	batch.Put([]byte("c"), []byte("5"))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("failed to write batch: %v", err)
+	}
+	check := func(stage string, want map[string]string) {
+		t.Helper()
+		for _, key := range []string{"a", "b", "c"} {
+			value, err := db.Get([]byte(key))
+			if wantValue, ok := want[key]; ok {
+				if err != nil || string(value) != wantValue {
+					t.Errorf("%s: key %s mismatch: have %q (%v), want %q", stage, key, value, err, wantValue)
+				}
+			} else if err == nil {
+				t.Errorf("%s: key %s present: %q", stage, key, value)
+			}
+		}
+	}
+	check("top", map[string]string{"a": "3", "b": "4", "c": "5"})
+
+	if err := db.pop(second); err != nil {
+		t.Fatalf("failed to pop second layer: %v", err)
+	}
+	check("first", map[string]string{"a": "3"})
+
+	if err := db.pop(first); err != nil {
+		t.Fatalf("failed to pop first layer: %v", err)
+	}
+	check("bottom", map[string]string{"a": "1", "b": "2"})
+
+	if err := db.pop(first); err == nil {
+		t.Errorf("popped the bottom layer")
+	}
+}
+
+// Tests that iterating an overlay store yields the visible keys in order,
+// skipping the ones deleted in upper layers.
+func TestOverlayStoreIterator(t *testing.T) {
+	db := newOverlayStore()
+	for _, key := range []string{"p1", "p3", "p5", "q1"} {
+		db.Put([]byte(key), []byte(key))
+	}
+	db.push()
+	db.Delete([]byte("p3"))
+	db.Put([]byte("p2"), []byte("new"))
+	db.Put([]byte("p5"), []byte("new"))
+
+	var (
+		it   = db.NewIterator([]byte("p"), []byte("2"))
+		keys [][]byte
+		vals [][]byte
+	)
+	defer it.Release()
+	for it.Next() {
+		keys = append(keys, common.CopyBytes(it.Key()))
+		vals = append(vals, common.CopyBytes(it.Value()))
+	}
+	wantKeys := [][]byte{[]byte("p2"), []byte("p5")}
+	if len(keys) != len(wantKeys) {
+		t.Fatalf("key count mismatch: have %q, want %q", keys, wantKeys)
+	}
+	for i := range keys {
+		if !bytes.Equal(keys[i], wantKeys[i]) || !bytes.Equal(vals[i], []byte("new")) {
+			t.Errorf("item %d mismatch: have %q=%q, want %q=new", i, keys[i], vals[i], wantKeys[i])
+		}
+	}
+}
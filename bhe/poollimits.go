@@ -0,0 +1,186 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"time"
+)
+
+// poolLimitInterval is the minimum time between two checks of the pool against
+// its size and gas limits.
+const poolLimitInterval = time.Second
+
+var (
+	poolBytesGauge      = metrics.NewRegisteredGauge("BHE/txpool/bytes", nil)      // Total encoded size of the pooled transactions
+	poolGasGauge        = metrics.NewRegisteredGauge("BHE/txpool/gas", nil)        // Aggregate gas limit of the pooled transactions
+	poolOverweightMeter = metrics.NewRegisteredMeter("BHE/txpool/overweight", nil) // Transactions evicted for exceeding the limits
+)
+
+// PoolUsage is the resource usage of the transactions in the pool.
+type PoolUsage struct {
+	Txs   int    `json:"txs"`
+	Bytes uint64 `json:"bytes"`
+	Gas   uint64 `json:"gas"`
+}
+
+// measurePool sums up the resource usage of the given pool content.
+func measurePool(contents ...map[common.Address]types.Transactions) PoolUsage {
+	var usage PoolUsage
+	for _, content := range contents {
+		for _, txs := range content {
+			for _, tx := range txs {
+				usage.Txs++
+				usage.Bytes += uint64(tx.Size())
+				usage.Gas += tx.Gas()
+			}
+		}
+	}
+	return usage
+}
+
+// overweightTxs picks the remote transactions of the pool content to evict for
+// the usage to fit both the byte size and the aggregate gas limits, zero meaning
+// unlimited, returning them along with the usage left after their eviction.
+// Only the last transaction of an account is eligible, so evictions never leave
+// nonce gaps, and among those the heaviest in the exceeded dimension goes first;
+// bulky transactions are thus dropped before ordinary transfers are.
+func overweightTxs(content map[common.Address]types.Transactions, locals map[common.Address]bool, usage PoolUsage, maxBytes, maxGas uint64) ([]common.Hash, PoolUsage) {
+	tails := make(map[common.Address]int)
+	for addr, txs := range content {
+		if !locals[addr] && len(txs) > 0 {
+			tails[addr] = len(txs) - 1
+		}
+	}
+	var evict []common.Hash
+	for {
+		bytesOver := maxBytes > 0 && usage.Bytes > maxBytes
+		gasOver := maxGas > 0 && usage.Gas > maxGas
+		if !bytesOver && !gasOver {
+			return evict, usage
+		}
+		weight := func(tx *types.Transaction) uint64 {
+			if bytesOver {
+				return uint64(tx.Size())
+			}
+			return tx.Gas()
+		}
+		var (
+			heaviest *types.Transaction
+			owner    common.Address
+		)
+		for addr, tail := range tails {
+			if tx := content[addr][tail]; heaviest == nil || weight(tx) > weight(heaviest) {
+				heaviest, owner = tx, addr
+			}
+		}
+		if heaviest == nil {
+			return evict, usage // Only local transactions left
+		}
+		evict = append(evict, heaviest.Hash())
+		usage.Txs--
+		usage.Bytes -= uint64(heaviest.Size())
+		usage.Gas -= heaviest.Gas()
+
+		if tails[owner]--; tails[owner] < 0 {
+			delete(tails, owner)
+		}
+	}
+}
+
+// enforcePoolLimits measures the pool and evicts the overweight remote
+// transactions, returning the usage after the evictions.
+func (s *BHEereum) enforcePoolLimits() PoolUsage {
+	pending, queued := s.txPool.Content()
+	usage := measurePool(pending, queued)
+
+	s.lock.RLock()
+	maxBytes, maxGas := s.config.TxPool.MaxBytes, s.config.TxPool.MaxGas
+	s.lock.RUnlock()
+
+	if maxBytes > 0 || maxGas > 0 {
+		locals := make(map[common.Address]bool)
+		for _, addr := range s.txPool.Locals() {
+			locals[addr] = true
+		}
+		// Queued transactions are the least likely to be mined, drop those first
+		evict, left := overweightTxs(queued, locals, usage, maxBytes, maxGas)
+		more, _ := overweightTxs(pending, locals, left, maxBytes, maxGas)
+		evict = append(evict, more...)
+
+		if len(evict) > 0 {
+			evicted := s.txPool.RemoveTransactions(evict)
+			poolOverweightMeter.Mark(int64(evicted))
+			log.Debug("Evicted overweight transactions", "evicted", evicted, "bytes", usage.Bytes, "gas", usage.Gas)
+
+			pending, queued = s.txPool.Content()
+			usage = measurePool(pending, queued)
+		}
+	}
+	poolBytesGauge.Update(int64(usage.Bytes))
+	poolGasGauge.Update(int64(usage.Gas))
+	return usage
+}
+
+// startPoolLimits keeps the pool within its size and gas limits as new
+// transactions arrive, checking at most once per poolLimitInterval.
+func (s *BHEereum) startPoolLimits() {
+	txs := make(chan core.NewTxsEvent, 256)
+	s.poolLimitSub = s.txPool.SubscribeNewTxsEvent(txs)
+
+	go func() {
+		ticker := time.NewTicker(poolLimitInterval)
+		defer ticker.Stop()
+
+		dirty := true
+		for {
+			select {
+			case <-txs:
+				dirty = true
+			case <-ticker.C:
+				if dirty {
+					s.enforcePoolLimits()
+					dirty = false
+				}
+			case <-s.poolLimitSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// PoolUsageStatus is the resource usage of the pool along with its limits, zero
+// meaning unlimited.
+type PoolUsageStatus struct {
+	PoolUsage
+	MaxBytes uint64 `json:"maxBytes"`
+	MaxGas   uint64 `json:"maxGas"`
+}
+
+// TxPoolUsage returns the total size and aggregate gas of the pooled
+// transactions and the limits they are held to.
+func (api *PrivateAdminAPI) TxPoolUsage() PoolUsageStatus {
+	pending, queued := api.BHE.txPool.Content()
+
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	return PoolUsageStatus{
+		PoolUsage: measurePool(pending, queued),
+		MaxBytes:  api.BHE.config.TxPool.MaxBytes,
+		MaxGas:    api.BHE.config.TxPool.MaxGas,
+	}
+}
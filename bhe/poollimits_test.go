@@ -0,0 +1,66 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that overweight eviction drops the heaviest account tails in the exceeded
+// dimension first, never touching local transactions or leaving nonce gaps.
+func TestOverweightTxs(t *testing.T) {
+	var (
+		alice = common.HexToAddress("0xa")
+		bob   = common.HexToAddress("0xb")
+		local = common.HexToAddress("0xc")
+
+		small = func(nonce uint64, gas uint64) *types.Transaction {
+			return types.NewTransaction(nonce, alice, new(big.Int), gas, big.NewInt(1), nil)
+		}
+		bulky = func(nonce uint64, size int) *types.Transaction {
+			return types.NewTransaction(nonce, alice, new(big.Int), 100000, big.NewInt(1), make([]byte, size))
+		}
+	)
+	content := map[common.Address]types.Transactions{
+		alice: {bulky(0, 2000), small(1, 21000)},
+		bob:   {bulky(0, 500), bulky(1, 1000)},
+		local: {bulky(0, 4000)},
+	}
+	locals := map[common.Address]bool{local: true}
+	usage := measurePool(content)
+
+	// Exceeding the size limit evicts bob's bulky transactions, not alice's
+	// transfer in front of hers
+	evict, left := overweightTxs(content, locals, usage, usage.Bytes-1200, 0)
+	if len(evict) != 2 || evict[0] != content[bob][1].Hash() || evict[1] != content[bob][0].Hash() {
+		t.Fatalf("size eviction mismatch: %x", evict)
+	}
+	if left.Txs != usage.Txs-2 || left.Bytes > usage.Bytes-1200 {
+		t.Fatalf("remaining usage mismatch: have %+v, from %+v", left, usage)
+	}
+	// Exceeding the gas limit evicts the tail using the most gas
+	evict, _ = overweightTxs(content, locals, usage, 0, usage.Gas-1)
+	if len(evict) != 1 || evict[0] != content[bob][1].Hash() {
+		t.Fatalf("gas eviction mismatch: %x", evict)
+	}
+	// Local transactions are never evicted
+	evict, _ = overweightTxs(content, locals, usage, 1, 0)
+	if len(evict) != 4 {
+		t.Fatalf("local transactions evicted: %d", len(evict))
+	}
+}
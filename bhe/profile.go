@@ -0,0 +1,154 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// errLocationExists is returned when a profile would overwrite an existing file.
+var errLocationExists = errors.New("location would overwrite an existing file")
+
+// cpuProfiler runs at most one CPU profile at a time, optionally stopping it
+// after a fixed window.
+type cpuProfiler struct {
+	file  *os.File
+	path  string
+	start time.Time
+	timer *time.Timer // Stops a windowed profile, nil if open ended
+	lock  sync.Mutex
+}
+
+// createProfileFile creates a new profile file, refusing to overwrite an
+// existing one since the path may point anywhere on the drive.
+func createProfileFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return nil, errLocationExists
+	}
+	return file, err
+}
+
+// begin starts profiling into a file, for the given window if non-zero.
+func (p *cpuProfiler) begin(path string, window time.Duration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.file != nil {
+		return errors.New("CPU profiling already in progress")
+	}
+	file, err := createProfileFile(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	p.file, p.path, p.start = file, path, time.Now()
+	if window > 0 {
+		p.timer = time.AfterFunc(window, func() {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+
+			if p.file == file { // Not stopped and restarted meanwhile
+				if err := p.stop(); err != nil {
+					log.Warn("Failed to stop CPU profile", "err", err)
+				}
+			}
+		})
+	}
+	log.Info("CPU profiling started", "path", path, "window", window)
+	return nil
+}
+
+// end stops the running profile and flushes it to its file.
+func (p *cpuProfiler) end() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.file == nil {
+		return errors.New("CPU profiling not in progress")
+	}
+	return p.stop()
+}
+
+// stop stops the running profile, the lock must be held.
+func (p *cpuProfiler) stop() error {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	pprof.StopCPUProfile()
+	err := p.file.Close()
+	log.Info("CPU profiling stopped", "path", p.path, "elapsed", common.PrettyDuration(time.Since(p.start)))
+
+	p.file = nil
+	return err
+}
+
+// StartCPUProfile starts writing a CPU profile into the given file. If seconds
+// is given, profiling stops by itself after that long.
+func (api *PrivateDebugAPI) StartCPUProfile(file string, seconds *uint64) (bool, error) {
+	var window time.Duration
+	if seconds != nil {
+		window = time.Duration(*seconds) * time.Second
+	}
+	if err := api.BHE.profiler.begin(file, window); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StopCPUProfile stops the running CPU profile.
+func (api *PrivateDebugAPI) StopCPUProfile() (bool, error) {
+	if err := api.BHE.profiler.end(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WriteMemProfile writes a heap profile into the given file, after a garbage
+// collection so that it reflects the live memory.
+func (api *PrivateDebugAPI) WriteMemProfile(file string) (bool, error) {
+	out, err := createProfileFile(file)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+
+	runtime.GC()
+	if err := pprof.Lookup("heap").WriteTo(out, 0); err != nil {
+		return false, err
+	}
+	log.Info("Wrote heap profile", "path", file)
+	return true, nil
+}
+
+// Stacks returns the stack traces of all goroutines.
+func (api *PrivateDebugAPI) Stacks() string {
+	buf := new(bytes.Buffer)
+	pprof.Lookup("goroutine").WriteTo(buf, 2)
+	return buf.String()
+}
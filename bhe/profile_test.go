@@ -0,0 +1,63 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that only one CPU profile runs at a time, that windowed profiles stop
+// by themselves and that existing files are never overwritten.
+func TestCPUProfiler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		p    cpuProfiler
+		path = filepath.Join(dir, "cpu.prof")
+	)
+	if err := p.begin(path, 0); err != nil {
+		t.Fatalf("failed to start profiling: %v", err)
+	}
+	if err := p.begin(filepath.Join(dir, "other.prof"), 0); err == nil {
+		t.Fatalf("concurrent profile started")
+	}
+	if err := p.end(); err != nil {
+		t.Fatalf("failed to stop profiling: %v", err)
+	}
+	if err := p.end(); err == nil {
+		t.Fatalf("stopped profiling twice")
+	}
+	if err := p.begin(path, 0); err != errLocationExists {
+		t.Fatalf("overwrite error mismatch: have %v, want %v", err, errLocationExists)
+	}
+	// Windowed profiles stop by themselves
+	if err := p.begin(filepath.Join(dir, "window.prof"), 50*time.Millisecond); err != nil {
+		t.Fatalf("failed to start windowed profiling: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := p.end(); err == nil {
+		t.Fatalf("windowed profile still running")
+	}
+}
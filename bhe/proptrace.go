@@ -0,0 +1,274 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// propagationTraceBlocks is the number of recent blocks whose propagation is
+// traced.
+const propagationTraceBlocks = 256
+
+var (
+	errPropagationNotTraced = errors.New("block propagation not traced")
+
+	propagationReceiveTimer = metrics.NewRegisteredTimer("BHE/propagation/receive", nil)
+	propagationImportTimer  = metrics.NewRegisteredTimer("BHE/propagation/import", nil)
+)
+
+// peerSighting is when a peer first announced a block and first delivered it in
+// full, zero if it didn't.
+type peerSighting struct {
+	announced time.Time
+	received  time.Time
+}
+
+// blockTrace records how a block reached the node.
+type blockTrace struct {
+	number   uint64
+	first    time.Time // First announcement or delivery by any peer
+	imported time.Time // Import into the chain, zero if not (yet) imported
+	peers    map[string]*peerSighting
+}
+
+// propagationTracer records when each recent block was first announced and
+// first delivered in full by every peer, so that slow relay paths show up as
+// peers lagging behind the first sighting of blocks. The protocol handler
+// reports the announcements and deliveries, the chain the imports.
+type propagationTracer struct {
+	traces map[common.Hash]*blockTrace
+	order  []common.Hash // Traced blocks, oldest first
+	lock   sync.Mutex
+}
+
+func newPropagationTracer() *propagationTracer {
+	return &propagationTracer{traces: make(map[common.Hash]*blockTrace)}
+}
+
+// sighting returns the record of a peer for a block, tracing the block if it's
+// new. The oldest trace is dropped once over the limit.
+func (t *propagationTracer) sighting(peer string, hash common.Hash, number uint64, now time.Time) *peerSighting {
+	trace := t.traces[hash]
+	if trace == nil {
+		if len(t.order) >= propagationTraceBlocks {
+			delete(t.traces, t.order[0])
+			t.order = t.order[1:]
+		}
+		trace = &blockTrace{number: number, first: now, peers: make(map[string]*peerSighting)}
+		t.traces[hash] = trace
+		t.order = append(t.order, hash)
+	}
+	seen := trace.peers[peer]
+	if seen == nil {
+		seen = new(peerSighting)
+		trace.peers[peer] = seen
+	}
+	return seen
+}
+
+// announced records a peer announcing a block by hash.
+func (t *propagationTracer) announced(peer string, hash common.Hash, number uint64, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if seen := t.sighting(peer, hash, number, now); seen.announced.IsZero() {
+		seen.announced = now
+	}
+}
+
+// received records a peer delivering a full block, either propagated or
+// fetched after an announcement.
+func (t *propagationTracer) received(peer string, hash common.Hash, number uint64, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if seen := t.sighting(peer, hash, number, now); seen.received.IsZero() {
+		seen.received = now
+		if first := t.traces[hash].first; !first.IsZero() {
+			propagationReceiveTimer.Update(now.Sub(first))
+		}
+	}
+}
+
+// imported records a traced block making it into the chain.
+func (t *propagationTracer) imported(hash common.Hash, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if trace := t.traces[hash]; trace != nil && trace.imported.IsZero() {
+		trace.imported = now
+		propagationImportTimer.Update(now.Sub(trace.first))
+	}
+}
+
+// earliest returns the first of the peer's announcement and delivery.
+func (seen *peerSighting) earliest() time.Time {
+	if seen.announced.IsZero() || (!seen.received.IsZero() && seen.received.Before(seen.announced)) {
+		return seen.received
+	}
+	return seen.announced
+}
+
+// sinceFirst returns the delay of a sighting after the first one of the block
+// in milliseconds, nil if there was no such sighting.
+func sinceFirst(first, at time.Time) *uint64 {
+	if at.IsZero() {
+		return nil
+	}
+	ms := uint64(at.Sub(first) / time.Millisecond)
+	return &ms
+}
+
+// PeerPropagation is the delay of a peer announcing and delivering a block
+// after its first sighting, in milliseconds.
+type PeerPropagation struct {
+	Peer     string  `json:"peer"`
+	Announce *uint64 `json:"announce,omitempty"`
+	Receive  *uint64 `json:"receive,omitempty"`
+}
+
+// BlockPropagation is how a block reached the node.
+type BlockPropagation struct {
+	Hash      common.Hash        `json:"hash"`
+	Number    uint64             `json:"number"`
+	FirstSeen time.Time          `json:"firstSeen"`
+	Import    *uint64            `json:"import,omitempty"` // Delay of the import after the first sighting, in milliseconds
+	Peers     []*PeerPropagation `json:"peers"`
+}
+
+// report returns the propagation of a traced block, peers ordered by their
+// earliest sighting.
+func (t *propagationTracer) report(hash common.Hash) *BlockPropagation {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	trace := t.traces[hash]
+	if trace == nil {
+		return nil
+	}
+	report := &BlockPropagation{
+		Hash:      hash,
+		Number:    trace.number,
+		FirstSeen: trace.first,
+		Import:    sinceFirst(trace.first, trace.imported),
+		Peers:     make([]*PeerPropagation, 0, len(trace.peers)),
+	}
+	earliest := make(map[string]time.Time, len(trace.peers))
+	for peer, seen := range trace.peers {
+		earliest[peer] = seen.earliest()
+		report.Peers = append(report.Peers, &PeerPropagation{
+			Peer:     peer,
+			Announce: sinceFirst(trace.first, seen.announced),
+			Receive:  sinceFirst(trace.first, seen.received),
+		})
+	}
+	sort.Slice(report.Peers, func(i, j int) bool {
+		a, b := earliest[report.Peers[i].Peer], earliest[report.Peers[j].Peer]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return report.Peers[i].Peer < report.Peers[j].Peer
+	})
+	return report
+}
+
+// PeerRelayStats are the mean delays of a peer announcing and delivering blocks
+// after their first sighting, in milliseconds, over the traced blocks.
+type PeerRelayStats struct {
+	Announced    int    `json:"announced"`
+	Received     int    `json:"received"`
+	First        int    `json:"first"` // Blocks the peer was the first to relay
+	AnnounceMean uint64 `json:"announceMean"`
+	ReceiveMean  uint64 `json:"receiveMean"`
+}
+
+// relayStats aggregates the relay delays of every peer over the traced blocks.
+func (t *propagationTracer) relayStats() map[string]*PeerRelayStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var (
+		stats    = make(map[string]*PeerRelayStats)
+		announce = make(map[string]time.Duration)
+		receive  = make(map[string]time.Duration)
+	)
+	for _, trace := range t.traces {
+		for peer, seen := range trace.peers {
+			stat := stats[peer]
+			if stat == nil {
+				stat = new(PeerRelayStats)
+				stats[peer] = stat
+			}
+			if !seen.announced.IsZero() {
+				stat.Announced++
+				announce[peer] += seen.announced.Sub(trace.first)
+			}
+			if !seen.received.IsZero() {
+				stat.Received++
+				receive[peer] += seen.received.Sub(trace.first)
+			}
+			if seen.earliest().Equal(trace.first) {
+				stat.First++
+			}
+		}
+	}
+	for peer, stat := range stats {
+		if stat.Announced > 0 {
+			stat.AnnounceMean = uint64(announce[peer] / time.Duration(stat.Announced) / time.Millisecond)
+		}
+		if stat.Received > 0 {
+			stat.ReceiveMean = uint64(receive[peer] / time.Duration(stat.Received) / time.Millisecond)
+		}
+	}
+	return stats
+}
+
+// startPropagationTracing records the imports of the traced blocks.
+func (s *BHEereum) startPropagationTracing() {
+	chain := make(chan core.ChainEvent, 64)
+	s.propagationSub = s.blockchain.SubscribeChainEvent(chain)
+
+	go func() {
+		for {
+			select {
+			case ev := <-chain:
+				s.propagation.imported(ev.Hash, time.Now())
+			case <-s.propagationSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// BlockPropagation returns when each peer announced and delivered a recent
+// block, relative to its first sighting.
+func (api *PrivateDebugAPI) BlockPropagation(hash common.Hash) (*BlockPropagation, error) {
+	if report := api.BHE.propagation.report(hash); report != nil {
+		return report, nil
+	}
+	return nil, errPropagationNotTraced
+}
+
+// PeerRelayStats returns the mean delays of every peer relaying the recent
+// blocks, to identify slow relay paths.
+func (api *PrivateDebugAPI) PeerRelayStats() map[string]*PeerRelayStats {
+	return api.BHE.propagation.relayStats()
+}
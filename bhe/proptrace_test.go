@@ -0,0 +1,95 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Tests that the propagation of a block is reported per peer relative to its
+// first sighting, peers ordered by how early they relayed it.
+func TestPropagationReport(t *testing.T) {
+	var (
+		tracer = newPropagationTracer()
+		hash   = common.HexToHash("0x01")
+		start  = time.Unix(1600000000, 0)
+		at     = func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	)
+	tracer.announced("fast", hash, 1, at(0))
+	tracer.announced("slow", hash, 1, at(400))
+	tracer.received("pusher", hash, 1, at(150))
+	tracer.received("fast", hash, 1, at(100))
+	tracer.announced("fast", hash, 1, at(500)) // Repeated announcements are ignored
+	tracer.imported(hash, at(180))
+
+	report := tracer.report(hash)
+	if report == nil {
+		t.Fatal("traced block not reported")
+	}
+	if report.Number != 1 || !report.FirstSeen.Equal(start) {
+		t.Errorf("block mismatch: have #%d at %v, want #1 at %v", report.Number, report.FirstSeen, start)
+	}
+	if report.Import == nil || *report.Import != 180 {
+		t.Errorf("import delay mismatch: have %v, want 180", report.Import)
+	}
+	want := []struct {
+		peer              string
+		announce, receive int
+	}{
+		{"fast", 0, 100},
+		{"pusher", -1, 150},
+		{"slow", 400, -1},
+	}
+	if len(report.Peers) != len(want) {
+		t.Fatalf("peer count mismatch: have %d, want %d", len(report.Peers), len(want))
+	}
+	delay := func(ms *uint64) int {
+		if ms == nil {
+			return -1
+		}
+		return int(*ms)
+	}
+	for i, w := range want {
+		p := report.Peers[i]
+		if p.Peer != w.peer || delay(p.Announce) != w.announce || delay(p.Receive) != w.receive {
+			t.Errorf("peer %d mismatch: have %s %d/%d, want %s %d/%d", i, p.Peer, delay(p.Announce), delay(p.Receive), w.peer, w.announce, w.receive)
+		}
+	}
+	stats := tracer.relayStats()
+	if stats["fast"].First != 1 || stats["slow"].First != 0 || stats["slow"].AnnounceMean != 400 {
+		t.Errorf("relay stats mismatch: fast %+v, slow %+v", stats["fast"], stats["slow"])
+	}
+}
+
+// Tests that only the most recent blocks are traced.
+func TestPropagationTraceLimit(t *testing.T) {
+	tracer := newPropagationTracer()
+	for i := 0; i <= propagationTraceBlocks; i++ {
+		tracer.announced("peer", common.BigToHash(big.NewInt(int64(i))), uint64(i), time.Now())
+	}
+	if tracer.report(common.BigToHash(big.NewInt(0))) != nil {
+		t.Error("oldest block still traced")
+	}
+	if tracer.report(common.BigToHash(big.NewInt(propagationTraceBlocks))) == nil {
+		t.Error("newest block not traced")
+	}
+	if len(tracer.traces) != propagationTraceBlocks {
+		t.Errorf("trace count mismatch: have %d, want %d", len(tracer.traces), propagationTraceBlocks)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateLimitClients is the number of client rate buckets tracked per limiter
+// before idle ones are evicted.
+const maxRateLimitClients = 4096
+
+// rateBucket is a token bucket tracking the request allowance of a client.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per-client token bucket rate limiter.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*rateBucket
+	lock    sync.Mutex
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// allow reports whBHEer the client may issue a request at the given time,
+// consuming one token from its bucket if so.
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	bucket := l.refill(client, now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// available reports whBHEer the client may issue n requests at the given time,
+// without consuming its allowance.
+func (l *rateLimiter) available(client string, n int, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.refill(client, now).tokens >= float64(n)
+}
+
+// refill tops up the bucket of a client with the allowance accrued since its
+// last request. The caller must hold the lock.
+func (l *rateLimiter) refill(client string, now time.Time) *rateBucket {
+	bucket := l.buckets[client]
+	if bucket == nil {
+		if len(l.buckets) >= maxRateLimitClients {
+			l.evict(now)
+		}
+		bucket = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+	return bucket
+}
+
+// consume deducts n requests from the allowance of a client, which has to be
+// checked to be available first.
+func (l *rateLimiter) consume(client string, n int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if bucket := l.buckets[client]; bucket != nil {
+		bucket.tokens -= float64(n)
+	}
+}
+
+// evict drops the buckets of all clients that are back to a full allowance,
+// as forgetting them doesn't change their rate limits.
+func (l *rateLimiter) evict(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// RateLimit is the request allowance of a single client.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  // Requests per second, unlimited if zero
+	Burst int     `json:"burst"` // Requests allowed in a burst
+}
+
+// RateLimitConfig contains the request rate limits of the RPC endpoints.
+type RateLimitConfig struct {
+	Default RateLimit            `json:"default"` // Allowance of a client across all methods
+	Rules   map[string]RateLimit `json:"rules"`   // Allowances by namespace (debug) or method (BHE_call)
+	ByToken bool                 `json:"byToken"` // Identify clients by the subject of a verified auth token if present, by IP otherwise
+}
+
+// requestLimiter enforces the rate limits of RPC requests per client, both the
+// default allowance and the namespace or method specific ones.
+type requestLimiter struct {
+	config RateLimitConfig
+	auth   *rpcAuth                // Token verifier identifying clients, nil if authentication is disabled
+	global *rateLimiter            // Default allowance, nil if unlimited
+	rules  map[string]*rateLimiter // Rule specific allowances
+	lock   sync.RWMutex
+}
+
+func newRequestLimiter(config RateLimitConfig, auth *rpcAuth) *requestLimiter {
+	if config.ByToken && auth == nil {
+		log.Warn("RPC rate limits by token need token authentication, limiting by IP")
+	}
+	l := &requestLimiter{config: RateLimitConfig{ByToken: config.ByToken, Rules: make(map[string]RateLimit)}, auth: auth, rules: make(map[string]*rateLimiter)}
+	l.set("", config.Default)
+	for rule, limit := range config.Rules {
+		l.set(rule, limit)
+	}
+	return l
+}
+
+// set updates the allowance of a rule, or the default one if the rule is empty.
+// A zero rate removes the limit. Clients start over with a full allowance.
+func (l *requestLimiter) set(rule string, limit RateLimit) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var limiter *rateLimiter
+	if limit.Rate > 0 {
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+		limiter = newRateLimiter(limit.Rate, limit.Burst)
+	}
+	if rule == "" {
+		l.config.Default, l.global = limit, limiter
+		return
+	}
+	if limiter == nil {
+		delete(l.config.Rules, rule)
+		delete(l.rules, rule)
+		return
+	}
+	l.config.Rules[rule], l.rules[rule] = limit, limiter
+}
+
+// allow reports whBHEer a client may call a method now, along with the name of
+// the exceeded limit if not.
+func (l *requestLimiter) allow(client, method string, now time.Time) (string, bool) {
+	return l.allowBatch(client, []string{method}, now)
+}
+
+// allowBatch reports whBHEer a client may call all the methods of a batch now,
+// along with the name of an exceeded limit if not. Method rules take precedence
+// over namespace ones. The allowances are only consumed if every limit admits
+// the whole batch.
+func (l *requestLimiter) allowBatch(client string, methods []string, now time.Time) (string, bool) {
+	// Checking and consuming the allowances has to be atomic
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var (
+		limiters []*rateLimiter
+		names    []string
+		demand   = make(map[*rateLimiter]int)
+	)
+	if l.global != nil {
+		limiters, names = append(limiters, l.global), append(names, "default")
+		demand[l.global] = len(methods)
+	}
+	for _, method := range methods {
+		rule := method
+		limiter := l.rules[rule]
+		if limiter == nil {
+			if i := strings.IndexByte(method, '_'); i > 0 {
+				rule = method[:i]
+				limiter = l.rules[rule]
+			}
+		}
+		if limiter == nil {
+			continue
+		}
+		if _, ok := demand[limiter]; !ok {
+			limiters, names = append(limiters, limiter), append(names, rule)
+		}
+		demand[limiter]++
+	}
+	for i, limiter := range limiters {
+		if !limiter.available(client, demand[limiter], now) {
+			return names[i], false
+		}
+	}
+	for _, limiter := range limiters {
+		limiter.consume(client, demand[limiter])
+	}
+	return "", true
+}
+
+// snapshot returns a copy of the current configuration.
+func (l *requestLimiter) snapshot() RateLimitConfig {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	config := RateLimitConfig{Default: l.config.Default, ByToken: l.config.ByToken, Rules: make(map[string]RateLimit)}
+	for rule, limit := range l.config.Rules {
+		config.Rules[rule] = limit
+	}
+	return config
+}
+
+// client identifies the originator of an HTTP request, by the subject of its
+// auth token if so configured and the token verifies, otherwise by remote IP.
+// Unverified tokens are ignored, as made up ones would each get a fresh
+// allowance.
+func (l *requestLimiter) client(r *http.Request) string {
+	if l.config.ByToken && l.auth != nil {
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			if claims, err := l.auth.verify(header[len("Bearer "):], time.Now()); err == nil && claims.Subject != "" {
+				return "token:" + claims.Subject
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitHandler rejects JSON-RPC requests of clients exceeding their
+// allowances before they reach the RPC server.
+type rateLimitHandler struct {
+	limiter *requestLimiter
+	next    http.Handler
+}
+
+// RateLimitHandler wraps the HTTP handler of an RPC transport with the request
+// rate limits of the node. Every message of a batch counts as a request, and
+// a batch is rejected as a whole if any of its messages exceeds a limit.
+// WebSocket connections share the allowance of their client, and are closed
+// on a message exceeding it.
+func (s *BHEereum) RateLimitHandler(next http.Handler) http.Handler {
+	return &rateLimitHandler{limiter: s.rateLimits, next: next}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *rateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := h.limiter.client(r)
+	if isWebSocketUpgrade(r) {
+		h.next.ServeHTTP(filterWebSocket(w, r, &wsFilter{request: func(msg []byte) error {
+			if rule, ok := h.limiter.allowBatch(client, compatMethods(msg), time.Now()); !ok {
+				return &RPCLimitError{Limit: "request rate", Value: rule}
+			}
+			return nil
+		}}), r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	body, err := readRequestBody(r)
+	if err == errRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, batch, err := parseCompatMessages(body)
+	if err != nil {
+		// Let the RPC server report the parse error, against the default allowance
+		if rule, ok := h.limiter.allow(client, "", time.Now()); !ok {
+			limitErr := &RPCLimitError{Limit: "request rate", Value: rule}
+			http.Error(w, limitErr.Error(), http.StatusTooManyRequests)
+			return
+		}
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	if rule, ok := h.limiter.allowBatch(client, compatMethods(body), time.Now()); !ok {
+		limitErr := &RPCLimitError{Limit: "request rate", Value: rule}
+		for _, msg := range msgs {
+			msg.Method, msg.Params, msg.Result = "", nil, nil
+			msg.Error = &compatError{Code: limitErr.ErrorCode(), Message: limitErr.Error()}
+		}
+		blob, _ := encodeCompatMessages(msgs, batch)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write(blob)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// RateLimits returns the request rate limits currently in force.
+func (api *PrivateAdminAPI) RateLimits() RateLimitConfig {
+	return api.BHE.rateLimits.snapshot()
+}
+
+// SetRateLimit updates the per-client allowance of a namespace (e.g. debug) or
+// method (e.g. BHE_call), or the default allowance if rule is empty. A zero
+// rate lifts the limit.
+func (api *PrivateAdminAPI) SetRateLimit(rule string, rate float64, burst int) (bool, error) {
+	if rate < 0 || burst < 0 {
+		return false, fmt.Errorf("invalid rate limit %v/%d", rate, burst)
+	}
+	api.BHE.rateLimits.set(rule, RateLimit{Rate: rate, Burst: burst})
+	log.Info("Updated RPC rate limit", "rule", rule, "rate", rate, "burst", burst)
+	return true, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Tests that the client rate limiter allows bursts, refills over time and tracks
+// clients independently.
+func TestRateLimiter(t *testing.T) {
+	var (
+		limiter = newRateLimiter(2, 3)
+		now     = time.Now()
+	)
+	for i := 0; i < 3; i++ {
+		if !limiter.allow("a", now) {
+			t.Fatalf("burst request %d rejected", i)
+		}
+	}
+	if limiter.allow("a", now) {
+		t.Fatalf("request beyond burst allowed")
+	}
+	if !limiter.allow("b", now) {
+		t.Fatalf("independent client rejected")
+	}
+	if !limiter.allow("a", now.Add(500*time.Millisecond)) {
+		t.Fatalf("refilled request rejected")
+	}
+	if limiter.allow("a", now.Add(500*time.Millisecond)) {
+		t.Fatalf("request beyond refill allowed")
+	}
+	// Clients back to full allowance are evicted once the table fills up
+	limiter.evict(now.Add(time.Hour))
+	if len(limiter.buckets) != 0 {
+		t.Fatalf("idle buckets not evicted: %d left", len(limiter.buckets))
+	}
+}
+
+// Tests that method rules take precedence over namespace ones, that both are
+// enforced on top of the default allowance, and that rules can be lifted.
+func TestRequestLimiterRules(t *testing.T) {
+	limiter := newRequestLimiter(RateLimitConfig{
+		Default: RateLimit{Rate: 1, Burst: 4},
+		Rules: map[string]RateLimit{
+			"debug":           {Rate: 1, Burst: 1},
+			"debug_traceCall": {Rate: 1, Burst: 2},
+		},
+	}, nil)
+	now := time.Now()
+
+	if _, ok := limiter.allow("a", "debug_traceBlock", now); !ok {
+		t.Fatalf("first namespace request rejected")
+	}
+	if rule, ok := limiter.allow("a", "debug_traceBlock", now); ok || rule != "debug" {
+		t.Fatalf("namespace limit not enforced: rule %q, allowed %v", rule, ok)
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := limiter.allow("a", "debug_traceCall", now); !ok {
+			t.Fatalf("method request %d rejected", i)
+		}
+	}
+	// Three requests were counted against the default allowance so far, the
+	// rejected one not included
+	if _, ok := limiter.allow("a", "BHE_blockNumber", now); !ok {
+		t.Fatalf("request within default limit rejected")
+	}
+	if rule, ok := limiter.allow("a", "BHE_blockNumber", now); ok || rule != "default" {
+		t.Fatalf("default limit not enforced: rule %q, allowed %v", rule, ok)
+	}
+	if _, ok := limiter.allow("b", "debug_traceBlock", now); !ok {
+		t.Fatalf("independent client rejected")
+	}
+	// Lifting the limits lets everything through
+	limiter.set("", RateLimit{})
+	limiter.set("debug", RateLimit{})
+	if _, ok := limiter.allow("a", "debug_traceBlock", now); !ok {
+		t.Fatalf("lifted namespace limit still enforced")
+	}
+	if config := limiter.snapshot(); len(config.Rules) != 1 || config.Default.Rate != 0 {
+		t.Fatalf("snapshot mismatch: %+v", config)
+	}
+}
+
+// Tests that a batch is admitted or rejected as a whole, without consuming any
+// allowance when rejected.
+func TestRequestLimiterBatch(t *testing.T) {
+	limiter := newRequestLimiter(RateLimitConfig{
+		Default: RateLimit{Rate: 1, Burst: 3},
+		Rules:   map[string]RateLimit{"debug": {Rate: 1, Burst: 1}},
+	}, nil)
+	now := time.Now()
+
+	if rule, ok := limiter.allowBatch("a", []string{"BHE_chainId", "debug_traceBlock", "debug_traceCall"}, now); ok || rule != "debug" {
+		t.Fatalf("batch over namespace limit admitted: rule %q, allowed %v", rule, ok)
+	}
+	if _, ok := limiter.allowBatch("a", []string{"BHE_chainId", "BHE_chainId", "debug_traceBlock"}, now); !ok {
+		t.Fatalf("batch within limits rejected")
+	}
+	if rule, ok := limiter.allow("a", "BHE_chainId", now); ok || rule != "default" {
+		t.Fatalf("default limit not enforced: rule %q, allowed %v", rule, ok)
+	}
+}
+
+// Tests that clients are only identified by verified auth tokens, so that made
+// up ones don't get allowances of their own.
+func TestRequestLimiterClient(t *testing.T) {
+	var (
+		secret  = make([]byte, rpcAuthSecretSize)
+		auth    = &rpcAuth{secret: secret}
+		limiter = newRequestLimiter(RateLimitConfig{ByToken: true}, auth)
+	)
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"", "10.0.0.1"},
+		{"made.up.token", "10.0.0.1"},
+		{signTestToken(bytes.Repeat([]byte{0x01}, rpcAuthSecretSize), "HS256", &authClaims{IssuedAt: time.Now().Unix(), Subject: "mallory"}), "10.0.0.1"},
+		{signTestToken(secret, "HS256", &authClaims{IssuedAt: time.Now().Unix()}), "10.0.0.1"},
+		{signTestToken(secret, "HS256", &authClaims{IssuedAt: time.Now().Unix(), Subject: "alice"}), "token:alice"},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "10.0.0.1:30303"
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		if client := limiter.client(req); client != tt.want {
+			t.Errorf("test %d: client mismatch: have %s, want %s", i, client, tt.want)
+		}
+	}
+}
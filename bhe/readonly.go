@@ -0,0 +1,32 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import "errors"
+
+// errReadOnly is returned by mutating operations on a read-only node.
+var errReadOnly = errors.New("node is in read-only mode")
+
+// writable returns an error if the node runs in read-only mode, in which it
+// serves queries off an existing database (e.g. a replica sharing a snapshot)
+// without ever modifying the chain, the transaction pool or the miner.
+func (s *BHEereum) writable() error {
+	if s.config.ReadOnly {
+		return errReadOnly
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// receiptPruneInterval is how often ancient receipts outside the retention are
+// pruned if a retention is configured.
+const receiptPruneInterval = 10 * time.Minute
+
+// receiptTailKey tracks the first block whose receipts are still held.
+var receiptTailKey = []byte("BHE-receipt-tail")
+
+var (
+	errReceiptsPruned          = errors.New("receipts pruned")
+	errTailPruningNotSupported = errors.New("freezer doesn't support tail pruning")
+)
+
+// ancientTailTruncater is implemented by freezers able to drop the oldest items
+// of a single table, leaving the others intact.
+type ancientTailTruncater interface {
+	TruncateTail(kind string, tail uint64) error
+}
+
+// readReceiptTail retrieves the first block whose receipts are held, zero if
+// none were ever pruned.
+func readReceiptTail(db BHEdb.KeyValueReader) uint64 {
+	blob, err := db.Get(receiptTailKey)
+	if err != nil || len(blob) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(blob)
+}
+
+// writeReceiptTail stores the first block whose receipts are held.
+func writeReceiptTail(db BHEdb.KeyValueWriter, tail uint64) error {
+	blob := make([]byte, 8)
+	binary.BigEndian.PutUint64(blob, tail)
+	return db.Put(receiptTailKey, blob)
+}
+
+// receiptPruneTarget returns the receipt tail implied by keeping the receipts
+// of the latest retain blocks. Only frozen receipts get pruned, as the freezer
+// still needs the recent ones to move them out of the key-value store.
+func receiptPruneTarget(head, retain, frozen uint64) uint64 {
+	if head < retain {
+		return 0
+	}
+	target := head - retain
+	if target > frozen {
+		target = frozen
+	}
+	return target
+}
+
+// pruneAncientReceipts drops the frozen receipts older than the latest retain
+// blocks, keeping the headers and bodies. It returns the number of blocks whose
+// receipts got pruned.
+func (s *BHEereum) pruneAncientReceipts(retain uint64) (uint64, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	truncater, ok := s.chainDb.(ancientTailTruncater)
+	if !ok {
+		return 0, errTailPruningNotSupported
+	}
+	frozen, err := s.chainDb.Ancients()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		head   = s.blockchain.CurrentBlock().NumberU64()
+		tail   = atomic.LoadUint64(&s.receiptTail)
+		target = receiptPruneTarget(head, retain, frozen)
+	)
+	if target <= tail {
+		return 0, nil
+	}
+	// Record the new tail first, so receipts are never served half pruned
+	if err := writeReceiptTail(s.chainDb, target); err != nil {
+		return 0, err
+	}
+	atomic.StoreUint64(&s.receiptTail, target)
+	if err := truncater.TruncateTail(ancientReceiptTable, target); err != nil {
+		return 0, fmt.Errorf("failed to prune ancient receipts: %v", err)
+	}
+	log.Info("Pruned ancient receipts", "from", tail, "to", target, "retained", head-target)
+	return target - tail, nil
+}
+
+// receiptsPruned returns errReceiptsPruned if the receipts of a block are gone.
+func (s *BHEereum) receiptsPruned(hash common.Hash) error {
+	tail := atomic.LoadUint64(&s.receiptTail)
+	if tail == 0 {
+		return nil
+	}
+	if number := rawdb.ReadHeaderNumber(s.chainDb, hash); number != nil && *number < tail {
+		return errReceiptsPruned
+	}
+	return nil
+}
+
+// startReceiptPruning periodically prunes the ancient receipts outside the
+// configured retention.
+func (s *BHEereum) startReceiptPruning() {
+	s.receiptsQuit = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(receiptPruneInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.pruneAncientReceipts(s.config.AncientReceiptRetention); err != nil {
+					log.Warn("Failed to prune ancient receipts", "err", err)
+				}
+			case <-s.receiptsQuit:
+				return
+			}
+		}
+	}()
+}
+
+// ReceiptRetention is the range of blocks whose receipts are held.
+type ReceiptRetention struct {
+	Retain hexutil.Uint64 `json:"retain"` // Number of recent blocks whose receipts are kept, zero for all
+	Tail   hexutil.Uint64 `json:"tail"`   // First block whose receipts are held
+}
+
+// ReceiptRetention returns the range of blocks whose receipts are held.
+func (api *PrivateAdminAPI) ReceiptRetention() *ReceiptRetention {
+	return &ReceiptRetention{
+		Retain: hexutil.Uint64(api.BHE.config.AncientReceiptRetention),
+		Tail:   hexutil.Uint64(atomic.LoadUint64(&api.BHE.receiptTail)),
+	}
+}
+
+// PruneAncientReceipts drops the frozen receipts older than the latest retain
+// blocks right away, returning the number of blocks pruned. Headers and bodies
+// are kept and pruned receipts can't be restored short of a resync.
+func (api *PrivateAdminAPI) PruneAncientReceipts(retain hexutil.Uint64) (hexutil.Uint64, error) {
+	pruned, err := api.BHE.pruneAncientReceipts(uint64(retain))
+	return hexutil.Uint64(pruned), err
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import "testing"
+
+// Tests that receipt pruning keeps the retained blocks and never reaches past
+// the frozen ones.
+func TestReceiptPruneTarget(t *testing.T) {
+	tests := []struct {
+		head, retain, frozen uint64
+		want                 uint64
+	}{
+		{1000, 2000, 900, 0},         // Chain shorter than the retention
+		{100000, 1000, 10000, 10000}, // Capped at the frozen blocks
+		{100000, 95000, 10000, 5000}, // Within the frozen blocks
+		{100000, 0, 10000, 10000},    // Zero retention prunes all frozen receipts
+	}
+	for i, tt := range tests {
+		if have := receiptPruneTarget(tt.head, tt.retain, tt.frozen); have != tt.want {
+			t.Errorf("test %d: target mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that the receipt tail round-trips through the database.
+func TestReceiptTail(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	if tail := readReceiptTail(db); tail != 0 {
+		t.Fatalf("fresh tail mismatch: have %d, want 0", tail)
+	}
+	if err := writeReceiptTail(db, 123456); err != nil {
+		t.Fatal(err)
+	}
+	if tail := readReceiptTail(db); tail != 123456 {
+		t.Fatalf("stored tail mismatch: have %d, want 123456", tail)
+	}
+}
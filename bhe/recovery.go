@@ -0,0 +1,270 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// resyncReportFile is the name of the file in the data directory recording the
+// automatic resyncs done so far.
+const resyncReportFile = "resync-reports.json"
+
+// ResyncReport describes an automatic resync triggered by database corruption.
+type ResyncReport struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Moved  []string  `json:"moved"` // Original -> quarantined paths
+}
+
+// chainCorruptionError is returned if the chain database opened, but its data is
+// inconsistent. It is the only failure automatic resync quarantines the chain
+// data on: anything else, like a misconfiguration or the database being locked
+// by another process, would be thrown away with a healthy chain.
+type chainCorruptionError struct {
+	err error
+}
+
+func (e *chainCorruptionError) Error() string { return "chain database corrupted: " + e.err.Error() }
+func (e *chainCorruptionError) Unwrap() error { return e.err }
+
+// checkChainIntegrity runs cheap consistency checks on the chain database that
+// detect corruption the blockchain can't repair by itself by rewinding.
+func checkChainIntegrity(db BHEdb.Database) error {
+	head := rawdb.ReadHeadHeaderHash(db)
+	if head == (common.Hash{}) {
+		return nil // Empty database, nothing to check
+	}
+	genesis := rawdb.ReadCanonicalHash(db, 0)
+	if genesis == (common.Hash{}) {
+		return errors.New("genesis canonical hash missing")
+	}
+	if rawdb.ReadHeader(db, genesis, 0) == nil {
+		return fmt.Errorf("genesis header %x missing", genesis)
+	}
+	number := rawdb.ReadHeaderNumber(db, head)
+	if number == nil {
+		return fmt.Errorf("head header %x number missing", head)
+	}
+	if rawdb.ReadHeader(db, head, *number) == nil {
+		return fmt.Errorf("head header #%d [%x] missing", *number, head)
+	}
+	if canon := rawdb.ReadCanonicalHash(db, *number); canon != head {
+		return fmt.Errorf("head header #%d [%x] not canonical (have %x)", *number, head, canon)
+	}
+	if frozen, err := db.Ancients(); err == nil && frozen > 0 {
+		if rawdb.ReadCanonicalHash(db, frozen-1) == (common.Hash{}) {
+			return fmt.Errorf("ancient store tail #%d unreadable", frozen-1)
+		}
+	}
+	return nil
+}
+
+// verifyRecentBlocks checks the most recent blocks of the canonical chain up to
+// the given head, oldest first: every header must hash to its canonical hash
+// and link to its parent, and every body and set of receipts must match the
+// roots committed to by its header. Receipts below the tail (pruned ones) are
+// not checked. It returns the number of the last block before the first
+// inconsistent one, or the head if all of them are consistent.
+func verifyRecentBlocks(db BHEdb.Reader, head, depth, receiptTail uint64) (uint64, error) {
+	start := uint64(0)
+	if head >= depth {
+		start = head - depth + 1
+	}
+	for number := start; number <= head; number++ {
+		fail := func(format string, args ...interface{}) (uint64, error) {
+			err := fmt.Errorf("block #%d: %s", number, fmt.Sprintf(format, args...))
+			if number == 0 {
+				return 0, err
+			}
+			return number - 1, err
+		}
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return fail("canonical hash missing")
+		}
+		header := rawdb.ReadHeader(db, hash, number)
+		if header == nil {
+			return fail("header %x missing", hash)
+		}
+		if have := header.Hash(); have != hash {
+			return fail("header hash mismatch: have %x, want %x", have, hash)
+		}
+		if number > 0 {
+			if parent := rawdb.ReadCanonicalHash(db, number-1); header.ParentHash != parent {
+				return fail("parent hash mismatch: have %x, want %x", header.ParentHash, parent)
+			}
+		}
+		body := rawdb.ReadBody(db, hash, number)
+		if body == nil {
+			return fail("body missing")
+		}
+		if root := types.DeriveSha(types.Transactions(body.Transactions)); root != header.TxHash {
+			return fail("transaction root mismatch: have %x, want %x", root, header.TxHash)
+		}
+		if uncles := types.CalcUncleHash(body.Uncles); uncles != header.UncleHash {
+			return fail("uncle hash mismatch: have %x, want %x", uncles, header.UncleHash)
+		}
+		if number < receiptTail {
+			continue
+		}
+		receipts := rawdb.ReadRawReceipts(db, hash, number)
+		if receipts == nil && header.ReceiptHash != types.EmptyRootHash {
+			return fail("receipts missing")
+		}
+		if root := types.DeriveSha(receipts); root != header.ReceiptHash {
+			return fail("receipt root mismatch: have %x, want %x", root, header.ReceiptHash)
+		}
+	}
+	return head, nil
+}
+
+// rollbackInconsistentBlocks verifies the most recent blocks of the chain after
+// an unclean shutdown may have left them half written, and rewinds the chain to
+// the last consistent block instead of failing on the damage later.
+func (s *BHEereum) rollbackInconsistentBlocks(depth uint64) error {
+	var (
+		start = time.Now()
+		head  = s.blockchain.CurrentBlock().NumberU64()
+	)
+	good, err := verifyRecentBlocks(s.chainDb, head, depth, atomic.LoadUint64(&s.receiptTail))
+	if err == nil {
+		log.Info("Verified recent chain data", "blocks", depth, "head", head, "elapsed", common.PrettyDuration(time.Since(start)))
+		return nil
+	}
+	if s.config.ReadOnly {
+		return fmt.Errorf("recent chain data inconsistent, rollback impossible in read-only mode: %v", err)
+	}
+	log.Error("Recent chain data inconsistent, rolling back", "err", err, "head", head, "rollback", good)
+	s.blockchain.SBHEead(good)
+	return nil
+}
+
+// ChainIntegrity is the result of verifying the most recent blocks.
+type ChainIntegrity struct {
+	Head       hexutil.Uint64 `json:"head"`
+	Consistent hexutil.Uint64 `json:"consistent"` // Last block before the first inconsistent one
+	Error      string         `json:"error,omitempty"`
+}
+
+// VerifyChain checks the hash links and the transaction, uncle and receipt roots
+// of the given number of most recent blocks, without repairing anything.
+func (api *PrivateAdminAPI) VerifyChain(blocks hexutil.Uint64) ChainIntegrity {
+	head := api.BHE.blockchain.CurrentBlock().NumberU64()
+	good, err := verifyRecentBlocks(api.BHE.chainDb, head, uint64(blocks), atomic.LoadUint64(&api.BHE.receiptTail))
+
+	result := ChainIntegrity{Head: hexutil.Uint64(head), Consistent: hexutil.Uint64(good)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// openChainDatabase opens the chain database and checks its integrity. If the
+// database is corrupted beyond repair and automatic resync is enabled, the
+// damaged data is moved aside and a fresh database is opened in its place, so
+// the node starts syncing from scratch instead of failing to start. Accounts,
+// the node key and everything else outside the chain data are left untouched.
+// Failures to open the database at all, misconfigurations included, are returned
+// as they are.
+func openChainDatabase(ctx *node.ServiceContext, config *Config) (BHEdb.Database, error) {
+	open := func() (BHEdb.Database, error) {
+		db, err := openChainStore(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkChainIntegrity(db); err != nil {
+			db.Close()
+			return nil, &chainCorruptionError{err}
+		}
+		return db, nil
+	}
+	db, err := open()
+	var corrupted *chainCorruptionError
+	if err == nil || !config.AutoResync || !errors.As(err, &corrupted) {
+		return db, err
+	}
+	log.Error("Chain database corrupted, starting automatic resync", "err", corrupted.err)
+
+	// Move the chain data (and an externally located freezer) out of the way
+	report := &ResyncReport{Time: time.Now(), Reason: corrupted.err.Error()}
+	paths := []string{ctx.ResolvePath("chaindata")}
+	if config.DatabaseFreezer != "" {
+		paths = append(paths, ctx.ResolvePath(config.DatabaseFreezer))
+	}
+	suffix := fmt.Sprintf(".corrupt-%d", report.Time.Unix())
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(path, path+suffix); err != nil {
+			return nil, fmt.Errorf("failed to quarantine corrupted %s: %v", path, err)
+		}
+		report.Moved = append(report.Moved, path+" -> "+path+suffix)
+		log.Warn("Quarantined corrupted chain data", "from", path, "to", path+suffix)
+	}
+	if err := appendResyncReport(ctx.ResolvePath(resyncReportFile), report); err != nil {
+		log.Warn("Failed to record resync report", "err", err)
+	}
+	if db, err = open(); err != nil {
+		return nil, fmt.Errorf("fresh chain database unusable: %v", err)
+	}
+	log.Warn("Opened fresh chain database, resyncing from scratch", "reason", report.Reason)
+	return db, nil
+}
+
+// appendResyncReport adds a report to the resync history file.
+func appendResyncReport(path string, report *ResyncReport) error {
+	var reports []*ResyncReport
+	if blob, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(blob, &reports); err != nil {
+			log.Warn("Discarding unreadable resync reports", "path", path, "err", err)
+		}
+	}
+	reports = append(reports, report)
+	blob, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, blob, 0600)
+}
+
+// ResyncReports returns the history of automatic resyncs of this node.
+func (api *PrivateAdminAPI) ResyncReports() ([]*ResyncReport, error) {
+	blob, err := ioutil.ReadFile(api.BHE.resyncReports)
+	if os.IsNotExist(err) {
+		return []*ResyncReport{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reports []*ResyncReport
+	if err := json.Unmarshal(blob, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
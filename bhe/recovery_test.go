@@ -0,0 +1,59 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that verifying the recent blocks finds the first inconsistent one and
+// reports the block before it as the last consistent head.
+func TestVerifyRecentBlocks(t *testing.T) {
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		parent common.Hash
+		hashes []common.Hash
+	)
+	for i := 0; i < 8; i++ {
+		block := types.NewBlock(&types.Header{Number: big.NewInt(int64(i)), ParentHash: parent}, nil, nil, nil)
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), nil)
+
+		parent = block.Hash()
+		hashes = append(hashes, parent)
+	}
+	if good, err := verifyRecentBlocks(db, 7, 8, 0); err != nil || good != 7 {
+		t.Fatalf("consistent chain: have #%d, %v, want #7", good, err)
+	}
+	// Corrupt a body in the middle of the chain
+	rawdb.WriteBody(db, hashes[5], 5, &types.Body{Uncles: []*types.Header{{Number: big.NewInt(4)}}})
+
+	if good, err := verifyRecentBlocks(db, 7, 8, 0); err == nil || good != 4 {
+		t.Errorf("corrupted body: have #%d, %v, want #4 and an error", good, err)
+	}
+	if good, err := verifyRecentBlocks(db, 7, 2, 0); err != nil || good != 7 {
+		t.Errorf("corruption outside the window: have #%d, %v, want #7", good, err)
+	}
+	// Break the hash link of a later block
+	rawdb.WriteCanonicalHash(db, common.Hash{0x01}, 6)
+
+	if good, err := verifyRecentBlocks(db, 7, 2, 0); err == nil || good != 5 {
+		t.Errorf("broken link: have #%d, %v, want #5 and an error", good, err)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ReloadableConfig is the subset of the configuration that can be changed on a
+// running node, read from a JSON file. Fields left out of the file keep their
+// current values.
+type ReloadableConfig struct {
+	GasPrice          *big.Int `json:"gasPrice,omitempty"`          // Minimum gas price of the pool and the miner
+	TxPoolMaxBytes    *uint64  `json:"txPoolMaxBytes,omitempty"`    // Maximum encoded size of the pooled transactions, zero if unlimited
+	TxPoolMaxGas      *uint64  `json:"txPoolMaxGas,omitempty"`      // Maximum aggregate gas of the pooled transactions, zero if unlimited
+	TxPendingLifetime *uint64  `json:"txPendingLifetime,omitempty"` // Maximum age of pending remote transactions in seconds, zero if unlimited
+	TxQueuedLifetime  *uint64  `json:"txQueuedLifetime,omitempty"`  // Maximum age of queued remote transactions in seconds, zero if unlimited
+	MaxPeers          *int     `json:"maxPeers,omitempty"`          // Maximum number of BHE protocol peers, light peers excluded
+	RPCGasCap         *big.Int `json:"rpcGasCap,omitempty"`         // Gas cap of RPC EVM executions, removed if zero
+}
+
+// loadReloadableConfig reads and validates a reloadable configuration file.
+// Unknown fields are rejected, so that a typo doesn't silently keep the old
+// value of a setting.
+func loadReloadableConfig(path string) (*ReloadableConfig, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(blob))
+	dec.DisallowUnknownFields()
+
+	config := new(ReloadableConfig)
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	if config.GasPrice != nil && config.GasPrice.Sign() < 0 {
+		return nil, fmt.Errorf("invalid gas price %v", config.GasPrice)
+	}
+	if config.RPCGasCap != nil && config.RPCGasCap.Sign() < 0 {
+		return nil, fmt.Errorf("invalid RPC gas cap %v", config.RPCGasCap)
+	}
+	if config.MaxPeers != nil && *config.MaxPeers < 1 {
+		return nil, fmt.Errorf("invalid peer limit %d", *config.MaxPeers)
+	}
+	return config, nil
+}
+
+// reloadConfig applies a reloadable configuration file to the running node. The
+// whole file is validated before anything is changed, so a bad file leaves the
+// node as it was. It returns the names of the settings changed.
+func (s *BHEereum) reloadConfig(path string) ([]string, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	config, err := loadReloadableConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+
+	s.lock.Lock()
+	if config.GasPrice != nil && config.GasPrice.Cmp(s.gasPrice) != 0 {
+		s.gasPrice = new(big.Int).Set(config.GasPrice)
+		changed = append(changed, "gasPrice")
+	}
+	if config.TxPoolMaxBytes != nil && *config.TxPoolMaxBytes != s.config.TxPool.MaxBytes {
+		s.config.TxPool.MaxBytes = *config.TxPoolMaxBytes
+		changed = append(changed, "txPoolMaxBytes")
+	}
+	if config.TxPoolMaxGas != nil && *config.TxPoolMaxGas != s.config.TxPool.MaxGas {
+		s.config.TxPool.MaxGas = *config.TxPoolMaxGas
+		changed = append(changed, "txPoolMaxGas")
+	}
+	if config.TxPendingLifetime != nil {
+		if lifetime := time.Duration(*config.TxPendingLifetime) * time.Second; lifetime != s.config.TxPool.PendingLifetime {
+			s.config.TxPool.PendingLifetime = lifetime
+			changed = append(changed, "txPendingLifetime")
+		}
+	}
+	if config.TxQueuedLifetime != nil {
+		if lifetime := time.Duration(*config.TxQueuedLifetime) * time.Second; lifetime != s.config.TxPool.Lifetime {
+			s.config.TxPool.Lifetime = lifetime
+			changed = append(changed, "txQueuedLifetime")
+		}
+	}
+	if config.RPCGasCap != nil {
+		gasCap := config.RPCGasCap
+		if gasCap.Sign() == 0 {
+			gasCap = nil
+		}
+		if (gasCap == nil) != (s.config.RPCGasCap == nil) || (gasCap != nil && gasCap.Cmp(s.config.RPCGasCap) != 0) {
+			s.config.RPCGasCap = gasCap
+			changed = append(changed, "rpcGasCap")
+		}
+	}
+	gasPrice := s.gasPrice
+	s.lock.Unlock()
+
+	// Push the new limits into the subsystems enforcing them
+	for _, name := range changed {
+		switch name {
+		case "gasPrice":
+			s.txPool.SetGasPrice(gasPrice)
+		case "txPoolMaxBytes", "txPoolMaxGas":
+			s.enforcePoolLimits()
+		}
+	}
+	if config.MaxPeers != nil && *config.MaxPeers != s.protocolManager.MaxPeers() {
+		s.protocolManager.SetMaxPeers(*config.MaxPeers)
+		changed = append(changed, "maxPeers")
+	}
+	log.Info("Reloaded configuration", "path", path, "changed", changed)
+	return changed, nil
+}
+
+// startConfigReload reloads the configuration file whenever the process receives
+// SIGHUP.
+func (s *BHEereum) startConfigReload(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	s.reloadQuit = make(chan struct{})
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-hup:
+				if _, err := s.reloadConfig(path); err != nil {
+					log.Error("Failed to reload configuration", "path", path, "err", err)
+				}
+			case <-s.reloadQuit:
+				return
+			}
+		}
+	}()
+}
+
+// ReloadConfig applies the reloadable settings of a configuration file to the
+// running node, returning the names of the settings changed. The configured
+// reload file is used if no path is given.
+func (api *PrivateAdminAPI) ReloadConfig(path *string) ([]string, error) {
+	file := api.BHE.config.ReloadFile
+	if path != nil {
+		file = *path
+	}
+	if file == "" {
+		return nil, errors.New("no configuration file to reload")
+	}
+	changed, err := api.BHE.reloadConfig(file)
+	if changed == nil && err == nil {
+		changed = []string{}
+	}
+	return changed, err
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that reloadable configuration files are parsed leaving unset fields
+// alone, and that invalid or misspelled settings reject the whole file.
+func TestLoadReloadableConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	load := func(content string) (*ReloadableConfig, error) {
+		path := filepath.Join(dir, "reload.json")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return loadReloadableConfig(path)
+	}
+	config, err := load(`{"gasPrice": 2000000000, "txPoolMaxGas": 0, "maxPeers": 25}`)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	if config.GasPrice == nil || config.GasPrice.Uint64() != 2000000000 {
+		t.Errorf("gas price mismatch: have %v, want 2000000000", config.GasPrice)
+	}
+	if config.TxPoolMaxGas == nil || *config.TxPoolMaxGas != 0 {
+		t.Errorf("explicit zero gas limit not loaded")
+	}
+	if config.MaxPeers == nil || *config.MaxPeers != 25 {
+		t.Errorf("peer limit mismatch: have %v, want 25", config.MaxPeers)
+	}
+	if config.TxPoolMaxBytes != nil || config.RPCGasCap != nil || config.TxPendingLifetime != nil {
+		t.Errorf("unset fields loaded: %+v", config)
+	}
+	for _, invalid := range []string{
+		`{"gasPrice": -1}`,
+		`{"maxPeers": 0}`,
+		`{"rpcGasCap": -5}`,
+		`{"gasPrize": 1, "txPoolMaxGas": 100}`,
+		`{"maxPeers": 25`,
+	} {
+		if _, err := load(invalid); err == nil {
+			t.Errorf("invalid configuration %s accepted", invalid)
+		}
+	}
+}
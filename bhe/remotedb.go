@@ -0,0 +1,374 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// remoteRangePage is the number of entries requested at once when iterating
+	// a remote store.
+	remoteRangePage = 1024
+
+	// remoteHeadPoll is how often a replica checks the remote store for a new
+	// chain head.
+	remoteHeadPoll = time.Second
+
+	// remoteCacheItemSize is the assumed average size of a cached entry, used to
+	// turn the database cache allowance into a number of entries.
+	remoteCacheItemSize = 512
+)
+
+var (
+	// ErrRemoteNotFound is returned by remote stores for missing keys.
+	ErrRemoteNotFound = errors.New("not found")
+
+	errRemoteReadOnly = errors.New("remote chain database is read-only")
+	errRemoteFrozen   = errors.New("remote chain database has ancient segments in a freezer, which replicas can't read")
+
+	remoteCacheHitMeter  = metrics.NewRegisteredMeter("BHE/db/remote/cache/hit", nil)
+	remoteCacheMissMeter = metrics.NewRegisteredMeter("BHE/db/remote/cache/miss", nil)
+)
+
+// RemoteStore is a shared key-value store holding a synced chain database, such
+// as an object store bucket or a remote KV service, which read replicas serve
+// their queries from.
+type RemoteStore interface {
+	// Get retrieves the value of a key, ErrRemoteNotFound if missing.
+	Get(key []byte) ([]byte, error)
+
+	// Range retrieves at most limit entries with the given prefix, in key order
+	// from prefix+start on.
+	Range(prefix, start []byte, limit int) (keys [][]byte, values [][]byte, err error)
+
+	Close() error
+}
+
+var (
+	remoteStores     = map[string]func(string) (RemoteStore, error){"http": dialHTTPRemoteStore, "https": dialHTTPRemoteStore}
+	remoteStoresLock sync.RWMutex
+)
+
+// RegisterRemoteStore makes a remote store available for URLs of a scheme, to
+// be selected by the RemoteDatabase option. It panics if the scheme is taken.
+func RegisterRemoteStore(scheme string, dial func(url string) (RemoteStore, error)) {
+	remoteStoresLock.Lock()
+	defer remoteStoresLock.Unlock()
+
+	if _, ok := remoteStores[scheme]; ok {
+		panic(fmt.Sprintf("remote store %q already registered", scheme))
+	}
+	remoteStores[scheme] = dial
+}
+
+// dialRemoteStore connects to the remote store of a URL.
+func dialRemoteStore(rawurl string) (RemoteStore, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	remoteStoresLock.RLock()
+	dial, ok := remoteStores[u.Scheme]
+	remoteStoresLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported remote database scheme %q", u.Scheme)
+	}
+	return dial(rawurl)
+}
+
+// immutableKey reports whether the value of a chain database key never changes
+// once written, so replicas may cache it. That holds for the entries keyed by
+// hash (trie nodes, code, headers, bodies, receipts and hash to number lookups),
+// not for the head pointers and the canonical or transaction indices, which
+// are rewritten as the chain advances and reorgs.
+func immutableKey(key []byte) bool {
+	switch len(key) {
+	case common.HashLength:
+		return true // Trie node or legacy code
+	case 1 + common.HashLength:
+		return key[0] == 'H' || key[0] == 'c' // Hash to number, code
+	case 1 + 8 + common.HashLength:
+		return key[0] == 'h' || key[0] == 'b' || key[0] == 'r' // Header, body, receipts
+	}
+	return false
+}
+
+// remoteDatabase is a read-only key-value store on top of a remote store, with
+// an in-memory cache of the immutable entries in front of it.
+type remoteDatabase struct {
+	remote RemoteStore
+	cache  *lru.Cache
+}
+
+func newRemoteDatabase(remote RemoteStore, cacheItems int) *remoteDatabase {
+	if cacheItems < 1 {
+		cacheItems = 1
+	}
+	cache, _ := lru.New(cacheItems)
+	return &remoteDatabase{remote: remote, cache: cache}
+}
+
+// Has implements BHEdb.KeyValueReader.
+func (db *remoteDatabase) Has(key []byte) (bool, error) {
+	if _, err := db.Get(key); err != nil {
+		if err == ErrRemoteNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Get implements BHEdb.KeyValueReader, caching immutable entries.
+func (db *remoteDatabase) Get(key []byte) ([]byte, error) {
+	if value, ok := db.cache.Get(string(key)); ok {
+		remoteCacheHitMeter.Mark(1)
+		return common.CopyBytes(value.([]byte)), nil
+	}
+	remoteCacheMissMeter.Mark(1)
+
+	value, err := db.remote.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if immutableKey(key) {
+		db.cache.Add(string(key), common.CopyBytes(value))
+	}
+	return value, nil
+}
+
+// Put implements BHEdb.KeyValueWriter, refusing to write.
+func (db *remoteDatabase) Put(key []byte, value []byte) error { return errRemoteReadOnly }
+
+// Delete implements BHEdb.KeyValueWriter, refusing to delete.
+func (db *remoteDatabase) Delete(key []byte) error { return errRemoteReadOnly }
+
+// NewBatch implements BHEdb.Batcher, the batch failing to write.
+func (db *remoteDatabase) NewBatch() BHEdb.Batch { return new(remoteBatch) }
+
+// NewIterator implements BHEdb.Iteratee, paging through the remote entries.
+func (db *remoteDatabase) NewIterator(prefix []byte, start []byte) BHEdb.Iterator {
+	return &remoteIterator{remote: db.remote, prefix: common.CopyBytes(prefix), next: common.CopyBytes(start), pos: -1}
+}
+
+// Stat implements BHEdb.Stater.
+func (db *remoteDatabase) Stat(property string) (string, error) {
+	return "", errors.New("unknown property")
+}
+
+// Compact implements BHEdb.Compacter, there being nothing local to compact.
+func (db *remoteDatabase) Compact(start []byte, limit []byte) error { return nil }
+
+// Close implements io.Closer.
+func (db *remoteDatabase) Close() error { return db.remote.Close() }
+
+// remoteBatch is a write batch of a read-only remote database.
+type remoteBatch struct {
+	size int
+}
+
+func (b *remoteBatch) Put(key, value []byte) error         { b.size += len(value); return nil }
+func (b *remoteBatch) Delete(key []byte) error             { b.size++; return nil }
+func (b *remoteBatch) ValueSize() int                      { return b.size }
+func (b *remoteBatch) Write() error                        { return errRemoteReadOnly }
+func (b *remoteBatch) Reset()                              { b.size = 0 }
+func (b *remoteBatch) Replay(w BHEdb.KeyValueWriter) error { return nil }
+
+// remoteIterator iterates the entries of a remote store a page at a time.
+type remoteIterator struct {
+	remote RemoteStore
+	prefix []byte
+	next   []byte // Start of the next page, relative to the prefix
+
+	keys   [][]byte
+	values [][]byte
+	pos    int
+	done   bool
+	err    error
+}
+
+// Next implements BHEdb.Iterator, fetching the next page when needed. Servers
+// may cut pages short, so only an empty page ends the iteration.
+func (it *remoteIterator) Next() bool {
+	if it.pos++; it.pos < len(it.keys) {
+		return true
+	}
+	if it.done || it.err != nil {
+		return false
+	}
+	it.keys, it.values, it.err = it.remote.Range(it.prefix, it.next, remoteRangePage)
+	it.pos = 0
+	if it.err != nil || len(it.keys) == 0 {
+		it.done = true
+		return false
+	}
+	last := it.keys[len(it.keys)-1]
+	it.next = append(common.CopyBytes(last[len(it.prefix):]), 0)
+	return true
+}
+
+func (it *remoteIterator) Error() error { return it.err }
+func (it *remoteIterator) Release()     { it.keys, it.values = nil, nil }
+
+func (it *remoteIterator) Key() []byte {
+	if it.pos < len(it.keys) {
+		return it.keys[it.pos]
+	}
+	return nil
+}
+
+func (it *remoteIterator) Value() []byte {
+	if it.pos < len(it.values) {
+		return it.values[it.pos]
+	}
+	return nil
+}
+
+// httpRemoteStore reads the chain database of another node exposing it with
+// its database server.
+type httpRemoteStore struct {
+	url    string
+	client *http.Client
+}
+
+func dialHTTPRemoteStore(rawurl string) (RemoteStore, error) {
+	return &httpRemoteStore{
+		url:    strings.TrimSuffix(rawurl, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// fetch retrieves a response body from the database server.
+func (s *httpRemoteStore) fetch(path string, query url.Values) ([]byte, error) {
+	res, err := s.client.Get(s.url + path + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(res.Body)
+	case http.StatusNotFound:
+		return nil, ErrRemoteNotFound
+	default:
+		msg, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("remote database: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+func (s *httpRemoteStore) Get(key []byte) ([]byte, error) {
+	return s.fetch("/get", url.Values{"key": {hex.EncodeToString(key)}})
+}
+
+func (s *httpRemoteStore) Range(prefix, start []byte, limit int) ([][]byte, [][]byte, error) {
+	blob, err := s.fetch("/range", url.Values{
+		"prefix": {hex.EncodeToString(prefix)},
+		"start":  {hex.EncodeToString(start)},
+		"limit":  {strconv.Itoa(limit)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []databaseEntry
+	if err := rlp.DecodeBytes(blob, &entries); err != nil {
+		return nil, nil, err
+	}
+	keys, values := make([][]byte, len(entries)), make([][]byte, len(entries))
+	for i, entry := range entries {
+		keys[i], values[i] = entry.Key, entry.Value
+	}
+	return keys, values, nil
+}
+
+func (s *httpRemoteStore) Close() error { return nil }
+
+// openRemoteChainStore opens the chain data of a remote store for a read-only
+// replica. Replicas have no freezer of their own, so the remote store has to
+// hold the whole chain: primaries that moved blocks into their freezer are
+// refused, as their ancient segments can't be served through the store.
+func openRemoteChainStore(ctx *node.ServiceContext, config *Config) (BHEdb.Database, error) {
+	if !config.ReadOnly {
+		return nil, errors.New("remote chain database requires read-only mode")
+	}
+	remote, err := dialRemoteStore(config.RemoteDatabase)
+	if err != nil {
+		return nil, err
+	}
+	db := rawdb.NewDatabase(newRemoteDatabase(remote, config.DatabaseCache*1024*1024/remoteCacheItemSize))
+	if remoteFrozen(db) {
+		db.Close()
+		return nil, errRemoteFrozen
+	}
+	if config.DatabaseFreezer != "" {
+		log.Warn("Ignoring freezer of remote chain database", "freezer", config.DatabaseFreezer)
+	}
+	log.Info("Opened remote chain database", "url", config.RemoteDatabase)
+	return db, nil
+}
+
+// remoteFrozen reports whether the chain in a key-value store is missing its
+// ancient segments, moved out into a freezer by the node writing it. The
+// freezer always leaves the genesis block behind, so the first block past it
+// is what goes missing.
+func remoteFrozen(db BHEdb.Reader) bool {
+	head := rawdb.ReadHeadHeaderHash(db)
+	if head == (common.Hash{}) {
+		return false
+	}
+	if number := rawdb.ReadHeaderNumber(db, head); number == nil || *number == 0 {
+		return false
+	}
+	return rawdb.ReadCanonicalHash(db, 1) == (common.Hash{})
+}
+
+// startReplicaFollower tracks the chain head written to the remote store by the
+// node syncing it, so the replica serves the latest blocks.
+func (s *BHEereum) startReplicaFollower() {
+	s.replicaQuit = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(remoteHeadPoll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				head := rawdb.ReadHeadBlockHash(s.chainDb)
+				if head == (common.Hash{}) || head == s.blockchain.CurrentBlock().Hash() {
+					continue
+				}
+				if err := s.blockchain.ReloadHead(); err != nil {
+					log.Warn("Failed to follow remote chain head", "hash", head, "err", err)
+				}
+			case <-s.replicaQuit:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Tests that a replica reads and iterates the chain database of another node
+// through its database server, across range pages.
+func TestRemoteDatabase(t *testing.T) {
+	source := rawdb.NewMemoryDatabase()
+	for i := 0; i < 3*remoteRangePage+7; i++ {
+		source.Put([]byte(fmt.Sprintf("a%05d", i)), []byte{byte(i)})
+	}
+	source.Put([]byte("b"), []byte("other"))
+
+	server := newDatabaseServer(source, "")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get", server.serveGet)
+	mux.HandleFunc("/range", server.serveRange)
+	httpsrv := httptest.NewServer(mux)
+	defer httpsrv.Close()
+
+	remote, err := dialRemoteStore(httpsrv.URL)
+	if err != nil {
+		t.Fatalf("failed to dial remote store: %v", err)
+	}
+	db := newRemoteDatabase(remote, 16)
+
+	if value, err := db.Get([]byte("b")); err != nil || string(value) != "other" {
+		t.Fatalf("value mismatch: have %q, %v, want %q", value, err, "other")
+	}
+	if ok, err := db.Has([]byte("missing")); err != nil || ok {
+		t.Fatalf("missing key reported present: %v, %v", ok, err)
+	}
+	if err := db.Put([]byte("c"), nil); err != errRemoteReadOnly {
+		t.Fatalf("write error mismatch: have %v, want %v", err, errRemoteReadOnly)
+	}
+	it := db.NewIterator([]byte("a"), []byte("00005"))
+	defer it.Release()
+
+	want := 5
+	for it.Next() {
+		if key := fmt.Sprintf("a%05d", want); !bytes.Equal(it.Key(), []byte(key)) {
+			t.Fatalf("iterated key mismatch: have %q, want %q", it.Key(), key)
+		}
+		want++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if want != 3*remoteRangePage+7 {
+		t.Errorf("iteration ended early: at %d, want %d", want, 3*remoteRangePage+7)
+	}
+}
+
+// Tests that only chain database entries which never change are cached.
+func TestImmutableKey(t *testing.T) {
+	hash := common.HexToHash("0x01").Bytes()
+	number := make([]byte, 8)
+
+	tests := []struct {
+		key  []byte
+		want bool
+	}{
+		{hash, true},
+		{append([]byte("h"), append(number, hash...)...), true},
+		{append([]byte("b"), append(number, hash...)...), true},
+		{append([]byte("H"), hash...), true},
+		{append(append([]byte("h"), number...), 'n'), false},
+		{append([]byte("l"), hash...), false},
+		{[]byte("LastBlock"), false},
+	}
+	for i, tt := range tests {
+		if have := immutableKey(tt.key); have != tt.want {
+			t.Errorf("test %d: key %q immutability mismatch: have %v, want %v", i, tt.key, have, tt.want)
+		}
+	}
+}
+
+// Tests that chains with blocks moved into a freezer are told apart from whole
+// ones, as replicas can't read the frozen segments.
+func TestRemoteFrozen(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	if remoteFrozen(db) {
+		t.Fatalf("empty database reported frozen")
+	}
+	genesis, block := common.HexToHash("0x01"), common.HexToHash("0x02")
+	rawdb.WriteCanonicalHash(db, genesis, 0)
+	rawdb.WriteHeaderNumber(db, genesis, 0)
+	rawdb.WriteHeadHeaderHash(db, genesis)
+	if remoteFrozen(db) {
+		t.Fatalf("genesis only database reported frozen")
+	}
+	rawdb.WriteHeaderNumber(db, block, 1)
+	rawdb.WriteHeadHeaderHash(db, block)
+	if !remoteFrozen(db) {
+		t.Fatalf("database missing its first block reported whole")
+	}
+	rawdb.WriteCanonicalHash(db, block, 1)
+	if remoteFrozen(db) {
+		t.Fatalf("whole database reported frozen")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// remoteJournalBatch is the number of journaled transactions handed to the pool
+// at once when reloading.
+const remoteJournalBatch = 1024
+
+// selectRemoteTxs picks at most limit transactions from the pending ones,
+// round-robin across accounts so that every sender keeps a gapless nonce
+// sequence and no single account crowds out the others.
+func selectRemoteTxs(pending map[common.Address]types.Transactions, locals map[common.Address]bool, limit int) types.Transactions {
+	var queues []types.Transactions
+	for addr, txs := range pending {
+		if !locals[addr] && len(txs) > 0 {
+			queues = append(queues, txs)
+		}
+	}
+	var selected types.Transactions
+	for round := 0; len(queues) > 0; round++ {
+		remaining := queues[:0]
+		for _, txs := range queues {
+			if limit > 0 && len(selected) >= limit {
+				return selected
+			}
+			selected = append(selected, txs[round])
+			if round+1 < len(txs) {
+				remaining = append(remaining, txs)
+			}
+		}
+		queues = remaining
+	}
+	return selected
+}
+
+// saveRemoteTxs journals the pending remote transactions to disk on shutdown,
+// so that a restart doesn't empty the pool. Local transactions are left to the
+// pool's own journal.
+func (s *BHEereum) saveRemoteTxs(path string) error {
+	pending, err := s.txPool.Pending()
+	if err != nil {
+		return err
+	}
+	locals := make(map[common.Address]bool)
+	for _, addr := range s.txPool.Locals() {
+		locals[addr] = true
+	}
+	txs := selectRemoteTxs(pending, locals, s.config.TxPool.RemoteJournalLimit)
+
+	tmp := path + ".new"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		if err = rlp.Encode(file, tx); err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	log.Info("Journaled remote transactions", "transactions", len(txs))
+	return nil
+}
+
+// loadRemoteTxs feeds the remote transactions journaled on the last shutdown
+// back into the pool, unless the journal is older than the configured age.
+// The journal is removed afterwards, it is only valid for a single restart.
+func (s *BHEereum) loadRemoteTxs(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	if age := time.Since(info.ModTime()); s.config.TxPool.RemoteJournalAge > 0 && age > s.config.TxPool.RemoteJournalAge {
+		log.Info("Discarding stale remote transaction journal", "age", common.PrettyDuration(age))
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		stream = rlp.NewStream(file, 0)
+		batch  types.Transactions
+		total  int
+		failed int
+	)
+	flush := func() {
+		total += len(batch)
+		admitted := s.filterRemoteTxs(batch)
+		failed += len(batch) - len(admitted)
+		for _, err := range s.txPool.AddRemotes(admitted) {
+			if err != nil {
+				failed++
+			}
+		}
+		batch = batch[:0]
+	}
+	for {
+		tx := new(types.Transaction)
+		if err = stream.Decode(tx); err != nil {
+			if err != io.EOF {
+				log.Warn("Truncated remote transaction journal", "err", err)
+			}
+			break
+		}
+		if batch = append(batch, tx); len(batch) >= remoteJournalBatch {
+			flush()
+		}
+	}
+	flush()
+	log.Info("Loaded remote transaction journal", "transactions", total, "dropped", failed)
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that the journaled remote transactions are picked round-robin across
+// accounts, keep every account's nonces gapless and skip local accounts.
+func TestSelectRemoteTxs(t *testing.T) {
+	var (
+		alice = common.HexToAddress("0xa")
+		bob   = common.HexToAddress("0xb")
+		local = common.HexToAddress("0xc")
+	)
+	txs := func(n int) types.Transactions {
+		var txs types.Transactions
+		for i := 0; i < n; i++ {
+			txs = append(txs, types.NewTransaction(uint64(i), common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil))
+		}
+		return txs
+	}
+	pending := map[common.Address]types.Transactions{alice: txs(5), bob: txs(1), local: txs(3)}
+	locals := map[common.Address]bool{local: true}
+
+	if selected := selectRemoteTxs(pending, locals, 0); len(selected) != 6 {
+		t.Errorf("unlimited selection mismatch: have %d, want 6", len(selected))
+	}
+	selected := selectRemoteTxs(pending, locals, 4)
+	if len(selected) != 4 {
+		t.Fatalf("limited selection mismatch: have %d, want 4", len(selected))
+	}
+	// Bob's single transaction must make it in despite Alice's backlog, and
+	// Alice's must be the lowest three nonces
+	nonces := make(map[uint64]int)
+	for _, tx := range selected {
+		nonces[tx.Nonce()]++
+	}
+	if nonces[0] != 2 || nonces[1] != 1 || nonces[2] != 1 {
+		t.Errorf("selected nonces mismatch: have %v", nonces)
+	}
+}
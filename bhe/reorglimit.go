@@ -0,0 +1,154 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// errReorgTooDeep is returned by the reorg guard for reorgs beyond the maximum
+// depth.
+var errReorgTooDeep = errors.New("reorg exceeds maximum depth")
+
+var reorgRejectedMeter = metrics.NewRegisteredMeter("BHE/reorg/rejected", nil)
+
+// reorgGuard vetoes a reorg onto a chain forking off at the given ancestor.
+type reorgGuard = func(ancestor *types.Header) error
+
+// combineReorgGuards merges guards into a single one vetoing a reorg if any of
+// them does, since the blockchain takes a single guard.
+func combineReorgGuards(guards ...reorgGuard) reorgGuard {
+	if len(guards) == 1 {
+		return guards[0]
+	}
+	return func(ancestor *types.Header) error {
+		for _, guard := range guards {
+			if err := guard(ancestor); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ReorgRejectedEvent is posted when a reorg deeper than the limit is refused.
+type ReorgRejectedEvent struct {
+	Head         hexutil.Uint64 `json:"head"`
+	HeadHash     common.Hash    `json:"headHash"`
+	Ancestor     hexutil.Uint64 `json:"ancestor"`
+	AncestorHash common.Hash    `json:"ancestorHash"`
+	Depth        hexutil.Uint64 `json:"depth"`
+}
+
+// reorgLimiter refuses reorgs dropping more than a fixed number of canonical
+// blocks, so that a majority attacker can't rewrite settled history.
+type reorgLimiter struct {
+	limit    uint64
+	head     func() *types.Header
+	rejected uint64 // Number of reorgs refused (atomic)
+
+	feed  event.Feed
+	scope event.SubscriptionScope
+}
+
+func newReorgLimiter(limit uint64, head func() *types.Header) *reorgLimiter {
+	return &reorgLimiter{limit: limit, head: head}
+}
+
+// checkReorg is installed into the blockchain to veto reorgs whose common
+// ancestor lies deeper than the limit below the current head.
+func (l *reorgLimiter) checkReorg(ancestor *types.Header) error {
+	head := l.head()
+	if head.Number.Cmp(ancestor.Number) <= 0 {
+		return nil
+	}
+	depth := head.Number.Uint64() - ancestor.Number.Uint64()
+	if depth <= l.limit {
+		return nil
+	}
+	atomic.AddUint64(&l.rejected, 1)
+	reorgRejectedMeter.Mark(1)
+	log.Error("Rejected deep chain reorg", "depth", depth, "limit", l.limit, "head", head.Number, "ancestor", ancestor.Number, "hash", ancestor.Hash())
+
+	// The guard runs with the chain locked, don't wait for slow subscribers
+	go l.feed.Send(ReorgRejectedEvent{
+		Head:         hexutil.Uint64(head.Number.Uint64()),
+		HeadHash:     head.Hash(),
+		Ancestor:     hexutil.Uint64(ancestor.Number.Uint64()),
+		AncestorHash: ancestor.Hash(),
+		Depth:        hexutil.Uint64(depth),
+	})
+	return errReorgTooDeep
+}
+
+// SubscribeReorgRejectedEvent registers a subscription of ReorgRejectedEvent.
+func (l *reorgLimiter) SubscribeReorgRejectedEvent(ch chan<- ReorgRejectedEvent) event.Subscription {
+	return l.scope.Track(l.feed.Subscribe(ch))
+}
+
+// ReorgLimit is the maximum reorg depth along with the number of reorgs
+// refused for exceeding it.
+type ReorgLimit struct {
+	MaxDepth hexutil.Uint64 `json:"maxDepth"`
+	Rejected hexutil.Uint64 `json:"rejected"`
+}
+
+// ReorgLimit returns the maximum depth of accepted reorgs.
+func (api *PublicBHEereumAPI) ReorgLimit() (*ReorgLimit, error) {
+	if api.e.reorgLimit == nil {
+		return nil, errors.New("reorg depth unlimited")
+	}
+	return &ReorgLimit{
+		MaxDepth: hexutil.Uint64(api.e.reorgLimit.limit),
+		Rejected: hexutil.Uint64(atomic.LoadUint64(&api.e.reorgLimit.rejected)),
+	}, nil
+}
+
+// RejectedReorgs creates a subscription notified whenever a reorg deeper than
+// the limit is refused, which is a strong sign of a majority attack.
+func (api *PublicBHEereumAPI) RejectedReorgs(ctx context.Context) (*rpc.Subscription, error) {
+	if api.e.reorgLimit == nil {
+		return nil, errors.New("reorg depth unlimited")
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan ReorgRejectedEvent, 16)
+		eventSub := api.e.reorgLimit.SubscribeReorgRejectedEvent(events)
+		defer eventSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(sub.ID, ev)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			case <-eventSub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// Tests that reorgs are only refused once they drop more blocks than the limit.
+func TestReorgLimiter(t *testing.T) {
+	head := &types.Header{Number: big.NewInt(100)}
+	limiter := newReorgLimiter(10, func() *types.Header { return head })
+
+	tests := []struct {
+		ancestor int64
+		want     error
+	}{
+		{100, nil},
+		{105, nil}, // Ancestor above the head, nothing dropped
+		{90, nil},  // Exactly at the limit
+		{89, errReorgTooDeep},
+		{0, errReorgTooDeep},
+	}
+	for i, tt := range tests {
+		if err := limiter.checkReorg(&types.Header{Number: big.NewInt(tt.ancestor)}); err != tt.want {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.want)
+		}
+	}
+	if limiter.rejected != 2 {
+		t.Errorf("rejected count mismatch: have %d, want %d", limiter.rejected, 2)
+	}
+}
+
+// Tests that combined reorg guards veto a reorg if any of them does.
+func TestCombineReorgGuards(t *testing.T) {
+	errVeto := errors.New("veto")
+	var (
+		allow = func(*types.Header) error { return nil }
+		deny  = func(*types.Header) error { return errVeto }
+	)
+	if err := combineReorgGuards(allow, allow)(new(types.Header)); err != nil {
+		t.Errorf("allowing guards vetoed: %v", err)
+	}
+	if err := combineReorgGuards(allow, deny)(new(types.Header)); err != errVeto {
+		t.Errorf("error mismatch: have %v, want %v", err, errVeto)
+	}
+	if err := combineReorgGuards(deny)(new(types.Header)); err != errVeto {
+		t.Errorf("error mismatch: have %v, want %v", err, errVeto)
+	}
+}
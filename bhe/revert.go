@@ -0,0 +1,109 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// revertError is an API error that encompasses an EVM revert with JSON error
+// code and a binary data blob.
+type revertError struct {
+	error
+	reason string // revert reason hex encoded
+}
+
+func newRevertError(result *core.ExecutionResult) *revertError {
+	reason, errUnpack := abi.UnpackRevertReason(result.Revert())
+	err := errors.New("execution reverted")
+	if errUnpack == nil {
+		err = fmt.Errorf("execution reverted: %v", reason)
+	}
+	return &revertError{
+		error:  err,
+		reason: hexutil.Encode(result.Revert()),
+	}
+}
+
+// ErrorCode returns the JSON error code for a revert.
+// See: https://github.com/BHEereum/wiki/wiki/JSON-RPC-Error-Codes-Improvement-Proposal
+func (e *revertError) ErrorCode() int {
+	return 3
+}
+
+// ErrorData returns the hex encoded revert reason.
+func (e *revertError) ErrorData() interface{} {
+	return e.reason
+}
+
+// RevertReason re-executes a failed transaction on top of the state it was
+// included on, returning the decoded reason and the raw data of its revert.
+// Receipts don't hold the return data of transactions, so the receipt RPCs
+// recompute it on demand. Successful transactions and failures other than a
+// revert yield an empty reason. As any receipt query may land here, blocks
+// whose parent state is gone are refused rather than regenerated, and the
+// replay runs in one of the RPC's EVM slots.
+func (b *BHEAPIBackend) RevertReason(ctx context.Context, hash common.Hash) (string, hexutil.Bytes, error) {
+	tx, blockHash, _, index := rawdb.ReadTransaction(b.BHE.chainDb, hash)
+	if tx == nil {
+		return "", nil, fmt.Errorf("transaction %#x not found", hash)
+	}
+	receipts := b.BHE.blockchain.GetReceiptsByHash(blockHash)
+	if index >= uint64(len(receipts)) {
+		return "", nil, fmt.Errorf("receipt of transaction %#x not found", hash)
+	}
+	if receipts[index].Status == types.ReceiptStatusSuccessful {
+		return "", nil, nil
+	}
+	header := b.BHE.blockchain.GetHeaderByHash(blockHash)
+	if header == nil {
+		return "", nil, fmt.Errorf("block %#x not found", blockHash)
+	}
+	msg, _, statedb, err := NewPrivateDebugAPI(b.BHE).computeTxEnv(blockHash, int(index), 0)
+	if err != nil {
+		return "", nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	evm, vmError, err := b.GetEVM(ctx, msg, statedb, header)
+	if err != nil {
+		return "", nil, err
+	}
+	// Abort the replay if the caller goes away
+	go func() {
+		<-ctx.Done()
+		evm.Cancel()
+	}()
+	result, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(msg.Gas()))
+	if err := vmError(); err != nil {
+		return "", nil, err
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("transaction %#x failed: %v", hash, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	if len(result.Revert()) == 0 {
+		return "", nil, nil
+	}
+	reason, _ := abi.UnpackRevertReason(result.Revert())
+	return reason, result.Revert(), nil
+}
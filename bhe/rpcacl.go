@@ -0,0 +1,294 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// errCodeMethodDenied is the JSON-RPC error code of requests rejected by the
+// access control list.
+const errCodeMethodDenied = -32007
+
+// ACLRule allows or denies methods to the clients it applies to. Methods are
+// matched as "*" (everything), a namespace ("debug") or a full method name
+// ("debug_traceCall").
+type ACLRule struct {
+	Transports []string `json:"transports,omitempty"` // Transports the rule applies to (http, ws), all if empty
+	Origins    []string `json:"origins,omitempty"`    // Remote IPs, CIDR ranges or Origin headers the rule applies to, all if empty
+	Allow      []string `json:"allow,omitempty"`      // Methods allowed, anything else is denied if set
+	Deny       []string `json:"deny,omitempty"`       // Methods denied
+}
+
+// RPCACLConfig contains the method access control lists of the RPC endpoints.
+type RPCACLConfig struct {
+	File  string    // JSON file with a list of rules, reloaded by admin_reloadRPCACL
+	Rules []ACLRule // Rules to use if no file is configured
+}
+
+// appliesTo reports whBHEer the rule covers a client.
+func (r *ACLRule) appliesTo(transport string, ip net.IP, origin string) bool {
+	return matchACLList(r.Transports, transport) && matchACLOrigin(r.Origins, ip, origin)
+}
+
+// matchACLOrigin reports whBHEer a client is in a list of origins, an empty
+// list matching everything. IP and CIDR entries are matched against the remote
+// address of the client, anything else against its Origin header, which is
+// set by the client and thus only tells browsers apart.
+func matchACLOrigin(list []string, ip net.IP, origin string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if _, cidr, err := net.ParseCIDR(item); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if addr := net.ParseIP(item); addr != nil {
+			if ip != nil && addr.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if origin != "" && strings.EqualFold(item, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchACLList reports whBHEer a value is in a list, an empty list matching
+// everything.
+func matchACLList(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchACLMethod reports whBHEer a method is matched by any of the patterns.
+func matchACLMethod(patterns []string, method string) bool {
+	namespace := method
+	if i := strings.IndexByte(method, '_'); i > 0 {
+		namespace = method[:i]
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == method || pattern == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// rpcACL evaluates the access control rules of the node. The rules applying to
+// a client are checked in order: the first one explicitly denying or allowing
+// a method decides; if none does, the method is denied if any of the rules is
+// an allow list and allowed otherwise.
+type rpcACL struct {
+	file  string
+	rules []ACLRule
+	lock  sync.RWMutex
+}
+
+// newRPCACL creates the access control list, loading the rules from the file
+// if one is configured.
+func newRPCACL(ctx *node.ServiceContext, config RPCACLConfig) (*rpcACL, error) {
+	acl := &rpcACL{rules: config.Rules}
+	if config.File != "" {
+		acl.file = ctx.ResolvePath(config.File)
+		if err := acl.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return acl, nil
+}
+
+// reload replaces the rules with the contents of the rule file. The current
+// rules stay in force if the file can't be read.
+func (acl *rpcACL) reload() error {
+	if acl.file == "" {
+		return fmt.Errorf("no RPC ACL file configured")
+	}
+	blob, err := ioutil.ReadFile(acl.file)
+	if err != nil {
+		return err
+	}
+	var rules []ACLRule
+	if err := json.Unmarshal(blob, &rules); err != nil {
+		return fmt.Errorf("invalid RPC ACL file %s: %v", acl.file, err)
+	}
+	acl.lock.Lock()
+	acl.rules = rules
+	acl.lock.Unlock()
+
+	log.Info("Loaded RPC access control list", "file", acl.file, "rules", len(rules))
+	return nil
+}
+
+// allowed reports whBHEer a client may call a method.
+func (acl *rpcACL) allowed(transport string, ip net.IP, origin, method string) bool {
+	acl.lock.RLock()
+	defer acl.lock.RUnlock()
+
+	allowList := false
+	for i := range acl.rules {
+		rule := &acl.rules[i]
+		if !rule.appliesTo(transport, ip, origin) {
+			continue
+		}
+		if matchACLMethod(rule.Deny, method) {
+			return false
+		}
+		if matchACLMethod(rule.Allow, method) {
+			return true
+		}
+		allowList = allowList || len(rule.Allow) > 0
+	}
+	return !allowList
+}
+
+// namespaceDenied reports whBHEer a namespace is denied to every client, in
+// which case it isn't registered with the RPC server at all. This is decided
+// at startup only, reloads can't bring back an unregistered namespace.
+func (acl *rpcACL) namespaceDenied(namespace string) bool {
+	acl.lock.RLock()
+	defer acl.lock.RUnlock()
+
+	for _, rule := range acl.rules {
+		if len(rule.Transports) > 0 || len(rule.Origins) > 0 {
+			continue
+		}
+		for _, pattern := range rule.Deny {
+			if pattern == "*" || pattern == namespace {
+				return true
+			}
+		}
+		if matchACLMethod(rule.Allow, namespace+"_") {
+			return false
+		}
+	}
+	return false
+}
+
+// aclHandler rejects JSON-RPC requests denied by the access control list.
+type aclHandler struct {
+	acl       *rpcACL
+	transport string
+	next      http.Handler
+}
+
+// ACLHandler wraps the HTTP handler of an RPC transport with the method access
+// control list. A batch is rejected as a whole if any of its methods is denied.
+// WebSocket connections are subject to the rules of the ws transport, and are
+// closed on a denied message.
+func (s *BHEereum) ACLHandler(transport string, next http.Handler) http.Handler {
+	return &aclHandler{acl: s.rpcACL, transport: transport, next: next}
+}
+
+// check returns an error if any of the methods called by msgs is denied to the
+// client of a request.
+func (h *aclHandler) check(r *http.Request, transport string, msgs []*compatMessage) error {
+	var (
+		ip     net.IP
+		origin = r.Header.Get("Origin")
+	)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = net.ParseIP(host)
+	} else {
+		ip = net.ParseIP(r.RemoteAddr)
+	}
+	for _, msg := range msgs {
+		if !h.acl.allowed(transport, ip, origin, msg.Method) {
+			log.Debug("Denied RPC method by ACL", "method", msg.Method, "transport", transport, "remote", r.RemoteAddr, "origin", origin)
+			return fmt.Errorf("method %s not allowed", msg.Method)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *aclHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isWebSocketUpgrade(r) {
+		h.next.ServeHTTP(filterWebSocket(w, r, &wsFilter{request: func(msg []byte) error {
+			msgs, _, err := parseCompatMessages(msg)
+			if err != nil {
+				return nil // Let the RPC server report the parse error
+			}
+			return h.check(r, "ws", msgs)
+		}}), r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	body, err := readRequestBody(r)
+	if err == errRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, batch, err := parseCompatMessages(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.check(r, h.transport, msgs); err != nil {
+		for _, msg := range msgs {
+			msg.Method, msg.Params, msg.Result = "", nil, nil
+			msg.Error = &compatError{Code: errCodeMethodDenied, Message: err.Error()}
+		}
+		blob, _ := encodeCompatMessages(msgs, batch)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(blob)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// RPCACL returns the access control rules currently in force.
+func (api *PrivateAdminAPI) RPCACL() []ACLRule {
+	api.BHE.rpcACL.lock.RLock()
+	defer api.BHE.rpcACL.lock.RUnlock()
+
+	return append([]ACLRule{}, api.BHE.rpcACL.rules...)
+}
+
+// ReloadRPCACL re-reads the access control rules from the configured file.
+func (api *PrivateAdminAPI) ReloadRPCACL() (bool, error) {
+	if err := api.BHE.rpcACL.reload(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
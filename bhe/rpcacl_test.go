@@ -0,0 +1,63 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"net"
+	"testing"
+)
+
+// Tests that access control rules are evaluated in order, scoped to their
+// transports and origins, and turn into allow lists when listing allowances.
+func TestRPCACLEvaluation(t *testing.T) {
+	acl := &rpcACL{rules: []ACLRule{
+		{Origins: []string{"10.0.0.1", "192.168.0.0/16"}, Allow: []string{"*"}},
+		{Origins: []string{"10.0.0.3"}, Deny: []string{"*"}},
+		{Transports: []string{"http"}, Deny: []string{"admin", "debug_setHead"}},
+		{Origins: []string{"https://dapp.example"}, Allow: []string{"BHE", "net_version"}},
+	}}
+	tests := []struct {
+		transport, ip, origin, method string
+		allowed                       bool
+	}{
+		{"http", "10.0.0.1", "", "admin_peers", true},                        // Trusted host
+		{"http", "192.168.7.7", "", "admin_peers", true},                     // Trusted range
+		{"http", "10.0.0.2", "", "admin_peers", false},                       // Namespace denied over HTTP
+		{"ws", "10.0.0.2", "", "admin_peers", true},                          // Rule is HTTP only
+		{"http", "10.0.0.2", "", "debug_setHead", false},                     // Method denied
+		{"http", "10.0.0.2", "", "debug_traceTransaction", true},             // Other methods fine
+		{"http", "10.0.0.2", "https://dapp.example", "BHE_call", true},       // Allowed namespace
+		{"http", "10.0.0.2", "https://dapp.example", "net_version", true},    // Allowed method
+		{"http", "10.0.0.2", "https://dapp.example", "net_peerCount", false}, // Outside the allow list
+		{"ws", "10.0.0.2", "https://dapp.example", "debug_setHead", false},   // Outside the allow list
+		{"http", "10.0.0.3", "", "BHE_call", false},                          // Denied host
+		{"http", "10.0.0.3", "https://dapp.example", "BHE_call", false},      // Origin can't lift an IP deny
+		{"http", "10.0.0.2", "10.0.0.1", "admin_peers", false},               // Origin can't pose as a trusted IP
+	}
+	for i, tt := range tests {
+		if allowed := acl.allowed(tt.transport, net.ParseIP(tt.ip), tt.origin, tt.method); allowed != tt.allowed {
+			t.Errorf("test %d (%s from %s/%q over %s): allowed %v, want %v", i, tt.method, tt.ip, tt.origin, tt.transport, allowed, tt.allowed)
+		}
+	}
+	if acl.namespaceDenied("admin") {
+		t.Errorf("transport scoped deny treated as global")
+	}
+	acl.rules = append(acl.rules, ACLRule{Deny: []string{"personal"}})
+	if !acl.namespaceDenied("personal") {
+		t.Errorf("global deny not detected")
+	}
+}
@@ -0,0 +1,509 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditMaxParams is the maximum size of the parameters recorded verbatim, larger
+// ones are replaced by their size.
+const auditMaxParams = 4096
+
+// auditRedacted replaces the values of redacted parameters.
+const auditRedacted = "<redacted>"
+
+// auditSecretParams are the positions of the parameters carrying credentials or
+// key material, which are never recorded.
+var auditSecretParams = map[string][]int{
+	"personal_newAccount":             {0},
+	"personal_importRawKey":           {0, 1},
+	"personal_unlockAccount":          {1},
+	"personal_openWallet":             {1},
+	"personal_sendTransaction":        {1},
+	"personal_signTransaction":        {1},
+	"personal_sign":                   {2},
+	"personal_signAndSendTransaction": {1},
+}
+
+// RPCAuditConfig contains the settings of the audit log of privileged RPC
+// requests.
+type RPCAuditConfig struct {
+	File       string   // File the audit records are appended to, disabled if empty
+	Namespaces []string // Namespaces audited, defaults to admin, miner, debug and personal
+	Redact     []string // Methods or namespaces whose parameters are never recorded
+	MaxSize    uint64   // Size of the log file at which it gets rotated
+	MaxFiles   int      // Number of rotated files kept
+}
+
+// DefaultRPCAuditConfig contains the default audit log settings.
+var DefaultRPCAuditConfig = RPCAuditConfig{
+	MaxSize:  64 * 1024 * 1024,
+	MaxFiles: 8,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *RPCAuditConfig) sanitize() RPCAuditConfig {
+	conf := *config
+	if conf.MaxSize < 1024*1024 {
+		log.Warn("Sanitizing invalid RPC audit log size", "provided", conf.MaxSize, "updated", DefaultRPCAuditConfig.MaxSize)
+		conf.MaxSize = DefaultRPCAuditConfig.MaxSize
+	}
+	if conf.MaxFiles < 1 {
+		log.Warn("Sanitizing invalid RPC audit log files", "provided", conf.MaxFiles, "updated", DefaultRPCAuditConfig.MaxFiles)
+		conf.MaxFiles = DefaultRPCAuditConfig.MaxFiles
+	}
+	return conf
+}
+
+// RPCAuditRecord is a single audited RPC call.
+type RPCAuditRecord struct {
+	Time      time.Time       `json:"time"`
+	Transport string          `json:"transport"`
+	Origin    string          `json:"origin"`             // Network address of the caller
+	Identity  string          `json:"identity,omitempty"` // Subject of the verified auth token
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Latency   float64         `json:"latency"` // Seconds taken by the request (the whole batch)
+}
+
+// redactAuditParams strips the credentials out of the parameters of a call.
+// Methods listed in redact have all their parameters removed, known secret
+// parameters are replaced, and oversized ones summarized.
+func redactAuditParams(method string, params json.RawMessage, redact []string) json.RawMessage {
+	if len(params) == 0 {
+		return nil
+	}
+	if matchACLMethod(redact, method) {
+		return json.RawMessage(strconv.Quote(auditRedacted))
+	}
+	if secret, ok := auditSecretParams[method]; ok {
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); err != nil {
+			return json.RawMessage(strconv.Quote(auditRedacted))
+		}
+		for _, i := range secret {
+			if i < len(list) {
+				list[i] = json.RawMessage(strconv.Quote(auditRedacted))
+			}
+		}
+		parts := make([][]byte, len(list))
+		for i, param := range list {
+			parts[i] = param
+		}
+		params = json.RawMessage("[" + string(bytes.Join(parts, []byte(","))) + "]")
+	}
+	if len(params) > auditMaxParams {
+		return json.RawMessage(strconv.Quote(fmt.Sprintf("<%d bytes>", len(params))))
+	}
+	return params
+}
+
+// rotatingFile is an append-only file rotated once it reaches a size limit,
+// keeping a number of older generations as path.1, path.2 and so on.
+type rotatingFile struct {
+	path     string
+	maxSize  uint64
+	maxFiles int
+	file     *os.File
+	size     uint64
+}
+
+func openRotatingFile(path string, maxSize uint64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, uint64(info.Size())
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first if the data would push
+// it over the size limit.
+func (f *rotatingFile) Write(data []byte) (int, error) {
+	if f.size > 0 && f.size+uint64(len(data)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += uint64(n)
+	return n, err
+}
+
+// rotate shifts the older generations up, dropping the oldest one, and starts
+// a fresh file.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+	for i := f.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
+
+// rpcAudit records the privileged RPC calls served by the node.
+type rpcAudit struct {
+	config  RPCAuditConfig
+	audited map[string]bool
+	auth    *rpcAuth // Token verifier identifying callers, nil if authentication is disabled
+	out     io.WriteCloser
+	lock    sync.Mutex
+}
+
+// newRPCAudit opens the audit log. It returns nil if auditing is disabled.
+func newRPCAudit(ctx *node.ServiceContext, config RPCAuditConfig, auth *rpcAuth) (*rpcAudit, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+	config = config.sanitize()
+	out, err := openRotatingFile(ctx.ResolvePath(config.File), config.MaxSize, config.MaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := config.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = defaultAuthNamespaces
+	}
+	audit := &rpcAudit{config: config, audited: make(map[string]bool), auth: auth, out: out}
+	for _, ns := range namespaces {
+		audit.audited[ns] = true
+	}
+	log.Info("Enabled RPC audit log", "file", config.File, "namespaces", namespaces)
+	return audit, nil
+}
+
+// audits reports whBHEer calls to a method are recorded.
+func (a *rpcAudit) audits(method string) bool {
+	if i := strings.IndexByte(method, '_'); i > 0 {
+		return a.audited[method[:i]]
+	}
+	return false
+}
+
+// identify returns the subject of the auth token of a request if it verifies.
+func (a *rpcAudit) identify(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if a.auth == nil || !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	claims, err := a.auth.verify(header[len("Bearer "):], time.Now())
+	if err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// record writes audit records to the log.
+func (a *rpcAudit) record(records []*RPCAuditRecord) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, record := range records {
+		blob, err := json.Marshal(record)
+		if err != nil {
+			log.Error("Failed to encode RPC audit record", "err", err)
+			continue
+		}
+		if _, err := a.out.Write(append(blob, '\n')); err != nil {
+			log.Error("Failed to write RPC audit record", "err", err)
+		}
+	}
+}
+
+// close closes the audit log.
+func (a *rpcAudit) close() {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.out.Close()
+}
+
+// auditHandler records the privileged JSON-RPC calls passing through an HTTP
+// transport.
+type auditHandler struct {
+	audit     *rpcAudit
+	transport string
+	next      http.Handler
+}
+
+// AuditHandler wraps the HTTP handler of an RPC transport with the audit log of
+// privileged calls. It should be the outermost wrapper, so that requests
+// rejected by authentication or the access control list are recorded too.
+func (s *BHEereum) AuditHandler(transport string, next http.Handler) http.Handler {
+	if s.rpcAudit == nil {
+		return next
+	}
+	return &auditHandler{audit: s.rpcAudit, transport: transport, next: next}
+}
+
+// auditRecords creates the audit records of the calls of a JSON-RPC message,
+// indexed like the calls, nil for the ones not audited. It returns nil if none
+// is audited.
+func (h *auditHandler) auditRecords(r *http.Request, msgs []*compatMessage, start time.Time) []*RPCAuditRecord {
+	var (
+		origin  = r.RemoteAddr
+		records = make([]*RPCAuditRecord, len(msgs))
+		audited bool
+	)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		origin = host
+	}
+	for i, msg := range msgs {
+		if !h.audit.audits(msg.Method) {
+			continue
+		}
+		records[i] = &RPCAuditRecord{
+			Time:      start.UTC(),
+			Transport: h.transport,
+			Origin:    origin,
+			Method:    msg.Method,
+			Params:    redactAuditParams(msg.Method, msg.Params, h.audit.config.Redact),
+		}
+		audited = true
+	}
+	if !audited {
+		return nil
+	}
+	if identity := h.audit.identify(r); identity != "" {
+		for _, record := range records {
+			if record != nil {
+				record.Identity = identity
+			}
+		}
+	}
+	return records
+}
+
+// answerAuditRecords fills in the errors of the audited calls from the server
+// responses, paired by position. A single error answering a batch applies to
+// all of its calls.
+func answerAuditRecords(records []*RPCAuditRecord, reqs, res []*compatMessage) {
+	if calls := answeredCalls(reqs, res); calls != nil {
+		for i, call := range calls {
+			if record := records[call]; record != nil && res[i].Error != nil {
+				record.Error = res[i].Error.Message
+			}
+		}
+		return
+	}
+	if len(res) == 1 && res[0].Error != nil {
+		for _, record := range records {
+			if record != nil {
+				record.Error = res[0].Error.Message
+			}
+		}
+	}
+}
+
+// writeAuditRecords sets the latency of the audited calls and writes them to
+// the log.
+func (h *auditHandler) writeAuditRecords(records []*RPCAuditRecord, start time.Time) {
+	var (
+		latency = time.Since(start).Seconds()
+		audited []*RPCAuditRecord
+	)
+	for _, record := range records {
+		if record != nil {
+			record.Latency = latency
+			audited = append(audited, record)
+		}
+	}
+	h.audit.record(audited)
+}
+
+// ServeHTTP implements http.Handler. Requests whose calls can't be told apart,
+// as too large or malformed, are rejected rather than passed on unaudited.
+func (h *auditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isWebSocketUpgrade(r) {
+		h.serveWebSocket(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	body, err := readRequestBody(r)
+	if err == errRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, _, err := parseCompatMessages(body)
+	if err != nil {
+		http.Error(w, "invalid JSON-RPC request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	start := time.Now()
+	records := h.auditRecords(r, msgs, start)
+	if records == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	rec := &compatRecorder{header: w.Header(), status: http.StatusOK}
+	h.next.ServeHTTP(rec, r)
+
+	if res, _, err := parseCompatMessages(rec.body.Bytes()); err == nil {
+		answerAuditRecords(records, msgs, res)
+	} else if rec.status != http.StatusOK {
+		for _, record := range records {
+			if record != nil {
+				record.Error = fmt.Sprintf("HTTP %d", rec.status)
+			}
+		}
+	}
+	h.writeAuditRecords(records, start)
+
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// auditExchange is an audited WebSocket message waiting for its response.
+type auditExchange struct {
+	reqs    []*compatMessage
+	records []*RPCAuditRecord
+	start   time.Time
+}
+
+// auditExchangeKey identifies the response to a WebSocket message by the IDs
+// of its calls, as the server answers concurrent messages in any order.
+func auditExchangeKey(msgs []*compatMessage, batch bool) string {
+	ids := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.ID) > 0 {
+			ids = append(ids, msg.ID)
+		}
+	}
+	key := string(bytes.Join(ids, []byte(",")))
+	if batch {
+		return "[" + key + "]"
+	}
+	return key
+}
+
+// serveWebSocket audits the calls sent over a WebSocket connection. Messages
+// are paired with their responses by the IDs of their calls, the oldest first
+// for reused ones, and the calls of a batch with its responses by position.
+// Calls left unanswered when the connection closes are recorded as such.
+func (h *auditHandler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	var (
+		pending = make(map[string][]*auditExchange)
+		lock    sync.Mutex
+	)
+	filter := &wsFilter{
+		request: func(msg []byte) error {
+			msgs, batch, err := parseCompatMessages(msg)
+			if err != nil {
+				return nil // Nothing to audit, the server reports the parse error
+			}
+			start := time.Now()
+			records := h.auditRecords(r, msgs, start)
+			if records == nil {
+				return nil
+			}
+			key := auditExchangeKey(msgs, batch)
+			if key == "" || key == "[]" {
+				h.writeAuditRecords(records, start) // Notifications are never answered
+				return nil
+			}
+			lock.Lock()
+			defer lock.Unlock()
+
+			pending[key] = append(pending[key], &auditExchange{reqs: msgs, records: records, start: start})
+			return nil
+		},
+		response: func(msg []byte) {
+			res, batch, err := parseCompatMessages(msg)
+			if err != nil {
+				return
+			}
+			key := auditExchangeKey(res, batch)
+
+			lock.Lock()
+			exchanges := pending[key]
+			if len(exchanges) == 0 {
+				lock.Unlock()
+				return // Unaudited call or subscription notification
+			}
+			exchange := exchanges[0]
+			if len(exchanges) == 1 {
+				delete(pending, key)
+			} else {
+				pending[key] = exchanges[1:]
+			}
+			lock.Unlock()
+
+			answerAuditRecords(exchange.records, exchange.reqs, res)
+			h.writeAuditRecords(exchange.records, exchange.start)
+		},
+		closed: func() {
+			lock.Lock()
+			defer lock.Unlock()
+
+			for _, exchanges := range pending {
+				for _, exchange := range exchanges {
+					for _, record := range exchange.records {
+						if record != nil {
+							record.Error = "connection closed"
+						}
+					}
+					h.writeAuditRecords(exchange.records, exchange.start)
+				}
+			}
+			pending = make(map[string][]*auditExchange)
+		},
+	}
+	h.next.ServeHTTP(filterWebSocket(w, r, filter), r)
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditBuffer collects the audit log in memory.
+type auditBuffer struct{ bytes.Buffer }
+
+func (b *auditBuffer) Close() error { return nil }
+
+// Tests that credentials are stripped from audited call parameters.
+func TestRedactAuditParams(t *testing.T) {
+	tests := []struct {
+		method string
+		params string
+		redact []string
+		want   string
+	}{
+		{"admin_addPeer", `["enode://abc@127.0.0.1:30303"]`, nil, `["enode://abc@127.0.0.1:30303"]`},
+		{"personal_unlockAccount", `["0x0102", "hunter2", 300]`, nil, `["0x0102","<redacted>",300]`},
+		{"personal_importRawKey", `["00ff", "hunter2"]`, nil, `["<redacted>","<redacted>"]`},
+		{"personal_sign", `["0x00", "0x0102"]`, nil, `["0x00","0x0102"]`},
+		{"admin_exportChain", `["/tmp/chain"]`, []string{"admin"}, `"<redacted>"`},
+		{"debug_setHead", `["0x0"]`, []string{"debug_setHead"}, `"<redacted>"`},
+		{"admin_nodeInfo", ``, nil, ``},
+	}
+	for i, tt := range tests {
+		if have := string(redactAuditParams(tt.method, json.RawMessage(tt.params), tt.redact)); have != tt.want {
+			t.Errorf("test %d: params mismatch: have %s, want %s", i, have, tt.want)
+		}
+	}
+	big := fmt.Sprintf(`["%s"]`, strings.Repeat("a", auditMaxParams))
+	if have := string(redactAuditParams("admin_importChain", json.RawMessage(big), nil)); have != fmt.Sprintf(`"<%d bytes>"`, len(big)) {
+		t.Errorf("oversized params mismatch: have %s", have)
+	}
+}
+
+// Tests that the audit log is rotated at its size limit, keeping the given
+// number of older generations.
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcaudit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	file, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for i := 0; i < 5; i++ {
+		if _, err := file.Write(line); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+	}
+	file.Close()
+
+	for _, name := range []string{"audit.log", "audit.log.1", "audit.log.2"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() != int64(len(line)) {
+			t.Errorf("%s: unexpected state: %v, %v", name, info, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("generation beyond the limit kept: %v", err)
+	}
+}
+
+// Tests that privileged calls are recorded with the caller's network address,
+// also for chunked bodies, and that requests which can't be audited are
+// rejected instead of passed on.
+func TestAuditHandler(t *testing.T) {
+	var (
+		out     = new(auditBuffer)
+		audit   = &rpcAudit{audited: map[string]bool{"admin": true}, out: out}
+		served  int
+		handler = &auditHandler{audit: audit, transport: "http", next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served++
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":true}`))
+		})}
+	)
+	send := func(body string, chunked bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:4444"
+		req.Header.Set("Origin", "http://forged.example")
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := send(`{"jsonrpc":"2.0","id":1,"method":"admin_addPeer","params":[]}`, true); code != http.StatusOK {
+		t.Fatalf("audited call status mismatch: have %d, want %d", code, http.StatusOK)
+	}
+	record := new(RPCAuditRecord)
+	if err := json.Unmarshal(out.Bytes(), record); err != nil {
+		t.Fatalf("failed to decode audit record: %v", err)
+	}
+	if record.Method != "admin_addPeer" || record.Origin != "10.0.0.1" {
+		t.Errorf("audit record mismatch: method %s, origin %s", record.Method, record.Origin)
+	}
+	if code := send("{", false); code != http.StatusBadRequest {
+		t.Errorf("malformed request status mismatch: have %d, want %d", code, http.StatusBadRequest)
+	}
+	padding := strings.Repeat(" ", compatMaxRequestSize)
+	if code := send(`{"jsonrpc":"2.0","id":1,"method":"admin_addPeer"}`+padding, true); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized request status mismatch: have %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+	if served != 1 {
+		t.Errorf("served requests mismatch: have %d, want 1", served)
+	}
+}
+
+// readAuditRecords decodes the records written to the audit log so far.
+func readAuditRecords(t *testing.T, audit *rpcAudit) []*RPCAuditRecord {
+	audit.lock.Lock()
+	defer audit.lock.Unlock()
+
+	var records []*RPCAuditRecord
+	for _, line := range bytes.Split(bytes.TrimSpace(audit.out.(*auditBuffer).Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		record := new(RPCAuditRecord)
+		if err := json.Unmarshal(line, record); err != nil {
+			t.Fatalf("failed to decode audit record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Tests that the responses of a batch are paired with its calls by position,
+// even if the client reused their IDs.
+func TestAuditHandlerBatch(t *testing.T) {
+	var (
+		audit   = &rpcAudit{audited: map[string]bool{"admin": true}, out: new(auditBuffer)}
+		handler = &auditHandler{audit: audit, transport: "http", next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":true},{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"denied"}}]`))
+		})}
+	)
+	body := `[{"jsonrpc":"2.0","id":1,"method":"admin_addPeer"},{"jsonrpc":"2.0","method":"admin_peers"},{"jsonrpc":"2.0","id":1,"method":"admin_removePeer"}]`
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	records := readAuditRecords(t, audit)
+	if len(records) != 3 {
+		t.Fatalf("audit records mismatch: have %d, want 3", len(records))
+	}
+	for i, want := range []string{"", "", "denied"} {
+		if records[i].Error != want {
+			t.Errorf("record %d (%s): error mismatch: have %q, want %q", i, records[i].Method, records[i].Error, want)
+		}
+	}
+}
+
+// Tests that the calls sent over a WebSocket connection are audited, paired with
+// their responses whatever the order they are answered in, and that the calls
+// left unanswered are recorded once the connection closes.
+func TestAuditHandlerWebSocket(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	audit := &rpcAudit{audited: map[string]bool{"admin": true}, out: new(auditBuffer)}
+	handler := &auditHandler{audit: audit, transport: "ws", next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		for i := 0; i < 3; i++ {
+			if _, err := readWSFrame(conn, wsMaxMessageSize, false); err != nil {
+				t.Errorf("failed to read message %d: %v", i, err)
+				return
+			}
+		}
+		conn.Write(encodeWSFrame(wsTextFrame, true, []byte(`[{"jsonrpc":"2.0","id":1,"result":true},{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"denied"}}]`), false))
+		conn.Write(encodeWSFrame(wsTextFrame, true, []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"failed"}}`), false))
+	})}
+
+	var sent bytes.Buffer
+	sent.Write(encodeWSFrame(wsTextFrame, true, []byte(`{"jsonrpc":"2.0","id":1,"method":"admin_addPeer"}`), true))
+	sent.Write(encodeWSFrame(wsTextFrame, true, []byte(`[{"jsonrpc":"2.0","id":1,"method":"admin_removePeer"},{"jsonrpc":"2.0","id":1,"method":"admin_peers"}]`), true))
+	sent.Write(encodeWSFrame(wsTextFrame, true, []byte(`{"jsonrpc":"2.0","id":2,"method":"admin_nodeInfo"}`), true))
+	go func() {
+		client.Write(sent.Bytes())
+		io.Copy(ioutil.Discard, client)
+	}()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Upgrade", "websocket")
+	handler.ServeHTTP(&testHijacker{ResponseRecorder: httptest.NewRecorder(), conn: server}, req)
+
+	var records []*RPCAuditRecord
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if records = readAuditRecords(t, audit); len(records) == 4 {
+			break
+		}
+	}
+	want := []struct{ method, err string }{
+		{"admin_removePeer", ""},
+		{"admin_peers", "denied"},
+		{"admin_addPeer", "failed"},
+		{"admin_nodeInfo", "connection closed"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("audit records mismatch: have %d, want %d", len(records), len(want))
+	}
+	for i, want := range want {
+		if records[i].Method != want.method || records[i].Error != want.err || records[i].Transport != "ws" {
+			t.Errorf("record %d mismatch: have %s/%q/%s, want %s/%q/ws", i, records[i].Method, records[i].Error, records[i].Transport, want.method, want.err)
+		}
+	}
+}
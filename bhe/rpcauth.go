@@ -0,0 +1,301 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// errCodeUnauthorized is the JSON-RPC error code of requests rejected for
+	// lacking a valid token.
+	errCodeUnauthorized = -32006
+
+	// rpcAuthMaxDrift is the maximum difference between the issuance time of a
+	// token and the local clock. Tokens are meant to be minted per request, so
+	// this also bounds how long a leaked token stays usable.
+	rpcAuthMaxDrift = 60 * time.Second
+
+	// rpcAuthSecretSize is the size of generated secrets, and the minimum size of
+	// configured ones.
+	rpcAuthSecretSize = 32
+)
+
+// defaultAuthNamespaces are the namespaces protected if none are configured.
+var defaultAuthNamespaces = []string{"admin", "miner", "debug", "personal"}
+
+var (
+	errMissingToken = errors.New("missing bearer token")
+	errInvalidToken = errors.New("invalid token")
+	errStaleToken   = errors.New("token issuance time too far from local clock")
+	errExpiredToken = errors.New("token expired")
+)
+
+// RPCAuthConfig contains the settings of the token authentication required for
+// privileged RPC namespaces.
+type RPCAuthConfig struct {
+	SecretFile string   // File holding the hex encoded HS256 secret, generated if missing; disabled if empty
+	Namespaces []string // Namespaces requiring a token, defaults to admin, miner, debug and personal
+}
+
+// authClaims are the claims of a JWT accepted by the node.
+type authClaims struct {
+	IssuedAt   int64    `json:"iat"`
+	Expiry     int64    `json:"exp,omitempty"`
+	Subject    string   `json:"sub,omitempty"` // Identity of the caller, recorded in the audit log
+	Namespaces []string `json:"ns,omitempty"`  // Protected namespaces granted, all if empty
+}
+
+// allows reports whBHEer the claims grant access to a namespace.
+func (c *authClaims) allows(namespace string) bool {
+	if len(c.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range c.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// rpcAuth verifies the HS256 signed JWTs guarding the protected namespaces.
+type rpcAuth struct {
+	secret    []byte
+	protected map[string]bool
+}
+
+// newRPCAuth loads (or generates) the shared secret and sets up the protected
+// namespaces. It returns nil if authentication is disabled.
+func newRPCAuth(ctx *node.ServiceContext, config RPCAuthConfig) (*rpcAuth, error) {
+	if config.SecretFile == "" {
+		return nil, nil
+	}
+	secret, err := loadAuthSecret(ctx.ResolvePath(config.SecretFile))
+	if err != nil {
+		return nil, err
+	}
+	namespaces := config.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = defaultAuthNamespaces
+	}
+	auth := &rpcAuth{secret: secret, protected: make(map[string]bool)}
+	for _, ns := range namespaces {
+		auth.protected[ns] = true
+	}
+	log.Info("Enabled RPC token authentication", "namespaces", namespaces)
+	return auth, nil
+}
+
+// loadAuthSecret reads the hex encoded secret from a file, generating a random
+// one if the file doesn't exist yet.
+func loadAuthSecret(path string) ([]byte, error) {
+	blob, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		secret := make([]byte, rpcAuthSecretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(secret)), 0600); err != nil {
+			return nil, fmt.Errorf("failed to store RPC auth secret: %v", err)
+		}
+		log.Info("Generated RPC auth secret", "path", path)
+		return secret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(blob)), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RPC auth secret in %s: %v", path, err)
+	}
+	if len(secret) < rpcAuthSecretSize {
+		return nil, fmt.Errorf("RPC auth secret in %s too short: %d bytes, need %d", path, len(secret), rpcAuthSecretSize)
+	}
+	return secret, nil
+}
+
+// verify checks the signature and the timestamps of a compact serialized JWT.
+func (a *rpcAuth) verify(token string, now time.Time) (*authClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if blob, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(blob, &header) != nil || header.Alg != "HS256" {
+		return nil, errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidToken
+	}
+	claims := new(authClaims)
+	if blob, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(blob, claims) != nil {
+		return nil, errInvalidToken
+	}
+	if drift := now.Sub(time.Unix(claims.IssuedAt, 0)); drift > rpcAuthMaxDrift || drift < -rpcAuthMaxDrift {
+		return nil, errStaleToken
+	}
+	if claims.Expiry != 0 && now.Unix() >= claims.Expiry {
+		return nil, errExpiredToken
+	}
+	return claims, nil
+}
+
+// namespace returns the protected namespace of a method, if any.
+func (a *rpcAuth) namespace(method string) (string, bool) {
+	i := strings.IndexByte(method, '_')
+	if i <= 0 {
+		return "", false
+	}
+	return method[:i], a.protected[method[:i]]
+}
+
+// authHandler rejects JSON-RPC requests to protected namespaces which don't
+// carry a valid token.
+type authHandler struct {
+	auth *rpcAuth
+	next http.Handler
+}
+
+// AuthHandler wraps the HTTP handler of an RPC transport with the token checks
+// of the protected namespaces. A batch is rejected as a whole if any message
+// in it lacks authorization. WebSocket connections are authorized by the token
+// of their upgrade request, which has to verify if present, and are closed on
+// a message the token doesn't authorize.
+func (s *BHEereum) AuthHandler(next http.Handler) http.Handler {
+	if s.rpcAuth == nil {
+		return next
+	}
+	return &authHandler{auth: s.rpcAuth, next: next}
+}
+
+// authorize checks that the token of a request grants access to the protected
+// namespaces called by msgs. The token is only verified if needed, unless it
+// is passed in verified already as claims.
+func (h *authHandler) authorize(r *http.Request, claims *authClaims, msgs []*compatMessage) error {
+	for _, msg := range msgs {
+		ns, ok := h.auth.namespace(msg.Method)
+		if !ok {
+			continue
+		}
+		if claims == nil {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Bearer ") {
+				return errMissingToken
+			}
+			var err error
+			if claims, err = h.auth.verify(header[len("Bearer "):], time.Now()); err != nil {
+				return err
+			}
+		}
+		// Connections outlive the verification of their token
+		if claims.Expiry != 0 && time.Now().Unix() >= claims.Expiry {
+			return errExpiredToken
+		}
+		if !claims.allows(ns) {
+			return fmt.Errorf("token not valid for namespace %s", ns)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isWebSocketUpgrade(r) {
+		h.serveWebSocket(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	// Requests which can't be inspected can't be let through either
+	body, err := readRequestBody(r)
+	if err == errRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msgs, batch, err := parseCompatMessages(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.authorize(r, nil, msgs); err != nil {
+		log.Debug("Rejected unauthorized RPC request", "remote", r.RemoteAddr, "err", err)
+		for _, msg := range msgs {
+			msg.Method, msg.Params, msg.Result = "", nil, nil
+			msg.Error = &compatError{Code: errCodeUnauthorized, Message: err.Error()}
+		}
+		blob, _ := encodeCompatMessages(msgs, batch)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(blob)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// serveWebSocket verifies the token of a WebSocket upgrade request, if any, and
+// checks every message of the connection against it. Browsers can't set the
+// header, so their connections are limited to the unprotected namespaces.
+func (h *authHandler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	var claims *authClaims
+	if header := r.Header.Get("Authorization"); header != "" {
+		var err error
+		if !strings.HasPrefix(header, "Bearer ") {
+			err = errInvalidToken
+		} else {
+			claims, err = h.auth.verify(header[len("Bearer "):], time.Now())
+		}
+		if err != nil {
+			log.Debug("Rejected unauthorized WebSocket connection", "remote", r.RemoteAddr, "err", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	h.next.ServeHTTP(filterWebSocket(w, r, &wsFilter{request: func(msg []byte) error {
+		msgs, _, err := parseCompatMessages(msg)
+		if err != nil {
+			return nil // Let the RPC server report the parse error
+		}
+		return h.authorize(r, claims, msgs)
+	}}), r)
+}
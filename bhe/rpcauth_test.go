@@ -0,0 +1,119 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signTestToken creates an HS256 JWT with the given claims.
+func signTestToken(secret []byte, alg string, claims *authClaims) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Tests that only correctly signed, fresh and unexpired tokens are accepted,
+// and that namespace scopes are honoured.
+func TestRPCAuthVerify(t *testing.T) {
+	var (
+		auth = &rpcAuth{secret: make([]byte, rpcAuthSecretSize), protected: map[string]bool{"admin": true}}
+		now  = time.Unix(1600000000, 0)
+	)
+	tests := []struct {
+		token string
+		err   error
+	}{
+		{signTestToken(auth.secret, "HS256", &authClaims{IssuedAt: now.Unix()}), nil},
+		{signTestToken(auth.secret, "HS256", &authClaims{IssuedAt: now.Unix() - 30, Expiry: now.Unix() + 1}), nil},
+		{signTestToken(auth.secret, "HS256", &authClaims{IssuedAt: now.Unix() - 61}), errStaleToken},
+		{signTestToken(auth.secret, "HS256", &authClaims{IssuedAt: now.Unix() + 61}), errStaleToken},
+		{signTestToken(auth.secret, "HS256", &authClaims{IssuedAt: now.Unix(), Expiry: now.Unix()}), errExpiredToken},
+		{signTestToken(auth.secret, "none", &authClaims{IssuedAt: now.Unix()}), errInvalidToken},
+		{signTestToken([]byte("wrong secret"), "HS256", &authClaims{IssuedAt: now.Unix()}), errInvalidToken},
+		{"not.a.token", errInvalidToken},
+		{"", errInvalidToken},
+	}
+	for i, tt := range tests {
+		if _, err := auth.verify(tt.token, now); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	claims, err := auth.verify(signTestToken(auth.secret, "HS256", &authClaims{IssuedAt: now.Unix(), Namespaces: []string{"debug"}}), now)
+	if err != nil {
+		t.Fatalf("scoped token rejected: %v", err)
+	}
+	if !claims.allows("debug") || claims.allows("admin") {
+		t.Errorf("scope not honoured: %v", claims.Namespaces)
+	}
+	if ns, ok := auth.namespace("admin_peers"); !ok || ns != "admin" {
+		t.Errorf("protected namespace not detected: %q %v", ns, ok)
+	}
+	if _, ok := auth.namespace("BHE_blockNumber"); ok {
+		t.Errorf("public namespace reported protected")
+	}
+}
+
+// Tests that WebSocket upgrades carrying an invalid token are refused, and that
+// the messages of a connection are checked against the token of its upgrade.
+func TestAuthHandlerWebSocket(t *testing.T) {
+	var (
+		auth    = &rpcAuth{secret: make([]byte, rpcAuthSecretSize), protected: map[string]bool{"admin": true, "debug": true}}
+		handler = &authHandler{auth: auth, next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Authorization", "Bearer not.a.token")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token upgrade status mismatch: have %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	claims := &authClaims{IssuedAt: time.Now().Unix(), Namespaces: []string{"debug"}}
+	tests := []struct {
+		claims *authClaims
+		msg    string
+		fail   bool
+	}{
+		{nil, `{"id":1,"method":"BHE_chainId"}`, false},
+		{nil, `{"id":1,"method":"admin_peers"}`, true},
+		{claims, `{"id":1,"method":"debug_traceBlock"}`, false},
+		{claims, `[{"id":1,"method":"debug_traceBlock"},{"id":2,"method":"admin_peers"}]`, true},
+		{&authClaims{Expiry: time.Now().Unix() - 1}, `{"id":1,"method":"debug_traceBlock"}`, true},
+	}
+	for i, tt := range tests {
+		msgs, _, err := parseCompatMessages([]byte(tt.msg))
+		if err != nil {
+			t.Fatalf("test %d: invalid message: %v", i, err)
+		}
+		if err := handler.authorize(httptest.NewRequest(http.MethodGet, "/", nil), tt.claims, msgs); (err != nil) != tt.fail {
+			t.Errorf("test %d: authorization mismatch: have %v, want failure %v", i, err, tt.fail)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+)
+
+// errCodeLimitExceeded is the JSON-RPC error code of requests rejected for
+// exceeding a resource limit (EIP-1474).
+const errCodeLimitExceeded = -32005
+
+// RPCMethodLimit caps the EVM resources a single RPC method may consume.
+type RPCMethodLimit struct {
+	GasCap  *big.Int      `toml:",omitempty"` // Maximum gas per execution, RPCGasCap if nil
+	Timeout time.Duration `toml:",omitempty"` // Maximum execution time, RPCEVMTimeout if zero
+}
+
+// RPCLimitError is returned when an RPC request exceeds an execution limit.
+type RPCLimitError struct {
+	Limit string      // Name of the exceeded limit
+	Value interface{} // Configured value of the limit
+}
+
+// Error implements error.
+func (e *RPCLimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded (%v)", e.Limit, e.Value)
+}
+
+// ErrorCode implements rpc.Error, reporting a limit exceeded error.
+func (e *RPCLimitError) ErrorCode() int {
+	return errCodeLimitExceeded
+}
+
+// evmLimiter bounds the number of concurrent EVM executions started through
+// the API backend and the time each of them may run.
+type evmLimiter struct {
+	slots   chan struct{} // Semaphore of execution slots, nil if unlimited
+	timeout time.Duration // Maximum execution time, zero if unlimited
+}
+
+func newEVMLimiter(concurrency int, timeout time.Duration) *evmLimiter {
+	l := &evmLimiter{timeout: timeout}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	return l
+}
+
+// acquire reserves an execution slot, waiting for one to free up until the
+// request context is cancelled.
+func (l *evmLimiter) acquire(ctx context.Context) error {
+	if l.slots == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return &RPCLimitError{Limit: "concurrent EVM", Value: cap(l.slots)}
+	}
+}
+
+// watch aborts the EVM once its execution timeout passes, and releases the
+// execution slot and calls done once the request context is done. The returned
+// function reports whBHEer the execution was aborted by the limiter.
+func (l *evmLimiter) watch(ctx context.Context, evm *vm.EVM, done func()) func() error {
+	var timedOut uint32
+	go func() {
+		var expired <-chan time.Time
+		if l.timeout > 0 {
+			timer := time.NewTimer(l.timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+			atomic.StoreUint32(&timedOut, 1)
+			evm.Cancel()
+			<-ctx.Done()
+		case <-ctx.Done():
+		}
+		if l.slots != nil {
+			<-l.slots
+		}
+		done()
+	}()
+	return func() error {
+		if atomic.LoadUint32(&timedOut) == 1 {
+			return &RPCLimitError{Limit: "execution time", Value: l.timeout}
+		}
+		return nil
+	}
+}
+
+// MethodGasCap returns the gas cap of EVM executions done by the given RPC
+// method (e.g. BHE_call, BHE_estimateGas), or the global RPCGasCap if the method
+// has no dedicated cap.
+func (b *BHEAPIBackend) MethodGasCap(method string) *big.Int {
+	if limit, ok := b.BHE.config.RPCMethodLimits[method]; ok && limit.GasCap != nil {
+		return limit.GasCap
+	}
+	return b.RPCGasCap()
+}
+
+// MethodTimeout returns the maximum execution time of EVM executions done by
+// the given RPC method, or the global RPCEVMTimeout if the method has no
+// dedicated timeout.
+func (b *BHEAPIBackend) MethodTimeout(method string) time.Duration {
+	if limit, ok := b.BHE.config.RPCMethodLimits[method]; ok && limit.Timeout > 0 {
+		return limit.Timeout
+	}
+	return b.BHE.config.RPCEVMTimeout
+}
@@ -0,0 +1,311 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// wsMaxMessageSize is the maximum size of the WebSocket messages inspected,
+// matching the read limit of the WebSocket RPC transport.
+const wsMaxMessageSize = 15 * 1024 * 1024
+
+// WebSocket frame opcodes.
+const (
+	wsContinuationFrame = 0x0
+	wsTextFrame         = 0x1
+	wsBinaryFrame       = 0x2
+)
+
+var (
+	// errWSMessageTooLarge is returned for client messages above wsMaxMessageSize.
+	errWSMessageTooLarge = errors.New("websocket message too large")
+
+	// errWSExtension is returned for frames using a protocol extension, whose
+	// payload can't be inspected.
+	errWSExtension = errors.New("websocket extensions not supported")
+)
+
+// isWebSocketUpgrade reports whBHEer an HTTP request asks to switch the
+// connection to the WebSocket protocol.
+func isWebSocketUpgrade(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	for _, value := range r.Header["Upgrade"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "websocket") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsFilter inspects the JSON-RPC messages exchanged over a WebSocket connection.
+type wsFilter struct {
+	request  func(msg []byte) error // Called with every client message, an error closes the connection
+	response func(msg []byte)       // Called with every server message, nil for oversized ones; optional
+	closed   func()                 // Called once the connection is closed; optional
+}
+
+// filterWebSocket prepares a WebSocket upgrade request for inspection by the
+// filter, returning the response writer to upgrade the connection through.
+// The RPC server reads and writes the connection through the filter, so a
+// client message only reaches it once accepted.
+func filterWebSocket(w http.ResponseWriter, r *http.Request, filter *wsFilter) http.ResponseWriter {
+	// Compressed messages can't be inspected, keep compression from being negotiated
+	r.Header.Del("Sec-WebSocket-Extensions")
+	return &wsResponseWriter{ResponseWriter: w, filter: filter}
+}
+
+// wsResponseWriter hands the filtered connection to the upgrading handler.
+type wsResponseWriter struct {
+	http.ResponseWriter
+	filter *wsFilter
+}
+
+// Hijack implements http.Hijacker.
+func (w *wsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Anything the HTTP server already buffered has to be inspected too
+	var in io.Reader = conn
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		in = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+	filtered := &wsConn{Conn: conn, filter: w.filter, in: bufio.NewReader(in)}
+	if w.filter.response != nil {
+		var out *io.PipeReader
+		out, filtered.out = io.Pipe()
+		go filtered.inspectResponses(out)
+	}
+	return filtered, bufio.NewReadWriter(bufio.NewReader(filtered), bufio.NewWriter(filtered)), nil
+}
+
+// wsFrame is a single frame of a WebSocket connection.
+type wsFrame struct {
+	raw     []byte // Frame as sent, without the payload if skipped
+	opcode  byte
+	fin     bool
+	payload []byte // Unmasked payload
+	skipped bool   // Whether the payload was too large to be read
+}
+
+// readWSFrame reads the next frame of a WebSocket stream. Payloads above the
+// size limit fail with errWSMessageTooLarge, or are skipped if requested.
+func readWSFrame(r io.Reader, limit uint64, skip bool) (*wsFrame, error) {
+	head := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if head[0]&0x70 != 0 {
+		return nil, errWSExtension
+	}
+	frame := &wsFrame{opcode: head[0] & 0x0f, fin: head[0]&0x80 != 0}
+
+	var (
+		masked = head[1]&0x80 != 0
+		size   = uint64(head[1] & 0x7f)
+		extra  int
+	)
+	switch size {
+	case 126:
+		extra = 2
+	case 127:
+		extra = 8
+	}
+	if masked {
+		extra += 4
+	}
+	head = head[:2+extra]
+	if _, err := io.ReadFull(r, head[2:]); err != nil {
+		return nil, err
+	}
+	switch size {
+	case 126:
+		size = uint64(binary.BigEndian.Uint16(head[2:]))
+	case 127:
+		size = binary.BigEndian.Uint64(head[2:])
+	}
+	if size > limit {
+		if !skip {
+			return nil, errWSMessageTooLarge
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(size)); err != nil {
+			return nil, err
+		}
+		frame.raw, frame.skipped = head, true
+		return frame, nil
+	}
+	frame.raw = make([]byte, len(head)+int(size))
+	copy(frame.raw, head)
+	if _, err := io.ReadFull(r, frame.raw[len(head):]); err != nil {
+		return nil, err
+	}
+	frame.payload = append([]byte{}, frame.raw[len(head):]...)
+	if masked {
+		key := head[len(head)-4:]
+		for i := range frame.payload {
+			frame.payload[i] ^= key[i%4]
+		}
+	}
+	return frame, nil
+}
+
+// wsAssembler reassembles the data messages of a WebSocket stream from their
+// frames.
+type wsAssembler struct {
+	message  []byte
+	oversize bool
+}
+
+// add feeds the next frame of the stream, returning the message it completes,
+// if any. Oversized messages complete as nil.
+func (a *wsAssembler) add(frame *wsFrame) ([]byte, bool) {
+	switch frame.opcode {
+	case wsTextFrame, wsBinaryFrame:
+		a.message, a.oversize = nil, false
+	case wsContinuationFrame:
+	default:
+		return nil, false // Control frames interleave with the fragments
+	}
+	if frame.skipped {
+		a.message, a.oversize = nil, true
+	}
+	if !a.oversize {
+		if uint64(len(a.message)+len(frame.payload)) > wsMaxMessageSize {
+			a.message, a.oversize = nil, true
+		} else {
+			a.message = append(a.message, frame.payload...)
+		}
+	}
+	if !frame.fin {
+		return nil, false
+	}
+	message := a.message
+	switch {
+	case a.oversize:
+		message = nil
+	case message == nil:
+		message = []byte{}
+	}
+	a.message, a.oversize = nil, false
+	return message, true
+}
+
+// wsConn is a hijacked WebSocket connection whose messages are inspected by a
+// filter.
+type wsConn struct {
+	net.Conn
+	filter *wsFilter
+
+	in        *bufio.Reader // Client frames not inspected yet
+	pending   []byte        // Inspected client frames not read by the server yet
+	assembler wsAssembler   // Client message being reassembled
+
+	out  *io.PipeWriter // Server frames to inspect, nil if not inspected
+	once sync.Once
+}
+
+// Read implements net.Conn, only releasing the frames of a client message to
+// the server once the whole message has been accepted by the filter.
+func (c *wsConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		frame, err := readWSFrame(c.in, wsMaxMessageSize, false)
+		if err != nil {
+			return 0, err
+		}
+		if msg, done := c.assembler.add(frame); done {
+			if msg == nil {
+				return 0, errWSMessageTooLarge
+			}
+			if err := c.filter.request(msg); err != nil {
+				log.Debug("Closing rejected WebSocket connection", "remote", c.RemoteAddr(), "err", err)
+				c.Close()
+				return 0, err
+			}
+		}
+		c.pending = frame.raw
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write implements net.Conn, passing a copy of the server frames on to the
+// response inspection.
+func (c *wsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.out != nil && n > 0 {
+		c.out.Write(b[:n])
+	}
+	return n, err
+}
+
+// Close implements net.Conn.
+func (c *wsConn) Close() error {
+	c.once.Do(func() {
+		if c.out != nil {
+			c.out.Close() // The response inspection reports the closure when done
+		} else if c.filter.closed != nil {
+			c.filter.closed()
+		}
+	})
+	return c.Conn.Close()
+}
+
+// inspectResponses decodes the server frames written to the connection and
+// hands the messages to the filter.
+func (c *wsConn) inspectResponses(out *io.PipeReader) {
+	defer func() {
+		if c.filter.closed != nil {
+			c.filter.closed()
+		}
+	}()
+	var (
+		r         = bufio.NewReader(out)
+		assembler wsAssembler
+	)
+	for {
+		frame, err := readWSFrame(r, wsMaxMessageSize, true)
+		if err != nil {
+			// Keep the writes of the server flowing whatever was sent
+			io.Copy(ioutil.Discard, r)
+			return
+		}
+		if msg, done := assembler.add(frame); done {
+			c.filter.response(msg)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testHijacker is a response writer whose connection can be hijacked.
+type testHijacker struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h *testHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+// encodeWSFrame encodes a single WebSocket frame, masked as sent by clients if
+// requested.
+func encodeWSFrame(opcode byte, fin bool, payload []byte, masked bool) []byte {
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	var mask byte
+	if masked {
+		mask = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, mask|byte(len(payload)))
+	default:
+		frame = append(frame, mask|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	key := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
+
+// Tests that client messages only reach the server once accepted by the filter,
+// fragmented ones included, that a rejected message closes the connection, and
+// that server messages are handed to the filter.
+func TestWebSocketFilter(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var (
+		requests  []string
+		responses = make(chan string, 1)
+		closed    = make(chan struct{})
+	)
+	filter := &wsFilter{
+		request: func(msg []byte) error {
+			requests = append(requests, string(msg))
+			if strings.Contains(string(msg), "admin_") {
+				return errors.New("denied")
+			}
+			return nil
+		},
+		response: func(msg []byte) { responses <- string(msg) },
+		closed:   func() { close(closed) },
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	if !isWebSocketUpgrade(req) {
+		t.Fatalf("upgrade request not detected")
+	}
+	w := filterWebSocket(&testHijacker{ResponseRecorder: httptest.NewRecorder(), conn: server}, req, filter)
+	if req.Header.Get("Sec-WebSocket-Extensions") != "" {
+		t.Errorf("extensions left to negotiate")
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Fatalf("failed to hijack connection: %v", err)
+	}
+	// Send a fragmented message with a ping in between, then a denied one
+	var sent bytes.Buffer
+	sent.Write(encodeWSFrame(wsTextFrame, false, []byte(`{"method":`), true))
+	sent.Write(encodeWSFrame(0x9, true, nil, true))
+	sent.Write(encodeWSFrame(wsContinuationFrame, true, []byte(`"BHE_chainId"}`), true))
+	accepted := sent.Len()
+	sent.Write(encodeWSFrame(wsTextFrame, true, []byte(`{"method":"admin_peers"}`), true))
+
+	go client.Write(sent.Bytes())
+
+	read := make([]byte, accepted)
+	if _, err := io.ReadFull(conn, read); err != nil {
+		t.Fatalf("failed to read accepted message: %v", err)
+	}
+	if !bytes.Equal(read, sent.Bytes()[:accepted]) {
+		t.Errorf("accepted frames altered: have %x, want %x", read, sent.Bytes()[:accepted])
+	}
+	if len(requests) != 1 || requests[0] != `{"method":"BHE_chainId"}` {
+		t.Errorf("reassembled messages mismatch: %q", requests)
+	}
+	// Answer the accepted message, the response has to be inspected
+	go io.Copy(ioutil.Discard, client)
+	if _, err := conn.Write(encodeWSFrame(wsTextFrame, true, []byte(`{"id":1,"result":"0x1"}`), false)); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+	select {
+	case msg := <-responses:
+		if msg != `{"id":1,"result":"0x1"}` {
+			t.Errorf("response mismatch: have %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("response not inspected")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("denied message passed")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("closure not reported")
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultScreeningTimeout is the time allowance for the policy service to
+	// reach a verdict on a single transaction.
+	defaultScreeningTimeout = 500 * time.Millisecond
+
+	// screeningCacheSize is the number of recent verdicts kept, so that the
+	// same transaction arriving from several peers is screened once.
+	screeningCacheSize = 4096
+
+	// screeningCacheTTL is the time a verdict is reused for. It is kept short,
+	// as the policy deciding it may change.
+	screeningCacheTTL = time.Minute
+
+	// screeningWorkers is the number of transactions of a network batch
+	// screened concurrently.
+	screeningWorkers = 16
+)
+
+// ScreeningConfig contains the settings of the external transaction policy
+// service consulted before transactions are admitted into the pool.
+type ScreeningConfig struct {
+	Endpoint string        // gRPC endpoint (host:port) of the policy service (empty = disabled)
+	CAFile   string        // PEM CA certificates authenticating the policy service (empty = plaintext)
+	Timeout  time.Duration // Time allowance for a single verdict
+	FailOpen bool          // Admit transactions if the policy service cannot be reached
+	Journal  string        // Append-only log of screening decisions, relative to the datadir
+}
+
+var (
+	// errScreeningRejected is returned if the policy service refused a transaction.
+	errScreeningRejected = errors.New("transaction rejected by policy")
+
+	// errScreeningUnavailable is returned if the policy service could not be
+	// consulted and the node is configured to fail closed.
+	errScreeningUnavailable = errors.New("transaction policy service unavailable")
+)
+
+// screeningRequest is the payload sent to the policy service for every
+// transaction entering the pool.
+type screeningRequest struct {
+	Hash     common.Hash
+	From     common.Address
+	To       *common.Address
+	Value    *big.Int
+	Nonce    uint64
+	Gas      uint64
+	GasPrice *big.Int
+	Input    []byte
+	Local    bool
+}
+
+// screeningVerdict is the reply of the policy service.
+type screeningVerdict struct {
+	Allow  bool
+	Reason string
+}
+
+// screeningRecord is a single entry in the screening audit journal.
+type screeningRecord struct {
+	Time    time.Time       `json:"time"`
+	Hash    common.Hash     `json:"hash"`
+	From    common.Address  `json:"from"`
+	To      *common.Address `json:"to"`
+	Local   bool            `json:"local"`
+	Allowed bool            `json:"allowed"`
+	Reason  string          `json:"reason,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// policyClient is the transport used to reach the external policy service.
+type policyClient interface {
+	screen(ctx context.Context, req *screeningRequest) (*screeningVerdict, error)
+	close()
+}
+
+// screeningMethod is the gRPC method consulted on every transaction, part of
+// the following service:
+//
+//	service TransactionPolicy {
+//	  rpc ScreenTransaction(ScreeningRequest) returns (ScreeningVerdict);
+//	}
+//	message ScreeningRequest {
+//	  bytes  hash      = 1;
+//	  bytes  from      = 2;
+//	  bytes  to        = 3; // Empty for contract creations
+//	  bytes  value     = 4; // Big endian
+//	  uint64 nonce     = 5;
+//	  uint64 gas       = 6;
+//	  bytes  gas_price = 7; // Big endian
+//	  bytes  input     = 8;
+//	  bool   local     = 9;
+//	}
+//	message ScreeningVerdict {
+//	  bool   allow  = 1;
+//	  string reason = 2;
+//	}
+const screeningMethod = "/policy.TransactionPolicy/ScreenTransaction"
+
+// grpcPolicyClient reaches the policy service over gRPC. The messages are few
+// and small, so they are encoded by hand instead of through generated stubs.
+type grpcPolicyClient struct {
+	conn *grpc.ClientConn
+}
+
+// dialPolicyService connects to the policy service, authenticating it against
+// the CA certificates in caFile if set, in plaintext otherwise.
+func dialPolicyService(endpoint string, caFile string) (*grpcPolicyClient, error) {
+	creds := grpc.WithInsecure()
+	if caFile != "" {
+		tls, err := credentials.NewClientTLSFromFile(caFile, "")
+		if err != nil {
+			return nil, err
+		}
+		creds = grpc.WithTransportCredentials(tls)
+	}
+	conn, err := grpc.Dial(endpoint, creds)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcPolicyClient{conn: conn}, nil
+}
+
+func (c *grpcPolicyClient) screen(ctx context.Context, req *screeningRequest) (*screeningVerdict, error) {
+	verdict := new(screeningVerdict)
+	if err := c.conn.Invoke(ctx, screeningMethod, req, verdict, grpc.ForceCodec(screeningCodec{})); err != nil {
+		return nil, err
+	}
+	return verdict, nil
+}
+
+func (c *grpcPolicyClient) close() {
+	c.conn.Close()
+}
+
+// screeningCodec is the gRPC codec encoding the screening messages in the
+// protobuf wire format.
+type screeningCodec struct{}
+
+func (screeningCodec) Name() string { return "proto" }
+
+func (screeningCodec) Marshal(v interface{}) ([]byte, error) {
+	req, ok := v.(*screeningRequest)
+	if !ok {
+		return nil, fmt.Errorf("unexpected screening message %T", v)
+	}
+	var blob []byte
+	blob = protowire.AppendTag(blob, 1, protowire.BytesType)
+	blob = protowire.AppendBytes(blob, req.Hash.Bytes())
+	blob = protowire.AppendTag(blob, 2, protowire.BytesType)
+	blob = protowire.AppendBytes(blob, req.From.Bytes())
+	if req.To != nil {
+		blob = protowire.AppendTag(blob, 3, protowire.BytesType)
+		blob = protowire.AppendBytes(blob, req.To.Bytes())
+	}
+	blob = protowire.AppendTag(blob, 4, protowire.BytesType)
+	blob = protowire.AppendBytes(blob, req.Value.Bytes())
+	blob = protowire.AppendTag(blob, 5, protowire.VarintType)
+	blob = protowire.AppendVarint(blob, req.Nonce)
+	blob = protowire.AppendTag(blob, 6, protowire.VarintType)
+	blob = protowire.AppendVarint(blob, req.Gas)
+	blob = protowire.AppendTag(blob, 7, protowire.BytesType)
+	blob = protowire.AppendBytes(blob, req.GasPrice.Bytes())
+	blob = protowire.AppendTag(blob, 8, protowire.BytesType)
+	blob = protowire.AppendBytes(blob, req.Input)
+	blob = protowire.AppendTag(blob, 9, protowire.VarintType)
+	blob = protowire.AppendVarint(blob, protowire.EncodeBool(req.Local))
+	return blob, nil
+}
+
+func (screeningCodec) Unmarshal(blob []byte, v interface{}) error {
+	verdict, ok := v.(*screeningVerdict)
+	if !ok {
+		return fmt.Errorf("unexpected screening message %T", v)
+	}
+	for len(blob) > 0 {
+		num, typ, n := protowire.ConsumeTag(blob)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		blob = blob[n:]
+
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			allow, n := protowire.ConsumeVarint(blob)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			verdict.Allow, blob = protowire.DecodeBool(allow), blob[n:]
+		case num == 2 && typ == protowire.BytesType:
+			reason, n := protowire.ConsumeString(blob)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			verdict.Reason, blob = reason, blob[n:]
+		default:
+			// Skip fields added to the service later
+			n := protowire.ConsumeFieldValue(num, typ, blob)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			blob = blob[n:]
+		}
+	}
+	return nil
+}
+
+// screeningCall is a verdict being requested from the policy service, shared
+// by everyone screening the same transaction meanwhile.
+type screeningCall struct {
+	done chan struct{}
+	err  error
+}
+
+// cachedVerdict is a decision of the policy service on a transaction.
+type cachedVerdict struct {
+	err  error
+	time time.Time
+}
+
+// txScreener consults the external policy service on transactions entering
+// the pool and records every decision in an append-only journal. Decisions are
+// cached by transaction hash for a while, so that the same transaction relayed
+// by many peers is only screened once. A nil screener admits everything.
+type txScreener struct {
+	config   ScreeningConfig
+	client   policyClient
+	journal  *os.File
+	verdicts *lru.Cache                     // Transaction hash -> *cachedVerdict
+	inflight map[common.Hash]*screeningCall // Verdicts being requested
+
+	lock     sync.Mutex // Serialises journal writes
+	callLock sync.Mutex // Protects inflight
+}
+
+// newTxScreener dials the policy service and opens the decision journal. If
+// no endpoint is configured, a nil screener is returned.
+func newTxScreener(config ScreeningConfig, journal string) (*txScreener, error) {
+	if config.Endpoint == "" {
+		return nil, nil
+	}
+	if config.Timeout <= 0 {
+		log.Warn("Sanitizing invalid screening timeout", "provided", config.Timeout, "updated", defaultScreeningTimeout)
+		config.Timeout = defaultScreeningTimeout
+	}
+	client, err := dialPolicyService(config.Endpoint, config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial policy service: %v", err)
+	}
+	s := newScreener(config, client)
+	if journal != "" {
+		if s.journal, err = os.OpenFile(journal, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			client.close()
+			return nil, fmt.Errorf("failed to open screening journal: %v", err)
+		}
+	}
+	log.Info("Transaction screening enabled", "endpoint", config.Endpoint, "timeout", config.Timeout, "failopen", config.FailOpen, "journal", journal)
+	return s, nil
+}
+
+// newScreener creates a screener consulting the policy service through client.
+func newScreener(config ScreeningConfig, client policyClient) *txScreener {
+	verdicts, _ := lru.New(screeningCacheSize)
+	return &txScreener{
+		config:   config,
+		client:   client,
+		verdicts: verdicts,
+		inflight: make(map[common.Hash]*screeningCall),
+	}
+}
+
+// screen asks the policy service whBHEer tx may enter the pool, returning a
+// non-nil error if it may not. Recent decisions are reused, and concurrent
+// requests for the same transaction wait for a single verdict.
+func (s *txScreener) screen(ctx context.Context, tx *types.Transaction, from common.Address, local bool) error {
+	if s == nil {
+		return nil
+	}
+	hash := tx.Hash()
+	if cached, ok := s.verdicts.Get(hash); ok {
+		if verdict := cached.(*cachedVerdict); time.Since(verdict.time) < screeningCacheTTL {
+			return verdict.err
+		}
+		s.verdicts.Remove(hash)
+	}
+	s.callLock.Lock()
+	if call, ok := s.inflight[hash]; ok {
+		s.callLock.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &screeningCall{done: make(chan struct{})}
+	s.inflight[hash] = call
+	s.callLock.Unlock()
+
+	decided, err := s.consult(ctx, tx, from, local)
+	if decided {
+		s.verdicts.Add(hash, &cachedVerdict{err: err, time: time.Now()})
+	}
+	s.callLock.Lock()
+	delete(s.inflight, hash)
+	s.callLock.Unlock()
+
+	call.err = err
+	close(call.done)
+	return err
+}
+
+// consult requests and journals a verdict from the policy service, reporting
+// whBHEer the outcome is a decision of the service which may be cached, rather
+// than a failure to reach it.
+func (s *txScreener) consult(ctx context.Context, tx *types.Transaction, from common.Address, local bool) (bool, error) {
+	req := &screeningRequest{
+		Hash:     tx.Hash(),
+		From:     from,
+		To:       tx.To(),
+		Value:    tx.Value(),
+		Nonce:    tx.Nonce(),
+		Gas:      tx.Gas(),
+		GasPrice: tx.GasPrice(),
+		Input:    tx.Data(),
+		Local:    local,
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	verdict, err := s.client.screen(ctx, req)
+
+	record := &screeningRecord{
+		Time:  time.Now(),
+		Hash:  req.Hash,
+		From:  from,
+		To:    req.To,
+		Local: local,
+	}
+	switch {
+	case err != nil:
+		record.Allowed = s.config.FailOpen
+		record.Error = err.Error()
+	default:
+		record.Allowed = verdict.Allow
+		record.Reason = verdict.Reason
+	}
+	s.record(record)
+
+	switch {
+	case err != nil && !s.config.FailOpen:
+		log.Warn("Transaction policy service unavailable, rejecting", "hash", req.Hash, "err", err)
+		return false, errScreeningUnavailable
+	case err != nil:
+		log.Warn("Transaction policy service unavailable, admitting", "hash", req.Hash, "err", err)
+		return false, nil
+	case !verdict.Allow:
+		if verdict.Reason != "" {
+			return true, fmt.Errorf("%v: %s", errScreeningRejected, verdict.Reason)
+		}
+		return true, errScreeningRejected
+	}
+	return true, nil
+}
+
+// record appends a screening decision to the audit journal.
+func (s *txScreener) record(record *screeningRecord) {
+	if s.journal == nil {
+		return
+	}
+	blob, err := json.Marshal(record)
+	if err != nil {
+		log.Error("Failed to encode screening record", "hash", record.Hash, "err", err)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.journal.Write(append(blob, '\n')); err != nil {
+		log.Error("Failed to write screening record", "hash", record.Hash, "err", err)
+	}
+}
+
+// close terminates the connection to the policy service and flushes the journal.
+func (s *txScreener) close() {
+	if s == nil {
+		return
+	}
+	s.client.close()
+	if s.journal != nil {
+		s.journal.Close()
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testPolicyClient is a mock policy service returning a canned verdict.
+type testPolicyClient struct {
+	verdict *screeningVerdict
+	err     error
+	calls   int
+}
+
+func (c *testPolicyClient) screen(ctx context.Context, req *screeningRequest) (*screeningVerdict, error) {
+	c.calls++
+	return c.verdict, c.err
+}
+
+func (c *testPolicyClient) close() {}
+
+func TestTxScreening(t *testing.T) {
+	tx := types.NewTransaction(0, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+	tests := []struct {
+		verdict  *screeningVerdict
+		err      error
+		failOpen bool
+		want     error
+	}{
+		{verdict: &screeningVerdict{Allow: true}},
+		{verdict: &screeningVerdict{Allow: false}, want: errScreeningRejected},
+		{err: errors.New("unreachable"), failOpen: true},
+		{err: errors.New("unreachable"), failOpen: false, want: errScreeningUnavailable},
+	}
+	for i, test := range tests {
+		screener := newScreener(ScreeningConfig{Timeout: time.Second, FailOpen: test.failOpen}, &testPolicyClient{verdict: test.verdict, err: test.err})
+		if err := screener.screen(context.Background(), tx, common.Address{0x02}, true); err != test.want {
+			t.Errorf("test %d: screening error mismatch: have %v, want %v", i, err, test.want)
+		}
+	}
+	// A nil screener must admit everything
+	var screener *txScreener
+	if err := screener.screen(context.Background(), tx, common.Address{0x02}, true); err != nil {
+		t.Errorf("nil screener rejected transaction: %v", err)
+	}
+}
+
+// Tests that verdicts of the policy service are reused for the same transaction,
+// while failures to reach it are not.
+func TestTxScreeningCache(t *testing.T) {
+	tx := types.NewTransaction(0, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+	client := &testPolicyClient{verdict: &screeningVerdict{Allow: false}}
+	screener := newScreener(ScreeningConfig{Timeout: time.Second}, client)
+	for i := 0; i < 3; i++ {
+		if err := screener.screen(context.Background(), tx, common.Address{0x02}, false); err != errScreeningRejected {
+			t.Fatalf("attempt %d: screening error mismatch: have %v, want %v", i, err, errScreeningRejected)
+		}
+	}
+	if client.calls != 1 {
+		t.Errorf("policy service consulted %d times, want 1", client.calls)
+	}
+	client = &testPolicyClient{err: errors.New("unreachable")}
+	screener = newScreener(ScreeningConfig{Timeout: time.Second}, client)
+	for i := 0; i < 3; i++ {
+		screener.screen(context.Background(), tx, common.Address{0x02}, false)
+	}
+	if client.calls != 3 {
+		t.Errorf("policy service consulted %d times, want 3", client.calls)
+	}
+}
+
+// Tests that the screening messages survive the protobuf encoding.
+func TestScreeningCodec(t *testing.T) {
+	to := common.Address{0x01}
+	req := &screeningRequest{
+		Hash:     common.Hash{0xff},
+		From:     common.Address{0x02},
+		To:       &to,
+		Value:    big.NewInt(1000),
+		Nonce:    3,
+		Gas:      21000,
+		GasPrice: big.NewInt(1),
+		Local:    true,
+	}
+	blob, err := screeningCodec{}.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	num, typ, n := protowire.ConsumeTag(blob)
+	if num != 1 || typ != protowire.BytesType {
+		t.Fatalf("first field mismatch: have %d/%d, want 1/%d", num, typ, protowire.BytesType)
+	}
+	if hash, _ := protowire.ConsumeBytes(blob[n:]); common.BytesToHash(hash) != req.Hash {
+		t.Errorf("hash mismatch: have %x, want %x", hash, req.Hash)
+	}
+	// Encode a verdict with an unknown field the codec has to skip
+	var reply []byte
+	reply = protowire.AppendTag(reply, 1, protowire.VarintType)
+	reply = protowire.AppendVarint(reply, 1)
+	reply = protowire.AppendTag(reply, 7, protowire.VarintType)
+	reply = protowire.AppendVarint(reply, 42)
+	reply = protowire.AppendTag(reply, 2, protowire.BytesType)
+	reply = protowire.AppendString(reply, "ok")
+
+	verdict := new(screeningVerdict)
+	if err := (screeningCodec{}).Unmarshal(reply, verdict); err != nil {
+		t.Fatalf("failed to decode verdict: %v", err)
+	}
+	if !verdict.Allow || verdict.Reason != "ok" {
+		t.Errorf("verdict mismatch: have %+v, want allow with reason ok", verdict)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	defaultSealerWindow = 1024 // Blocks the sealer statistics cover by default
+	maxSealerWindow     = 8192 // Blocks the sealer statistics cover at most
+)
+
+// SealerStats is the sealing performance of a single signer or miner.
+type SealerStats struct {
+	Sealed       int            `json:"sealed"`       // Blocks sealed in the window
+	InTurn       int            `json:"inTurn"`       // Blocks sealed in turn (clique only)
+	MissedInTurn int            `json:"missedInTurn"` // In-turn slots sealed by someone else (clique only)
+	AvgLatency   float64        `json:"avgLatency"`   // Mean seconds between the parent and the sealed block
+	LastSealed   hexutil.Uint64 `json:"lastSealed"`   // Number of the latest block sealed
+
+	latency uint64
+}
+
+// SealerReport is the sealing performance of all signers or miners over a range
+// of blocks.
+type SealerReport struct {
+	From    hexutil.Uint64                  `json:"from"`
+	To      hexutil.Uint64                  `json:"to"`
+	Sealers map[common.Address]*SealerStats `json:"sealers"`
+}
+
+// sealedBlock is a block with the information the sealer statistics need.
+type sealedBlock struct {
+	number  uint64
+	sealer  common.Address
+	inTurn  common.Address // Signer whose turn it was, zero if unknown
+	latency uint64         // Seconds since the parent
+}
+
+// collectSealerStats aggregates the sealing performance from a range of blocks.
+func collectSealerStats(blocks []sealedBlock) map[common.Address]*SealerStats {
+	sealers := make(map[common.Address]*SealerStats)
+	stats := func(addr common.Address) *SealerStats {
+		if sealers[addr] == nil {
+			sealers[addr] = new(SealerStats)
+		}
+		return sealers[addr]
+	}
+	for _, block := range blocks {
+		sealer := stats(block.sealer)
+		sealer.Sealed++
+		sealer.latency += block.latency
+		if block.number > uint64(sealer.LastSealed) {
+			sealer.LastSealed = hexutil.Uint64(block.number)
+		}
+		if block.inTurn != (common.Address{}) {
+			if block.inTurn == block.sealer {
+				sealer.InTurn++
+			} else {
+				stats(block.inTurn).MissedInTurn++
+			}
+		}
+	}
+	for _, sealer := range sealers {
+		if sealer.Sealed > 0 {
+			sealer.AvgLatency = float64(sealer.latency) / float64(sealer.Sealed)
+		}
+	}
+	return sealers
+}
+
+// SealerStats reports the blocks sealed by each signer or miner over the last
+// blocks of the chain (1024 by default), their average seal latency and, on
+// clique networks, the in-turn slots they sealed and missed.
+func (api *PrivateMinerAPI) SealerStats(blocks *hexutil.Uint64) (*SealerReport, error) {
+	window := uint64(defaultSealerWindow)
+	if blocks != nil {
+		window = uint64(*blocks)
+	}
+	if window == 0 || window > maxSealerWindow {
+		return nil, fmt.Errorf("window must be between 1 and %d blocks", maxSealerWindow)
+	}
+	var (
+		chain   = api.e.blockchain
+		head    = chain.CurrentHeader()
+		signers func(common.Hash) ([]common.Address, error)
+	)
+	if engine, ok := api.e.engine.(*clique.Clique); ok {
+		signers = engine.APIs(chain)[0].Service.(*clique.API).GetSignersAtHash
+	}
+	if head.Number.Uint64() == 0 {
+		return nil, errors.New("no blocks sealed yet")
+	}
+	var (
+		to     = head.Number.Uint64()
+		from   = uint64(1)
+		sealed []sealedBlock
+		block  = head
+	)
+	if to > window {
+		from = to - window + 1
+	}
+	for block.Number.Uint64() >= from {
+		parent := chain.GetHeader(block.ParentHash, block.Number.Uint64()-1)
+		if parent == nil {
+			return nil, fmt.Errorf("missing header %d", block.Number.Uint64()-1)
+		}
+		sealer, err := api.e.engine.Author(block)
+		if err != nil {
+			return nil, err
+		}
+		entry := sealedBlock{number: block.Number.Uint64(), sealer: sealer, latency: block.Time - parent.Time}
+		if signers != nil {
+			// Clique signers take their turns in address order, at the parent's signer set
+			if set, err := signers(parent.Hash()); err == nil && len(set) > 0 {
+				entry.inTurn = set[block.Number.Uint64()%uint64(len(set))]
+			}
+		}
+		sealed = append(sealed, entry)
+		block = parent
+	}
+	return &SealerReport{
+		From:    hexutil.Uint64(from),
+		To:      hexutil.Uint64(to),
+		Sealers: collectSealerStats(sealed),
+	}, nil
+}
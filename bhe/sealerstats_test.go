@@ -0,0 +1,46 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+)
+
+// Tests that sealed blocks, in-turn slots and latencies are attributed to the
+// right sealers.
+func TestCollectSealerStats(t *testing.T) {
+	var (
+		a = common.HexToAddress("0xa")
+		b = common.HexToAddress("0xb")
+		c = common.HexToAddress("0xc")
+	)
+	sealers := collectSealerStats([]sealedBlock{
+		{number: 3, sealer: a, inTurn: a, latency: 5},
+		{number: 4, sealer: b, inTurn: b, latency: 5},
+		{number: 5, sealer: a, inTurn: c, latency: 8}, // c missed its turn
+		{number: 6, sealer: a, inTurn: a, latency: 5},
+	})
+	if have := sealers[a]; have.Sealed != 3 || have.InTurn != 2 || have.MissedInTurn != 0 || have.AvgLatency != 6 || have.LastSealed != 6 {
+		t.Errorf("sealer a stats mismatch: %+v", have)
+	}
+	if have := sealers[b]; have.Sealed != 1 || have.InTurn != 1 || have.LastSealed != 4 {
+		t.Errorf("sealer b stats mismatch: %+v", have)
+	}
+	if have := sealers[c]; have.Sealed != 0 || have.MissedInTurn != 1 || have.AvgLatency != 0 {
+		t.Errorf("sealer c stats mismatch: %+v", have)
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSessions is the maximum number of speculative sessions open at once.
+	maxSessions = 64
+
+	// maxSessionLayers is the maximum depth of overlays stacked in a session.
+	maxSessionLayers = 32
+
+	// sessionIdleTimeout is the time after which an unused session is discarded.
+	sessionIdleTimeout = 10 * time.Minute
+)
+
+var (
+	errUnknownSession   = errors.New("unknown or expired session")
+	errTooManySessions  = errors.New("too many open sessions")
+	errTooManyLayers    = errors.New("too many session layers")
+	errNoSessionLayer   = errors.New("no session layer to discard")
+	errSessionGasLimit  = errors.New("session block gas limit reached")
+	errSessionNotLatest = errors.New("sessions cannot be opened on the pending block")
+)
+
+// sessionLayer is the state of a speculative session at some point of its
+// execution, restorable by discarding all overlays stacked on top of it.
+type sessionLayer struct {
+	state    *state.StateDB
+	gasPool  core.GasPool
+	usedGas  uint64
+	txs      types.Transactions
+	receipts types.Receipts
+}
+
+// copy returns a deep copy of the layer, sharing only immutable data.
+func (l *sessionLayer) copy() *sessionLayer {
+	return &sessionLayer{
+		state:    l.state.Copy(),
+		gasPool:  l.gasPool,
+		usedGas:  l.usedGas,
+		txs:      append(types.Transactions{}, l.txs...),
+		receipts: append(types.Receipts{}, l.receipts...),
+	}
+}
+
+// specSession is a speculative execution session: a stack of in-memory state
+// overlays on top of a block, into which transactions are applied one by one
+// as if they were included in the next block.
+type specSession struct {
+	header *types.Header   // Header of the speculative block being built
+	parent common.Hash     // Hash of the block the session was opened on
+	layers []*sessionLayer // Stacked overlays, the last one is the live one
+	used   time.Time       // Time of last access, for expiry
+	lock   sync.Mutex
+}
+
+func (s *specSession) top() *sessionLayer {
+	return s.layers[len(s.layers)-1]
+}
+
+// sessionManager tracks the open speculative sessions.
+type sessionManager struct {
+	sessions map[string]*specSession
+	lock     sync.Mutex
+}
+
+func newSessionManager() *sessionManager {
+	return &sessionManager{sessions: make(map[string]*specSession)}
+}
+
+// add registers a session, expiring idle ones first, and returns its id.
+func (m *sessionManager) add(session *specSession) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, s := range m.sessions {
+		s.lock.Lock()
+		if time.Since(s.used) > sessionIdleTimeout {
+			delete(m.sessions, id)
+		}
+		s.lock.Unlock()
+	}
+	if len(m.sessions) >= maxSessions {
+		return "", errTooManySessions
+	}
+	var blob [16]byte
+	if _, err := rand.Read(blob[:]); err != nil {
+		return "", err
+	}
+	id := "0x" + hex.EncodeToString(blob[:])
+	session.used = time.Now()
+	m.sessions[id] = session
+	return id, nil
+}
+
+// get retrieves a live session, locking it. The caller must unlock it.
+func (m *sessionManager) get(id string) (*specSession, error) {
+	m.lock.Lock()
+	session := m.sessions[id]
+	m.lock.Unlock()
+
+	if session == nil {
+		return nil, errUnknownSession
+	}
+	session.lock.Lock()
+	if time.Since(session.used) > sessionIdleTimeout {
+		session.lock.Unlock()
+		m.remove(id)
+		return nil, errUnknownSession
+	}
+	session.used = time.Now()
+	return session, nil
+}
+
+// remove discards a session, reporting whBHEer it existed.
+func (m *sessionManager) remove(id string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, ok := m.sessions[id]
+	delete(m.sessions, id)
+	return ok
+}
+
+// SessionReceipt is the outcome of a transaction applied in a session.
+type SessionReceipt struct {
+	TxHash            common.Hash     `json:"transactionHash"`
+	Status            hexutil.Uint64  `json:"status"`
+	GasUsed           hexutil.Uint64  `json:"gasUsed"`
+	CumulativeGasUsed hexutil.Uint64  `json:"cumulativeGasUsed"`
+	ContractAddress   *common.Address `json:"contractAddress,omitempty"`
+	Logs              []*types.Log    `json:"logs"`
+}
+
+func newSessionReceipt(receipt *types.Receipt) *SessionReceipt {
+	res := &SessionReceipt{
+		TxHash:            receipt.TxHash,
+		Status:            hexutil.Uint64(receipt.Status),
+		GasUsed:           hexutil.Uint64(receipt.GasUsed),
+		CumulativeGasUsed: hexutil.Uint64(receipt.CumulativeGasUsed),
+		Logs:              receipt.Logs,
+	}
+	if receipt.ContractAddress != (common.Address{}) {
+		res.ContractAddress = &receipt.ContractAddress
+	}
+	if res.Logs == nil {
+		res.Logs = []*types.Log{}
+	}
+	return res
+}
+
+// SessionAccount is the state of an account inside a session.
+type SessionAccount struct {
+	Balance  *hexutil.Big   `json:"balance"`
+	Nonce    hexutil.Uint64 `json:"nonce"`
+	CodeHash common.Hash    `json:"codeHash"`
+}
+
+// SessionExport is the full result of a speculative session.
+type SessionExport struct {
+	Parent       common.Hash       `json:"parentHash"`
+	Number       hexutil.Uint64    `json:"number"`
+	Root         common.Hash       `json:"stateRoot"`
+	GasUsed      hexutil.Uint64    `json:"gasUsed"`
+	Transactions []hexutil.Bytes   `json:"transactions"`
+	Receipts     []*SessionReceipt `json:"receipts"`
+}
+
+// OpenSession starts a speculative execution session on top of the state of
+// the given block. Transactions applied to it are executed as if they were
+// included in the next block, without touching the chain or the pool.
+func (api *PrivateDebugAPI) OpenSession(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (string, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		return "", errSessionNotLatest
+	}
+	block, err := api.BHE.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return "", err
+	}
+	if block == nil {
+		return "", errors.New("block not found")
+	}
+	statedb, err := api.BHE.BlockChain().StateAt(block.Root())
+	if err != nil {
+		return "", err
+	}
+	parent := block.Header()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		GasLimit:   parent.GasLimit,
+		Time:       parent.Time + 1,
+		Difficulty: parent.Difficulty,
+		Coinbase:   parent.Coinbase,
+	}
+	session := &specSession{
+		header: header,
+		parent: parent.Hash(),
+		layers: []*sessionLayer{{state: statedb, gasPool: core.GasPool(header.GasLimit)}},
+	}
+	return api.BHE.sessions.add(session)
+}
+
+// ForkSession opens a new session starting from the current state of an
+// existing one. The two sessions evolve independently afterwards.
+func (api *PrivateDebugAPI) ForkSession(id string) (string, error) {
+	session, err := api.BHE.sessions.get(id)
+	if err != nil {
+		return "", err
+	}
+	fork := &specSession{
+		header: types.CopyHeader(session.header),
+		parent: session.parent,
+		layers: []*sessionLayer{session.top().copy()},
+	}
+	session.lock.Unlock()
+
+	return api.BHE.sessions.add(fork)
+}
+
+// PushSessionLayer stacks a new overlay on the session, so that the changes
+// made from here on can be discarded with PopSessionLayer. The new depth of
+// the overlay stack is returned.
+func (api *PrivateDebugAPI) PushSessionLayer(id string) (int, error) {
+	session, err := api.BHE.sessions.get(id)
+	if err != nil {
+		return 0, err
+	}
+	defer session.lock.Unlock()
+
+	if len(session.layers) >= maxSessionLayers {
+		return 0, errTooManyLayers
+	}
+	// The live layer is the one mutated, so stash a copy of it underneath
+	top := session.top()
+	session.layers[len(session.layers)-1] = top.copy()
+	session.layers = append(session.layers, top)
+	return len(session.layers) - 1, nil
+}
+
+// PopSessionLayer discards the topmost overlay of the session, reverting it to
+// the state of the matching PushSessionLayer. The new depth is returned.
+func (api *PrivateDebugAPI) PopSessionLayer(id string) (int, error) {
+	session, err := api.BHE.sessions.get(id)
+	if err != nil {
+		return 0, err
+	}
+	defer session.lock.Unlock()
+
+	if len(session.layers) == 1 {
+		return 0, errNoSessionLayer
+	}
+	session.layers = session.layers[:len(session.layers)-1]
+	return len(session.layers) - 1, nil
+}
+
+// SessionApplyTransaction executes a signed, RLP encoded transaction on top of
+// the session state. Failed transactions are not applied and leave the session
+// unchanged.
+func (api *PrivateDebugAPI) SessionApplyTransaction(id string, encodedTx hexutil.Bytes) (*SessionReceipt, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+		return nil, err
+	}
+	session, err := api.BHE.sessions.get(id)
+	if err != nil {
+		return nil, err
+	}
+	defer session.lock.Unlock()
+
+	var (
+		chain   = api.BHE.blockchain
+		layer   = session.top()
+		gasPool = layer.gasPool
+		usedGas = layer.usedGas
+		statedb = layer.state.Copy()
+	)
+	if gasPool.Gas() < tx.Gas() {
+		return nil, errSessionGasLimit
+	}
+	statedb.Prepare(tx.Hash(), common.Hash{}, len(layer.txs))
+	receipt, err := core.ApplyTransaction(chain.Config(), chain, &session.header.Coinbase, &gasPool, statedb, session.header, tx, &usedGas, *chain.GetVMConfig())
+	if err != nil {
+		return nil, err
+	}
+	layer.state, layer.gasPool, layer.usedGas = statedb, gasPool, usedGas
+	layer.txs = append(layer.txs, tx)
+	layer.receipts = append(layer.receipts, receipt)
+
+	return newSessionReceipt(receipt), nil
+}
+
+// SessionAccount returns the balance, nonce and code hash of an account in the
+// current state of the session.
+func (api *PrivateDebugAPI) SessionAccount(id string, address common.Address) (*SessionAccount, error) {
+	session, err := api.BHE.sessions.get(id)
+	if err != nil {
+		return nil, err
+	}
+	defer session.lock.Unlock()
+
+	statedb := session.top().state
+	return &SessionAccount{
+		Balance:  (*hexutil.Big)(statedb.GetBalance(address)),
+		Nonce:    hexutil.Uint64(statedb.GetNonce(address)),
+		CodeHash: statedb.GetCodeHash(address),
+	}, nil
+}
+
+// SessionStorageAt returns a storage slot of an account in the current state
+// of the session.
+func (api *PrivateDebugAPI) SessionStorageAt(id string, address common.Address, key common.Hash) (common.Hash, error) {
+	session, err := api.BHE.sessions.get(id)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	defer session.lock.Unlock()
+
+	return session.top().state.GetState(address, key), nil
+}
+
+// ExportSession returns the transactions applied in the session along with
+// their receipts and the resulting state root.
+func (api *PrivateDebugAPI) ExportSession(id string) (*SessionExport, error) {
+	session, err := api.BHE.sessions.get(id)
+	if err != nil {
+		return nil, err
+	}
+	defer session.lock.Unlock()
+
+	var (
+		layer  = session.top()
+		config = api.BHE.blockchain.Config()
+		export = &SessionExport{
+			Parent:       session.parent,
+			Number:       hexutil.Uint64(session.header.Number.Uint64()),
+			Root:         layer.state.Copy().IntermediateRoot(config.IsEIP158(session.header.Number)),
+			GasUsed:      hexutil.Uint64(layer.usedGas),
+			Transactions: make([]hexutil.Bytes, len(layer.txs)),
+			Receipts:     make([]*SessionReceipt, len(layer.receipts)),
+		}
+	)
+	for i, tx := range layer.txs {
+		blob, err := rlp.EncodeToBytes(tx)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+		export.Transactions[i] = blob
+	}
+	for i, receipt := range layer.receipts {
+		export.Receipts[i] = newSessionReceipt(receipt)
+	}
+	return export, nil
+}
+
+// CloseSession discards a speculative session and all its overlays.
+func (api *PrivateDebugAPI) CloseSession(id string) bool {
+	return api.BHE.sessions.remove(id)
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errShuttingDown is returned for EVM executions requested while the node is
+// shutting down.
+var errShuttingDown = errors.New("node is shutting down")
+
+// ShutdownConfig contains the bounds of the shutdown sequence.
+type ShutdownConfig struct {
+	DrainTimeout time.Duration // Maximum time to wait for in-flight RPC executions
+	FlushTimeout time.Duration // Maximum time to wait for the chain state flush, unbounded if zero
+}
+
+// DefaultShutdownConfig contains the default shutdown bounds.
+var DefaultShutdownConfig = ShutdownConfig{
+	DrainTimeout: 5 * time.Second,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *ShutdownConfig) sanitize() ShutdownConfig {
+	conf := *config
+	if conf.DrainTimeout < 0 {
+		log.Warn("Sanitizing invalid RPC drain timeout", "provided", conf.DrainTimeout, "updated", DefaultShutdownConfig.DrainTimeout)
+		conf.DrainTimeout = DefaultShutdownConfig.DrainTimeout
+	}
+	if conf.FlushTimeout < 0 {
+		log.Warn("Sanitizing invalid state flush timeout", "provided", conf.FlushTimeout, "updated", DefaultShutdownConfig.FlushTimeout)
+		conf.FlushTimeout = DefaultShutdownConfig.FlushTimeout
+	}
+	return conf
+}
+
+// rpcDrainer tracks the EVM executions started by RPC requests, so that the
+// shutdown can wait for them instead of pulling the state from under them.
+type rpcDrainer struct {
+	active  int
+	closing bool
+	idle    chan struct{} // Closed when the last execution ends while closing
+	lock    sync.Mutex
+}
+
+// enter registers an execution, refusing it once draining started.
+func (d *rpcDrainer) enter() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closing {
+		return false
+	}
+	d.active++
+	return true
+}
+
+// exit unregisters an execution.
+func (d *rpcDrainer) exit() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.active--; d.active == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// drain refuses new executions and waits for the running ones to end, at most
+// for the given timeout. It returns the number of executions still running.
+func (d *rpcDrainer) drain(timeout time.Duration) int {
+	d.lock.Lock()
+	d.closing = true
+	if d.active == 0 {
+		d.lock.Unlock()
+		return 0
+	}
+	idle := make(chan struct{})
+	d.idle = idle
+	d.lock.Unlock()
+
+	var (
+		start   = time.Now()
+		logged  = time.NewTicker(8 * time.Second)
+		expired = time.NewTimer(timeout)
+	)
+	defer logged.Stop()
+	defer expired.Stop()
+
+	for {
+		select {
+		case <-idle:
+			return 0
+		case <-logged.C:
+			d.lock.Lock()
+			active := d.active
+			d.lock.Unlock()
+			log.Info("Waiting for in-flight RPC executions", "active", active, "elapsed", common.PrettyDuration(time.Since(start)))
+		case <-expired.C:
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			return d.active
+		}
+	}
+}
+
+// flushChain stops the blockchain, which flushes the dirty tries and the
+// snapshot journal to disk, logging progress while it runs. If the flush
+// doesn't complete within the deadline, it gives up waiting and returns false;
+// the database must then be left open for the flush to keep writing into.
+func (s *BHEereum) flushChain(deadline time.Duration) bool {
+	var (
+		start = time.Now()
+		done  = make(chan struct{})
+	)
+	go func() {
+		s.blockchain.Stop()
+		close(done)
+	}()
+	logged := time.NewTicker(8 * time.Second)
+	defer logged.Stop()
+
+	var expired <-chan time.Time
+	if deadline > 0 {
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case <-done:
+			log.Info("Flushed chain state", "elapsed", common.PrettyDuration(time.Since(start)))
+			return true
+		case <-logged.C:
+			log.Info("Flushing chain state", "elapsed", common.PrettyDuration(time.Since(start)))
+		case <-expired:
+			log.Error("Chain state flush overran its deadline, abandoning it", "deadline", deadline)
+			return false
+		}
+	}
+}
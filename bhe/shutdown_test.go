@@ -0,0 +1,49 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that draining waits for the running executions, refuses new ones and
+// gives up on the stragglers once the timeout passes.
+func TestRPCDrainer(t *testing.T) {
+	var d rpcDrainer
+	if !d.enter() || !d.enter() {
+		t.Fatal("execution refused before draining")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		d.exit()
+		d.exit()
+	}()
+	if active := d.drain(time.Second); active != 0 {
+		t.Fatalf("executions left after drain: have %d, want 0", active)
+	}
+	if d.enter() {
+		t.Fatal("execution accepted while draining")
+	}
+	// Executions outliving the timeout are reported
+	var stuck rpcDrainer
+	stuck.enter()
+	if active := stuck.drain(50 * time.Millisecond); active != 1 {
+		t.Fatalf("stuck executions mismatch: have %d, want 1", active)
+	}
+	stuck.exit()
+}
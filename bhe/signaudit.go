@@ -0,0 +1,263 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxSigningAuditResults is the maximum number of audit records returned by a
+// single query.
+const maxSigningAuditResults = 1024
+
+// SigningRecord is a single entry in the signing audit trail. Every record
+// commits to its predecessor through Prev, so removing or altering an entry
+// breaks the hash chain of every later one.
+type SigningRecord struct {
+	Seq       uint64         `json:"seq"`
+	Time      time.Time      `json:"time"`
+	Subsystem string         `json:"subsystem"` // Node component requesting the signature (e.g. "clique")
+	Account   common.Address `json:"account"`
+	MimeType  string         `json:"mimeType"`
+	Digest    common.Hash    `json:"digest"` // Keccak256 of the signed payload
+	Error     string         `json:"error,omitempty"`
+	Prev      common.Hash    `json:"prev"`
+	Hash      common.Hash    `json:"hash"`
+}
+
+// seal computes the chained hash of the record, covering every field but the
+// hash itself.
+func (r *SigningRecord) seal() common.Hash {
+	blob, _ := rlp.EncodeToBytes([]interface{}{
+		r.Seq, uint64(r.Time.UnixNano()), r.Subsystem, r.Account, r.MimeType, r.Digest, r.Error, r.Prev,
+	})
+	return crypto.Keccak256Hash(blob)
+}
+
+// signDataFn is the signature callback handed to node subsystems. It is an alias
+// so wrapped callbacks stay assignable to the engine specific signer types.
+type signDataFn = func(account accounts.Account, mimeType string, data []byte) ([]byte, error)
+
+// signTxFn is the transaction signature callback handed to node subsystems.
+type signTxFn = func(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+
+// signTxMimeType is the content type recorded for transaction signatures, the
+// digest covering the transaction's signing hash.
+const signTxMimeType = "application/x-transaction"
+
+// signingAudit is an append-only, hash-chained log of every signing operation
+// performed by the node on behalf of its own subsystems.
+type signingAudit struct {
+	path string
+	file *os.File
+	seq  uint64      // Sequence number of the next record
+	last common.Hash // Hash of the last record written
+	size int64       // Size of the trail written so far
+	lock sync.Mutex
+}
+
+// openSigningAudit opens (or creates) the audit trail at path, verifying the
+// existing hash chain. An empty path disables auditing and yields nil.
+func openSigningAudit(path string) (*signingAudit, error) {
+	if path == "" {
+		return nil, nil
+	}
+	a := &signingAudit{path: path}
+	if info, err := os.Stat(path); err == nil {
+		a.size = info.Size()
+	}
+	if err := a.iterate(a.size, func(r *SigningRecord) bool {
+		a.seq, a.last = r.Seq+1, r.Hash
+		return true
+	}); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = file
+	log.Info("Opened signing audit trail", "path", path, "records", a.seq)
+	return a, nil
+}
+
+// iterate walks the first size bytes of the on-disk trail in order, verifying
+// the hash chain, until the callback returns false. It doesn't need the lock,
+// records are only ever appended past the size taken from snapshot.
+func (a *signingAudit) iterate(size int64, fn func(r *SigningRecord) bool) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var (
+		reader = bufio.NewReader(io.LimitReader(file, size))
+		prev   common.Hash
+		seq    uint64
+	)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r := new(SigningRecord)
+		if err := json.Unmarshal(line, r); err != nil {
+			return fmt.Errorf("signing audit record %d: %v", seq, err)
+		}
+		if r.Seq != seq || r.Prev != prev || r.seal() != r.Hash {
+			return fmt.Errorf("signing audit trail tampered at record %d", seq)
+		}
+		if !fn(r) {
+			return nil
+		}
+		prev, seq = r.Hash, seq+1
+	}
+}
+
+// append adds a new record to the trail.
+func (a *signingAudit) append(subsystem string, account common.Address, mimeType string, data []byte, signErr error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	r := &SigningRecord{
+		Seq:       a.seq,
+		Time:      time.Now().UTC(),
+		Subsystem: subsystem,
+		Account:   account,
+		MimeType:  mimeType,
+		Digest:    crypto.Keccak256Hash(data),
+		Prev:      a.last,
+	}
+	if signErr != nil {
+		r.Error = signErr.Error()
+	}
+	r.Hash = r.seal()
+
+	blob, err := json.Marshal(r)
+	if err != nil {
+		log.Error("Failed to encode signing audit record", "err", err)
+		return
+	}
+	n, err := a.file.Write(append(blob, '\n'))
+	a.size += int64(n)
+	if err != nil {
+		log.Error("Failed to write signing audit record", "err", err)
+		return
+	}
+	a.seq, a.last = a.seq+1, r.Hash
+}
+
+// snapshot returns the size of the trail written so far, which can be iterated
+// without holding the lock, keeping queries from stalling the signers, along
+// with the number of records it holds.
+func (a *signingAudit) snapshot() (int64, uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.size, a.seq
+}
+
+// wrap returns a signature callback which records every invocation of fn in
+// the audit trail. Auditing is skipped on a nil trail.
+func (a *signingAudit) wrap(subsystem string, fn signDataFn) signDataFn {
+	if a == nil {
+		return fn
+	}
+	return func(account accounts.Account, mimeType string, data []byte) ([]byte, error) {
+		sig, err := fn(account, mimeType, data)
+		a.append(subsystem, account.Address, mimeType, data, err)
+		return sig, err
+	}
+}
+
+// wrapTx returns a transaction signature callback which records every
+// invocation of fn in the audit trail. Auditing is skipped on a nil trail.
+func (a *signingAudit) wrapTx(subsystem string, fn signTxFn) signTxFn {
+	if a == nil {
+		return fn
+	}
+	return func(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+		signed, err := fn(account, tx, chainID)
+		a.append(subsystem, account.Address, signTxMimeType, types.NewEIP155Signer(chainID).Hash(tx).Bytes(), err)
+		return signed, err
+	}
+}
+
+// close flushes and closes the trail.
+func (a *signingAudit) close() {
+	if a == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.file.Close()
+}
+
+// SigningAudit returns up to count signing audit records starting at sequence
+// number from, optionally filtered by signer account.
+func (api *PrivateAdminAPI) SigningAudit(from hexutil.Uint64, count int, account *common.Address) ([]*SigningRecord, error) {
+	audit := api.BHE.signingAudit
+	if audit == nil {
+		return nil, errors.New("signing audit disabled")
+	}
+	if count <= 0 || count > maxSigningAuditResults {
+		count = maxSigningAuditResults
+	}
+	size, _ := audit.snapshot()
+
+	var records []*SigningRecord
+	err := audit.iterate(size, func(r *SigningRecord) bool {
+		if r.Seq >= uint64(from) && (account == nil || r.Account == *account) {
+			records = append(records, r)
+		}
+		return len(records) < count
+	})
+	return records, err
+}
+
+// VerifySigningAudit checks the integrity of the whole signing audit trail,
+// returning the number of records verified.
+func (api *PrivateAdminAPI) VerifySigningAudit() (hexutil.Uint64, error) {
+	audit := api.BHE.signingAudit
+	if audit == nil {
+		return 0, errors.New("signing audit disabled")
+	}
+	size, written := audit.snapshot()
+
+	var verified uint64
+	err := audit.iterate(size, func(r *SigningRecord) bool {
+		verified++
+		return true
+	})
+	if err == nil && verified != written {
+		err = fmt.Errorf("signing audit trail truncated at record %d of %d", verified, written)
+	}
+	return hexutil.Uint64(verified), err
+}
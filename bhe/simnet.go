@@ -0,0 +1,340 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// simNetworkGasLimit is the genesis gas limit of simulated networks.
+const simNetworkGasLimit = 8000000
+
+// SimNetworkConfig contains the settings of a simulated network.
+type SimNetworkConfig struct {
+	Nodes  int               // Number of nodes to start
+	Alloc  core.GenesisAlloc // Pre-funded accounts of the genesis block
+	Config *Config           // Template of the node configurations, DefaultConfig if nil
+}
+
+// SimNode is a single node of a simulated network.
+type SimNode struct {
+	Stack     *node.Node
+	BHE       *BHEereum
+	BHEerbase common.Address // Coinbase of the blocks mined on the node
+}
+
+// simLink is a live connection between two nodes of a simulated network.
+type simLink struct {
+	a, b *p2p.MsgPipeRW
+}
+
+// SimNetwork is a set of in-process BHEereum instances on in-memory databases,
+// running fake proof-of-work and connected by in-memory message pipes instead of
+// real sockets. It lets downstream projects integration test against the full
+// protocol stack without real nodes: blocks are mined on demand, links can be
+// cut to partition the network and competing chains injected to force reorgs.
+type SimNetwork struct {
+	nodes []*SimNode
+	links map[[2]int]*simLink
+	lock  sync.Mutex
+}
+
+// NewSimNetwork starts the nodes of a simulated network on a shared genesis and
+// connects all of them to each other.
+func NewSimNetwork(config SimNetworkConfig) (*SimNetwork, error) {
+	if config.Nodes < 1 {
+		return nil, errors.New("simulated network needs at least one node")
+	}
+	template := config.Config
+	if template == nil {
+		template = &DefaultConfig
+	}
+	genesis := &core.Genesis{
+		Config:   params.AllBHEashProtocolChanges,
+		GasLimit: simNetworkGasLimit,
+		Alloc:    config.Alloc,
+	}
+	net := &SimNetwork{links: make(map[[2]int]*simLink)}
+	for i := 0; i < config.Nodes; i++ {
+		sim, err := newSimNode(i, config.Nodes, template, genesis)
+		if err != nil {
+			net.Close()
+			return nil, err
+		}
+		net.nodes = append(net.nodes, sim)
+	}
+	net.Heal()
+	return net, nil
+}
+
+// newSimNode starts a single node of a simulated network.
+func newSimNode(index int, peers int, template *Config, genesis *core.Genesis) (*SimNode, error) {
+	stack, err := node.New(&node.Config{
+		Name: fmt.Sprintf("simnode%d", index),
+		P2P: p2p.Config{
+			MaxPeers:    peers,
+			NoDiscovery: true,
+			ListenAddr:  "",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	config := *template
+	config.Genesis = genesis
+	config.NoPruning = true // Blocks are generated straight on the persisted state
+	config.BHEash.PowMode = BHEash.ModeFake
+	config.Miner.BHEerbase = crypto.PubkeyToAddress(key.PublicKey)
+
+	sim := &SimNode{Stack: stack, BHEerbase: config.Miner.BHEerbase}
+	if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		var err error
+		sim.BHE, err = New(ctx, &config)
+		return sim.BHE, err
+	}); err != nil {
+		return nil, err
+	}
+	if err := stack.Start(); err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+// Node returns a node of the network.
+func (n *SimNetwork) Node(index int) *SimNode {
+	return n.nodes[index]
+}
+
+// Len returns the number of nodes in the network.
+func (n *SimNetwork) Len() int {
+	return len(n.nodes)
+}
+
+// Close disconnects and stops all nodes of the network.
+func (n *SimNetwork) Close() {
+	n.lock.Lock()
+	for key, link := range n.links {
+		link.a.Close()
+		delete(n.links, key)
+	}
+	n.lock.Unlock()
+
+	for _, sim := range n.nodes {
+		sim.Stack.Stop()
+	}
+}
+
+// simLinkKey returns the key of the link between two nodes.
+func simLinkKey(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+// Connect links two nodes, running the BHE protocol between them over an
+// in-memory pipe. Connecting linked nodes is a no-op.
+func (n *SimNetwork) Connect(a, b int) {
+	if a == b {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	key := simLinkKey(a, b)
+	if _, ok := n.links[key]; ok {
+		return
+	}
+	rwA, rwB := p2p.MsgPipe()
+	n.links[key] = &simLink{a: rwA, b: rwB}
+
+	n.nodes[a].run(n.nodes[b], rwA)
+	n.nodes[b].run(n.nodes[a], rwB)
+}
+
+// run serves the highest protocol version of the node to a remote node.
+func (s *SimNode) run(remote *SimNode, rw p2p.MsgReadWriter) {
+	proto := s.BHE.Protocols()[0]
+	peer := p2p.NewPeer(remote.Stack.Server().Self().ID(), remote.Stack.Server().Config.Name, []p2p.Cap{{Name: proto.Name, Version: proto.Version}})
+
+	go func() {
+		if err := proto.Run(peer, rw); err != nil {
+			log.Debug("Simulated peer disconnected", "local", s.Stack.Server().Config.Name, "remote", peer.Name(), "err", err)
+		}
+	}()
+}
+
+// Disconnect cuts the link between two nodes, if any.
+func (n *SimNetwork) Disconnect(a, b int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	key := simLinkKey(a, b)
+	if link, ok := n.links[key]; ok {
+		link.a.Close()
+		delete(n.links, key)
+	}
+}
+
+// Partition cuts every link between nodes of different groups, leaving the links
+// within the groups intact. Nodes not in any group are isolated.
+func (n *SimNetwork) Partition(groups ...[]int) {
+	group := make(map[int]int)
+	for i, members := range groups {
+		for _, member := range members {
+			group[member] = i
+		}
+	}
+	for a := range n.nodes {
+		for b := a + 1; b < len(n.nodes); b++ {
+			ga, oka := group[a]
+			gb, okb := group[b]
+			if !oka || !okb || ga != gb {
+				n.Disconnect(a, b)
+			}
+		}
+	}
+}
+
+// Heal links every pair of nodes. Chains diverged during a partition converge
+// once the next block is mined on the heavier one.
+func (n *SimNetwork) Heal() {
+	for a := range n.nodes {
+		for b := a + 1; b < len(n.nodes); b++ {
+			n.Connect(a, b)
+		}
+	}
+}
+
+// generate creates blocks on top of a parent with fake proof-of-work, filling the
+// first one with the executable transactions of the node's pool.
+func (s *SimNode) generate(parent *types.Block, count int, coinbase common.Address, extra []byte) ([]*types.Block, error) {
+	chain := s.BHE.blockchain
+
+	pending, err := s.BHE.txPool.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		signer = types.MakeSigner(chain.Config(), new(big.Int).Add(parent.Number(), common.Big1))
+		txs    = types.NewTransactionsByPriceAndNonce(signer, pending)
+		limit  = core.CalcGasLimit(parent, parent.GasLimit(), parent.GasLimit())
+	)
+	blocks, _ := core.GenerateChain(chain.Config(), parent, BHEash.NewFaker(), s.BHE.chainDb, count, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(coinbase)
+		if len(extra) > 0 {
+			gen.SetExtra(extra)
+		}
+		if i > 0 || parent.Hash() != chain.CurrentBlock().Hash() {
+			return
+		}
+		for used := uint64(0); ; {
+			tx := txs.Peek()
+			if tx == nil {
+				break
+			}
+			if used+tx.Gas() > limit {
+				txs.Pop()
+				continue
+			}
+			gen.AddTxWithChain(chain, tx)
+			used += tx.Gas()
+			txs.Shift()
+		}
+	})
+	return blocks, nil
+}
+
+// insert imports generated blocks into the node and propagates them to its peers
+// the same way the miner does.
+func (s *SimNode) insert(blocks []*types.Block) error {
+	if _, err := s.BHE.blockchain.InsertChain(blocks); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		s.BHE.eventMux.Post(core.NewMinedBlockEvent{Block: block})
+	}
+	return nil
+}
+
+// Mine seals blocks on top of the head of a node, the first one including the
+// executable transactions of its pool, and propagates them to the peers.
+func (n *SimNetwork) Mine(index int, count int) ([]*types.Block, error) {
+	sim := n.nodes[index]
+
+	blocks, err := sim.generate(sim.BHE.blockchain.CurrentBlock(), count, sim.BHEerbase, nil)
+	if err != nil {
+		return nil, err
+	}
+	return blocks, sim.insert(blocks)
+}
+
+// Reorg replaces the last depth blocks of a node with a longer competing chain of
+// length blocks, mined on the ancestor below them, and propagates it.
+func (n *SimNetwork) Reorg(index int, depth uint64, length int) ([]*types.Block, error) {
+	sim := n.nodes[index]
+
+	head := sim.BHE.blockchain.CurrentBlock().NumberU64()
+	if depth > head {
+		return nil, fmt.Errorf("reorg depth %d beyond genesis", depth)
+	}
+	if uint64(length) <= depth {
+		return nil, fmt.Errorf("competing chain of %d blocks can't replace %d", length, depth)
+	}
+	parent := sim.BHE.blockchain.GetBlockByNumber(head - depth)
+	blocks, err := sim.generate(parent, length, sim.BHEerbase, []byte("simnet reorg"))
+	if err != nil {
+		return nil, err
+	}
+	return blocks, sim.insert(blocks)
+}
+
+// Synced waits until the given nodes, or all of them if none are given, agree
+// on the head block.
+func (n *SimNetwork) Synced(timeout time.Duration, nodes ...int) error {
+	if len(nodes) == 0 {
+		for i := range n.nodes {
+			nodes = append(nodes, i)
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		head := n.nodes[nodes[0]].BHE.blockchain.CurrentBlock()
+		synced := true
+		for _, i := range nodes[1:] {
+			if n.nodes[i].BHE.blockchain.CurrentBlock().Hash() != head.Hash() {
+				synced = false
+				break
+			}
+		}
+		if synced {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("nodes %v not synced within %v", nodes, timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
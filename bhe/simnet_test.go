@@ -0,0 +1,91 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the nodes of a simulated network converge on the heavier chain after
+// a partition heals.
+func TestSimNetworkPartition(t *testing.T) {
+	net, err := NewSimNetwork(SimNetworkConfig{Nodes: 3})
+	if err != nil {
+		t.Fatalf("failed to start simulated network: %v", err)
+	}
+	defer net.Close()
+
+	if _, err := net.Mine(0, 2); err != nil {
+		t.Fatalf("failed to mine: %v", err)
+	}
+	if err := net.Synced(10 * time.Second); err != nil {
+		t.Fatalf("initial sync failed: %v", err)
+	}
+	net.Partition([]int{0}, []int{1, 2})
+
+	if _, err := net.Mine(0, 2); err != nil {
+		t.Fatalf("failed to mine on the minority: %v", err)
+	}
+	if _, err := net.Mine(1, 4); err != nil {
+		t.Fatalf("failed to mine on the majority: %v", err)
+	}
+	if err := net.Synced(10*time.Second, 1, 2); err != nil {
+		t.Fatalf("majority sync failed: %v", err)
+	}
+	if net.Node(0).BHE.BlockChain().CurrentBlock().Hash() == net.Node(1).BHE.BlockChain().CurrentBlock().Hash() {
+		t.Fatalf("partitioned nodes share the head")
+	}
+	net.Heal()
+	blocks, err := net.Mine(1, 1)
+	if err != nil {
+		t.Fatalf("failed to mine after healing: %v", err)
+	}
+	if err := net.Synced(10 * time.Second); err != nil {
+		t.Fatalf("sync after healing failed: %v", err)
+	}
+	if head := net.Node(0).BHE.BlockChain().CurrentBlock(); head.Hash() != blocks[0].Hash() {
+		t.Errorf("minority head mismatch: have #%d [%x], want #%d [%x]", head.NumberU64(), head.Hash(), blocks[0].NumberU64(), blocks[0].Hash())
+	}
+}
+
+// Tests that a competing chain injected into one node is adopted by all of them.
+func TestSimNetworkReorg(t *testing.T) {
+	net, err := NewSimNetwork(SimNetworkConfig{Nodes: 2})
+	if err != nil {
+		t.Fatalf("failed to start simulated network: %v", err)
+	}
+	defer net.Close()
+
+	if _, err := net.Mine(0, 4); err != nil {
+		t.Fatalf("failed to mine: %v", err)
+	}
+	if err := net.Synced(10 * time.Second); err != nil {
+		t.Fatalf("initial sync failed: %v", err)
+	}
+	blocks, err := net.Reorg(0, 2, 3)
+	if err != nil {
+		t.Fatalf("failed to reorg: %v", err)
+	}
+	if err := net.Synced(10 * time.Second); err != nil {
+		t.Fatalf("sync after reorg failed: %v", err)
+	}
+	want := blocks[len(blocks)-1]
+	if head := net.Node(1).BHE.BlockChain().CurrentBlock(); head.Hash() != want.Hash() {
+		t.Errorf("head mismatch: have #%d [%x], want #%d [%x]", head.NumberU64(), head.Hash(), want.NumberU64(), want.Hash())
+	}
+}
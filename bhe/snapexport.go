@@ -0,0 +1,473 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// snapshotExportVersion is the version of the snapshot export format.
+const snapshotExportVersion = 1
+
+// snapshotImportFlush is the amount of dirty trie nodes accumulated in memory
+// while verifying a snapshot import before they are flushed to disk.
+const snapshotImportFlush = 256 * 1024 * 1024
+
+// Kinds of the entries in a snapshot export.
+const (
+	snapshotAccountEntry = iota // Slim RLP encoded account
+	snapshotStorageEntry        // Storage slot of the preceding account
+	snapshotCodeEntry           // Contract code of the preceding account
+)
+
+var errSnapshotsEnabled = errors.New("snapshots must be disabled to import one")
+
+// snapshotExportHeader leads a snapshot export. The block header is the proof of
+// the exported state: the state root rebuilt from the entries has to match it,
+// and the header itself the local canonical chain.
+type snapshotExportHeader struct {
+	Version uint
+	Header  *types.Header
+}
+
+// snapshotExportEntry is a single account, storage slot or contract code of a
+// snapshot export. Entries are ordered by account hash, each account followed by
+// its code and its storage slots in hash order.
+type snapshotExportEntry struct {
+	Kind uint8
+	Hash common.Hash // Account hash, slot hash or code hash
+	Data []byte      // Slim account, slot value or contract code
+}
+
+// snapshotGenerator mirrors the generator progress journaled by the snapshot
+// package, so an imported snapshot is picked up as complete on restart instead
+// of being regenerated.
+type snapshotGenerator struct {
+	Wiping   bool
+	Done     bool
+	Marker   []byte
+	Accounts uint64
+	Slots    uint64
+	Storage  uint64
+}
+
+// ExportSnapshot writes the flat state snapshot of the head block into a local
+// file, along with the header proving its root. The export can be imported into
+// another node with admin_importSnapshot instead of re-syncing its state.
+func (api *PrivateAdminAPI) ExportSnapshot(file string) (bool, error) {
+	snaps := api.BHE.blockchain.Snapshot()
+	if snaps == nil {
+		return false, errors.New("snapshots disabled")
+	}
+	if _, err := os.Stat(file); err == nil {
+		return false, errors.New("location would overwrite an existing file")
+	}
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+
+	var writer io.Writer = out
+	if strings.HasSuffix(file, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	header := api.BHE.blockchain.CurrentBlock().Header()
+	if err := rlp.Encode(writer, &snapshotExportHeader{Version: snapshotExportVersion, Header: header}); err != nil {
+		return false, err
+	}
+	it, err := snaps.AccountIterator(header.Root, common.Hash{})
+	if err != nil {
+		return false, err
+	}
+	defer it.Release()
+
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		accounts int
+		slots    int
+		db       = api.BHE.blockchain.StateCache()
+	)
+	for it.Next() {
+		if err := rlp.Encode(writer, &snapshotExportEntry{Kind: snapshotAccountEntry, Hash: it.Hash(), Data: it.Account()}); err != nil {
+			return false, err
+		}
+		full, err := snapshot.FullAccountRLP(it.Account())
+		if err != nil {
+			return false, fmt.Errorf("invalid account %x: %v", it.Hash(), err)
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(full, &account); err != nil {
+			return false, fmt.Errorf("invalid account %x: %v", it.Hash(), err)
+		}
+		if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+			code, err := db.ContractCode(it.Hash(), common.BytesToHash(account.CodeHash))
+			if err != nil {
+				return false, fmt.Errorf("missing code for account %x: %v", it.Hash(), err)
+			}
+			if err := rlp.Encode(writer, &snapshotExportEntry{Kind: snapshotCodeEntry, Hash: common.BytesToHash(account.CodeHash), Data: code}); err != nil {
+				return false, err
+			}
+		}
+		if account.Root != types.EmptyRootHash {
+			sit, err := snaps.StorageIterator(header.Root, it.Hash(), common.Hash{})
+			if err != nil {
+				return false, err
+			}
+			for sit.Next() {
+				if err := rlp.Encode(writer, &snapshotExportEntry{Kind: snapshotStorageEntry, Hash: sit.Hash(), Data: sit.Slot()}); err != nil {
+					sit.Release()
+					return false, err
+				}
+				slots++
+			}
+			err = sit.Error()
+			sit.Release()
+			if err != nil {
+				return false, err
+			}
+		}
+		accounts++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting state snapshot", "root", header.Root, "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return false, err
+	}
+	log.Info("Exported state snapshot", "number", header.Number, "root", header.Root, "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+	return true, nil
+}
+
+// snapshotImporter rebuilds the state tries from the entries of a snapshot
+// export, verifying every storage root against its account and the account
+// trie root against the exported header.
+type snapshotImporter struct {
+	triedb *trie.Database
+	codes  BHEdb.Batch
+
+	accounts *trie.Trie
+	storage  *trie.Trie  // Storage trie of the current account, nil if none
+	account  common.Hash // Hash of the current account
+	root     common.Hash // Storage root the current account expects
+	codeHash []byte      // Code hash the current account expects
+	code     bool        // Whether the code of the current account was seen
+}
+
+func newSnapshotImporter(db BHEdb.Database, triedb *trie.Database) (*snapshotImporter, error) {
+	accounts, err := trie.New(common.Hash{}, triedb)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotImporter{triedb: triedb, codes: db.NewBatch(), accounts: accounts}, nil
+}
+
+// finishAccount verifies and commits the storage trie of the current account
+// and checks its code was included.
+func (im *snapshotImporter) finishAccount() error {
+	if im.account == (common.Hash{}) {
+		return nil
+	}
+	root := types.EmptyRootHash
+	if im.storage != nil {
+		var err error
+		if root, err = im.storage.Commit(nil); err != nil {
+			return err
+		}
+	}
+	if root != im.root {
+		return fmt.Errorf("account %x: storage root mismatch: have %x, want %x", im.account, root, im.root)
+	}
+	if !im.code && !bytes.Equal(im.codeHash, emptyCodeHash) {
+		return fmt.Errorf("account %x: missing code %x", im.account, im.codeHash)
+	}
+	im.account, im.storage, im.code = common.Hash{}, nil, false
+	return nil
+}
+
+// add processes the next entry of the export.
+func (im *snapshotImporter) add(entry *snapshotExportEntry) error {
+	switch entry.Kind {
+	case snapshotAccountEntry:
+		if err := im.finishAccount(); err != nil {
+			return err
+		}
+		full, err := snapshot.FullAccountRLP(entry.Data)
+		if err != nil {
+			return fmt.Errorf("invalid account %x: %v", entry.Hash, err)
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(full, &account); err != nil {
+			return fmt.Errorf("invalid account %x: %v", entry.Hash, err)
+		}
+		if err := im.accounts.TryUpdate(entry.Hash[:], full); err != nil {
+			return err
+		}
+		im.account, im.root, im.codeHash = entry.Hash, account.Root, account.CodeHash
+		return im.flush(false)
+
+	case snapshotStorageEntry:
+		if im.account == (common.Hash{}) {
+			return fmt.Errorf("slot %x without account", entry.Hash)
+		}
+		if im.storage == nil {
+			storage, err := trie.New(common.Hash{}, im.triedb)
+			if err != nil {
+				return err
+			}
+			im.storage = storage
+		}
+		return im.storage.TryUpdate(entry.Hash[:], entry.Data)
+
+	case snapshotCodeEntry:
+		if im.account == (common.Hash{}) || !bytes.Equal(entry.Hash[:], im.codeHash) {
+			return fmt.Errorf("unexpected code %x", entry.Hash)
+		}
+		if crypto.Keccak256Hash(entry.Data) != entry.Hash {
+			return fmt.Errorf("code %x: hash mismatch", entry.Hash)
+		}
+		rawdb.WriteCode(im.codes, entry.Hash, entry.Data)
+		im.code = true
+		if im.codes.ValueSize() > BHEdb.IdealBatchSize {
+			if err := im.codes.Write(); err != nil {
+				return err
+			}
+			im.codes.Reset()
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown snapshot entry kind %d", entry.Kind)
+}
+
+// flush commits the account trie and writes all tries out to disk, if forced
+// or if the dirty nodes outgrew the memory allowance. The storage tries are
+// referenced from the account leaves, so they're flushed along.
+func (im *snapshotImporter) flush(force bool) error {
+	if nodes, _ := im.triedb.Size(); !force && nodes < snapshotImportFlush {
+		return nil
+	}
+	root, err := im.accounts.Commit(func(leaf []byte, parent common.Hash) error {
+		var account state.Account
+		if err := rlp.DecodeBytes(leaf, &account); err != nil {
+			return nil
+		}
+		if account.Root != types.EmptyRootHash {
+			im.triedb.Reference(account.Root, parent)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := im.triedb.Commit(root, false); err != nil {
+		return err
+	}
+	if err := im.codes.Write(); err != nil {
+		return err
+	}
+	im.codes.Reset()
+	return nil
+}
+
+// commit finishes the import, returning the root of the rebuilt state.
+func (im *snapshotImporter) commit() (common.Hash, error) {
+	if err := im.finishAccount(); err != nil {
+		return common.Hash{}, err
+	}
+	if err := im.flush(true); err != nil {
+		return common.Hash{}, err
+	}
+	return im.accounts.Hash(), nil
+}
+
+// openSnapshotExport opens a snapshot export, decoding its header.
+func openSnapshotExport(file string) (*rlp.Stream, *types.Header, func(), error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var reader io.Reader = in
+	if strings.HasSuffix(file, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			in.Close()
+			return nil, nil, nil, err
+		}
+	}
+	stream := rlp.NewStream(reader, 0)
+
+	var head snapshotExportHeader
+	if err := stream.Decode(&head); err != nil {
+		in.Close()
+		return nil, nil, nil, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if head.Version != snapshotExportVersion {
+		in.Close()
+		return nil, nil, nil, fmt.Errorf("unsupported snapshot version %d", head.Version)
+	}
+	return stream, head.Header, func() { in.Close() }, nil
+}
+
+// wipeSnapshotData deletes the flat snapshot entries left in the database, which
+// would otherwise shadow the accounts and slots missing from an imported state.
+func wipeSnapshotData(db BHEdb.Database) error {
+	for _, prefix := range [][]byte{rawdb.SnapshotAccountPrefix, rawdb.SnapshotStoragePrefix} {
+		var (
+			it    = db.NewIterator(prefix, nil)
+			batch = db.NewBatch()
+		)
+		for it.Next() {
+			if key := it.Key(); len(key) == len(prefix)+common.HashLength || len(key) == len(prefix)+2*common.HashLength {
+				batch.Delete(key)
+			}
+			if batch.ValueSize() > BHEdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					it.Release()
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		it.Release()
+		if err := batch.Write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportSnapshot imports a state snapshot exported by admin_exportSnapshot.
+// The block of the snapshot has to be on the local canonical chain. The state
+// tries are rebuilt from the snapshot and verified against the block's root
+// before the flat snapshot is written, so a tampered or truncated export is
+// rejected without touching the existing one. If the block is ahead of the
+// local head, the head is moved onto it.
+//
+// Snapshots have to be disabled while importing; once restarted with them
+// enabled, the imported snapshot is used without regeneration.
+func (api *PrivateAdminAPI) ImportSnapshot(file string) (bool, error) {
+	if err := api.BHE.writable(); err != nil {
+		return false, err
+	}
+	if api.BHE.blockchain.Snapshot() != nil {
+		return false, errSnapshotsEnabled
+	}
+	db := api.BHE.chainDb
+
+	// Rebuild and verify the state tries
+	stream, header, closer, err := openSnapshotExport(file)
+	if err != nil {
+		return false, err
+	}
+	if rawdb.ReadCanonicalHash(db, header.Number.Uint64()) != header.Hash() {
+		closer()
+		return false, fmt.Errorf("snapshot block %d [%x] not canonical", header.Number, header.Hash())
+	}
+	importer, err := newSnapshotImporter(db, api.BHE.blockchain.StateCache().TrieDB())
+	if err != nil {
+		closer()
+		return false, err
+	}
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		count  int
+	)
+	for {
+		entry := new(snapshotExportEntry)
+		if err := stream.Decode(entry); err == io.EOF {
+			break
+		} else if err != nil {
+			closer()
+			return false, fmt.Errorf("entry %d: failed to parse: %v", count, err)
+		}
+		if err := importer.add(entry); err != nil {
+			closer()
+			return false, err
+		}
+		count++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying state snapshot", "root", header.Root, "entries", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	closer()
+	root, err := importer.commit()
+	if err != nil {
+		return false, err
+	}
+	if root != header.Root {
+		return false, fmt.Errorf("state root mismatch: have %x, want %x", root, header.Root)
+	}
+	// The state is verified, replace the flat snapshot
+	if stream, _, closer, err = openSnapshotExport(file); err != nil {
+		return false, err
+	}
+	defer closer()
+
+	if err := wipeSnapshotData(db); err != nil {
+		return false, err
+	}
+	batch := db.NewBatch()
+	rawdb.DeleteSnapshotJournal(batch)
+	var account common.Hash
+	for {
+		entry := new(snapshotExportEntry)
+		if err := stream.Decode(entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		}
+		switch entry.Kind {
+		case snapshotAccountEntry:
+			account = entry.Hash
+			rawdb.WriteAccountSnapshot(batch, entry.Hash, entry.Data)
+		case snapshotStorageEntry:
+			rawdb.WriteStorageSnapshot(batch, account, entry.Hash, entry.Data)
+		}
+		if batch.ValueSize() > BHEdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return false, err
+			}
+			batch.Reset()
+		}
+	}
+	generator, _ := rlp.EncodeToBytes(&snapshotGenerator{Done: true})
+	rawdb.WriteSnapshotGenerator(batch, generator)
+	rawdb.WriteSnapshotRoot(batch, root)
+	if err := batch.Write(); err != nil {
+		return false, err
+	}
+	log.Info("Imported state snapshot", "number", header.Number, "root", root, "entries", count, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Move the head onto the imported state if it's ahead
+	if header.Number.Uint64() > api.BHE.blockchain.CurrentBlock().NumberU64() {
+		if err := api.BHE.blockchain.FastSyncCommitHead(header.Hash()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,411 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// forwarderABI is the interface of the forwarder contract executing sponsored
+// calls on behalf of their signers, after checking signature and nonce.
+const forwarderABI = `[{"name":"execute","type":"function","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"data","type":"bytes"},{"name":"gas","type":"uint256"},{"name":"nonce","type":"uint256"},{"name":"signature","type":"bytes"}],"outputs":[]}]`
+
+// sponsorGasOverhead is the gas added on top of a sponsored call's own limit to
+// cover the forwarder's signature and nonce checks.
+const sponsorGasOverhead = 50000
+
+var (
+	errSponsorDisabled    = errors.New("sponsored transactions disabled")
+	errSponsorPoolFull    = errors.New("sponsored transaction pool full")
+	errSponsorAccountFull = errors.New("sponsored transaction pool full for account")
+	errSponsorSignature   = errors.New("invalid sponsored call signature")
+	errSponsorKnown       = errors.New("sponsored call already waiting")
+)
+
+// SponsorConfig contains the settings of the sponsored (gasless) transaction
+// experiment. The node relays signed calls of users through a forwarder contract
+// in transactions paid for by the relayer account.
+type SponsorConfig struct {
+	Relayer      common.Address   // Account paying the gas, empty to disable
+	Forwarder    common.Address   // Forwarder contract executing the calls
+	MaxGas       uint64           // Maximum gas limit of a single sponsored call
+	GlobalSlots  int              // Maximum number of sponsored calls waiting to be relayed
+	AccountSlots int              // Maximum number of waiting sponsored calls per signer
+	PerBlock     int              // Maximum number of calls relayed per block
+	Targets      []common.Address // Contracts calls may be sponsored to, any if empty
+	Validators   []string         // Registered validation hooks to run on every call
+}
+
+// DefaultSponsorConfig contains the default sponsored transaction settings.
+var DefaultSponsorConfig = SponsorConfig{
+	MaxGas:       500000,
+	GlobalSlots:  1024,
+	AccountSlots: 16,
+	PerBlock:     64,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *SponsorConfig) sanitize() SponsorConfig {
+	conf := *config
+	if conf.MaxGas == 0 {
+		log.Warn("Sanitizing invalid sponsored call gas limit", "provided", conf.MaxGas, "updated", DefaultSponsorConfig.MaxGas)
+		conf.MaxGas = DefaultSponsorConfig.MaxGas
+	}
+	if conf.GlobalSlots < 1 {
+		log.Warn("Sanitizing invalid sponsored pool slots", "provided", conf.GlobalSlots, "updated", DefaultSponsorConfig.GlobalSlots)
+		conf.GlobalSlots = DefaultSponsorConfig.GlobalSlots
+	}
+	if conf.AccountSlots < 1 {
+		log.Warn("Sanitizing invalid sponsored pool account slots", "provided", conf.AccountSlots, "updated", DefaultSponsorConfig.AccountSlots)
+		conf.AccountSlots = DefaultSponsorConfig.AccountSlots
+	}
+	if conf.PerBlock < 1 {
+		log.Warn("Sanitizing invalid sponsored calls per block", "provided", conf.PerBlock, "updated", DefaultSponsorConfig.PerBlock)
+		conf.PerBlock = DefaultSponsorConfig.PerBlock
+	}
+	return conf
+}
+
+// SponsoredCall is a call signed by a user to be executed through the forwarder
+// contract, with the gas paid for by the relayer.
+type SponsoredCall struct {
+	From      common.Address `json:"from"`
+	To        common.Address `json:"to"`
+	Data      hexutil.Bytes  `json:"data"`
+	Gas       hexutil.Uint64 `json:"gas"`
+	Nonce     hexutil.Uint64 `json:"nonce"` // Forwarder nonce of the signer
+	Signature hexutil.Bytes  `json:"signature"`
+}
+
+// Hash returns the hash signed by the user, binding the call to the forwarder
+// and the chain.
+func (c *SponsoredCall) Hash(forwarder common.Address, chainID *big.Int) common.Hash {
+	return crypto.Keccak256Hash(
+		forwarder.Bytes(),
+		c.From.Bytes(),
+		c.To.Bytes(),
+		crypto.Keccak256(c.Data),
+		common.BigToHash(new(big.Int).SetUint64(uint64(c.Gas))).Bytes(),
+		common.BigToHash(new(big.Int).SetUint64(uint64(c.Nonce))).Bytes(),
+		common.BigToHash(chainID).Bytes(),
+	)
+}
+
+// SponsorValidator is a hook vetting sponsored calls before they are accepted,
+// e.g. to enforce quotas or restrict which methods are sponsored.
+type SponsorValidator func(call *SponsoredCall) error
+
+var (
+	sponsorValidators     = make(map[string]SponsorValidator)
+	sponsorValidatorsLock sync.RWMutex
+)
+
+// RegisterSponsorValidator makes a sponsored call validation hook available
+// under a name for selection in the configuration.
+func RegisterSponsorValidator(name string, validator SponsorValidator) {
+	sponsorValidatorsLock.Lock()
+	defer sponsorValidatorsLock.Unlock()
+
+	sponsorValidators[name] = validator
+}
+
+// sponsorAdmitter runs the node-wide admission policy on a sponsored call.
+type sponsorAdmitter func(ctx context.Context, call *SponsoredCall) error
+
+// sponsorPool holds the validated sponsored calls waiting to be relayed, in
+// arrival order.
+type sponsorPool struct {
+	config     SponsorConfig
+	chainID    *big.Int
+	forwarder  abi.ABI
+	targets    map[common.Address]bool
+	validators []SponsorValidator
+	admit      sponsorAdmitter // Deny-list and screening checks, nil to skip
+
+	queue    []*SponsoredCall
+	accounts map[common.Address]int
+	known    map[sponsorKey]struct{} // Signer nonces of the waiting calls
+	lock     sync.Mutex
+}
+
+// sponsorKey identifies a sponsored call by signer and forwarder nonce, all but
+// one call of the same key reverting in the forwarder.
+type sponsorKey struct {
+	from  common.Address
+	nonce uint64
+}
+
+// newSponsorPool creates the pool of sponsored calls, returning nil if the
+// experiment is disabled. Every call is run by admit before being accepted.
+func newSponsorPool(config SponsorConfig, chainID *big.Int, admit sponsorAdmitter) (*sponsorPool, error) {
+	if config.Relayer == (common.Address{}) {
+		return nil, nil
+	}
+	if config.Forwarder == (common.Address{}) {
+		return nil, errors.New("sponsored transactions require a forwarder contract")
+	}
+	forwarder, err := abi.JSON(strings.NewReader(forwarderABI))
+	if err != nil {
+		return nil, err
+	}
+	pool := &sponsorPool{
+		config:    config.sanitize(),
+		chainID:   chainID,
+		forwarder: forwarder,
+		targets:   make(map[common.Address]bool),
+		admit:     admit,
+		accounts:  make(map[common.Address]int),
+		known:     make(map[sponsorKey]struct{}),
+	}
+	for _, target := range config.Targets {
+		pool.targets[target] = true
+	}
+	sponsorValidatorsLock.RLock()
+	defer sponsorValidatorsLock.RUnlock()
+
+	for _, name := range config.Validators {
+		validator, ok := sponsorValidators[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown sponsored call validator %q", name)
+		}
+		pool.validators = append(pool.validators, validator)
+	}
+	log.Info("Sponsored transactions enabled", "relayer", config.Relayer, "forwarder", config.Forwarder)
+	return pool, nil
+}
+
+// validate checks the signature and limits of a sponsored call and runs it by
+// the validation hooks.
+func (p *sponsorPool) validate(call *SponsoredCall) error {
+	if len(call.Signature) != crypto.SignatureLength {
+		return errSponsorSignature
+	}
+	sig := common.CopyBytes(call.Signature)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(call.Hash(p.config.Forwarder, p.chainID).Bytes(), sig)
+	if err != nil || crypto.PubkeyToAddress(*pub) != call.From {
+		return errSponsorSignature
+	}
+	if uint64(call.Gas) > p.config.MaxGas {
+		return fmt.Errorf("sponsored call gas %d above limit %d", call.Gas, p.config.MaxGas)
+	}
+	if len(p.targets) > 0 && !p.targets[call.To] {
+		return fmt.Errorf("calls to %x not sponsored", call.To)
+	}
+	for _, validator := range p.validators {
+		if err := validator(call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add validates and queues a sponsored call for relaying, once its signer and
+// target passed the admission policy.
+func (p *sponsorPool) add(ctx context.Context, call *SponsoredCall) error {
+	if err := p.validate(call); err != nil {
+		return err
+	}
+	if p.admit != nil {
+		if err := p.admit(ctx, call); err != nil {
+			return err
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.queue) >= p.config.GlobalSlots {
+		return errSponsorPoolFull
+	}
+	key := sponsorKey{call.From, uint64(call.Nonce)}
+	if _, ok := p.known[key]; ok {
+		return errSponsorKnown
+	}
+	if p.accounts[call.From] >= p.config.AccountSlots {
+		return errSponsorAccountFull
+	}
+	p.queue = append(p.queue, call)
+	p.accounts[call.From]++
+	p.known[key] = struct{}{}
+	return nil
+}
+
+// take removes up to the per block limit of calls from the queue.
+func (p *sponsorPool) take() []*SponsoredCall {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	n := len(p.queue)
+	if n > p.config.PerBlock {
+		n = p.config.PerBlock
+	}
+	calls := p.queue[:n:n]
+	p.queue = p.queue[n:]
+	for _, call := range calls {
+		if p.accounts[call.From]--; p.accounts[call.From] == 0 {
+			delete(p.accounts, call.From)
+		}
+		delete(p.known, sponsorKey{call.From, uint64(call.Nonce)})
+	}
+	return calls
+}
+
+// admitSponsoredCall runs the deny-list and the screening policy on the signer
+// and the target of a sponsored call, as for a remote transaction between them.
+func (s *BHEereum) admitSponsoredCall(ctx context.Context, call *SponsoredCall) error {
+	tx := types.NewTransaction(uint64(call.Nonce), call.To, new(big.Int), uint64(call.Gas), new(big.Int), call.Data)
+	if err := s.denyList.check(tx, call.From, false); err != nil {
+		return err
+	}
+	return s.screener.screen(ctx, tx, call.From, false)
+}
+
+// relaySponsoredCalls wraps a batch of waiting sponsored calls into forwarder
+// transactions signed by the relayer and submits them to the pool as local
+// transactions, so they are mined like any other transaction of the node.
+// Every call is simulated on top of the pending state and the calls relayed
+// before it first, dropping those the forwarder would revert (e.g. replayed
+// nonces) instead of paying for their gas. The admission policy is checked
+// again, as it may have changed while the calls were waiting.
+func (s *BHEereum) relaySponsoredCalls() {
+	relayer := s.sponsors.config.Relayer
+	wallet, err := s.signerWallet(relayer)
+	if err != nil {
+		log.Warn("Sponsored call relayer unavailable", "relayer", relayer, "err", err)
+		return
+	}
+	calls := s.sponsors.take()
+	if len(calls) == 0 {
+		return
+	}
+	s.lock.RLock()
+	price := new(big.Int).Set(s.gasPrice)
+	s.lock.RUnlock()
+
+	block, statedb := s.miner.Pending()
+	if block == nil || statedb == nil {
+		if statedb, err = s.blockchain.State(); err != nil {
+			log.Warn("Failed to simulate sponsored calls", "err", err)
+			return
+		}
+		block = s.blockchain.CurrentBlock()
+	}
+	var (
+		header = block.Header()
+		config = s.blockchain.Config()
+		signTx = s.signingAudit.wrapTx("sponsor", wallet.SignTx)
+	)
+	for _, call := range calls {
+		if err := s.admitSponsoredCall(context.Background(), call); err != nil {
+			log.Debug("Dropped inadmissible sponsored call", "from", call.From, "to", call.To, "err", err)
+			continue
+		}
+		input, err := s.sponsors.forwarder.Pack("execute", call.From, call.To, []byte(call.Data),
+			new(big.Int).SetUint64(uint64(call.Gas)), new(big.Int).SetUint64(uint64(call.Nonce)), []byte(call.Signature))
+		if err != nil {
+			log.Warn("Failed to encode sponsored call", "from", call.From, "err", err)
+			continue
+		}
+		gas := uint64(call.Gas) + sponsorGasOverhead
+
+		msg := types.NewMessage(relayer, &s.sponsors.config.Forwarder, 0, new(big.Int), gas, price, input, false)
+		vmenv := vm.NewEVM(core.NewEVMContext(msg, header, s.blockchain, nil), statedb, config, *s.blockchain.GetVMConfig())
+		snapshot := statedb.Snapshot()
+		res, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(gas))
+		if err == nil && res.Failed() {
+			err = res.Err
+		}
+		if err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			log.Debug("Dropped failing sponsored call", "from", call.From, "nonce", uint64(call.Nonce), "err", err)
+			continue
+		}
+		nonce := s.txPool.Nonce(relayer)
+		tx := types.NewTransaction(nonce, s.sponsors.config.Forwarder, new(big.Int), gas, price, input)
+
+		signed, err := signTx(accounts.Account{Address: relayer}, tx, config.ChainID)
+		if err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			log.Warn("Failed to sign sponsored call", "from", call.From, "err", err)
+			continue
+		}
+		if err := s.admitTx(context.Background(), signed, true); err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			log.Warn("Sponsored call transaction refused", "from", call.From, "err", err)
+			continue
+		}
+		if err := s.txPool.AddLocal(signed); err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			log.Warn("Failed to relay sponsored call", "from", call.From, "err", err)
+			continue
+		}
+		statedb.Finalise(config.IsEIP158(header.Number))
+		log.Debug("Relayed sponsored call", "from", call.From, "to", call.To, "hash", signed.Hash())
+	}
+}
+
+// startSponsorRelay relays a batch of waiting sponsored calls on every new head.
+func (s *BHEereum) startSponsorRelay() {
+	heads := make(chan core.ChainHeadEvent, 16)
+	s.sponsorSub = s.events.SubscribeChainHeadEvent(heads)
+
+	go func() {
+		for {
+			select {
+			case <-heads:
+				s.relaySponsoredCalls()
+			case <-s.sponsorSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// SendSponsoredCall submits a call signed by its sender to be executed through
+// the forwarder contract, with the gas paid for by the node's relayer. The call
+// is relayed in one of the next blocks.
+func (api *PublicBHEereumAPI) SendSponsoredCall(ctx context.Context, call SponsoredCall) (bool, error) {
+	if api.e.sponsors == nil {
+		return false, errSponsorDisabled
+	}
+	if err := api.e.writable(); err != nil {
+		return false, err
+	}
+	if err := api.e.sponsors.add(ctx, &call); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SponsoredCalls returns the number of sponsored calls waiting to be relayed.
+func (api *PublicBHEereumAPI) SponsoredCalls() (int, error) {
+	if api.e.sponsors == nil {
+		return 0, errSponsorDisabled
+	}
+	api.e.sponsors.lock.Lock()
+	defer api.e.sponsors.lock.Unlock()
+
+	return len(api.e.sponsors.queue), nil
+}
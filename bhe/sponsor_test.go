@@ -0,0 +1,100 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// Tests that sponsored calls are only accepted with a valid signature, within
+// the limits and past the validation hooks and the admission policy.
+func TestSponsorPool(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var (
+		from      = crypto.PubkeyToAddress(key.PublicKey)
+		forwarder = common.HexToAddress("0xf")
+		target    = common.HexToAddress("0x7")
+		chainID   = big.NewInt(1337)
+		errHook   = errors.New("rejected by hook")
+		denied    = common.HexToAddress("0xde")
+		errDenied = errors.New("denied by policy")
+	)
+	RegisterSponsorValidator("test", func(call *SponsoredCall) error {
+		if len(call.Data) > 0 && call.Data[0] == 0xff {
+			return errHook
+		}
+		return nil
+	})
+	pool, err := newSponsorPool(SponsorConfig{
+		Relayer:      common.HexToAddress("0x1"),
+		Forwarder:    forwarder,
+		AccountSlots: 2,
+		Targets:      []common.Address{target, denied},
+		Validators:   []string{"test"},
+	}, chainID, func(ctx context.Context, call *SponsoredCall) error {
+		if call.To == denied {
+			return errDenied
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	call := func(to common.Address, data []byte, gas uint64, nonce uint64) *SponsoredCall {
+		c := &SponsoredCall{From: from, To: to, Data: data, Gas: hexutil.Uint64(gas), Nonce: hexutil.Uint64(nonce)}
+		c.Signature, _ = crypto.Sign(c.Hash(forwarder, chainID).Bytes(), key)
+		return c
+	}
+	if err := pool.add(context.Background(), call(target, nil, 21000, 0)); err != nil {
+		t.Fatalf("failed to add valid call: %v", err)
+	}
+	forged := call(target, nil, 21000, 1)
+	forged.From = common.HexToAddress("0x2")
+	if err := pool.add(context.Background(), forged); err != errSponsorSignature {
+		t.Errorf("forged call error mismatch: have %v, want %v", err, errSponsorSignature)
+	}
+	if err := pool.add(context.Background(), call(common.HexToAddress("0x8"), nil, 21000, 1)); err == nil {
+		t.Errorf("call to unsponsored target accepted")
+	}
+	if err := pool.add(context.Background(), call(target, nil, DefaultSponsorConfig.MaxGas+1, 1)); err == nil {
+		t.Errorf("call above gas limit accepted")
+	}
+	if err := pool.add(context.Background(), call(target, []byte{0xff}, 21000, 1)); err != errHook {
+		t.Errorf("hook error mismatch: have %v, want %v", err, errHook)
+	}
+	if err := pool.add(context.Background(), call(denied, nil, 21000, 1)); err != errDenied {
+		t.Errorf("admission error mismatch: have %v, want %v", err, errDenied)
+	}
+	if err := pool.add(context.Background(), call(target, nil, 21000, 1)); err != nil {
+		t.Fatalf("failed to add second call: %v", err)
+	}
+	if err := pool.add(context.Background(), call(target, nil, 21000, 1)); err != errSponsorKnown {
+		t.Errorf("duplicate call error mismatch: have %v, want %v", err, errSponsorKnown)
+	}
+	if err := pool.add(context.Background(), call(target, nil, 21000, 2)); err != errSponsorAccountFull {
+		t.Errorf("account limit error mismatch: have %v, want %v", err, errSponsorAccountFull)
+	}
+	if calls := pool.take(); len(calls) != 2 || len(pool.queue) != 0 || len(pool.accounts) != 0 || len(pool.known) != 0 {
+		t.Errorf("take mismatch: took %d, left %d", len(calls), len(pool.queue))
+	}
+	if err := pool.add(context.Background(), call(target, nil, 21000, 1)); err != nil {
+		t.Errorf("failed to add call again once taken: %v", err)
+	}
+}
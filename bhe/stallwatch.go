@@ -0,0 +1,223 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"time"
+)
+
+// StallWatchConfig contains the settings of the sync stall watchdog.
+type StallWatchConfig struct {
+	Timeout   time.Duration // Time without sync progress, despite a peer ahead, deemed a stall; disabled if zero
+	DropPeers bool          // Drop all peers and restart syncing on a stall
+}
+
+// StallEvent is posted when the sync stopped progressing while a peer is ahead,
+// along with the diagnostic state at the time. The local total difficulty is
+// the one of the header chain.
+type StallEvent struct {
+	Since     time.Time      `json:"since"` // Time of the last sync progress
+	Head      hexutil.Uint64 `json:"head"`
+	Peers     int            `json:"peers"`
+	BestPeer  string         `json:"bestPeer,omitempty"`
+	BestTd    *hexutil.Big   `json:"bestTd,omitempty"`
+	LocalTd   *hexutil.Big   `json:"localTd"`
+	Syncing   bool           `json:"syncing"`
+	Progress  interface{}    `json:"progress"`  // Downloader sync progress
+	Recovered bool           `json:"recovered"` // Whether peers were dropped to restart syncing
+}
+
+// stallWatch is the state of the sync stall watchdog.
+type stallWatch struct {
+	last  int64 // Unix nanoseconds of the last sync progress seen, zero if not watching (atomic)
+	feed  event.Feed
+	scope event.SubscriptionScope
+	sub   event.Subscription // Chain heads resetting the stall timer, nil if not watching
+}
+
+// stalled reports whether the chain stalled: it made no progress for longer than
+// the timeout while a peer is ahead of it. A node without peers or level with
+// the best of them isn't stalled, it has nothing to import.
+func stalled(now, lastProgress time.Time, localTd, bestTd *big.Int, timeout time.Duration) bool {
+	return bestTd != nil && bestTd.Cmp(localTd) > 0 && now.Sub(lastProgress) > timeout
+}
+
+// syncMarker is a snapshot of every measure of sync progress: the full, fast
+// and header chain heads, and the blocks and state entries the downloader got.
+// Any of them changing counts as progress, as fast sync advances the latter
+// ones only for most of its duration.
+type syncMarker struct {
+	block, fast, header uint64
+	downloaded, states  uint64
+}
+
+// syncMarker takes a snapshot of the sync progress.
+func (s *BHEereum) syncMarker() syncMarker {
+	progress := s.protocolManager.downloader.Progress()
+	return syncMarker{
+		block:      s.blockchain.CurrentBlock().NumberU64(),
+		fast:       s.blockchain.CurrentFastBlock().NumberU64(),
+		header:     s.blockchain.CurrentHeader().Number.Uint64(),
+		downloaded: progress.CurrentBlock,
+		states:     progress.PulledStates,
+	}
+}
+
+// syncTds returns the total difficulty of the local header chain and of the
+// best peer, nil if there are no peers.
+func (s *BHEereum) syncTds() (local *big.Int, best *big.Int) {
+	head := s.blockchain.CurrentHeader()
+	if local = s.blockchain.GetTd(head.Hash(), head.Number.Uint64()); local == nil {
+		local = new(big.Int)
+	}
+	if peer := s.protocolManager.peers.BestPeer(); peer != nil {
+		_, best = peer.Head()
+	}
+	return local, best
+}
+
+// diagnoseStall collects the state of the sync machinery for a stall report.
+func (s *BHEereum) diagnoseStall(since time.Time) *StallEvent {
+	local, _ := s.syncTds()
+	ev := &StallEvent{
+		Since:    since,
+		Head:     hexutil.Uint64(s.blockchain.CurrentBlock().NumberU64()),
+		Peers:    s.protocolManager.peers.Len(),
+		LocalTd:  (*hexutil.Big)(local),
+		Syncing:  s.protocolManager.downloader.Synchronising(),
+		Progress: s.protocolManager.downloader.Progress(),
+	}
+	if best := s.protocolManager.peers.BestPeer(); best != nil {
+		_, td := best.Head()
+		ev.BestPeer, ev.BestTd = best.id, (*hexutil.Big)(td)
+	}
+	return ev
+}
+
+// recoverStall aborts the running sync and drops every peer, so that syncing
+// starts over with fresh connections.
+func (s *BHEereum) recoverStall() {
+	s.protocolManager.downloader.Cancel()
+	for _, peer := range s.p2pServer.Peers() {
+		peer.Disconnect(p2p.DiscUselessPeer)
+	}
+}
+
+// startStallWatch checks that the sync keeps progressing while a peer is ahead,
+// reporting (and optionally recovering) a stall once per stall.
+func (s *BHEereum) startStallWatch(config StallWatchConfig) {
+	heads := make(chan core.ChainHeadEvent, 16)
+	s.stalls.sub = s.events.SubscribeChainHeadEvent(heads)
+
+	go func() {
+		interval := config.Timeout / 4
+		if interval < time.Second {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var (
+			lastProgress = time.Now()
+			marker       = s.syncMarker()
+			reported     bool
+		)
+		progressed := func(now time.Time) {
+			if reported {
+				log.Info("Chain sync resumed", "stalled", common.PrettyDuration(now.Sub(lastProgress)))
+			}
+			lastProgress, reported = now, false
+			atomic.StoreInt64(&s.stalls.last, lastProgress.UnixNano())
+		}
+		atomic.StoreInt64(&s.stalls.last, lastProgress.UnixNano())
+		for {
+			select {
+			case <-heads:
+				progressed(time.Now())
+			case now := <-ticker.C:
+				if current := s.syncMarker(); current != marker {
+					marker = current
+					progressed(now)
+					continue
+				}
+				local, best := s.syncTds()
+				if !stalled(now, lastProgress, local, best, config.Timeout) {
+					if best == nil || best.Cmp(local) <= 0 {
+						lastProgress = now // Time with nothing to sync doesn't count towards a stall
+					}
+					continue
+				}
+				if reported {
+					continue
+				}
+				ev := s.diagnoseStall(lastProgress)
+				log.Warn("Chain sync stalled", "since", common.PrettyDuration(now.Sub(lastProgress)), "head", ev.Head, "peers", ev.Peers, "best", ev.BestPeer, "besttd", ev.BestTd, "localtd", ev.LocalTd, "syncing", ev.Syncing, "progress", ev.Progress)
+				if config.DropPeers {
+					log.Warn("Dropping all peers to restart sync")
+					s.recoverStall()
+					ev.Recovered = true
+				}
+				s.stalls.feed.Send(*ev)
+				reported = true
+			case <-s.stalls.sub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// Stalls creates a subscription notified whenever the chain import stalls.
+func (api *PrivateAdminAPI) Stalls(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan StallEvent, 16)
+		eventSub := api.BHE.stalls.scope.Track(api.BHE.stalls.feed.Subscribe(events))
+		defer eventSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(sub.ID, ev)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			case <-eventSub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// DiagnoseSync returns the state of the sync machinery, as reported on stalls.
+// The time of the last import is only known while the watchdog runs.
+func (api *PrivateAdminAPI) DiagnoseSync() *StallEvent {
+	var since time.Time
+	if last := atomic.LoadInt64(&api.BHE.stalls.last); last != 0 {
+		since = time.Unix(0, last)
+	}
+	return api.BHE.diagnoseStall(since)
+}
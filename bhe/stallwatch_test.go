@@ -0,0 +1,50 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Tests that only nodes behind a peer and without recent progress are deemed
+// stalled.
+func TestStalled(t *testing.T) {
+	var (
+		now     = time.Now()
+		timeout = 10 * time.Minute
+		local   = big.NewInt(100)
+	)
+	tests := []struct {
+		last    time.Time
+		best    *big.Int
+		stalled bool
+	}{
+		{now.Add(-time.Minute), big.NewInt(200), false},
+		{now.Add(-timeout), big.NewInt(200), false},
+		{now.Add(-timeout - time.Second), big.NewInt(200), true},
+		{now.Add(-time.Hour), nil, false},
+		{now.Add(-time.Hour), big.NewInt(100), false},
+		{now.Add(-time.Hour), big.NewInt(50), false},
+	}
+	for i, tt := range tests {
+		if have := stalled(now, tt.last, local, tt.best, timeout); have != tt.stalled {
+			t.Errorf("test %d: stall mismatch: have %v, want %v", i, have, tt.stalled)
+		}
+	}
+}
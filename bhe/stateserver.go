@@ -0,0 +1,329 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// stateServerRecentBlocks is the number of blocks below the head whose state
+	// roots are served. Older roots may already be pruned from the trie cache.
+	stateServerRecentBlocks = 128
+
+	// stateServerMaxItems is the maximum number of trie entries in one response.
+	stateServerMaxItems = 1024
+
+	// stateServerSoftLimit is the target maximum response size in bytes. A range
+	// is cut short once it is exceeded, even if below the item limit.
+	stateServerSoftLimit = 2 * 1024 * 1024
+
+	defaultStateServerRate  = 5  // Requests per second allowed per client
+	defaultStateServerBurst = 20 // Requests allowed in a burst per client
+)
+
+var (
+	errStateRootTooOld = errors.New("state root not recent")
+	errStateRateLimit  = errors.New("rate limit exceeded")
+)
+
+// StateServerConfig contains the settings of the HTTP state range server.
+type StateServerConfig struct {
+	ListenAddr string  // Network address to serve state ranges on, disabled if empty
+	Rate       float64 // Requests per second allowed per client address
+	Burst      int     // Requests allowed in a burst per client address
+}
+
+// StateRangeEntry is a single trie leaf of a served state range.
+type StateRangeEntry struct {
+	Hash  common.Hash   `json:"hash"`
+	Value hexutil.Bytes `json:"value"`
+}
+
+// StateRangeResponse is a contiguous range of account or storage trie leaves
+// along with the Merkle proofs of its boundaries, so a client can verify the
+// range is complete against the state root.
+type StateRangeResponse struct {
+	Root    common.Hash        `json:"root"`
+	Number  hexutil.Uint64     `json:"number"`
+	Account *common.Address    `json:"account,omitempty"` // Owner of a storage range
+	Entries []*StateRangeEntry `json:"entries"`
+	Proof   []hexutil.Bytes    `json:"proof"`
+	More    bool               `json:"more"` // Whether leaves exist beyond the range
+}
+
+// proofList collects the trie nodes of a Merkle proof.
+type proofList []hexutil.Bytes
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error {
+	panic("not supported")
+}
+
+// stateServer serves contiguous account and storage ranges of recent state
+// roots over plain HTTP, with boundary proofs, so that clients without devp2p
+// (e.g. mobile wallets) can sync and verify selected parts of the state.
+type stateServer struct {
+	BHE     *BHEereum
+	config  StateServerConfig
+	limiter *rateLimiter
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// newStateServer creates a state range server, sanitizing the rate limits.
+func newStateServer(BHE *BHEereum, config StateServerConfig) *stateServer {
+	if config.Rate <= 0 {
+		log.Warn("Sanitizing invalid state server rate", "provided", config.Rate, "updated", defaultStateServerRate)
+		config.Rate = defaultStateServerRate
+	}
+	if config.Burst <= 0 {
+		log.Warn("Sanitizing invalid state server burst", "provided", config.Burst, "updated", defaultStateServerBurst)
+		config.Burst = defaultStateServerBurst
+	}
+	return &stateServer{
+		BHE:     BHE,
+		config:  config,
+		limiter: newRateLimiter(config.Rate, config.Burst),
+	}
+}
+
+// start opens the listener and starts serving requests in the background.
+func (s *stateServer) start() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("state server: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/accounts", s.limit(s.serveAccounts))
+	mux.HandleFunc("/storage", s.limit(s.serveStorage))
+
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go s.server.Serve(listener)
+
+	log.Info("State range server started", "addr", listener.Addr(), "rate", s.config.Rate, "burst", s.config.Burst)
+	return nil
+}
+
+// stop terminates the server, if it is running.
+func (s *stateServer) stop() {
+	if s == nil || s.server == nil {
+		return
+	}
+	s.server.Close()
+	log.Info("State range server stopped", "addr", s.listener.Addr())
+}
+
+// limit wraps a handler with the per-client rate limiter.
+func (s *stateServer) limit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if !s.limiter.allow(client, time.Now()) {
+			http.Error(w, errStateRateLimit.Error(), http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// recentHeader returns the header of a recent canonical block with the given
+// state root.
+func (s *stateServer) recentHeader(root common.Hash) (*types.Header, error) {
+	header := s.BHE.blockchain.CurrentHeader()
+	for i := 0; i < stateServerRecentBlocks && header != nil; i++ {
+		if header.Root == root {
+			return header, nil
+		}
+		header = s.BHE.blockchain.GBHEeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	return nil, errStateRootTooOld
+}
+
+// parseRange extracts the common root, start and limit query parameters.
+func parseRange(r *http.Request) (root common.Hash, start common.Hash, limit int, err error) {
+	query := r.URL.Query()
+	if err = root.UnmarshalText([]byte(query.Get("root"))); err != nil {
+		return root, start, 0, fmt.Errorf("invalid root: %v", err)
+	}
+	if v := query.Get("start"); v != "" {
+		if err = start.UnmarshalText([]byte(v)); err != nil {
+			return root, start, 0, fmt.Errorf("invalid start: %v", err)
+		}
+	}
+	limit = stateServerMaxItems
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return root, start, 0, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > stateServerMaxItems {
+			limit = stateServerMaxItems
+		}
+	}
+	return root, start, limit, nil
+}
+
+// serveAccounts serves a range of the account trie of a recent root.
+//
+//	GET /accounts?root=<hash>&start=<hash>&limit=<n>
+func (s *stateServer) serveAccounts(w http.ResponseWriter, r *http.Request) {
+	root, start, limit, err := parseRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	header, err := s.recentHeader(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	tr, err := s.BHE.blockchain.StateCache().OpenTrie(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	res, err := serveRange(tr, start, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Root, res.Number = root, hexutil.Uint64(header.Number.Uint64())
+	writeStateRange(w, res)
+}
+
+// serveStorage serves a range of the storage trie of an account at a recent
+// root. The account itself is proven against the state root as well.
+//
+//	GET /storage?root=<hash>&account=<address>&start=<hash>&limit=<n>
+func (s *stateServer) serveStorage(w http.ResponseWriter, r *http.Request) {
+	root, start, limit, err := parseRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var account common.Address
+	if err := account.UnmarshalText([]byte(r.URL.Query().Get("account"))); err != nil {
+		http.Error(w, fmt.Sprintf("invalid account: %v", err), http.StatusBadRequest)
+		return
+	}
+	header, err := s.recentHeader(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	db := s.BHE.blockchain.StateCache()
+	accTrie, err := db.OpenTrie(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	blob, err := accTrie.TryGet(account.Bytes())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if blob == nil {
+		http.Error(w, "unknown account", http.StatusNotFound)
+		return
+	}
+	var acc state.Account
+	if err := rlp.DecodeBytes(blob, &acc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	addrHash := crypto.Keccak256Hash(account.Bytes())
+	storage, err := db.OpenStorageTrie(addrHash, acc.Root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := serveRange(storage, start, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Prepend the account proof so the storage root is verifiable too
+	var proof proofList
+	if err := accTrie.Prove(addrHash.Bytes(), 0, &proof); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res.Proof = append(proof, res.Proof...)
+	res.Root, res.Number, res.Account = root, hexutil.Uint64(header.Number.Uint64()), &account
+	writeStateRange(w, res)
+}
+
+// serveRange collects the leaves of a trie starting at the given key, up to the
+// item and size limits, and proves the first and last keys of the range.
+func serveRange(tr state.Trie, start common.Hash, limit int) (*StateRangeResponse, error) {
+	var (
+		res  = &StateRangeResponse{Entries: []*StateRangeEntry{}}
+		size int
+	)
+	it := trie.NewIterator(tr.NodeIterator(start.Bytes()))
+	for it.Next() {
+		if len(res.Entries) >= limit || size >= stateServerSoftLimit {
+			res.More = true
+			break
+		}
+		res.Entries = append(res.Entries, &StateRangeEntry{
+			Hash:  common.BytesToHash(it.Key),
+			Value: common.CopyBytes(it.Value),
+		})
+		size += common.HashLength + len(it.Value)
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	var proof proofList
+	if err := tr.Prove(start.Bytes(), 0, &proof); err != nil {
+		return nil, err
+	}
+	if n := len(res.Entries); n > 0 {
+		if err := tr.Prove(res.Entries[n-1].Hash.Bytes(), 0, &proof); err != nil {
+			return nil, err
+		}
+	}
+	res.Proof = proof
+	return res, nil
+}
+
+// writeStateRange encodes a state range response as JSON.
+func writeStateRange(w http.ResponseWriter, res *StateRangeResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Debug("Failed to write state range", "err", err)
+	}
+}
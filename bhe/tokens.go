@@ -0,0 +1,235 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// tokenTransferPageSize is the default and maximum number of transfers
+	// returned by a single query.
+	tokenTransferPageSize = 256
+
+	erc20  = "erc20"
+	erc721 = "erc721"
+)
+
+var (
+	// tokenTransferPrefix is the database key prefix of the token transfer index:
+	// tokenTransferPrefix + account + block number + log index -> RLP(TokenTransfer)
+	// with an entry for both the sender and the recipient of every transfer.
+	tokenTransferPrefix = []byte("BHE-tokens-")
+
+	// transferTopic is the event signature shared by ERC-20 and ERC-721 transfers,
+	// keccak256("Transfer(address,address,uint256)").
+	transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+)
+
+// TokenTransfer is a decoded ERC-20 or ERC-721 Transfer event. For ERC-20 the
+// value is the amount transferred, for ERC-721 the identifier of the token.
+type TokenTransfer struct {
+	Standard    string         `json:"standard"`
+	Token       common.Address `json:"token"`
+	From        common.Address `json:"from"`
+	To          common.Address `json:"to"`
+	Value       *hexutil.Big   `json:"value"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	TxHash      common.Hash    `json:"transactionHash"`
+	LogIndex    hexutil.Uint   `json:"logIndex"`
+}
+
+// storedTokenTransfer is the database representation of a token transfer.
+type storedTokenTransfer struct {
+	Standard  string
+	Token     common.Address
+	From      common.Address
+	To        common.Address
+	Value     *big.Int
+	BlockHash common.Hash
+	TxHash    common.Hash
+}
+
+// decodeTokenTransfer decodes a standard Transfer event, returning nil if the
+// log isn't one. ERC-20 carries the amount in the data, ERC-721 indexes the
+// token identifier as a fourth topic.
+func decodeTokenTransfer(l *types.Log) *storedTokenTransfer {
+	if len(l.Topics) < 3 || l.Topics[0] != transferTopic {
+		return nil
+	}
+	transfer := &storedTokenTransfer{
+		Token:     l.Address,
+		From:      common.BytesToAddress(l.Topics[1].Bytes()),
+		To:        common.BytesToAddress(l.Topics[2].Bytes()),
+		BlockHash: l.BlockHash,
+		TxHash:    l.TxHash,
+	}
+	switch {
+	case len(l.Topics) == 3 && len(l.Data) == 32:
+		transfer.Standard, transfer.Value = erc20, new(big.Int).SetBytes(l.Data)
+	case len(l.Topics) == 4 && len(l.Data) == 0:
+		transfer.Standard, transfer.Value = erc721, l.Topics[3].Big()
+	default:
+		return nil
+	}
+	return transfer
+}
+
+// tokenTransferKey = tokenTransferPrefix + account + block number (uint64 big endian) + log index (uint32 big endian)
+func tokenTransferKey(account common.Address, number uint64, index uint) []byte {
+	key := make([]byte, len(tokenTransferPrefix)+common.AddressLength+12)
+	copy(key, tokenTransferPrefix)
+	copy(key[len(tokenTransferPrefix):], account.Bytes())
+	binary.BigEndian.PutUint64(key[len(tokenTransferPrefix)+common.AddressLength:], number)
+	binary.BigEndian.PutUint32(key[len(tokenTransferPrefix)+common.AddressLength+8:], uint32(index))
+	return key
+}
+
+// indexTokenTransfers stores the transfers among the logs of a canonical block.
+// Entries of blocks reorged out are left in place and filtered on retrieval,
+// as a replacement block overwrites only the positions it uses itself.
+func indexTokenTransfers(db BHEdb.KeyValueWriter, block *types.Block, logs []*types.Log) int {
+	var count int
+	for _, l := range logs {
+		transfer := decodeTokenTransfer(l)
+		if transfer == nil {
+			continue
+		}
+		blob, err := rlp.EncodeToBytes(transfer)
+		if err != nil {
+			log.Crit("Failed to encode token transfer", "err", err)
+		}
+		for _, account := range []common.Address{transfer.From, transfer.To} {
+			if err := db.Put(tokenTransferKey(account, block.NumberU64(), l.Index), blob); err != nil {
+				log.Crit("Failed to store token transfer", "err", err)
+			}
+		}
+		count++
+	}
+	return count
+}
+
+// startTokenIndex starts indexing the token transfers of blocks as they become
+// canonical, following the event sequencer to also catch blocks of side chains
+// promoted by a reorg.
+func (s *BHEereum) startTokenIndex() {
+	events := make(chan core.ChainEvent, 64)
+	s.tokenIndexSub = s.events.SubscribeChainEvent(events)
+
+	go func() {
+		for {
+			select {
+			case ev := <-events:
+				batch := s.chainDb.NewBatch()
+				if n := indexTokenTransfers(batch, ev.Block, ev.Logs); n > 0 {
+					if err := batch.Write(); err != nil {
+						log.Crit("Failed to write token transfer index", "err", err)
+					}
+					log.Debug("Indexed token transfers", "number", ev.Block.Number(), "hash", ev.Hash, "transfers", n)
+				}
+			case <-s.tokenIndexSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// TokenTransferQuery filters and pages the token transfers of an account.
+type TokenTransferQuery struct {
+	FromBlock *hexutil.Uint64 `json:"fromBlock"` // First block to include, genesis if nil
+	ToBlock   *hexutil.Uint64 `json:"toBlock"`   // Last block to include, head if nil
+	Token     *common.Address `json:"token"`     // Only transfers of this token contract
+	Limit     int             `json:"limit"`     // Maximum number of transfers, at most 256
+	Cursor    hexutil.Bytes   `json:"cursor"`    // Continuation of a previous page
+}
+
+// TokenTransferPage is a page of token transfers, oldest first.
+type TokenTransferPage struct {
+	Transfers []*TokenTransfer `json:"transfers"`
+	Cursor    hexutil.Bytes    `json:"cursor,omitempty"` // Continuation if more transfers exist
+}
+
+// GetTokenTransfers returns the ERC-20 and ERC-721 transfers sent or received
+// by an account, as recorded by the optional token transfer index.
+func (api *PublicBHEereumAPI) GetTokenTransfers(ctx context.Context, account common.Address, query *TokenTransferQuery) (*TokenTransferPage, error) {
+	if !api.e.config.TokenIndex {
+		return nil, fmt.Errorf("token transfer index disabled")
+	}
+	if query == nil {
+		query = new(TokenTransferQuery)
+	}
+	limit := query.Limit
+	if limit <= 0 || limit > tokenTransferPageSize {
+		limit = tokenTransferPageSize
+	}
+	var from, to uint64 = 0, api.e.blockchain.CurrentBlock().NumberU64()
+	if query.FromBlock != nil {
+		from = uint64(*query.FromBlock)
+	}
+	if query.ToBlock != nil && uint64(*query.ToBlock) < to {
+		to = uint64(*query.ToBlock)
+	}
+	start := tokenTransferKey(account, from, 0)
+	if len(query.Cursor) > 0 {
+		if len(query.Cursor) != len(start) {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		start = query.Cursor
+	}
+	prefix := start[:len(tokenTransferPrefix)+common.AddressLength]
+	it := api.e.chainDb.NewIterator(prefix, start[len(prefix):])
+	defer it.Release()
+
+	page := &TokenTransferPage{Transfers: []*TokenTransfer{}}
+	for it.Next() {
+		key := it.Key()
+		number := binary.BigEndian.Uint64(key[len(prefix):])
+		if number > to {
+			break
+		}
+		if len(page.Transfers) == limit {
+			page.Cursor = common.CopyBytes(key)
+			break
+		}
+		var transfer storedTokenTransfer
+		if err := rlp.DecodeBytes(it.Value(), &transfer); err != nil {
+			return nil, err
+		}
+		if api.e.blockchain.GetCanonicalHash(number) != transfer.BlockHash {
+			continue // Left over from a reorged block
+		}
+		if query.Token != nil && transfer.Token != *query.Token {
+			continue
+		}
+		page.Transfers = append(page.Transfers, &TokenTransfer{
+			Standard:    transfer.Standard,
+			Token:       transfer.Token,
+			From:        transfer.From,
+			To:          transfer.To,
+			Value:       (*hexutil.Big)(transfer.Value),
+			BlockNumber: hexutil.Uint64(number),
+			BlockHash:   transfer.BlockHash,
+			TxHash:      transfer.TxHash,
+			LogIndex:    hexutil.Uint(binary.BigEndian.Uint32(key[len(prefix)+8:])),
+		})
+	}
+	return page, it.Error()
+}
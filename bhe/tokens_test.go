@@ -0,0 +1,79 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Tests that ERC-20 and ERC-721 Transfer events are told apart by their layout
+// and that other logs are ignored.
+func TestDecodeTokenTransfer(t *testing.T) {
+	var (
+		token = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		from  = common.HexToHash("0x2")
+		to    = common.HexToHash("0x3")
+		id    = common.HexToHash("0x2a")
+	)
+	tests := []struct {
+		log      *types.Log
+		standard string
+		value    uint64
+	}{
+		{&types.Log{Address: token, Topics: []common.Hash{transferTopic, from, to}, Data: id.Bytes()}, erc20, 42},
+		{&types.Log{Address: token, Topics: []common.Hash{transferTopic, from, to, id}}, erc721, 42},
+		{&types.Log{Address: token, Topics: []common.Hash{transferTopic, from, to}}, "", 0},
+		{&types.Log{Address: token, Topics: []common.Hash{transferTopic, from, to, id}, Data: id.Bytes()}, "", 0},
+		{&types.Log{Address: token, Topics: []common.Hash{common.HexToHash("0x1"), from, to}, Data: id.Bytes()}, "", 0},
+	}
+	for i, tt := range tests {
+		transfer := decodeTokenTransfer(tt.log)
+		if tt.standard == "" {
+			if transfer != nil {
+				t.Errorf("test %d: decoded non-transfer log as %s", i, transfer.Standard)
+			}
+			continue
+		}
+		if transfer == nil {
+			t.Errorf("test %d: failed to decode transfer", i)
+			continue
+		}
+		if transfer.Standard != tt.standard || transfer.Value.Uint64() != tt.value {
+			t.Errorf("test %d: transfer mismatch: have %s/%v, want %s/%d", i, transfer.Standard, transfer.Value, tt.standard, tt.value)
+		}
+		if transfer.Token != token || transfer.From != common.BytesToAddress(from.Bytes()) || transfer.To != common.BytesToAddress(to.Bytes()) {
+			t.Errorf("test %d: parties mismatch: have %x %x->%x", i, transfer.Token, transfer.From, transfer.To)
+		}
+	}
+}
+
+// Tests that the index keys of an account sort by block and log position.
+func TestTokenTransferKeyOrder(t *testing.T) {
+	account := common.HexToAddress("0x1")
+	keys := [][]byte{
+		tokenTransferKey(account, 1, 5),
+		tokenTransferKey(account, 2, 0),
+		tokenTransferKey(account, 256, 1),
+		tokenTransferKey(account, 256, 300),
+	}
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			t.Errorf("key %d not ordered after key %d", i, i-1)
+		}
+	}
+}
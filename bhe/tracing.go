@@ -0,0 +1,165 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation within a trace.
+type Span interface {
+	// SetAttributes annotates the span with key-value pairs.
+	SetAttributes(kv ...interface{})
+
+	// RecordError marks the span failed.
+	RecordError(err error)
+
+	// End finishes the span.
+	End()
+}
+
+// SpanTracer starts spans as children of the span carried by a context, if any.
+// An OpenTelemetry exporter plugs in by registering an adapter around its
+// tracer; spans started by the RPC layer then continue into the node.
+type SpanTracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+var (
+	spanTracers     = map[string]SpanTracer{"log": logTracer{}}
+	spanTracersLock sync.RWMutex
+)
+
+// RegisterSpanTracer makes a span tracer available by name, to be selected by
+// the node configuration. It panics if the name is taken.
+func RegisterSpanTracer(name string, tracer SpanTracer) {
+	spanTracersLock.Lock()
+	defer spanTracersLock.Unlock()
+
+	if _, ok := spanTracers[name]; ok {
+		panic(fmt.Sprintf("span tracer %q already registered", name))
+	}
+	spanTracers[name] = tracer
+}
+
+// lookupSpanTracer returns the span tracer registered by name.
+func lookupSpanTracer(name string) (SpanTracer, error) {
+	spanTracersLock.RLock()
+	defer spanTracersLock.RUnlock()
+
+	tracer, ok := spanTracers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown span tracer %q", name)
+	}
+	return tracer, nil
+}
+
+// noopSpan is the span of untraced operations.
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(kv ...interface{}) {}
+func (noopSpan) RecordError(err error)           {}
+func (noopSpan) End()                            {}
+
+// finishSpan ends a span, recording the error pointed to if set. It is meant to
+// be deferred with the named error result of the traced function.
+func finishSpan(span Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+	}
+	span.End()
+}
+
+// startSpan starts a span of an API backend operation if tracing is enabled.
+func (b *BHEAPIBackend) startSpan(ctx context.Context, name string, kv ...interface{}) (context.Context, Span) {
+	if b.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := b.tracer.Start(ctx, name)
+	if len(kv) > 0 {
+		span.SetAttributes(kv...)
+	}
+	return ctx, span
+}
+
+// logSpanKey is the context key of the span started by the log tracer.
+type logSpanKey struct{}
+
+// logTracer is a built-in span tracer logging every span when it ends, for
+// operators without a tracing backend.
+type logTracer struct{}
+
+// Start implements SpanTracer.
+func (logTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &logSpan{name: name, id: randomSpanID(8), start: time.Now()}
+	if parent, ok := ctx.Value(logSpanKey{}).(*logSpan); ok {
+		span.trace, span.parent = parent.trace, parent.id
+	} else {
+		span.trace = randomSpanID(16)
+	}
+	return context.WithValue(ctx, logSpanKey{}, span), span
+}
+
+// logSpan is a span of the log tracer.
+type logSpan struct {
+	name   string
+	trace  string
+	id     string
+	parent string
+	start  time.Time
+	attrs  []interface{}
+	err    error
+	lock   sync.Mutex
+}
+
+func (s *logSpan) SetAttributes(kv ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.attrs = append(s.attrs, kv...)
+}
+
+func (s *logSpan) RecordError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.err = err
+}
+
+func (s *logSpan) End() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ctx := []interface{}{"span", s.name, "trace", s.trace, "id", s.id, "parent", s.parent, "elapsed", common.PrettyDuration(time.Since(s.start))}
+	ctx = append(ctx, s.attrs...)
+	if s.err != nil {
+		ctx = append(ctx, "err", s.err)
+	}
+	log.Debug("Traced span", ctx...)
+}
+
+// randomSpanID generates a hex encoded random identifier of the given size.
+func randomSpanID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Tests that spans of the log tracer started from a context carrying a span
+// join its trace as children, and that failures are recorded on the span.
+func TestLogTracerPropagation(t *testing.T) {
+	tracer, err := lookupSpanTracer("log")
+	if err != nil {
+		t.Fatalf("built-in tracer missing: %v", err)
+	}
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+
+	parent, span := root.(*logSpan), child.(*logSpan)
+	if parent.parent != "" {
+		t.Errorf("root span has parent %s", parent.parent)
+	}
+	if span.trace != parent.trace || span.parent != parent.id {
+		t.Errorf("child span mismatch: have trace %s parent %s, want trace %s parent %s", span.trace, span.parent, parent.trace, parent.id)
+	}
+	failure := errors.New("failure")
+	finishSpan(child, &failure)
+	if span.err != failure {
+		t.Errorf("span error mismatch: have %v, want %v", span.err, failure)
+	}
+	if _, err := lookupSpanTracer("missing"); err == nil {
+		t.Errorf("unknown tracer found")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"sync"
+)
+
+// TxBumpConfig contains the gas price escalation policy of stuck local
+// transactions.
+type TxBumpConfig struct {
+	Blocks   uint64   // Blocks a local transaction may stay pending before it is bumped, 0 disables
+	Percent  uint64   // Gas price increase per bump, in percent
+	MaxPrice *big.Int // Gas price never bumped beyond
+}
+
+// DefaultTxBumpConfig contains the default fee bumping policy, disabled.
+var DefaultTxBumpConfig = TxBumpConfig{
+	Percent:  10,
+	MaxPrice: big.NewInt(500 * params.GWei),
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *TxBumpConfig) sanitize() TxBumpConfig {
+	conf := *config
+	if conf.Percent < 1 {
+		log.Warn("Sanitizing invalid fee bump percentage", "provided", conf.Percent, "updated", DefaultTxBumpConfig.Percent)
+		conf.Percent = DefaultTxBumpConfig.Percent
+	}
+	if conf.MaxPrice == nil || conf.MaxPrice.Sign() <= 0 {
+		log.Warn("Sanitizing invalid fee bump maximum price", "provided", conf.MaxPrice, "updated", DefaultTxBumpConfig.MaxPrice)
+		conf.MaxPrice = new(big.Int).Set(DefaultTxBumpConfig.MaxPrice)
+	}
+	return conf
+}
+
+// bumpPrice returns the gas price to re-sign a transaction priced at old with,
+// raised by percent but at least as much as the pool requires of a replacement,
+// and capped at max. False is returned if the cap leaves no room for a bump.
+func bumpPrice(old *big.Int, percent, poolBump uint64, max *big.Int) (*big.Int, bool) {
+	price := new(big.Int).Mul(old, new(big.Int).SetUint64(100+percent))
+	price.Div(price, big.NewInt(100))
+
+	min := replacementPrice(old, poolBump)
+	if price.Cmp(min) < 0 {
+		price = min
+	}
+	if price.Cmp(max) > 0 {
+		if max.Cmp(min) < 0 {
+			return nil, false
+		}
+		price = new(big.Int).Set(max)
+	}
+	return price, true
+}
+
+// txBumper tracks how long the local transactions of the pool have been stuck
+// pending, so that the ones not mined in time get re-signed at a higher price.
+type txBumper struct {
+	config TxBumpConfig
+	seen   map[common.Hash]uint64 // Head numbers the transactions were last (re)timed at
+	lock   sync.Mutex
+}
+
+func newTxBumper(config TxBumpConfig) *txBumper {
+	return &txBumper{
+		config: config.sanitize(),
+		seen:   make(map[common.Hash]uint64),
+	}
+}
+
+// due returns the pending local transactions waiting for at least the configured
+// number of blocks, restarting their timers so a transaction that can't be
+// bumped isn't retried on every block. Transactions no longer pending are
+// forgotten.
+func (b *txBumper) due(pending map[common.Address]types.Transactions, locals map[common.Address]bool, head uint64) []*types.Transaction {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var (
+		live  = make(map[common.Hash]uint64, len(b.seen))
+		stuck []*types.Transaction
+	)
+	for addr, txs := range pending {
+		if !locals[addr] {
+			continue
+		}
+		for _, tx := range txs {
+			hash := tx.Hash()
+			since, ok := b.seen[hash]
+			if !ok {
+				since = head
+			}
+			if head >= since+b.config.Blocks {
+				stuck = append(stuck, tx)
+				since = head
+			}
+			live[hash] = since
+		}
+	}
+	b.seen = live
+	return stuck
+}
+
+// bumpLocalTxs re-signs the stuck local transactions at an escalated gas price
+// and resubmits them, replacing the originals in the pool.
+func (s *BHEereum) bumpLocalTxs(head uint64) {
+	pending, err := s.txPool.Pending()
+	if err != nil {
+		return
+	}
+	locals := make(map[common.Address]bool)
+	for _, addr := range s.txPool.Locals() {
+		locals[addr] = true
+	}
+	signer := types.MakeSigner(s.blockchain.Config(), new(big.Int).SetUint64(head))
+	for _, tx := range s.bumper.due(pending, locals, head) {
+		price, ok := bumpPrice(tx.GasPrice(), s.bumper.config.Percent, s.config.TxPool.PriceBump, s.bumper.config.MaxPrice)
+		if !ok {
+			log.Debug("Stuck local transaction at maximum price", "hash", tx.Hash(), "gasprice", tx.GasPrice())
+			continue
+		}
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			continue
+		}
+		wallet, err := s.signerWallet(from)
+		if err != nil {
+			log.Warn("Failed to bump local transaction", "hash", tx.Hash(), "from", from, "err", err)
+			continue
+		}
+		var bumped *types.Transaction
+		if to := tx.To(); to != nil {
+			bumped = types.NewTransaction(tx.Nonce(), *to, tx.Value(), tx.Gas(), price, tx.Data())
+		} else {
+			bumped = types.NewContractCreation(tx.Nonce(), tx.Value(), tx.Gas(), price, tx.Data())
+		}
+		signTx := s.signingAudit.wrapTx("txbump", wallet.SignTx)
+		signed, err := signTx(accounts.Account{Address: from}, bumped, s.blockchain.Config().ChainID)
+		if err != nil {
+			log.Warn("Failed to bump local transaction", "hash", tx.Hash(), "from", from, "err", err)
+			continue
+		}
+		if err := s.txPool.AddLocal(signed); err != nil {
+			log.Warn("Failed to bump local transaction", "hash", tx.Hash(), "from", from, "err", err)
+			continue
+		}
+		log.Info("Bumped stuck local transaction", "old", tx.Hash(), "hash", signed.Hash(), "from", from, "nonce", tx.Nonce(), "gasprice", price)
+	}
+}
+
+// startTxBumper checks the local transactions for stuck ones on every new head.
+func (s *BHEereum) startTxBumper() {
+	heads := make(chan core.ChainHeadEvent, 16)
+	s.bumperSub = s.events.SubscribeChainHeadEvent(heads)
+
+	go func() {
+		for {
+			select {
+			case ev := <-heads:
+				s.bumpLocalTxs(ev.Block.NumberU64())
+			case <-s.bumperSub.Err():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that bumped prices escalate by the configured percentage, satisfy the
+// replacement rules of the pool and respect the maximum price.
+func TestBumpPrice(t *testing.T) {
+	tests := []struct {
+		old     int64
+		percent uint64
+		max     int64
+		want    int64
+		ok      bool
+	}{
+		{100, 20, 1000, 120, true},
+		{100, 5, 1000, 110, true}, // The pool demands a 10% bump
+		{100, 20, 115, 115, true}, // Capped, but still a valid replacement
+		{100, 20, 105, 0, false},  // Cap below a valid replacement
+		{1000, 20, 1000, 0, false},
+	}
+	for i, tt := range tests {
+		have, ok := bumpPrice(big.NewInt(tt.old), tt.percent, 10, big.NewInt(tt.max))
+		if ok != tt.ok {
+			t.Errorf("test %d: bumpable mismatch: have %v, want %v", i, ok, tt.ok)
+			continue
+		}
+		if ok && have.Cmp(big.NewInt(tt.want)) != 0 {
+			t.Errorf("test %d: bumped price mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that only local transactions pending for long enough are due, and that
+// their timers restart once reported.
+func TestTxBumperDue(t *testing.T) {
+	var (
+		local  = common.HexToAddress("0xa")
+		remote = common.HexToAddress("0xb")
+		ltx    = types.NewTransaction(0, local, new(big.Int), 21000, big.NewInt(1), nil)
+		rtx    = types.NewTransaction(0, remote, new(big.Int), 21000, big.NewInt(1), nil)
+	)
+	bumper := newTxBumper(TxBumpConfig{Blocks: 3, Percent: 10, MaxPrice: big.NewInt(100)})
+	pending := map[common.Address]types.Transactions{local: {ltx}, remote: {rtx}}
+	locals := map[common.Address]bool{local: true}
+
+	for head := uint64(10); head < 13; head++ {
+		if due := bumper.due(pending, locals, head); len(due) != 0 {
+			t.Fatalf("head %d: unexpected due transactions: %d", head, len(due))
+		}
+	}
+	if due := bumper.due(pending, locals, 13); len(due) != 1 || due[0] != ltx {
+		t.Fatalf("stuck local transaction not due: %v", due)
+	}
+	if due := bumper.due(pending, locals, 14); len(due) != 0 {
+		t.Fatalf("timer not restarted after bump")
+	}
+	bumper.due(nil, locals, 15)
+	if len(bumper.seen) != 0 {
+		t.Fatalf("departed transactions not forgotten: %d", len(bumper.seen))
+	}
+}
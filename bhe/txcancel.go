@@ -0,0 +1,86 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var errTxNotPending = errors.New("transaction not in the pool")
+
+// replacementPrice returns the minimum gas price a transaction has to pay to
+// replace one priced at old in the pool, which demands a strictly higher price
+// exceeding the old one by at least bump percent.
+func replacementPrice(old *big.Int, bump uint64) *big.Int {
+	price := new(big.Int).Mul(old, new(big.Int).SetUint64(100+bump))
+	price.Div(price, big.NewInt(100))
+	if price.Cmp(old) <= 0 {
+		price.Add(old, common.Big1)
+	}
+	return price
+}
+
+// cancelTx replaces a pooled transaction of a managed account with a plain
+// value-less transfer to itself using the same nonce, priced high enough for the
+// pool to accept the replacement. A gas price below the replacement minimum is
+// rejected, if none is given the minimum is used.
+func (s *BHEereum) cancelTx(hash common.Hash, gasPrice *big.Int) (common.Hash, error) {
+	if err := s.writable(); err != nil {
+		return common.Hash{}, err
+	}
+	tx := s.txPool.Get(hash)
+	if tx == nil {
+		return common.Hash{}, errTxNotPending
+	}
+	signer := types.MakeSigner(s.blockchain.Config(), s.blockchain.CurrentBlock().Number())
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	wallet, err := s.signerWallet(from)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	price := replacementPrice(tx.GasPrice(), s.config.TxPool.PriceBump)
+	if gasPrice != nil {
+		if gasPrice.Cmp(price) < 0 {
+			return common.Hash{}, fmt.Errorf("gas price %v below replacement minimum %v", gasPrice, price)
+		}
+		price = gasPrice
+	}
+	cancel := types.NewTransaction(tx.Nonce(), from, new(big.Int), params.TxGas, price, nil)
+	signTx := s.signingAudit.wrapTx("txcancel", wallet.SignTx)
+	signed, err := signTx(accounts.Account{Address: from}, cancel, s.blockchain.Config().ChainID)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := s.txPool.AddLocal(signed); err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Submitted transaction cancellation", "cancelled", hash, "hash", signed.Hash(), "from", from, "nonce", tx.Nonce(), "gasprice", price)
+	return signed.Hash(), nil
+}
+
+// CancelTransaction cancels a pending transaction of an unlocked account by
+// replacing it with a 0-value transfer to the sender, returning the hash of the
+// replacement. The gas price is optional and defaults to the lowest one the pool
+// accepts as a replacement.
+func (api *PublicBHEereumAPI) CancelTransaction(hash common.Hash, gasPrice *hexutil.Big) (common.Hash, error) {
+	return api.e.cancelTx(hash, (*big.Int)(gasPrice))
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that replacement prices clear both the price bump and the old price.
+func TestReplacementPrice(t *testing.T) {
+	tests := []struct {
+		old  int64
+		bump uint64
+		want int64
+	}{
+		{100, 10, 110},
+		{105, 10, 115}, // Rounded down like the pool does
+		{1, 10, 2},     // Rounded down to the old price, must still exceed it
+		{0, 10, 1},
+		{1000, 0, 1001},
+	}
+	for i, tt := range tests {
+		if have := replacementPrice(big.NewInt(tt.old), tt.bump); have.Cmp(big.NewInt(tt.want)) != 0 {
+			t.Errorf("test %d: replacement price mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
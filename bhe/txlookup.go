@@ -0,0 +1,148 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errTxLookupBusy = errors.New("transaction lookup job already running")
+
+// TxLookupJob is a manual (un)indexing run over a range of transaction lookups
+// below the tail maintained by the blockchain.
+type TxLookupJob struct {
+	From    hexutil.Uint64 `json:"from"`
+	To      hexutil.Uint64 `json:"to"`
+	Unindex bool           `json:"unindex"`
+	Started time.Time      `json:"started"`
+
+	interrupt chan struct{}
+}
+
+// TxLookupStatus is the coverage of the transaction lookup index.
+type TxLookupStatus struct {
+	Limit hexutil.Uint64  `json:"limit"` // Number of recent blocks kept indexed, zero for the entire chain
+	Tail  *hexutil.Uint64 `json:"tail"`  // First block indexed by the blockchain, nil if never unindexed
+	Head  hexutil.Uint64  `json:"head"`
+	Job   *TxLookupJob    `json:"job"` // Manual indexing run in progress, if any
+}
+
+// TxLookupStatus returns the blocks whose transactions can be looked up by hash.
+func (api *PrivateAdminAPI) TxLookupStatus() *TxLookupStatus {
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	status := &TxLookupStatus{
+		Limit: hexutil.Uint64(api.BHE.config.TxLookupLimit),
+		Head:  hexutil.Uint64(api.BHE.blockchain.CurrentBlock().NumberU64()),
+		Job:   api.BHE.txLookupJob,
+	}
+	if tail := rawdb.ReadTxIndexTail(api.BHE.chainDb); tail != nil {
+		status.Tail = (*hexutil.Uint64)(tail)
+	}
+	return status
+}
+
+// SetTxLookupLimit changes the number of recent blocks whose transactions are
+// kept indexed. Raising it makes the blockchain index older blocks back from
+// the freezer on the next head, lowering it unindexes them again.
+func (api *PrivateAdminAPI) SetTxLookupLimit(limit hexutil.Uint64) (bool, error) {
+	if err := api.BHE.writable(); err != nil {
+		return false, err
+	}
+	api.BHE.lock.Lock()
+	old := api.BHE.config.TxLookupLimit
+	api.BHE.config.TxLookupLimit = uint64(limit)
+	api.BHE.lock.Unlock()
+
+	api.BHE.blockchain.SetTxLookupLimit(uint64(limit))
+	log.Info("Updated transaction lookup limit", "old", old, "new", uint64(limit))
+	return true, nil
+}
+
+// IndexTxLookups indexes the transactions of the blocks [from, to] in the
+// background, temporarily extending lookup coverage below the maintained tail
+// without changing the limit. The range stays indexed until unindexed with
+// UnindexTxLookups.
+func (api *PrivateAdminAPI) IndexTxLookups(from, to hexutil.Uint64) (bool, error) {
+	return api.BHE.startTxLookupJob(uint64(from), uint64(to), false)
+}
+
+// UnindexTxLookups removes the lookups of the blocks [from, to] indexed earlier
+// with IndexTxLookups.
+func (api *PrivateAdminAPI) UnindexTxLookups(from, to hexutil.Uint64) (bool, error) {
+	return api.BHE.startTxLookupJob(uint64(from), uint64(to), true)
+}
+
+// startTxLookupJob validates and starts a manual (un)indexing run. Only blocks
+// below the tail are accepted, the ones above belong to the blockchain's own
+// index maintenance.
+func (s *BHEereum) startTxLookupJob(from, to uint64, unindex bool) (bool, error) {
+	if err := s.writable(); err != nil {
+		return false, err
+	}
+	if from > to {
+		return false, fmt.Errorf("invalid range [%d, %d]", from, to)
+	}
+	tail := rawdb.ReadTxIndexTail(s.chainDb)
+	if tail == nil {
+		return false, errors.New("entire chain indexed, nothing to do")
+	}
+	if to >= *tail {
+		return false, fmt.Errorf("range [%d, %d] overlaps the maintained index from block %d", from, to, *tail)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.txLookupJob != nil {
+		return false, errTxLookupBusy
+	}
+	job := &TxLookupJob{
+		From:      hexutil.Uint64(from),
+		To:        hexutil.Uint64(to),
+		Unindex:   unindex,
+		Started:   time.Now(),
+		interrupt: make(chan struct{}),
+	}
+	s.txLookupJob = job
+
+	go func() {
+		if unindex {
+			rawdb.UnindexTransactions(s.chainDb, from, to+1, job.interrupt)
+		} else {
+			rawdb.IndexTransactions(s.chainDb, from, to+1, job.interrupt)
+		}
+		s.lock.Lock()
+		s.txLookupJob = nil
+		s.lock.Unlock()
+
+		log.Info("Finished transaction lookup job", "from", from, "to", to, "unindex", unindex, "elapsed", common.PrettyDuration(time.Since(job.Started)))
+	}()
+	return true, nil
+}
+
+// stopTxLookupJob interrupts the manual (un)indexing run in progress, if any.
+func (s *BHEereum) stopTxLookupJob() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.txLookupJob != nil {
+		close(s.txLookupJob.interrupt)
+	}
+}
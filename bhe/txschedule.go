@@ -0,0 +1,422 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// txScheduleEvictionInterval is the time between two sweeps of the schedule for
+// expired transactions.
+const txScheduleEvictionInterval = time.Minute
+
+var (
+	errScheduleFull        = errors.New("transaction schedule full")
+	errScheduleAccountFull = errors.New("transaction schedule full for account")
+	errScheduleUnderpriced = errors.New("replacement scheduled transaction underpriced")
+	errScheduleNonceGap    = errors.New("scheduled nonce too far ahead")
+	errScheduleFunds       = errors.New("insufficient funds for scheduled transactions")
+	errScheduleExpired     = errors.New("scheduled transaction expired")
+)
+
+// TxScheduleConfig contains the limits of the future-nonce transaction schedule.
+type TxScheduleConfig struct {
+	GlobalSlots  int           // Maximum number of scheduled transactions
+	AccountSlots int           // Maximum number of scheduled transactions per account
+	MaxNonceGap  uint64        // Maximum distance of a scheduled nonce from the pool nonce
+	Lifetime     time.Duration // Maximum time a transaction stays scheduled
+}
+
+// DefaultTxScheduleConfig contains the default transaction schedule limits.
+var DefaultTxScheduleConfig = TxScheduleConfig{
+	GlobalSlots:  4096,
+	AccountSlots: 1024,
+	MaxNonceGap:  1024,
+	Lifetime:     3 * time.Hour,
+}
+
+// sanitize checks the provided user configurations and changes anything that's
+// unreasonable or unworkable.
+func (config *TxScheduleConfig) sanitize() TxScheduleConfig {
+	conf := *config
+	if conf.GlobalSlots < 1 {
+		log.Warn("Sanitizing invalid transaction schedule slots", "provided", conf.GlobalSlots, "updated", DefaultTxScheduleConfig.GlobalSlots)
+		conf.GlobalSlots = DefaultTxScheduleConfig.GlobalSlots
+	}
+	if conf.AccountSlots < 1 || conf.AccountSlots > conf.GlobalSlots {
+		log.Warn("Sanitizing invalid transaction schedule account slots", "provided", conf.AccountSlots, "updated", conf.GlobalSlots)
+		conf.AccountSlots = conf.GlobalSlots
+	}
+	if conf.MaxNonceGap < 1 {
+		log.Warn("Sanitizing invalid transaction schedule nonce gap", "provided", conf.MaxNonceGap, "updated", DefaultTxScheduleConfig.MaxNonceGap)
+		conf.MaxNonceGap = DefaultTxScheduleConfig.MaxNonceGap
+	}
+	if conf.Lifetime < 1 {
+		log.Warn("Sanitizing invalid transaction schedule lifetime", "provided", conf.Lifetime, "updated", DefaultTxScheduleConfig.Lifetime)
+		conf.Lifetime = DefaultTxScheduleConfig.Lifetime
+	}
+	return conf
+}
+
+// scheduledTx is a transaction waiting for its predecessors.
+type scheduledTx struct {
+	tx    *types.Transaction
+	from  common.Address
+	local bool
+	added time.Time
+}
+
+// ActivatedTxEvent is posted when a scheduled transaction is handed to the pool
+// after its predecessors arrived, or dropped for waiting beyond its lifetime.
+type ActivatedTxEvent struct {
+	Hash  common.Hash    `json:"hash"`
+	From  common.Address `json:"from"`
+	Nonce hexutil.Uint64 `json:"nonce"`
+	Error string         `json:"error,omitempty"` // Set if the pool rejected the transaction or it expired
+}
+
+// txScheduler holds transactions whose nonces are too far ahead to be accepted
+// by the pool, so that batch senders don't have to submit strictly in order.
+// Whenever the pool nonce of an account advances, its scheduled transactions
+// that became contiguous are handed to the pool.
+type txScheduler struct {
+	config TxScheduleConfig
+	txs    map[common.Address]map[uint64]*scheduledTx
+	count  int
+
+	feed  event.Feed
+	scope event.SubscriptionScope
+	lock  sync.Mutex
+}
+
+func newTxScheduler(config TxScheduleConfig) *txScheduler {
+	return &txScheduler{
+		config: config.sanitize(),
+		txs:    make(map[common.Address]map[uint64]*scheduledTx),
+	}
+}
+
+// add schedules a transaction, replacing a scheduled one with the same nonce if
+// it pays a higher gas price. The nonce may be at most the configured gap ahead
+// of the pool nonce of the sender, whose balance has to cover the cost of all
+// its scheduled transactions.
+func (s *txScheduler) add(tx *types.Transaction, from common.Address, nonce uint64, balance *big.Int, local bool) error {
+	if tx.Nonce() > nonce+s.config.MaxNonceGap {
+		return errScheduleNonceGap
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	account := s.txs[from]
+	cost := tx.Cost()
+	for n, stx := range account {
+		if n != tx.Nonce() {
+			cost.Add(cost, stx.tx.Cost())
+		}
+	}
+	if balance.Cmp(cost) < 0 {
+		return errScheduleFunds
+	}
+	if old, ok := account[tx.Nonce()]; ok {
+		if tx.GasPrice().Cmp(old.tx.GasPrice()) <= 0 {
+			return errScheduleUnderpriced
+		}
+		account[tx.Nonce()] = &scheduledTx{tx: tx, from: from, local: local, added: time.Now()}
+		return nil
+	}
+	if s.count >= s.config.GlobalSlots {
+		return errScheduleFull
+	}
+	if len(account) >= s.config.AccountSlots {
+		return errScheduleAccountFull
+	}
+	if account == nil {
+		account = make(map[uint64]*scheduledTx)
+		s.txs[from] = account
+	}
+	account[tx.Nonce()] = &scheduledTx{tx: tx, from: from, local: local, added: time.Now()}
+	s.count++
+	return nil
+}
+
+// evict removes and returns the transactions scheduled for longer than the
+// configured lifetime.
+func (s *txScheduler) evict(now time.Time) []*scheduledTx {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var expired []*scheduledTx
+	for from, account := range s.txs {
+		for n, stx := range account {
+			if now.Sub(stx.added) > s.config.Lifetime {
+				expired = append(expired, stx)
+				delete(account, n)
+				s.count--
+			}
+		}
+		if len(account) == 0 {
+			delete(s.txs, from)
+		}
+	}
+	return expired
+}
+
+// ready removes and returns the scheduled transactions that are contiguous with
+// the pool nonce of their account, compacting away the ones made obsolete by a
+// transaction with the same nonce making it in first.
+func (s *txScheduler) ready(nonce func(common.Address) uint64) []*scheduledTx {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var ready []*scheduledTx
+	for from, account := range s.txs {
+		next := nonce(from)
+		for n := range account {
+			if n < next {
+				delete(account, n)
+				s.count--
+			}
+		}
+		for {
+			stx, ok := account[next]
+			if !ok {
+				break
+			}
+			ready = append(ready, stx)
+			delete(account, next)
+			s.count--
+			next++
+		}
+		if len(account) == 0 {
+			delete(s.txs, from)
+		}
+	}
+	return ready
+}
+
+// pending returns the scheduled transactions of an account in nonce order.
+func (s *txScheduler) pending(from common.Address) types.Transactions {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var txs types.Transactions
+	for _, stx := range s.txs[from] {
+		txs = append(txs, stx.tx)
+	}
+	sort.Sort(types.TxByNonce(txs))
+	return txs
+}
+
+// SubscribeActivatedTxEvent registers a subscription of ActivatedTxEvent.
+func (s *txScheduler) SubscribeActivatedTxEvent(ch chan<- ActivatedTxEvent) event.Subscription {
+	return s.scope.Track(s.feed.Subscribe(ch))
+}
+
+// scheduleTx submits a transaction to the pool, or schedules it if its nonce is
+// ahead of the next one the pool accepts for the sender.
+func (s *BHEereum) scheduleTx(ctx context.Context, tx *types.Transaction, local bool) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := s.admitTx(ctx, tx, local); err != nil {
+		return err
+	}
+	signer := types.MakeSigner(s.blockchain.Config(), s.blockchain.CurrentBlock().Number())
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return err
+	}
+	nonce := s.txPool.Nonce(from)
+	if tx.Nonce() <= nonce {
+		if local {
+			return s.txPool.AddLocal(tx)
+		}
+		return s.txPool.AddRemote(tx)
+	}
+	statedb, err := s.blockchain.State()
+	if err != nil {
+		return err
+	}
+	if err := s.scheduler.add(tx, from, nonce, statedb.GetBalance(from), local); err != nil {
+		return err
+	}
+	log.Trace("Scheduled future transaction", "hash", tx.Hash(), "from", from, "nonce", tx.Nonce())
+	return nil
+}
+
+// activateScheduledTxs hands the scheduled transactions whose predecessors are
+// in the pool over to it in batches, notifying the subscribers. It must not run
+// on a subscriber of the pool's feeds: adding transactions sends on them, which
+// would block on the subscriber itself.
+func (s *BHEereum) activateScheduledTxs() {
+	var locals, remotes []*scheduledTx
+	for _, stx := range s.scheduler.ready(s.txPool.Nonce) {
+		if stx.local {
+			locals = append(locals, stx)
+		} else {
+			remotes = append(remotes, stx)
+		}
+	}
+	submit := func(stxs []*scheduledTx, add func([]*types.Transaction) []error) {
+		if len(stxs) == 0 {
+			return
+		}
+		txs := make([]*types.Transaction, len(stxs))
+		for i, stx := range stxs {
+			txs[i] = stx.tx
+		}
+		for i, err := range add(txs) {
+			stx := stxs[i]
+			ev := ActivatedTxEvent{Hash: stx.tx.Hash(), From: stx.from, Nonce: hexutil.Uint64(stx.tx.Nonce())}
+			if err != nil {
+				ev.Error = err.Error()
+				log.Debug("Scheduled transaction rejected", "hash", stx.tx.Hash(), "err", err)
+			}
+			s.scheduler.feed.Send(ev)
+		}
+	}
+	submit(locals, s.txPool.AddLocals)
+	submit(remotes, s.txPool.AddRemotes)
+}
+
+// expireScheduledTxs drops the transactions scheduled for longer than their
+// lifetime, notifying the subscribers.
+func (s *BHEereum) expireScheduledTxs() {
+	expired := s.scheduler.evict(time.Now())
+	for _, stx := range expired {
+		s.scheduler.feed.Send(ActivatedTxEvent{
+			Hash:  stx.tx.Hash(),
+			From:  stx.from,
+			Nonce: hexutil.Uint64(stx.tx.Nonce()),
+			Error: errScheduleExpired.Error(),
+		})
+	}
+	if len(expired) > 0 {
+		log.Debug("Evicted expired scheduled transactions", "count", len(expired))
+	}
+}
+
+// startTxScheduler activates scheduled transactions whenever the pool accepts
+// new executable transactions or the chain advances, and periodically evicts
+// the expired ones. The subscriber only flags the activation, which runs on a
+// separate goroutine, so that the feeds are drained while the pool is busy
+// adding the activated transactions.
+func (s *BHEereum) startTxScheduler() {
+	var (
+		txs   = make(chan core.NewTxsEvent, 256)
+		heads = make(chan core.ChainHeadEvent, 16)
+		kick  = make(chan struct{}, 1)
+		quit  = make(chan struct{})
+	)
+	s.schedulerSub = s.txPool.SubscribeNewTxsEvent(txs)
+	headSub := s.events.SubscribeChainHeadEvent(heads)
+
+	go func() {
+		ticker := time.NewTicker(txScheduleEvictionInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-kick:
+				s.activateScheduledTxs()
+			case <-ticker.C:
+				s.expireScheduledTxs()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(quit)
+		defer headSub.Unsubscribe()
+
+		activate := func() {
+			select {
+			case kick <- struct{}{}:
+			default: // Activation already pending, it will pick these up too
+			}
+		}
+		for {
+			select {
+			case <-txs:
+				activate()
+			case <-heads:
+				activate()
+			case <-s.schedulerSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// ScheduleRawTransaction submits a signed transaction which may have a nonce
+// ahead of the sender's next one. Such transactions are held back and handed
+// to the pool once their predecessors arrive; subscribe to activatedTransactions
+// to be notified. Anyone may call this, so the transactions are treated as
+// remote ones, subject to the same admission checks and pool limits.
+func (api *PublicBHEereumAPI) ScheduleRawTransaction(ctx context.Context, encodedTx hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), api.e.scheduleTx(ctx, tx, false)
+}
+
+// ScheduledTransactions returns the transactions of an account waiting for
+// their predecessors, in nonce order.
+func (api *PublicBHEereumAPI) ScheduledTransactions(from common.Address) []common.Hash {
+	hashes := []common.Hash{}
+	for _, tx := range api.e.scheduler.pending(from) {
+		hashes = append(hashes, tx.Hash())
+	}
+	return hashes
+}
+
+// ActivatedTransactions creates a subscription notified whenever a scheduled
+// transaction is handed to the pool.
+func (api *PublicBHEereumAPI) ActivatedTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan ActivatedTxEvent, 64)
+		eventSub := api.e.scheduler.SubscribeActivatedTxEvent(events)
+		defer eventSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(sub.ID, ev)
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			case <-eventSub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
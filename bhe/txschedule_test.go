@@ -0,0 +1,107 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Tests that scheduled transactions are released only once contiguous with the
+// pool nonce, that obsolete ones are dropped and that the limits hold.
+func TestTxScheduler(t *testing.T) {
+	var (
+		from      = common.HexToAddress("0xa")
+		scheduler = newTxScheduler(TxScheduleConfig{GlobalSlots: 4, AccountSlots: 3})
+		balance   = big.NewInt(1000000)
+		nonce     uint64
+	)
+	tx := func(nonce uint64, price int64) *types.Transaction {
+		return types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(price), nil)
+	}
+	for _, n := range []uint64{2, 3, 5} {
+		if err := scheduler.add(tx(n, 1), from, nonce, balance, false); err != nil {
+			t.Fatalf("failed to schedule nonce %d: %v", n, err)
+		}
+	}
+	if err := scheduler.add(tx(6, 1), from, nonce, balance, false); err != errScheduleAccountFull {
+		t.Errorf("account limit error mismatch: have %v, want %v", err, errScheduleAccountFull)
+	}
+	if err := scheduler.add(tx(3, 1), from, nonce, balance, false); err != errScheduleUnderpriced {
+		t.Errorf("replacement error mismatch: have %v, want %v", err, errScheduleUnderpriced)
+	}
+	if err := scheduler.add(tx(3, 2), from, nonce, balance, false); err != nil {
+		t.Errorf("failed to replace scheduled transaction: %v", err)
+	}
+	current := func(common.Address) uint64 { return nonce }
+
+	// Nothing is contiguous with the pool yet
+	if ready := scheduler.ready(current); len(ready) != 0 {
+		t.Fatalf("released %d transactions with a nonce gap", len(ready))
+	}
+	// Once nonce 2 is due, 2 and 3 are released but 5 still waits
+	nonce = 2
+	ready := scheduler.ready(current)
+	if len(ready) != 2 || ready[0].tx.Nonce() != 2 || ready[1].tx.Nonce() != 3 || ready[1].tx.GasPrice().Int64() != 2 {
+		t.Fatalf("released transactions mismatch: %v", ready)
+	}
+	// Moving past 5 via other transactions makes it obsolete
+	nonce = 6
+	if ready := scheduler.ready(current); len(ready) != 0 {
+		t.Errorf("released obsolete transaction")
+	}
+	if scheduler.count != 0 || len(scheduler.txs) != 0 {
+		t.Errorf("schedule not emptied: %d transactions, %d accounts", scheduler.count, len(scheduler.txs))
+	}
+}
+
+// Tests that scheduling is refused for nonces too far ahead and for senders that
+// can't fund their scheduled transactions, and that expired ones are evicted.
+func TestTxSchedulerBounds(t *testing.T) {
+	var (
+		from      = common.HexToAddress("0xa")
+		scheduler = newTxScheduler(TxScheduleConfig{GlobalSlots: 4, AccountSlots: 4, MaxNonceGap: 8, Lifetime: time.Hour})
+		balance   = big.NewInt(21000 * 3)
+	)
+	tx := func(nonce uint64) *types.Transaction {
+		return types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)
+	}
+	if err := scheduler.add(tx(9), from, 0, balance, false); err != errScheduleNonceGap {
+		t.Errorf("nonce gap error mismatch: have %v, want %v", err, errScheduleNonceGap)
+	}
+	if err := scheduler.add(tx(9), from, 1, balance, false); err != nil {
+		t.Errorf("failed to schedule nonce within gap: %v", err)
+	}
+	for _, n := range []uint64{3, 4} {
+		if err := scheduler.add(tx(n), from, 1, balance, false); err != nil {
+			t.Fatalf("failed to schedule nonce %d: %v", n, err)
+		}
+	}
+	if err := scheduler.add(tx(5), from, 1, balance, false); err != errScheduleFunds {
+		t.Errorf("funding error mismatch: have %v, want %v", err, errScheduleFunds)
+	}
+	if expired := scheduler.evict(time.Now()); len(expired) != 0 {
+		t.Errorf("evicted %d fresh transactions", len(expired))
+	}
+	if expired := scheduler.evict(time.Now().Add(2 * time.Hour)); len(expired) != 3 {
+		t.Errorf("expired transactions mismatch: have %d, want 3", len(expired))
+	}
+	if scheduler.count != 0 || len(scheduler.txs) != 0 {
+		t.Errorf("schedule not emptied: %d transactions, %d accounts", scheduler.count, len(scheduler.txs))
+	}
+}
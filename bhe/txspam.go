@@ -0,0 +1,115 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+)
+
+var (
+	// errSenderRateLimited is returned if a sender submitted more transactions
+	// within the current block window than allowed.
+	errSenderRateLimited = errors.New("sender rate limited")
+
+	// errInsufficientReserve is returned if a sender's balance doesn't cover the
+	// required multiple of a transaction's cost.
+	errInsufficientReserve = errors.New("insufficient balance reserve")
+
+	spamRateMeter    = metrics.NewRegisteredMeter("BHE/spam/ratelimited", nil) // Remote transactions over the sender rate limit
+	spamReserveMeter = metrics.NewRegisteredMeter("BHE/spam/reserve", nil)     // Remote transactions below the balance reserve
+)
+
+// spamGuard protects the pool from single accounts flooding it with remote
+// transactions. It caps the number of transactions a sender may submit within
+// each window of blocks, and demands the sender to hold a multiple of the cost
+// of every transaction, so that cheap accounts can't fill the pool with
+// transactions they won't be able to pay for.
+type spamGuard struct {
+	limit    int    // Transactions admitted per sender and window, 0 if unlimited
+	window   uint64 // Length of a rate limiting window in blocks
+	multiple uint64 // Multiple of the transaction cost to be held, 0 if disabled
+
+	epoch  uint64                 // Index of the current window
+	counts map[common.Address]int // Transactions admitted per sender in the current window
+	lock   sync.Mutex
+}
+
+// newSpamGuard creates the account-level spam protection, or nil if neither a
+// rate limit nor a balance reserve is configured.
+func newSpamGuard(limit int, window uint64, multiple uint64) *spamGuard {
+	if limit <= 0 && multiple == 0 {
+		return nil
+	}
+	if window < 1 {
+		log.Warn("Sanitizing invalid sender rate window", "provided", window, "updated", 1)
+		window = 1
+	}
+	return &spamGuard{
+		limit:    limit,
+		window:   window,
+		multiple: multiple,
+		counts:   make(map[common.Address]int),
+	}
+}
+
+// check admits a remote transaction if its sender holds enough reserve and is
+// within its rate limit at the given head, counting it towards the limit.
+func (g *spamGuard) check(tx *types.Transaction, from common.Address, balance *big.Int, head uint64) error {
+	if g == nil {
+		return nil
+	}
+	if g.multiple > 0 {
+		reserve := new(big.Int).Mul(tx.Cost(), new(big.Int).SetUint64(g.multiple))
+		if balance.Cmp(reserve) < 0 {
+			spamReserveMeter.Mark(1)
+			return errInsufficientReserve
+		}
+	}
+	if g.limit <= 0 {
+		return nil
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if epoch := head / g.window; epoch != g.epoch {
+		g.epoch, g.counts = epoch, make(map[common.Address]int)
+	}
+	if g.counts[from] >= g.limit {
+		spamRateMeter.Mark(1)
+		return errSenderRateLimited
+	}
+	g.counts[from]++
+	return nil
+}
+
+// checkSpam runs the account-level spam protection on a remote transaction
+// against the current head state.
+func (s *BHEereum) checkSpam(tx *types.Transaction, from common.Address) error {
+	head := s.blockchain.CurrentBlock().NumberU64()
+
+	balance := new(big.Int)
+	if s.spam.multiple > 0 {
+		statedb, err := s.blockchain.State()
+		if err != nil {
+			return err
+		}
+		balance = statedb.GetBalance(from)
+	}
+	return s.spam.check(tx, from, balance, head)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that senders are limited to the configured number of transactions per
+// block window, independently of each other.
+func TestSpamGuardRateLimit(t *testing.T) {
+	var (
+		alice   = common.HexToAddress("0xa")
+		bob     = common.HexToAddress("0xb")
+		tx      = types.NewTransaction(0, alice, new(big.Int), 21000, big.NewInt(1), nil)
+		balance = big.NewInt(1000000)
+	)
+	guard := newSpamGuard(2, 4, 0)
+
+	for i := 0; i < 2; i++ {
+		if err := guard.check(tx, alice, balance, 8); err != nil {
+			t.Fatalf("transaction %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := guard.check(tx, alice, balance, 11); err != errSenderRateLimited {
+		t.Fatalf("limit not enforced within window: %v", err)
+	}
+	if err := guard.check(tx, bob, balance, 11); err != nil {
+		t.Fatalf("unrelated sender limited: %v", err)
+	}
+	if err := guard.check(tx, alice, balance, 12); err != nil {
+		t.Fatalf("limit not reset in new window: %v", err)
+	}
+}
+
+// Tests that senders have to hold the configured multiple of the cost of their
+// transactions.
+func TestSpamGuardReserve(t *testing.T) {
+	var (
+		sender = common.HexToAddress("0xa")
+		tx     = types.NewTransaction(0, sender, big.NewInt(1000), 21000, big.NewInt(1), nil) // Costs 22000
+	)
+	guard := newSpamGuard(0, 0, 3)
+
+	if err := guard.check(tx, sender, big.NewInt(65999), 1); err != errInsufficientReserve {
+		t.Fatalf("reserve not enforced: %v", err)
+	}
+	if err := guard.check(tx, sender, big.NewInt(66000), 1); err != nil {
+		t.Fatalf("sufficient reserve rejected: %v", err)
+	}
+	if newSpamGuard(0, 1, 0) != nil {
+		t.Fatalf("guard created without any limits")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"sync"
+	"time"
+)
+
+// txEvictionInterval is how often the pool is swept for expired transactions.
+const txEvictionInterval = time.Minute
+
+// txAgeTracker records when the transactions of the pool were first seen, which
+// the pool itself only tracks per account for queued transactions. Pending ones
+// are timed from their announcement, queued ones from the first sweep seeing
+// them.
+type txAgeTracker struct {
+	seen map[common.Hash]time.Time
+	lock sync.Mutex
+}
+
+func newTxAgeTracker() *txAgeTracker {
+	return &txAgeTracker{seen: make(map[common.Hash]time.Time)}
+}
+
+// add records the first sighting of transactions.
+func (t *txAgeTracker) add(txs []*types.Transaction, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, tx := range txs {
+		if _, ok := t.seen[tx.Hash()]; !ok {
+			t.seen[tx.Hash()] = now
+		}
+	}
+}
+
+// expired returns the transactions older than their lifetime, recording the ones
+// not seen before and forgetting the ones no longer in the pool. A zero lifetime
+// never expires.
+func (t *txAgeTracker) expired(pending, queued map[common.Address]types.Transactions, locals map[common.Address]bool, pendingTTL, queuedTTL time.Duration, now time.Time) []common.Hash {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var (
+		live  = make(map[common.Hash]time.Time, len(t.seen))
+		stale []common.Hash
+	)
+	check := func(content map[common.Address]types.Transactions, ttl time.Duration) {
+		for addr, txs := range content {
+			for _, tx := range txs {
+				hash := tx.Hash()
+				first, ok := t.seen[hash]
+				if !ok {
+					first = now
+				}
+				live[hash] = first
+				if ttl > 0 && !locals[addr] && now.Sub(first) > ttl {
+					stale = append(stale, hash)
+				}
+			}
+		}
+	}
+	check(pending, pendingTTL)
+	check(queued, queuedTTL)
+	t.seen = live
+	return stale
+}
+
+// age returns how long ago a transaction was first seen.
+func (t *txAgeTracker) age(hash common.Hash, now time.Time) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if first, ok := t.seen[hash]; ok {
+		return now.Sub(first)
+	}
+	return 0
+}
+
+// evictStaleTxs drops the remote transactions of the pool exceeding their
+// lifetime, returning the number evicted.
+func (s *BHEereum) evictStaleTxs() int {
+	s.lock.RLock()
+	pendingTTL, queuedTTL := s.config.TxPool.PendingLifetime, s.config.TxPool.Lifetime
+	s.lock.RUnlock()
+
+	locals := make(map[common.Address]bool)
+	for _, addr := range s.txPool.Locals() {
+		locals[addr] = true
+	}
+	pending, queued := s.txPool.Content()
+	stale := s.txAges.expired(pending, queued, locals, pendingTTL, queuedTTL, time.Now())
+	if len(stale) == 0 {
+		return 0
+	}
+	evicted := s.txPool.RemoveTransactions(stale)
+	log.Debug("Evicted stale transactions", "expired", len(stale), "evicted", evicted)
+	return evicted
+}
+
+// startTxEviction tracks the age of the announced transactions and periodically
+// evicts the expired ones.
+func (s *BHEereum) startTxEviction() {
+	txs := make(chan core.NewTxsEvent, 256)
+	s.txAgeSub = s.txPool.SubscribeNewTxsEvent(txs)
+
+	go func() {
+		ticker := time.NewTicker(txEvictionInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case ev := <-txs:
+				s.txAges.add(ev.Txs, time.Now())
+			case <-ticker.C:
+				s.evictStaleTxs()
+			case <-s.txAgeSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// TxLifetimes are the maximum ages of remote transactions in the pool, in
+// seconds. Zero disables eviction.
+type TxLifetimes struct {
+	Pending uint64 `json:"pending"`
+	Queued  uint64 `json:"queued"`
+}
+
+// TxLifetimes returns the lifetimes of remote transactions in the pool.
+func (api *PrivateAdminAPI) TxLifetimes() TxLifetimes {
+	api.BHE.lock.RLock()
+	defer api.BHE.lock.RUnlock()
+
+	return TxLifetimes{
+		Pending: uint64(api.BHE.config.TxPool.PendingLifetime / time.Second),
+		Queued:  uint64(api.BHE.config.TxPool.Lifetime / time.Second),
+	}
+}
+
+// SetTxLifetimes changes the lifetimes of remote transactions in the pool. They
+// take effect on the next sweep.
+func (api *PrivateAdminAPI) SetTxLifetimes(lifetimes TxLifetimes) bool {
+	api.BHE.lock.Lock()
+	defer api.BHE.lock.Unlock()
+
+	api.BHE.config.TxPool.PendingLifetime = time.Duration(lifetimes.Pending) * time.Second
+	api.BHE.config.TxPool.Lifetime = time.Duration(lifetimes.Queued) * time.Second
+	log.Info("Updated transaction lifetimes", "pending", api.BHE.config.TxPool.PendingLifetime, "queued", api.BHE.config.TxPool.Lifetime)
+	return true
+}
+
+// EvictStaleTxs sweeps the pool for expired remote transactions right away,
+// returning the number evicted.
+func (api *PrivateAdminAPI) EvictStaleTxs() (int, error) {
+	if err := api.BHE.writable(); err != nil {
+		return 0, err
+	}
+	return api.BHE.evictStaleTxs(), nil
+}
+
+// TxAgeStats are the ages of an account's transactions in the pool, in seconds.
+type TxAgeStats struct {
+	Pending int    `json:"pending"`
+	Queued  int    `json:"queued"`
+	Oldest  uint64 `json:"oldest"`
+	Newest  uint64 `json:"newest"`
+	Mean    uint64 `json:"mean"`
+}
+
+// TxAgeStats returns the ages of the transactions in the pool per account.
+func (api *PrivateAdminAPI) TxAgeStats() map[common.Address]*TxAgeStats {
+	var (
+		now             = time.Now()
+		pending, queued = api.BHE.txPool.Content()
+		stats           = make(map[common.Address]*TxAgeStats)
+		totals          = make(map[common.Address]time.Duration)
+	)
+	collect := func(content map[common.Address]types.Transactions, count func(*TxAgeStats)) {
+		for addr, txs := range content {
+			stat := stats[addr]
+			if stat == nil {
+				stat = &TxAgeStats{Newest: ^uint64(0)}
+				stats[addr] = stat
+			}
+			for _, tx := range txs {
+				age := api.BHE.txAges.age(tx.Hash(), now)
+				secs := uint64(age / time.Second)
+				if secs > stat.Oldest {
+					stat.Oldest = secs
+				}
+				if secs < stat.Newest {
+					stat.Newest = secs
+				}
+				totals[addr] += age
+				count(stat)
+			}
+		}
+	}
+	collect(pending, func(stat *TxAgeStats) { stat.Pending++ })
+	collect(queued, func(stat *TxAgeStats) { stat.Queued++ })
+
+	for addr, stat := range stats {
+		if n := stat.Pending + stat.Queued; n > 0 {
+			stat.Mean = uint64(totals[addr] / time.Duration(n) / time.Second)
+		} else {
+			stat.Newest = 0
+		}
+	}
+	return stats
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Tests that transactions expire according to their lifetime from their first
+// sighting, that local ones never do and that departed ones are forgotten.
+func TestTxAgeTracker(t *testing.T) {
+	var (
+		remote  = common.HexToAddress("0xa")
+		local   = common.HexToAddress("0xb")
+		tracker = newTxAgeTracker()
+		start   = time.Now()
+	)
+	tx := func(nonce uint64) *types.Transaction {
+		return types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)
+	}
+	var (
+		old, fresh, queued, mine = tx(0), tx(1), tx(5), tx(7)
+		locals                   = map[common.Address]bool{local: true}
+	)
+	tracker.add([]*types.Transaction{old, mine}, start)
+	tracker.add([]*types.Transaction{fresh}, start.Add(50*time.Minute))
+
+	pending := map[common.Address]types.Transactions{remote: {old, fresh}, local: {mine}}
+	content := map[common.Address]types.Transactions{remote: {queued}}
+
+	// The queued transaction is first seen by the sweep, nothing else is due
+	if stale := tracker.expired(pending, content, locals, time.Hour, 10*time.Minute, start.Add(30*time.Minute)); len(stale) != 0 {
+		t.Fatalf("premature expiry: %x", stale)
+	}
+	stale := tracker.expired(pending, content, locals, time.Hour, 10*time.Minute, start.Add(61*time.Minute))
+	if len(stale) != 2 || stale[0] != old.Hash() || stale[1] != queued.Hash() {
+		t.Errorf("expired mismatch: have %x, want [%x %x]", stale, old.Hash(), queued.Hash())
+	}
+	// Transactions leaving the pool must be forgotten
+	tracker.expired(map[common.Address]types.Transactions{remote: {fresh}}, nil, locals, 0, 0, start)
+	if age := tracker.age(old.Hash(), start.Add(time.Hour)); age != 0 {
+		t.Errorf("departed transaction still tracked, age %v", age)
+	}
+	if age := tracker.age(fresh.Hash(), start.Add(time.Hour)); age != 10*time.Minute {
+		t.Errorf("age mismatch: have %v, want %v", age, 10*time.Minute)
+	}
+}
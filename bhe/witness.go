@@ -0,0 +1,589 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// witnessExportVersion is the version of the witness export format.
+const witnessExportVersion = 1
+
+// witnessPrefix is the database key prefix of stored block witnesses:
+// witnessPrefix + block hash -> RLP(blockWitness).
+var witnessPrefix = []byte("BHE-witness-")
+
+// witnessQueue is the number of imported blocks waiting for their witness to be
+// recorded, beyond which blocks are skipped.
+const witnessQueue = 16
+
+var (
+	errWitnessNotFound = errors.New("not part of the witness")
+
+	witnessDroppedMeter = metrics.NewRegisteredMeter("BHE/witness/dropped", nil)
+)
+
+// blockWitness is everything needed to execute a block without access to the
+// chain: the block itself, the headers it reads and the pre-state trie nodes and
+// contract code it touches.
+type blockWitness struct {
+	Block   *types.Block
+	Headers []*types.Header // Parent first, then the ancestors read, newest first
+	Nodes   [][]byte        // Account and storage trie nodes of the parent state
+	Codes   [][]byte        // Contract code executed or read by the block
+}
+
+// witnessExportHeader leads a witness export. The chain configuration is needed
+// to execute the blocks with the fork rules of the exporting network.
+type witnessExportHeader struct {
+	Version uint
+	Config  []byte // JSON encoded chain configuration
+}
+
+// witnessRecorder is a read-through key-value store serving the trie nodes and
+// contract code of a state database, recording every blob it hands out. Writes
+// go to a scratch store that is discarded with the recorder.
+type witnessRecorder struct {
+	BHEdb.KeyValueStore
+
+	source state.Database
+	nodes  map[common.Hash][]byte
+	codes  map[common.Hash][]byte
+	lock   sync.Mutex
+}
+
+func newWitnessRecorder(source state.Database) *witnessRecorder {
+	return &witnessRecorder{
+		KeyValueStore: memorydb.New(),
+		source:        source,
+		nodes:         make(map[common.Hash][]byte),
+		codes:         make(map[common.Hash][]byte),
+	}
+}
+
+// Has retrieves if a key is present in the source database.
+func (r *witnessRecorder) Has(key []byte) (bool, error) {
+	_, err := r.Get(key)
+	return err == nil, nil
+}
+
+// Get retrieves a trie node or contract code from the source database, recording
+// it into the witness.
+func (r *witnessRecorder) Get(key []byte) ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch {
+	case len(key) == common.HashLength:
+		hash := common.BytesToHash(key)
+		blob, err := r.source.TrieDB().Node(hash)
+		if err != nil {
+			return nil, err
+		}
+		r.nodes[hash] = blob
+		return blob, nil
+
+	case len(key) == len(rawdb.CodePrefix)+common.HashLength && bytes.HasPrefix(key, rawdb.CodePrefix):
+		hash := common.BytesToHash(key[len(rawdb.CodePrefix):])
+		code, err := r.source.ContractCode(common.Hash{}, hash)
+		if err != nil {
+			return nil, err
+		}
+		r.codes[hash] = code
+		return code, nil
+	}
+	return nil, errWitnessNotFound
+}
+
+// witness returns the recorded trie nodes and codes in a stable order.
+func (r *witnessRecorder) witness() (nodes [][]byte, codes [][]byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return sortedBlobs(r.nodes), sortedBlobs(r.codes)
+}
+
+// sortedBlobs flattens a set of blobs into a list ordered by their hash.
+func sortedBlobs(set map[common.Hash][]byte) [][]byte {
+	hashes := make([]common.Hash, 0, len(set))
+	for hash := range set {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	blobs := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		blobs[i] = set[hash]
+	}
+	return blobs
+}
+
+// witnessChain is the chain context a block is executed in when recording or
+// verifying a witness. Headers are resolved from the source chain if there is
+// one, recording them, or from the headers of a witness otherwise.
+type witnessChain struct {
+	config  *params.ChainConfig
+	engine  consensus.Engine
+	parent  *types.Header
+	source  func(hash common.Hash, number uint64) *types.Header // Nil when verifying
+	headers map[common.Hash]*types.Header
+}
+
+func newWitnessChain(config *params.ChainConfig, engine consensus.Engine, parent *types.Header, source func(common.Hash, uint64) *types.Header) *witnessChain {
+	return &witnessChain{
+		config:  config,
+		engine:  engine,
+		parent:  parent,
+		source:  source,
+		headers: map[common.Hash]*types.Header{parent.Hash(): parent},
+	}
+}
+
+// Config retrieves the chain configuration.
+func (c *witnessChain) Config() *params.ChainConfig { return c.config }
+
+// Engine retrieves the consensus engine.
+func (c *witnessChain) Engine() consensus.Engine { return c.engine }
+
+// CurrentHeader returns the parent of the executed block.
+func (c *witnessChain) CurrentHeader() *types.Header { return c.parent }
+
+// GBHEeader retrieves a header by hash and number.
+func (c *witnessChain) GBHEeader(hash common.Hash, number uint64) *types.Header {
+	if header, ok := c.headers[hash]; ok {
+		return header
+	}
+	if c.source == nil {
+		return nil
+	}
+	header := c.source(hash, number)
+	if header != nil {
+		c.headers[hash] = header
+	}
+	return header
+}
+
+// GBHEeaderByHash retrieves a header by hash.
+func (c *witnessChain) GBHEeaderByHash(hash common.Hash) *types.Header {
+	return c.headers[hash]
+}
+
+// GBHEeaderByNumber retrieves an ancestor of the executed block by number.
+func (c *witnessChain) GBHEeaderByNumber(number uint64) *types.Header {
+	for header := c.parent; header != nil; {
+		switch n := header.Number.Uint64(); {
+		case n == number:
+			return header
+		case n < number || n == 0:
+			return nil
+		default:
+			header = c.GBHEeader(header.ParentHash, n-1)
+		}
+	}
+	return nil
+}
+
+// GetBlock is not supported, witnesses carry no ancestor bodies.
+func (c *witnessChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return nil
+}
+
+// ancestors returns the headers read, the parent first then newest first.
+func (c *witnessChain) ancestors() []*types.Header {
+	headers := make([]*types.Header, 0, len(c.headers))
+	for _, header := range c.headers {
+		headers = append(headers, header)
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Number.Cmp(headers[j].Number) > 0 })
+	return headers
+}
+
+// processWitnessBlock executes a block on a state, the same way the state
+// processor does, but within the given chain context instead of the local chain.
+func processWitnessBlock(chain *witnessChain, block *types.Block, statedb *state.StateDB, config vm.Config) (types.Receipts, uint64, error) {
+	var (
+		receipts types.Receipts
+		usedGas  = new(uint64)
+		header   = block.Header()
+		gp       = new(core.GasPool).AddGas(block.GasLimit())
+	)
+	if chain.config.DAOForkSupport && chain.config.DAOForkBlock != nil && chain.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		receipt, err := core.ApplyTransaction(chain.config, chain, nil, gp, statedb, header, tx, usedGas, config)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	if err := consensus.SplitFees(chain.engine, chain, header, statedb, block.Transactions(), receipts); err != nil {
+		return nil, 0, err
+	}
+	chain.engine.Finalize(chain, header, statedb, block.Transactions(), block.Uncles())
+	return receipts, *usedGas, nil
+}
+
+// recordWitness executes a block on the state of its parent held by db and
+// returns the witness of everything it read. The execution has to reproduce the
+// state root of the block, otherwise the witness would be incomplete.
+func (s *BHEereum) recordWitness(block *types.Block, parent *types.Header, db state.Database) (*blockWitness, error) {
+	recorder := newWitnessRecorder(db)
+	statedb, err := state.New(parent.Root, state.NewDatabase(rawdb.NewDatabase(recorder)), nil)
+	if err != nil {
+		return nil, err
+	}
+	chain := newWitnessChain(s.blockchain.Config(), s.engine, parent, s.blockchain.GBHEeader)
+	if _, _, err := processWitnessBlock(chain, block, statedb, vm.Config{}); err != nil {
+		return nil, err
+	}
+	if root := statedb.IntermediateRoot(chain.config.IsEIP158(block.Number())); root != block.Root() {
+		return nil, fmt.Errorf("block %d state root mismatch: have %x, want %x", block.NumberU64(), root, block.Root())
+	}
+	nodes, codes := recorder.witness()
+	return &blockWitness{Block: block, Headers: chain.ancestors(), Nodes: nodes, Codes: codes}, nil
+}
+
+// ExportWitnesses writes the stateless witnesses of a range of canonical blocks
+// into a local file, preceded by the chain configuration. Every block of the
+// export can be executed and checked against its header in isolation, without
+// the chain or the state it was produced on.
+func (api *PrivateDebugAPI) ExportWitnesses(file string, first uint64, last uint64, config *ReplayConfig) (bool, error) {
+	if first == 0 || last < first {
+		return false, errors.New("invalid block range")
+	}
+	if head := api.BHE.blockchain.CurrentBlock().NumberU64(); last > head {
+		return false, fmt.Errorf("block #%d not yet available", last)
+	}
+	if _, err := os.Stat(file); err == nil {
+		return false, errors.New("location would overwrite an existing file")
+	}
+	parent := api.BHE.blockchain.GetBlockByNumber(first - 1)
+	if parent == nil {
+		return false, fmt.Errorf("block #%d not found", first-1)
+	}
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	statedb, err := api.computeStateDB(parent, reexec)
+	if err != nil {
+		return false, err
+	}
+	chainConfig, err := json.Marshal(api.BHE.blockchain.Config())
+	if err != nil {
+		return false, err
+	}
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+
+	var writer io.Writer = out
+	if strings.HasSuffix(file, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	if err := rlp.Encode(writer, &witnessExportHeader{Version: witnessExportVersion, Config: chainConfig}); err != nil {
+		return false, err
+	}
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		database = statedb.Database()
+		proot    common.Hash
+		nodes    int
+	)
+	for number := first; number <= last; number++ {
+		block := api.BHE.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			return false, fmt.Errorf("block #%d not found", number)
+		}
+		witness, err := api.BHE.recordWitness(block, parent.Header(), database)
+		if err != nil {
+			return false, err
+		}
+		if err := rlp.Encode(writer, witness); err != nil {
+			return false, err
+		}
+		nodes += len(witness.Nodes)
+
+		// Advance the parent state to the exported block for the next witness
+		if number < last {
+			if _, _, _, err := api.BHE.blockchain.Processor().Process(block, statedb, vm.Config{}); err != nil {
+				return false, fmt.Errorf("processing block %d failed: %v", number, err)
+			}
+			root, err := statedb.Commit(api.BHE.blockchain.Config().IsEIP158(block.Number()))
+			if err != nil {
+				return false, err
+			}
+			if err := statedb.Reset(root); err != nil {
+				return false, fmt.Errorf("state reset after block %d failed: %v", number, err)
+			}
+			database.TrieDB().Reference(root, common.Hash{})
+			if proot != (common.Hash{}) {
+				database.TrieDB().Dereference(proot)
+			}
+			proot = root
+		}
+		parent = block
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting block witnesses", "block", number, "target", last, "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if proot != (common.Hash{}) {
+		database.TrieDB().Dereference(proot)
+	}
+	log.Info("Exported block witnesses", "first", first, "last", last, "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return true, nil
+}
+
+// computeWitness re-executes a block on top of its parent state and returns the
+// witness of it.
+func (s *BHEereum) computeWitness(block *types.Block) (*blockWitness, error) {
+	parent := s.blockchain.GBHEeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, errors.New("parent block not found")
+	}
+	if _, err := s.blockchain.StateAt(parent.Root); err != nil {
+		return nil, err
+	}
+	return s.recordWitness(block, parent, s.blockchain.StateCache())
+}
+
+// checkWitnessLinks verifies that the headers of a witness are the contiguous
+// ancestors of its block, starting with the parent.
+func checkWitnessLinks(witness *blockWitness) error {
+	if witness.Block == nil {
+		return errors.New("witness without block")
+	}
+	if len(witness.Headers) == 0 {
+		return errors.New("witness without parent header")
+	}
+	want := witness.Block.ParentHash()
+	for i, header := range witness.Headers {
+		if header.Hash() != want {
+			return fmt.Errorf("witness header %d not an ancestor: have %x, want %x", i, header.Hash(), want)
+		}
+		want = header.ParentHash
+	}
+	return nil
+}
+
+// verifyWitness executes the block of a witness using nothing but the witness
+// itself and compares the results against the commitments of the block header.
+// Missing trie nodes or code surface as an execution error.
+func (s *BHEereum) verifyWitness(witness *blockWitness) (*ReplayReport, error) {
+	if err := checkWitnessLinks(witness); err != nil {
+		return nil, err
+	}
+	block := witness.Block
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return nil, fmt.Errorf("transaction root mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return nil, fmt.Errorf("uncle root mismatch: have %x, want %x", hash, block.UncleHash())
+	}
+	db := memorydb.New()
+	for _, node := range witness.Nodes {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	for _, code := range witness.Codes {
+		rawdb.WriteCode(db, crypto.Keccak256Hash(code), code)
+	}
+	parent := witness.Headers[0]
+	chain := newWitnessChain(s.blockchain.Config(), s.engine, parent, nil)
+	for _, header := range witness.Headers[1:] {
+		chain.headers[header.Hash()] = header
+	}
+	report := &ReplayReport{
+		Number:     hexutil.Uint64(block.NumberU64()),
+		Hash:       block.Hash(),
+		Mismatches: []*ReplayMismatch{},
+	}
+	statedb, err := state.New(parent.Root, state.NewDatabase(rawdb.NewDatabase(db)), nil)
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	receipts, usedGas, err := processWitnessBlock(chain, block, statedb, vm.Config{})
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	if err := statedb.Error(); err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.Root = statedb.IntermediateRoot(chain.config.IsEIP158(block.Number()))
+	report.Mismatches = compareBlockResults(block.Header(), report.Root, receipts, usedGas)
+	report.Match = len(report.Mismatches) == 0
+	return report, nil
+}
+
+// readWitness retrieves the stored witness of a block, if any.
+func readWitness(db BHEdb.KeyValueReader, hash common.Hash) []byte {
+	blob, err := db.Get(append(witnessPrefix, hash.Bytes()...))
+	if err != nil || len(blob) == 0 {
+		return nil
+	}
+	return blob
+}
+
+// writeWitness stores the RLP encoded witness of a block.
+func writeWitness(db BHEdb.KeyValueWriter, hash common.Hash, blob []byte) {
+	if err := db.Put(append(witnessPrefix, hash.Bytes()...), blob); err != nil {
+		log.Crit("Failed to store block witness", "err", err)
+	}
+}
+
+// deleteWitness removes the stored witness of a block.
+func deleteWitness(db BHEdb.KeyValueWriter, hash common.Hash) {
+	if err := db.Delete(append(witnessPrefix, hash.Bytes()...)); err != nil {
+		log.Crit("Failed to delete block witness", "err", err)
+	}
+}
+
+// startWitnesses records the witness of every block the chain imports, pruning
+// those older than the configured history and dropping those of blocks reorged
+// out. Witnesses are computed on a worker behind a bounded queue, so that the
+// feeds and thereby the import never wait for them; blocks arriving while the
+// queue is full go without a witness, which GetBlockWitness recomputes then.
+func (s *BHEereum) startWitnesses() {
+	var (
+		events = make(chan core.ChainEvent, 64)
+		sides  = make(chan core.ChainSideEvent, 64)
+		queue  = make(chan *types.Block, witnessQueue)
+	)
+	s.witnessSub = s.blockchain.SubscribeChainEvent(events)
+	sideSub := s.blockchain.SubscribeChainSideEvent(sides)
+
+	go func() {
+		for block := range queue {
+			s.storeWitness(block)
+		}
+	}()
+	go func() {
+		defer close(queue)
+		defer sideSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				select {
+				case queue <- ev.Block:
+				default:
+					witnessDroppedMeter.Mark(1)
+					log.Debug("Witness recording behind, skipping block", "number", ev.Block.Number(), "hash", ev.Hash)
+				}
+			case ev := <-sides:
+				deleteWitness(s.chainDb, ev.Block.Hash())
+			case <-s.witnessSub.Err():
+				return
+			}
+		}
+	}()
+}
+
+// storeWitness computes and stores the witness of a block, unless reorged out
+// meanwhile, and prunes the one falling out of the history.
+func (s *BHEereum) storeWitness(block *types.Block) {
+	var (
+		start  = time.Now()
+		hash   = block.Hash()
+		number = block.NumberU64()
+	)
+	witness, err := s.computeWitness(block)
+	if err != nil {
+		log.Warn("Failed to record block witness", "number", number, "hash", hash, "err", err)
+		return
+	}
+	blob, err := rlp.EncodeToBytes(witness)
+	if err != nil {
+		log.Crit("Failed to encode block witness", "err", err)
+	}
+	writeWitness(s.chainDb, hash, blob)
+
+	// Drop the witness again if the block got reorged out while it was computed,
+	// its side event having found nothing to delete
+	if rawdb.ReadCanonicalHash(s.chainDb, number) != hash {
+		deleteWitness(s.chainDb, hash)
+		return
+	}
+	log.Debug("Recorded block witness", "number", number, "hash", hash, "nodes", len(witness.Nodes), "codes", len(witness.Codes), "size", common.StorageSize(len(blob)), "elapsed", common.PrettyDuration(time.Since(start)))
+
+	if history := s.config.WitnessHistory; history > 0 && number > history {
+		if old := rawdb.ReadCanonicalHash(s.chainDb, number-history); old != (common.Hash{}) {
+			deleteWitness(s.chainDb, old)
+		}
+	}
+}
+
+// GetBlockWitness returns the RLP encoded stateless witness of a block: the
+// block, the ancestor headers and the pre-state trie nodes and code needed to
+// execute it. Recorded witnesses are served from disk, other blocks are
+// re-executed on demand if their parent state is available.
+func (api *PrivateDebugAPI) GetBlockWitness(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		return nil, errors.New("pending block witness not available")
+	}
+	block, err := api.BHE.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	if blob := readWitness(api.BHE.chainDb, block.Hash()); blob != nil {
+		return blob, nil
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis block has no witness")
+	}
+	witness, err := api.BHE.computeWitness(block)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(witness)
+}
+
+// VerifyBlockWitness executes the block of an RLP encoded witness without
+// touching the local chain or state, and reports whether the results match the
+// commitments of its header. The witness is executed with the local chain
+// configuration.
+func (api *PrivateDebugAPI) VerifyBlockWitness(encoded hexutil.Bytes) (*ReplayReport, error) {
+	witness := new(blockWitness)
+	if err := rlp.DecodeBytes(encoded, witness); err != nil {
+		return nil, fmt.Errorf("invalid witness: %v", err)
+	}
+	return api.BHE.verifyWitness(witness)
+}
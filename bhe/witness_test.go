@@ -0,0 +1,116 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHE
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// Tests that the witness chain records the ancestors resolved from the source
+// chain and serves only those when verifying.
+func TestWitnessChainAncestors(t *testing.T) {
+	var (
+		chain  []*types.Header
+		byHash = make(map[common.Hash]*types.Header)
+	)
+	for i := 0; i < 8; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i)), Extra: []byte{byte(i)}}
+		if i > 0 {
+			header.ParentHash = chain[i-1].Hash()
+		}
+		chain = append(chain, header)
+		byHash[header.Hash()] = header
+	}
+	source := func(hash common.Hash, number uint64) *types.Header { return byHash[hash] }
+
+	recording := newWitnessChain(nil, nil, chain[7], source)
+	if header := recording.GBHEeaderByNumber(4); header == nil || header.Hash() != chain[4].Hash() {
+		t.Fatalf("ancestor #4 not resolved: %v", header)
+	}
+	if header := recording.GBHEeaderByNumber(8); header != nil {
+		t.Fatalf("descendant resolved: %v", header)
+	}
+	ancestors := recording.ancestors()
+	if len(ancestors) != 4 {
+		t.Fatalf("ancestor count mismatch: have %d, want 4", len(ancestors))
+	}
+	for i, header := range ancestors {
+		if header.Hash() != chain[7-i].Hash() {
+			t.Errorf("ancestor %d: have #%d, want #%d", i, header.Number, 7-i)
+		}
+	}
+	verifying := newWitnessChain(nil, nil, chain[7], nil)
+	for _, header := range ancestors[1:] {
+		verifying.headers[header.Hash()] = header
+	}
+	if header := verifying.GBHEeaderByNumber(4); header == nil || header.Hash() != chain[4].Hash() {
+		t.Fatalf("ancestor #4 not served from witness: %v", header)
+	}
+	if header := verifying.GBHEeaderByNumber(3); header != nil {
+		t.Fatalf("ancestor #3 served without being part of the witness")
+	}
+}
+
+// Tests that witness blobs are ordered by hash, independent of recording order.
+func TestSortedBlobs(t *testing.T) {
+	set := make(map[common.Hash][]byte)
+	for i := 0; i < 16; i++ {
+		blob := []byte{byte(i)}
+		set[crypto.Keccak256Hash(blob)] = blob
+	}
+	blobs := sortedBlobs(set)
+	if len(blobs) != len(set) {
+		t.Fatalf("blob count mismatch: have %d, want %d", len(blobs), len(set))
+	}
+	for i := 1; i < len(blobs); i++ {
+		if prev, next := crypto.Keccak256Hash(blobs[i-1]), crypto.Keccak256Hash(blobs[i]); bytes.Compare(prev[:], next[:]) >= 0 {
+			t.Errorf("blobs %d and %d out of order", i-1, i)
+		}
+	}
+}
+
+// Tests that witness headers have to be the contiguous ancestors of the block.
+func TestCheckWitnessLinks(t *testing.T) {
+	var chain []*types.Header
+	for i := 0; i < 4; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i))}
+		if i > 0 {
+			header.ParentHash = chain[i-1].Hash()
+		}
+		chain = append(chain, header)
+	}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(4), ParentHash: chain[3].Hash()})
+
+	tests := []struct {
+		headers []*types.Header
+		valid   bool
+	}{
+		{nil, false},
+		{[]*types.Header{chain[3]}, true},
+		{[]*types.Header{chain[3], chain[2], chain[1]}, true},
+		{[]*types.Header{chain[2]}, false},
+		{[]*types.Header{chain[3], chain[1]}, false},
+	}
+	for i, tt := range tests {
+		err := checkWitnessLinks(&blockWitness{Block: block, Headers: tt.headers})
+		if (err == nil) != tt.valid {
+			t.Errorf("test %d: validity mismatch: have %v, want %v (err %v)", i, err == nil, tt.valid, err)
+		}
+	}
+}
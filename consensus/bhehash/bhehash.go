@@ -428,6 +428,7 @@ type BHEash struct {
 	update   chan struct{} // Notification channel to update mining parameters
 	hashrate metrics.Meter // Meter tracking the average hashrate
 	remote   *remoteSealer
+	hook     consensus.SealerHook // Co-signing hook run on sealed blocks, nil if none
 
 	// The fields below are hooks for testing
 	shared    *BHEash       // Shared PoW verifier to avoid cache regeneration
@@ -248,6 +248,9 @@ func (BHEash *BHEash) verifyHeader(chain consensus.ChainReader, header, parent *
 	if uint64(len(header.Extra)) > params.MaximumExtraDataSize {
 		return fmt.Errorf("extra-data too long: %d > %d", len(header.Extra), params.MaximumExtraDataSize)
 	}
+	if err := consensus.VerifyExtraDataPolicy(chain.Config(), header); err != nil {
+		return err
+	}
 	// Verify the header's timestamp
 	if !uncle {
 		if header.Time > uint64(time.Now().Add(allowedFutureBlockTime).Unix()) {
@@ -300,6 +303,9 @@ func (BHEash *BHEash) verifyHeader(chain consensus.ChainReader, header, parent *
 	if err := misc.VerifyForkHashes(chain.Config(), header, uncle); err != nil {
 		return err
 	}
+	if err := consensus.VerifyHeaderExtension(chain, header); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -582,6 +588,10 @@ func (BHEash *BHEash) FinalizeAndAssemble(chain consensus.ChainReader, header *t
 	accumulateRewards(chain.Config(), state, header, uncles)
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 
+	// Fill in the application commitment if the header extension is active
+	if err := consensus.SealHeaderExtension(chain, header, state, txs, receipts); err != nil {
+		return nil, err
+	}
 	// Header seems complete, assemble into a block and return
 	return types.NewBlock(header, txs, uncles, receipts), nil
 }
@@ -590,7 +600,7 @@ func (BHEash *BHEash) FinalizeAndAssemble(chain consensus.ChainReader, header *t
 func (BHEash *BHEash) SealHash(header *types.Header) (hash common.Hash) {
 	hasher := sha3.NewLegacyKeccak256()
 
-	rlp.Encode(hasher, []interface{}{
+	enc := []interface{}{
 		header.ParentHash,
 		header.UncleHash,
 		header.Coinbase,
@@ -604,7 +614,13 @@ func (BHEash *BHEash) SealHash(header *types.Header) (hash common.Hash) {
 		header.GasUsed,
 		header.Time,
 		header.Extra,
-	})
+	}
+	// The extension commitment is only part of the seal once the fork is active,
+	// keeping the seal hash of older headers unchanged.
+	if header.Extension != nil {
+		enc = append(enc, *header.Extension)
+	}
+	rlp.Encode(hasher, enc)
 	hasher.Sum(hash[:0])
 	return hash
 }
@@ -615,10 +631,16 @@ var (
 	big32 = big.NewInt(32)
 )
 
-// AccumulateRewards credits the coinbase of the given block with the mining
-// reward. The total reward consists of the static block reward and rewards for
-// included uncles. The coinbase of each uncle block is also rewarded.
-func accumulateRewards(config *params.ChainConfig, state *state.StateDB, header *types.Header, uncles []*types.Header) {
+// BlockRewards implements consensus.Rewarder, returning the rewards credited
+// by accumulateRewards without touching any state.
+func (BHEash *BHEash) BlockRewards(config *params.ChainConfig, header *types.Header, uncles []*types.Header) (*big.Int, []*big.Int) {
+	return blockRewards(config, header, uncles)
+}
+
+// blockRewards computes the reward of the miner of the given block, consisting
+// of the static block reward and a bonus for every included uncle, and the
+// rewards of the uncle miners themselves.
+func blockRewards(config *params.ChainConfig, header *types.Header, uncles []*types.Header) (*big.Int, []*big.Int) {
 	// Select the correct block reward based on chain progression
 	blockReward := FrontierBlockReward
 	if config.IsByzantium(header.Number) {
@@ -628,17 +650,29 @@ func accumulateRewards(config *params.ChainConfig, state *state.StateDB, header
 		blockReward = ConstantinopleBlockReward
 	}
 	// Accumulate the rewards for the miner and any included uncles
-	reward := new(big.Int).Set(blockReward)
-	r := new(big.Int)
-	for _, uncle := range uncles {
-		r.Add(uncle.Number, big8)
+	var (
+		reward       = new(big.Int).Set(blockReward)
+		uncleRewards = make([]*big.Int, len(uncles))
+	)
+	for i, uncle := range uncles {
+		r := new(big.Int).Add(uncle.Number, big8)
 		r.Sub(r, header.Number)
 		r.Mul(r, blockReward)
 		r.Div(r, big8)
-		state.AddBalance(uncle.Coinbase, r)
+		uncleRewards[i] = r
 
-		r.Div(blockReward, big32)
-		reward.Add(reward, r)
+		reward.Add(reward, new(big.Int).Div(blockReward, big32))
+	}
+	return reward, uncleRewards
+}
+
+// AccumulateRewards credits the coinbase of the given block with the mining
+// reward. The total reward consists of the static block reward and rewards for
+// included uncles. The coinbase of each uncle block is also rewarded.
+func accumulateRewards(config *params.ChainConfig, state *state.StateDB, header *types.Header, uncles []*types.Header) {
+	reward, uncleRewards := blockRewards(config, header, uncles)
+	for i, uncle := range uncles {
+		state.AddBalance(uncle.Coinbase, uncleRewards[i])
 	}
 	state.AddBalance(header.Coinbase, reward)
 }
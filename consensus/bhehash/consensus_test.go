@@ -0,0 +1,83 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package BHEash
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/consensus"
+	"github.com/BHEereum/go-BHEereum/core/rawdb"
+	"github.com/BHEereum/go-BHEereum/core/state"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+// feeSplitChain is a chain reader only serving the chain config, which is all
+// finalization needs.
+type feeSplitChain struct {
+	consensus.ChainReader
+	config *params.ChainConfig
+}
+
+func (c *feeSplitChain) Config() *params.ChainConfig { return c.config }
+
+// Tests that the fee split run ahead of finalization leaves the block reward
+// untouched, and fails the block if the coinbase spent the fees.
+func TestFinalizeFeeSplit(t *testing.T) {
+	var (
+		coinbase = common.HexToAddress("0xc0ffee")
+		treasury = common.HexToAddress("0x7ea5")
+		config   = *params.TestChainConfig
+		engine   = NewFaker()
+		parent   = &types.Header{Number: big.NewInt(0)}
+		chain    = &feeSplitChain{config: &config}
+		txs      = []*types.Transaction{
+			types.NewTransaction(0, common.Address{}, nil, 21000, big.NewInt(10), nil),
+			types.NewTransaction(1, common.Address{}, nil, 100000, big.NewInt(20), nil),
+		}
+		receipts = []*types.Receipt{{GasUsed: 21000}, {GasUsed: 50000}}
+	)
+	config.FeeSplitBlock = big.NewInt(0)
+	config.FeeSplit = &params.FeeSplitConfig{BurnPercent: 30, TreasuryPercent: 20, Treasury: treasury}
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(coinbase, big.NewInt(1210000))
+
+	header := &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1), Coinbase: coinbase}
+	if err := consensus.SplitFees(engine, chain, header, statedb, txs, receipts); err != nil {
+		t.Fatalf("failed to split fees: %v", err)
+	}
+	engine.Finalize(chain, header, statedb, txs, nil)
+
+	want := new(big.Int).Add(big.NewInt(605000), ConstantinopleBlockReward)
+	if balance := statedb.GetBalance(coinbase); balance.Cmp(want) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", balance, want)
+	}
+	if balance := statedb.GetBalance(treasury); balance.Cmp(big.NewInt(242000)) != 0 {
+		t.Errorf("treasury balance mismatch: have %v, want %v", balance, 242000)
+	}
+	if header.Root != statedb.IntermediateRoot(true) {
+		t.Errorf("state root not set on the header")
+	}
+	spent, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	header = &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1), Coinbase: coinbase}
+	if err := consensus.SplitFees(engine, chain, header, spent, txs, receipts); err != consensus.ErrFeeSplitUnderflow {
+		t.Errorf("spent fees error mismatch: have %v, want %v", err, consensus.ErrFeeSplitUnderflow)
+	}
+}
@@ -46,9 +46,41 @@ var (
 	errInvalidSealResult = errors.New("invalid or stale proof-of-work solution")
 )
 
+// SetSealerHook implements consensus.HookableSealer, running every block sealed
+// locally or by a remote miner through a co-signing hook before delivery. As
+// the proof-of-work covers the entire header, the hook can only approve or veto
+// blocks (keeping the co-signatures out of band), not amend them.
+func (BHEash *BHEash) SetSealerHook(hook consensus.SealerHook) {
+	BHEash.lock.Lock()
+	defer BHEash.lock.Unlock()
+
+	BHEash.hook = hook
+}
+
 // Seal implements consensus.Engine, attempting to find a nonce that satisfies
 // the block's difficulty requirements.
 func (BHEash *BHEash) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	BHEash.lock.Lock()
+	hook := BHEash.hook
+	BHEash.lock.Unlock()
+
+	if hook != nil {
+		verify := func(signed *types.Block) error {
+			if BHEash.SealHash(signed.Header()) != BHEash.SealHash(block.Header()) {
+				return errors.New("co-signed block altered the sealed header")
+			}
+			return BHEash.verifySeal(nil, signed.Header(), false)
+		}
+		onError := func(sealed *types.Block, err error) {
+			BHEash.config.Log.Warn("Discarding block failing co-signing", "number", sealed.NumberU64(), "hash", sealed.Hash(), "err", err)
+		}
+		results = consensus.CoSignResults(hook, chain, results, stop, verify, onError)
+	}
+	return BHEash.seal(chain, block, results, stop)
+}
+
+// seal is the hook-less implementation of Seal.
+func (BHEash *BHEash) seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
 	// If we're running a fake PoW, simply return a 0 nonce immediately
 	if BHEash.config.PowMode == ModeFake || BHEash.config.PowMode == ModeFullFake {
 		header := block.Header()
@@ -117,7 +149,7 @@ func (BHEash *BHEash) Seal(chain consensus.ChainReader, block *types.Block, resu
 		case <-BHEash.update:
 			// Thread count was changed on user request, restart
 			close(abort)
-			if err := BHEash.Seal(chain, block, results, stop); err != nil {
+			if err := BHEash.seal(chain, block, results, stop); err != nil {
 				BHEash.config.Log.Error("Failed to restart sealing after update", "err", err)
 			}
 		}
@@ -123,3 +123,12 @@ type PoW interface {
 	// Hashrate returns the current mining hashrate of a PoW consensus engine.
 	Hashrate() float64
 }
+
+// Rewarder is a consensus engine minting block rewards.
+type Rewarder interface {
+	Engine
+
+	// BlockRewards returns the amounts credited by Finalize to the coinbase of a
+	// block and to the coinbases of its uncles, in order.
+	BlockRewards(config *params.ChainConfig, header *types.Header, uncles []*types.Header) (*big.Int, []*big.Int)
+}
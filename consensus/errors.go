@@ -34,4 +34,8 @@ var (
 	// ErrInvalidNumber is returned if a block's number doesn't equal its parent's
 	// plus one.
 	ErrInvalidNumber = errors.New("invalid block number")
+
+	// ErrFeeSplitUnderflow is returned if the coinbase of a block spent the fees
+	// credited to it before the fee split could take its shares back.
+	ErrFeeSplitUnderflow = errors.New("coinbase balance below the fee split")
 )
@@ -0,0 +1,144 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/core/state"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+var (
+	// ErrUnexpectedExtension is returned if a header carries an extension
+	// commitment before the extension fork is active.
+	ErrUnexpectedExtension = errors.New("unexpected header extension")
+
+	// ErrMissingExtension is returned if a header lacks the extension commitment
+	// after the extension fork is active.
+	ErrMissingExtension = errors.New("missing header extension")
+
+	// ErrExtensionEngine is returned if a chain config activates header
+	// extensions on a consensus engine which doesn't support them.
+	ErrExtensionEngine = errors.New("header extensions are only supported by BHEash")
+)
+
+// HeaderExtension produces and validates the application specific commitment
+// (e.g. an application state root or a data availability root) carried in the
+// Extension field of block headers once the extension fork is active. Only the
+// proof-of-work engine seals and verifies the commitment.
+type HeaderExtension interface {
+	// Commit computes the commitment for a block being sealed, after all the
+	// transactions have been applied to the state.
+	Commit(chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (common.Hash, error)
+
+	// VerifyHeader checks the commitment of an imported header. Commitments are
+	// not recomputed against the post-state on import, so the provider has to
+	// validate everything it relies on from the header and the chain.
+	VerifyHeader(chain ChainReader, header *types.Header) error
+}
+
+var (
+	extensions     = make(map[string]HeaderExtension)
+	extensionsLock sync.RWMutex
+)
+
+// RegisterHeaderExtension makes a header extension provider available by name,
+// to be selected by the chain config. It panics if the name is taken.
+func RegisterHeaderExtension(name string, ext HeaderExtension) {
+	extensionsLock.Lock()
+	defer extensionsLock.Unlock()
+
+	if _, ok := extensions[name]; ok {
+		panic(fmt.Sprintf("header extension %q already registered", name))
+	}
+	extensions[name] = ext
+}
+
+// CheckHeaderExtension verifies that a chain config activating header
+// extensions runs on proof-of-work and names a registered provider.
+func CheckHeaderExtension(config *params.ChainConfig) error {
+	if config.HeaderExtension == "" {
+		return nil
+	}
+	if config.Clique != nil {
+		return ErrExtensionEngine
+	}
+	extensionsLock.RLock()
+	defer extensionsLock.RUnlock()
+
+	if _, ok := extensions[config.HeaderExtension]; !ok {
+		return fmt.Errorf("unknown header extension %q", config.HeaderExtension)
+	}
+	return nil
+}
+
+// headerExtension returns the provider configured for the given block, or nil
+// if the extension fork is not active.
+func headerExtension(chain ChainReader, number uint64) (HeaderExtension, error) {
+	config := chain.Config()
+	if !config.IsHeaderExtension(new(big.Int).SetUint64(number)) {
+		return nil, nil
+	}
+	extensionsLock.RLock()
+	defer extensionsLock.RUnlock()
+
+	ext, ok := extensions[config.HeaderExtension]
+	if !ok {
+		return nil, fmt.Errorf("unknown header extension %q", config.HeaderExtension)
+	}
+	return ext, nil
+}
+
+// SealHeaderExtension fills in the extension commitment of a header being
+// sealed, if the extension fork is active.
+func SealHeaderExtension(chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) error {
+	ext, err := headerExtension(chain, header.Number.Uint64())
+	if ext == nil || err != nil {
+		return err
+	}
+	commitment, err := ext.Commit(chain, header, state, txs, receipts)
+	if err != nil {
+		return err
+	}
+	header.Extension = &commitment
+	return nil
+}
+
+// VerifyHeaderExtension checks that a header carries an extension commitment
+// if and only if the extension fork is active, and runs the stateless checks
+// of the configured provider on it.
+func VerifyHeaderExtension(chain ChainReader, header *types.Header) error {
+	ext, err := headerExtension(chain, header.Number.Uint64())
+	if err != nil {
+		return err
+	}
+	switch {
+	case ext == nil && header.Extension != nil:
+		return ErrUnexpectedExtension
+	case ext == nil:
+		return nil
+	case header.Extension == nil:
+		return ErrMissingExtension
+	}
+	return ext.VerifyHeader(chain, header)
+}
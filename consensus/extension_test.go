@@ -0,0 +1,56 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"testing"
+
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/core/state"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+// testExtension is a header extension provider committing to nothing.
+type testExtension struct{}
+
+func (testExtension) Commit(chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (common.Hash, error) {
+	return common.Hash{}, nil
+}
+
+func (testExtension) VerifyHeader(chain ChainReader, header *types.Header) error { return nil }
+
+// Tests that header extensions are refused on proof-of-authority chains and
+// for providers nobody registered.
+func TestCheckHeaderExtension(t *testing.T) {
+	RegisterHeaderExtension("test-check", testExtension{})
+
+	tests := []struct {
+		config *params.ChainConfig
+		ok     bool
+	}{
+		{&params.ChainConfig{}, true},
+		{&params.ChainConfig{HeaderExtension: "test-check"}, true},
+		{&params.ChainConfig{HeaderExtension: "test-check", Clique: &params.CliqueConfig{}}, false},
+		{&params.ChainConfig{HeaderExtension: "unknown"}, false},
+	}
+	for i, tt := range tests {
+		if err := CheckHeaderExtension(tt.config); (err == nil) != tt.ok {
+			t.Errorf("test %d: validity mismatch: have %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
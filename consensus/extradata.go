@@ -0,0 +1,62 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+// ErrReservedExtraData is returned if the extra-data of a header starts with a
+// prefix reserved by the chain config.
+var ErrReservedExtraData = errors.New("extra-data uses reserved prefix")
+
+// cliqueVanity is the size of the vanity leading the extra-data of clique
+// headers, followed by the signer list and the seal.
+const cliqueVanity = 32
+
+// CheckExtraDataPrefixes checks a vanity against the prefixes reserved by the
+// chain config, whether the policy is active yet or not.
+func CheckExtraDataPrefixes(config *params.ChainConfig, vanity []byte) error {
+	for _, prefix := range config.ReservedExtraPrefixes {
+		if len(prefix) > 0 && bytes.HasPrefix(vanity, prefix) {
+			return fmt.Errorf("%v: %x", ErrReservedExtraData, []byte(prefix))
+		}
+	}
+	return nil
+}
+
+// VerifyExtraDataPolicy checks the vanity part of a header's extra-data against
+// the prefixes reserved by the chain config, once the extra-data policy fork is
+// active. Headers from before the fork are accepted whatever their extra-data,
+// so reserving a prefix doesn't invalidate the existing chain. The vanity is
+// the first 32 bytes of the extra-data on clique, which seals into the rest,
+// and the whole of it otherwise.
+func VerifyExtraDataPolicy(config *params.ChainConfig, header *types.Header) error {
+	if !config.IsExtraDataPolicy(header.Number) {
+		return nil
+	}
+	vanity := header.Extra
+	if config.Clique != nil && len(vanity) > cliqueVanity {
+		vanity = vanity[:cliqueVanity]
+	}
+	return CheckExtraDataPrefixes(config, vanity)
+}
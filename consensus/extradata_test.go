@@ -0,0 +1,55 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/BHEereum/go-BHEereum/common/hexutil"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+// Tests that reserved prefixes are only enforced on headers past the policy
+// fork, and only in the vanity of clique headers.
+func TestVerifyExtraDataPolicy(t *testing.T) {
+	var (
+		reserved = []hexutil.Bytes{[]byte("pool:")}
+		pow      = &params.ChainConfig{ReservedExtraPrefixes: reserved, ExtraDataPolicyBlock: big.NewInt(10)}
+		poa      = &params.ChainConfig{ReservedExtraPrefixes: reserved, ExtraDataPolicyBlock: big.NewInt(10), Clique: &params.CliqueConfig{}}
+		vanity   = make([]byte, 32)
+	)
+	tests := []struct {
+		config *params.ChainConfig
+		number int64
+		extra  []byte
+		valid  bool
+	}{
+		{pow, 9, []byte("pool:fake"), true},
+		{pow, 10, []byte("pool:fake"), false},
+		{pow, 10, []byte("mined by pool:"), true},
+		{poa, 10, append([]byte("pool:fake"), make([]byte, 32+65)...), false},
+		{poa, 10, append(vanity, []byte("pool:")...), true},
+	}
+	for i, tt := range tests {
+		header := &types.Header{Number: big.NewInt(tt.number), Extra: tt.extra}
+		if err := VerifyExtraDataPolicy(tt.config, header); (err == nil) != tt.valid {
+			t.Errorf("test %d: validity mismatch: have %v, want valid %v", i, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"math/big"
+
+	"github.com/BHEereum/go-BHEereum/core/state"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+var big100 = big.NewInt(100)
+
+// BlockFees sums the transaction fees paid in a block, which the state
+// transition credited to its coinbase.
+func BlockFees(txs []*types.Transaction, receipts []*types.Receipt) *big.Int {
+	fees := new(big.Int)
+	for i, tx := range txs {
+		if i < len(receipts) {
+			fees.Add(fees, new(big.Int).Mul(new(big.Int).SetUint64(receipts[i].GasUsed), tx.GasPrice()))
+		}
+	}
+	return fees
+}
+
+// FeeSplit returns the shares of the transaction fees of a block that are burnt
+// and paid to the treasury once the fee split fork is active, both zero before.
+// Percentages beyond the whole of the fees are capped, the burn taking
+// precedence.
+func FeeSplit(config *params.ChainConfig, header *types.Header, fees *big.Int) (burnt *big.Int, treasury *big.Int) {
+	burnt, treasury = new(big.Int), new(big.Int)
+	if !config.IsFeeSplit(header.Number) {
+		return burnt, treasury
+	}
+	burnPercent := config.FeeSplit.BurnPercent
+	if burnPercent > 100 {
+		burnPercent = 100
+	}
+	treasuryPercent := config.FeeSplit.TreasuryPercent
+	if treasuryPercent > 100-burnPercent {
+		treasuryPercent = 100 - burnPercent
+	}
+	burnt.Mul(fees, new(big.Int).SetUint64(burnPercent)).Div(burnt, big100)
+	treasury.Mul(fees, new(big.Int).SetUint64(treasuryPercent)).Div(treasury, big100)
+	return burnt, treasury
+}
+
+// FeeSplitter is implemented by engines applying the fee split to a block in
+// their own way instead of the ApplyFeeSplit default.
+type FeeSplitter interface {
+	// SplitFees applies the fee split to the state once the transactions of the
+	// block ran, failing the block if it can't be applied.
+	SplitFees(chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) error
+}
+
+// SplitFees applies the fee split to a block through the engine's FeeSplitter,
+// or ApplyFeeSplit if the engine doesn't implement one. The state processor and
+// the miner run it for every engine once the transactions of the block ran,
+// before finalizing it, so the split holds whatever the consensus.
+func SplitFees(engine Engine, chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) error {
+	if splitter, ok := engine.(FeeSplitter); ok {
+		return splitter.SplitFees(chain, header, state, txs, receipts)
+	}
+	return ApplyFeeSplit(chain.Config(), state, header, txs, receipts)
+}
+
+// ApplyFeeSplit takes the burnt and treasury shares of the transaction fees of
+// a block back from its coinbase, crediting the treasury share to the treasury
+// address, if the fee split fork is active. It runs before the rewards are
+// credited and the state root is computed at finalization.
+//
+// The fees were credited to the coinbase as the transactions ran, so it may have
+// spent them within the block already. Such a block is invalid: the shares are
+// never taken from a balance that can't cover them, which would mint coins.
+func ApplyFeeSplit(config *params.ChainConfig, state *state.StateDB, header *types.Header, txs []*types.Transaction, receipts []*types.Receipt) error {
+	if !config.IsFeeSplit(header.Number) {
+		return nil
+	}
+	burnt, treasury := FeeSplit(config, header, BlockFees(txs, receipts))
+	shares := new(big.Int).Add(burnt, treasury)
+	if state.GetBalance(header.Coinbase).Cmp(shares) < 0 {
+		return ErrFeeSplitUnderflow
+	}
+	state.SubBalance(header.Coinbase, shares)
+	state.AddBalance(config.FeeSplit.Treasury, treasury)
+	return nil
+}
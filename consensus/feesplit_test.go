@@ -0,0 +1,165 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/core/rawdb"
+	"github.com/BHEereum/go-BHEereum/core/state"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+var (
+	feeSplitCoinbase = common.HexToAddress("0xc0ffee")
+	feeSplitTreasury = common.HexToAddress("0x7ea5")
+)
+
+// feeSplitConfig returns a chain config splitting the fees from genesis on.
+func feeSplitConfig(burn, treasury uint64) *params.ChainConfig {
+	config := *params.TestChainConfig
+	config.FeeSplitBlock = big.NewInt(0)
+	config.FeeSplit = &params.FeeSplitConfig{BurnPercent: burn, TreasuryPercent: treasury, Treasury: feeSplitTreasury}
+	return &config
+}
+
+// feeSplitBlock returns two transactions paying 21000*10 and 50000*20 wei of
+// fees, along with their receipts.
+func feeSplitBlock() ([]*types.Transaction, []*types.Receipt) {
+	txs := []*types.Transaction{
+		types.NewTransaction(0, common.Address{}, nil, 21000, big.NewInt(10), nil),
+		types.NewTransaction(1, common.Address{}, nil, 100000, big.NewInt(20), nil),
+	}
+	receipts := []*types.Receipt{{GasUsed: 21000}, {GasUsed: 50000}}
+	return txs, receipts
+}
+
+// Tests that block fees are summed from the gas used, not the gas limit.
+func TestBlockFees(t *testing.T) {
+	txs, receipts := feeSplitBlock()
+	if fees := BlockFees(txs, receipts); fees.Cmp(big.NewInt(1210000)) != 0 {
+		t.Errorf("fees mismatch: have %v, want %v", fees, 1210000)
+	}
+	if fees := BlockFees(txs, receipts[:1]); fees.Cmp(big.NewInt(210000)) != 0 {
+		t.Errorf("fees without all receipts mismatch: have %v, want %v", fees, 210000)
+	}
+}
+
+// Tests that the fee shares are zero before the fork and capped at the whole of
+// the fees, the burn taking precedence.
+func TestFeeSplit(t *testing.T) {
+	fees := big.NewInt(1000)
+	tests := []struct {
+		config   *params.ChainConfig
+		burnt    int64
+		treasury int64
+	}{
+		{params.TestChainConfig, 0, 0},
+		{feeSplitConfig(30, 20), 300, 200},
+		{feeSplitConfig(80, 50), 800, 200},
+		{feeSplitConfig(150, 10), 1000, 0},
+	}
+	for i, tt := range tests {
+		burnt, treasury := FeeSplit(tt.config, &types.Header{Number: big.NewInt(1)}, fees)
+		if burnt.Int64() != tt.burnt || treasury.Int64() != tt.treasury {
+			t.Errorf("test %d: shares mismatch: have %v/%v, want %v/%v", i, burnt, treasury, tt.burnt, tt.treasury)
+		}
+	}
+}
+
+// Tests that the fee split takes its shares from the coinbase, and refuses a
+// block whose coinbase spent the fees credited to it.
+func TestApplyFeeSplit(t *testing.T) {
+	var (
+		config        = feeSplitConfig(30, 20)
+		header        = &types.Header{Number: big.NewInt(1), Coinbase: feeSplitCoinbase}
+		txs, receipts = feeSplitBlock()
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(feeSplitCoinbase, big.NewInt(1210000))
+
+	if err := ApplyFeeSplit(config, statedb, header, txs, receipts); err != nil {
+		t.Fatalf("failed to split fees: %v", err)
+	}
+	if balance := statedb.GetBalance(feeSplitCoinbase); balance.Cmp(big.NewInt(605000)) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", balance, 605000)
+	}
+	if balance := statedb.GetBalance(feeSplitTreasury); balance.Cmp(big.NewInt(242000)) != 0 {
+		t.Errorf("treasury balance mismatch: have %v, want %v", balance, 242000)
+	}
+	// Spend the fees from the coinbase as if it sent a transaction in the block
+	spent, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	spent.AddBalance(feeSplitCoinbase, big.NewInt(100000))
+
+	if err := ApplyFeeSplit(config, spent, header, txs, receipts); err != ErrFeeSplitUnderflow {
+		t.Fatalf("spent fees error mismatch: have %v, want %v", err, ErrFeeSplitUnderflow)
+	}
+	if balance := spent.GetBalance(feeSplitCoinbase); balance.Cmp(big.NewInt(100000)) != 0 {
+		t.Errorf("coinbase balance changed: have %v, want %v", balance, 100000)
+	}
+	if balance := spent.GetBalance(feeSplitTreasury); balance.Sign() != 0 {
+		t.Errorf("treasury credited from spent fees: %v", balance)
+	}
+}
+
+// feeSplitChain is a chain reader only serving the chain config.
+type feeSplitChain struct {
+	ChainReader
+	config *params.ChainConfig
+}
+
+func (c *feeSplitChain) Config() *params.ChainConfig { return c.config }
+
+// feeSplitEngine is an engine overriding the fee split.
+type feeSplitEngine struct {
+	Engine
+	err error
+}
+
+func (e *feeSplitEngine) SplitFees(chain ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) error {
+	return e.err
+}
+
+// Tests that the fee split runs through the engine's splitter if it has one,
+// and through the default split for any other engine.
+func TestSplitFees(t *testing.T) {
+	var (
+		chain         = &feeSplitChain{config: feeSplitConfig(30, 20)}
+		header        = &types.Header{Number: big.NewInt(1), Coinbase: feeSplitCoinbase}
+		txs, receipts = feeSplitBlock()
+		errOverride   = errors.New("overridden")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(feeSplitCoinbase, big.NewInt(1210000))
+
+	if err := SplitFees(&feeSplitEngine{err: errOverride}, chain, header, statedb, txs, receipts); err != errOverride {
+		t.Fatalf("override error mismatch: have %v, want %v", err, errOverride)
+	}
+	if balance := statedb.GetBalance(feeSplitCoinbase); balance.Cmp(big.NewInt(1210000)) != 0 {
+		t.Errorf("coinbase balance changed by the override: have %v, want %v", balance, 1210000)
+	}
+	if err := SplitFees(struct{ Engine }{}, chain, header, statedb, txs, receipts); err != nil {
+		t.Fatalf("failed to split fees: %v", err)
+	}
+	if balance := statedb.GetBalance(feeSplitTreasury); balance.Cmp(big.NewInt(242000)) != 0 {
+		t.Errorf("treasury balance mismatch: have %v, want %v", balance, 242000)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/BHEereum/go-BHEereum/core/types"
+)
+
+// ErrSealRejected is returned by sealer hooks refusing to co-sign a block.
+var ErrSealRejected = errors.New("seal rejected by co-signers")
+
+// SealerHook collects co-signatures (e.g. from an HSM cluster or an MPC
+// service) for a block sealed by the local engine before it is published.
+type SealerHook interface {
+	// CoSign is called with a freshly sealed block and returns the block to be
+	// published, which may carry the co-signatures in fields not covered by the
+	// engine's seal. An error discards the block. The context is cancelled once
+	// the sealing work is abandoned by the miner.
+	CoSign(ctx context.Context, chain ChainReader, block *types.Block) (*types.Block, error)
+}
+
+// HookableSealer is implemented by engines able to run their sealed blocks
+// through a SealerHook.
+type HookableSealer interface {
+	SetSealerHook(hook SealerHook)
+}
+
+var (
+	sealerHooks     = make(map[string]SealerHook)
+	sealerHooksLock sync.RWMutex
+)
+
+// RegisterSealerHook makes a sealer hook available by name, to be selected by
+// the node configuration. It panics if the name is taken.
+func RegisterSealerHook(name string, hook SealerHook) {
+	sealerHooksLock.Lock()
+	defer sealerHooksLock.Unlock()
+
+	if _, ok := sealerHooks[name]; ok {
+		panic(fmt.Sprintf("sealer hook %q already registered", name))
+	}
+	sealerHooks[name] = hook
+}
+
+// InstallSealerHook looks up a registered sealer hook by name and installs it
+// into an engine.
+func InstallSealerHook(engine Engine, name string) error {
+	sealerHooksLock.RLock()
+	hook, ok := sealerHooks[name]
+	sealerHooksLock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("unknown sealer hook %q", name)
+	}
+	sealer, ok := engine.(HookableSealer)
+	if !ok {
+		return fmt.Errorf("consensus engine %T doesn't support sealer hooks", engine)
+	}
+	sealer.SetSealerHook(hook)
+	return nil
+}
+
+// CoSignResults interposes a sealer hook between an engine and the channel its
+// sealing results are delivered on. The returned channel is to be handed to the
+// engine in place of results; the first block delivered on it is co-signed and
+// forwarded to results, unless sealing is stopped first. The verify callback
+// checks that the co-signed block still carries a valid seal.
+func CoSignResults(hook SealerHook, chain ChainReader, results chan<- *types.Block, stop <-chan struct{}, verify func(*types.Block) error, onError func(*types.Block, error)) chan<- *types.Block {
+	sealed := make(chan *types.Block, 1)
+
+	go func() {
+		var block *types.Block
+		select {
+		case block = <-sealed:
+		case <-stop:
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		signed, err := hook.CoSign(ctx, chain, block)
+		if err == nil && signed == nil {
+			err = ErrSealRejected
+		}
+		if err == nil {
+			err = verify(signed)
+		}
+		if err != nil {
+			onError(block, err)
+			return
+		}
+		select {
+		case results <- signed:
+		case <-stop:
+		}
+	}()
+	return sealed
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/BHEereum/go-BHEereum/BHEdb/memorydb"
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/crypto"
+	"github.com/BHEereum/go-BHEereum/params"
+	"github.com/BHEereum/go-BHEereum/rlp"
+	"github.com/BHEereum/go-BHEereum/trie"
+)
+
+const (
+	bridgeBaseGas    = 3000 // Base price of a bridge proof verification
+	bridgePerWordGas = 12   // Price per 32 byte word of input
+)
+
+// Trie roots of a foreign header that bridge proofs can be verified against.
+const (
+	BridgeReceiptRoot = iota
+	BridgeStateRoot
+	BridgeTxRoot
+)
+
+// ErrBridgeAttestation is returned if a foreign header isn't signed by enough
+// relayers.
+var ErrBridgeAttestation = errors.New("insufficient relayer attestations")
+
+// BridgeAttestationData returns the payload relayers sign to attest a foreign
+// header: the EIP-191 version 0 prefix, the foreign chain id and the header
+// hash.
+func BridgeAttestationData(chainID *big.Int, hash common.Hash) []byte {
+	data := append([]byte{0x19, 0x00}, common.BigToHash(chainID).Bytes()...)
+	return append(data, hash.Bytes()...)
+}
+
+// VerifyBridgeAttestation checks that at least threshold distinct relayers out
+// of the given set signed a foreign header. Signatures of unknown signers are
+// ignored.
+func VerifyBridgeAttestation(chainID *big.Int, hash common.Hash, relayers []common.Address, threshold int, sigs [][]byte) error {
+	allowed := make(map[common.Address]bool, len(relayers))
+	for _, relayer := range relayers {
+		allowed[relayer] = true
+	}
+	var (
+		digest = crypto.Keccak256(BridgeAttestationData(chainID, hash))
+		signed = make(map[common.Address]bool)
+	)
+	for _, sig := range sigs {
+		if len(sig) != crypto.SignatureLength {
+			continue
+		}
+		plain := common.CopyBytes(sig)
+		if plain[crypto.RecoveryIDOffset] >= 27 {
+			plain[crypto.RecoveryIDOffset] -= 27
+		}
+		pubkey, err := crypto.SigToPub(digest, plain)
+		if err != nil {
+			continue
+		}
+		if signer := crypto.PubkeyToAddress(*pubkey); allowed[signer] {
+			signed[signer] = true
+		}
+	}
+	if threshold < 1 || len(signed) < threshold {
+		return fmt.Errorf("%v: have %d, want %d", ErrBridgeAttestation, len(signed), threshold)
+	}
+	return nil
+}
+
+// VerifyBridgeProof checks a Merkle-Patricia proof for key against root,
+// returning the proven value.
+func VerifyBridgeProof(root common.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	db := memorydb.New()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	value, err := trie.VerifyProof(root, key, db)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof: %v", err)
+	}
+	if value == nil {
+		return nil, errors.New("proof of absence")
+	}
+	return value, nil
+}
+
+// BridgeProofInput is the RLP encoded input of the bridge precompile: a foreign
+// header with the relayer signatures attesting it, and a Merkle proof of a key
+// in one of its tries.
+type BridgeProofInput struct {
+	Header     []byte   // RLP encoded foreign header
+	Signatures [][]byte // Relayer signatures of the header
+	Root       uint64   // Trie proven against: receipts, state or transactions
+	Key        []byte   // Trie key, e.g. the RLP encoded receipt index
+	Proof      [][]byte // Merkle-Patricia proof nodes
+}
+
+// bridgePrecompile verifies cross-chain claims for contracts. Proofs are only
+// checked against headers attested by the relayer set of the chain config, as
+// the precompile can't consult node-local state like the verified foreign
+// chain without breaking consensus. The output is the proven trie value.
+type bridgePrecompile struct {
+	chainID   *big.Int
+	relayers  []common.Address
+	threshold int
+}
+
+// newBridgePrecompile creates the bridge precompile for the relayer set of the
+// chain config.
+func newBridgePrecompile(config *params.BridgeRelayConfig) (*bridgePrecompile, error) {
+	if config.ChainID == nil || len(config.Relayers) == 0 {
+		return nil, errors.New("bridge precompile requires a foreign chain id and relayers")
+	}
+	if config.Threshold < 1 || config.Threshold > len(config.Relayers) {
+		return nil, fmt.Errorf("invalid bridge relayer threshold %d of %d", config.Threshold, len(config.Relayers))
+	}
+	return &bridgePrecompile{chainID: config.ChainID, relayers: config.Relayers, threshold: config.Threshold}, nil
+}
+
+func (p *bridgePrecompile) RequiredGas(input []byte) uint64 {
+	gas := uint64(len(input)+31)/32*bridgePerWordGas + bridgeBaseGas
+	var in BridgeProofInput
+	if err := rlp.DecodeBytes(input, &in); err == nil {
+		gas += uint64(len(in.Signatures)) * params.EcrecoverGas
+	}
+	return gas
+}
+
+func (p *bridgePrecompile) Run(input []byte) ([]byte, error) {
+	var in BridgeProofInput
+	if err := rlp.DecodeBytes(input, &in); err != nil {
+		return nil, fmt.Errorf("invalid bridge proof input: %v", err)
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(in.Header, header); err != nil {
+		return nil, fmt.Errorf("invalid foreign header: %v", err)
+	}
+	if err := VerifyBridgeAttestation(p.chainID, header.Hash(), p.relayers, p.threshold, in.Signatures); err != nil {
+		return nil, err
+	}
+	var root common.Hash
+	switch in.Root {
+	case BridgeReceiptRoot:
+		root = header.ReceiptHash
+	case BridgeStateRoot:
+		root = header.Root
+	case BridgeTxRoot:
+		root = header.TxHash
+	default:
+		return nil, fmt.Errorf("unknown bridge proof root %d", in.Root)
+	}
+	return VerifyBridgeProof(root, in.Key, in.Proof)
+}
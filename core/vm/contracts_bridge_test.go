@@ -0,0 +1,142 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/BHEereum/go-BHEereum/BHEdb/memorydb"
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/core/types"
+	"github.com/BHEereum/go-BHEereum/crypto"
+	"github.com/BHEereum/go-BHEereum/params"
+	"github.com/BHEereum/go-BHEereum/rlp"
+	"github.com/BHEereum/go-BHEereum/trie"
+)
+
+// bridgeProof collects the nodes of a Merkle-Patricia proof.
+type bridgeProof [][]byte
+
+func (p *bridgeProof) Put(key []byte, value []byte) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func (p *bridgeProof) Delete(key []byte) error {
+	panic("not supported")
+}
+
+// signBridgeAttestation signs a foreign header hash as a relayer.
+func signBridgeAttestation(t *testing.T, key *ecdsa.PrivateKey, chainID *big.Int, hash common.Hash) []byte {
+	sig, err := crypto.Sign(crypto.Keccak256(BridgeAttestationData(chainID, hash)), key)
+	if err != nil {
+		t.Fatalf("failed to sign attestation: %v", err)
+	}
+	return sig
+}
+
+// Tests that foreign headers need attestations of enough distinct relayers.
+func TestBridgeAttestation(t *testing.T) {
+	var (
+		chainID  = big.NewInt(7)
+		hash     = common.HexToHash("0x01")
+		keys     = make([]*ecdsa.PrivateKey, 3)
+		relayers = make([]common.Address, 2)
+	)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	for i := range relayers {
+		relayers[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	var (
+		sig0     = signBridgeAttestation(t, keys[0], chainID, hash)
+		sig1     = signBridgeAttestation(t, keys[1], chainID, hash)
+		outsider = signBridgeAttestation(t, keys[2], chainID, hash)
+		replayed = signBridgeAttestation(t, keys[1], big.NewInt(8), hash)
+	)
+	tests := []struct {
+		sigs [][]byte
+		ok   bool
+	}{
+		{[][]byte{sig0, sig1}, true},
+		{[][]byte{sig0}, false},
+		{[][]byte{sig0, sig0}, false},     // Same relayer twice
+		{[][]byte{sig0, outsider}, false}, // Not a relayer
+		{[][]byte{sig0, replayed}, false}, // Signed for another chain
+		{[][]byte{sig0, sig1[:64]}, false},
+	}
+	for i, tt := range tests {
+		err := VerifyBridgeAttestation(chainID, hash, relayers, 2, tt.sigs)
+		if (err == nil) != tt.ok {
+			t.Errorf("test %d: attestation mismatch: have %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
+
+// Tests that the bridge precompile returns values proven against attested
+// foreign headers only.
+func TestBridgePrecompile(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	chainID := big.NewInt(7)
+	bridge := &params.BridgeRelayConfig{Address: common.HexToAddress("0x0200"), ChainID: chainID, Relayers: []common.Address{crypto.PubkeyToAddress(key.PublicKey)}, Threshold: 1}
+	ext, err := ResolveExtensions(&params.ChainConfig{BridgeRelay: bridge})
+	if err != nil {
+		t.Fatalf("failed to resolve extensions: %v", err)
+	}
+	precompile, ok := ext.Precompile(big.NewInt(0), bridge.Address)
+	if !ok {
+		t.Fatalf("bridge precompile not active")
+	}
+	receipts, _ := trie.New(common.Hash{}, trie.NewDatabase(memorydb.New()))
+	receiptKey, _ := rlp.EncodeToBytes(uint(0))
+	receipts.Update(receiptKey, []byte("receipt"))
+
+	var proof bridgeProof
+	if err := receipts.Prove(receiptKey, 0, &proof); err != nil {
+		t.Fatalf("failed to prove receipt: %v", err)
+	}
+	header := &types.Header{Number: big.NewInt(100), Difficulty: big.NewInt(1), ReceiptHash: receipts.Hash()}
+	blob, _ := rlp.EncodeToBytes(header)
+
+	input := func(sigs [][]byte, root uint64) []byte {
+		in := &BridgeProofInput{Header: blob, Signatures: sigs, Root: root, Key: receiptKey, Proof: proof}
+		enc, _ := rlp.EncodeToBytes(in)
+		return enc
+	}
+	sig := signBridgeAttestation(t, key, chainID, header.Hash())
+
+	out, err := precompile.Run(input([][]byte{sig}, BridgeReceiptRoot))
+	if err != nil {
+		t.Fatalf("failed to verify proof: %v", err)
+	}
+	if !bytes.Equal(out, []byte("receipt")) {
+		t.Errorf("proven value mismatch: have %q, want %q", out, "receipt")
+	}
+	if _, err := precompile.Run(input(nil, BridgeReceiptRoot)); err == nil {
+		t.Errorf("unattested header accepted")
+	}
+	if _, err := precompile.Run(input([][]byte{sig}, BridgeStateRoot)); err == nil {
+		t.Errorf("proof accepted against the wrong root")
+	}
+	if signed, unsigned := precompile.RequiredGas(input([][]byte{sig}, BridgeReceiptRoot)), precompile.RequiredGas(input(nil, BridgeReceiptRoot)); signed < unsigned+params.EcrecoverGas {
+		t.Errorf("signature verification not charged: have %d, unsigned %d", signed, unsigned)
+	}
+}
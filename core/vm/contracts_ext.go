@@ -0,0 +1,106 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"fmt"
+	"sync"
+)
+
+const (
+	sha512BaseGas    = 60   // Base price of a SHA512 hash
+	sha512PerWordGas = 12   // Price per 32 byte word of hashed data
+	ed25519VerifyGas = 2000 // Base price of an ed25519 signature verification
+	ed25519WordGas   = 12   // Price per 32 byte word of signed message
+)
+
+var (
+	extensionPrecompiles = map[string]PrecompiledContract{
+		"sha512":        &sha512Precompile{},
+		"ed25519Verify": &ed25519Precompile{},
+	}
+	extensionPrecompilesLock sync.RWMutex
+)
+
+// RegisterPrecompile makes a precompiled contract available by name, to be
+// activated at an address and fork block by the chain config. It panics if the
+// name is taken. Every node of a network must register the same contracts, so
+// registration belongs in the init of a package the client binary imports.
+func RegisterPrecompile(name string, contract PrecompiledContract) {
+	extensionPrecompilesLock.Lock()
+	defer extensionPrecompilesLock.Unlock()
+
+	if _, ok := extensionPrecompiles[name]; ok {
+		panic(fmt.Sprintf("precompile %q already registered", name))
+	}
+	extensionPrecompiles[name] = contract
+}
+
+// lookupPrecompile returns the precompiled contract registered by name.
+func lookupPrecompile(name string) (PrecompiledContract, error) {
+	extensionPrecompilesLock.RLock()
+	defer extensionPrecompilesLock.RUnlock()
+
+	contract, ok := extensionPrecompiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown precompile %q", name)
+	}
+	return contract, nil
+}
+
+// sha512Precompile returns the SHA512 hash of its input.
+type sha512Precompile struct{}
+
+func (c *sha512Precompile) RequiredGas(input []byte) uint64 {
+	return uint64(len(input)+31)/32*sha512PerWordGas + sha512BaseGas
+}
+
+func (c *sha512Precompile) Run(input []byte) ([]byte, error) {
+	hash := sha512.Sum512(input)
+	return hash[:], nil
+}
+
+// ed25519Precompile verifies an ed25519 signature. The input is the 32 byte
+// public key, the 64 byte signature and the message; the output is a word set
+// to one if the signature is valid and zero otherwise.
+type ed25519Precompile struct{}
+
+func (c *ed25519Precompile) RequiredGas(input []byte) uint64 {
+	message := 0
+	if len(input) > ed25519.PublicKeySize+ed25519.SignatureSize {
+		message = len(input) - ed25519.PublicKeySize - ed25519.SignatureSize
+	}
+	return uint64(message+31)/32*ed25519WordGas + ed25519VerifyGas
+}
+
+func (c *ed25519Precompile) Run(input []byte) ([]byte, error) {
+	result := make([]byte, 32)
+	if len(input) < ed25519.PublicKeySize+ed25519.SignatureSize {
+		return result, nil
+	}
+	var (
+		key = ed25519.PublicKey(input[:ed25519.PublicKeySize])
+		sig = input[ed25519.PublicKeySize : ed25519.PublicKeySize+ed25519.SignatureSize]
+		msg = input[ed25519.PublicKeySize+ed25519.SignatureSize:]
+	)
+	if ed25519.Verify(key, msg, sig) {
+		result[31] = 1
+	}
+	return result, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"math/big"
+	"testing"
+
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+// resolvePrecompile returns the extension precompile a chain config activates
+// at an address from the given block.
+func resolvePrecompile(t *testing.T, name string, addr common.Address, number int64) PrecompiledContract {
+	ext, err := ResolveExtensions(&params.ChainConfig{Precompiles: []params.PrecompileConfig{{Name: name, Address: addr}}})
+	if err != nil {
+		t.Fatalf("failed to resolve extensions: %v", err)
+	}
+	contract, ok := ext.Precompile(big.NewInt(number), addr)
+	if !ok {
+		t.Fatalf("precompile %q not active", name)
+	}
+	return contract
+}
+
+// Tests that the SHA512 precompile hashes its input and charges per word.
+func TestSHA512Precompile(t *testing.T) {
+	precompile := resolvePrecompile(t, "sha512", common.HexToAddress("0x0100"), 0)
+
+	input := []byte("hello world")
+	out, err := precompile.Run(input)
+	if err != nil {
+		t.Fatalf("failed to hash: %v", err)
+	}
+	if want := sha512.Sum512(input); !bytes.Equal(out, want[:]) {
+		t.Errorf("hash mismatch: have %x, want %x", out, want)
+	}
+	if gas := precompile.RequiredGas(make([]byte, 33)); gas != 60+2*12 {
+		t.Errorf("gas mismatch: have %d, want %d", gas, 60+2*12)
+	}
+}
+
+// Tests that the ed25519 precompile accepts valid signatures only.
+func TestEd25519Precompile(t *testing.T) {
+	precompile := resolvePrecompile(t, "ed25519Verify", common.HexToAddress("0x0100"), 0)
+
+	pub, key, _ := ed25519.GenerateKey(bytes.NewReader(make([]byte, 64)))
+	msg := []byte("bridge transfer")
+	sig := ed25519.Sign(key, msg)
+
+	valid := append(append(append([]byte{}, pub...), sig...), msg...)
+	tampered := append([]byte{}, valid...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		input []byte
+		want  byte
+	}{
+		{valid, 1},
+		{tampered, 0},
+		{valid[:80], 0}, // Truncated signature
+		{nil, 0},
+	}
+	for i, tt := range tests {
+		out, err := precompile.Run(tt.input)
+		if err != nil {
+			t.Fatalf("test %d: failed to verify: %v", i, err)
+		}
+		if len(out) != 32 || out[31] != tt.want {
+			t.Errorf("test %d: result mismatch: have %x, want %d", i, out, tt.want)
+		}
+	}
+}
+
+// Tests that extension precompiles only exist from their activation block on,
+// and that invalid activations are rejected.
+func TestPrecompileActivation(t *testing.T) {
+	addr := common.HexToAddress("0x0100")
+	ext, err := ResolveExtensions(&params.ChainConfig{Precompiles: []params.PrecompileConfig{{Name: "sha512", Address: addr, Block: big.NewInt(10)}}})
+	if err != nil {
+		t.Fatalf("failed to resolve extensions: %v", err)
+	}
+	if _, ok := ext.Precompile(big.NewInt(9), addr); ok {
+		t.Errorf("precompile active before its fork")
+	}
+	if _, ok := ext.Precompile(big.NewInt(10), addr); !ok {
+		t.Errorf("precompile inactive at its fork")
+	}
+	if _, ok := ext.Precompile(big.NewInt(10), common.HexToAddress("0x0101")); ok {
+		t.Errorf("precompile found at unknown address")
+	}
+	invalid := [][]params.PrecompileConfig{
+		{{Name: "nope", Address: addr}},
+		{{Name: "sha512", Address: common.BytesToAddress([]byte{1})}}, // Shadows ecrecover
+		{{Name: "sha512", Address: addr}, {Name: "ed25519Verify", Address: addr}},
+	}
+	for i, precompiles := range invalid {
+		if _, err := ResolveExtensions(&params.ChainConfig{Precompiles: precompiles}); err == nil {
+			t.Errorf("test %d: invalid activation accepted", i)
+		}
+	}
+}
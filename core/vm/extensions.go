@@ -0,0 +1,257 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/BHEereum/go-BHEereum/common"
+	"github.com/BHEereum/go-BHEereum/log"
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+// ChainExtensions are the chain specific EVM rules of a chain config: extension
+// precompiles, opcode gas cost overrides and contract size limits. They are
+// consensus rules, so NewEVM resolves them from the chain config it is given
+// rather than taking them through Config, and every execution of the chain,
+// whoever sets it up, runs them.
+type ChainExtensions struct {
+	precompiles map[common.Address]*precompileActivation
+	gasForks    []gasOverrideFork   // Opcode gas overrides in fork order
+	limitForks  []contractLimitFork // Contract size limits in fork order
+}
+
+var chainExtensions sync.Map // *params.ChainConfig -> resolvedExtensions
+
+// resolvedExtensions caches the outcome of resolving a chain config.
+type resolvedExtensions struct {
+	ext *ChainExtensions
+	err error
+}
+
+// ResolveExtensions returns the EVM extensions of a chain config, nil if it has
+// none. Configs are resolved once and must not change afterwards. Nodes call
+// it at startup to reject invalid configs before executing anything.
+func ResolveExtensions(config *params.ChainConfig) (*ChainExtensions, error) {
+	if cached, ok := chainExtensions.Load(config); ok {
+		res := cached.(resolvedExtensions)
+		return res.ext, res.err
+	}
+	ext, err := newChainExtensions(config)
+	cached, _ := chainExtensions.LoadOrStore(config, resolvedExtensions{ext, err})
+	res := cached.(resolvedExtensions)
+	return res.ext, res.err
+}
+
+// newChainExtensions resolves the EVM extensions of a chain config.
+func newChainExtensions(config *params.ChainConfig) (*ChainExtensions, error) {
+	if len(config.Precompiles) == 0 && config.BridgeRelay == nil && len(config.GasOverrides) == 0 && len(config.ContractLimits) == 0 {
+		return nil, nil
+	}
+	ext := &ChainExtensions{precompiles: make(map[common.Address]*precompileActivation)}
+	if err := ext.resolvePrecompiles(config); err != nil {
+		return nil, err
+	}
+	if err := ext.resolveGasOverrides(config.GasOverrides); err != nil {
+		return nil, err
+	}
+	if err := ext.resolveContractLimits(config.ContractLimits); err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// precompileActivation is a precompiled contract activated at an address from
+// a fork block on.
+type precompileActivation struct {
+	name     string
+	block    *big.Int
+	contract PrecompiledContract
+}
+
+// resolvePrecompiles resolves the precompiles activated by the chain config,
+// including the bridge precompile of its relayer set. An address can't be
+// activated twice nor shadow one of the EVM's own precompiles.
+func (ext *ChainExtensions) resolvePrecompiles(config *params.ChainConfig) error {
+	activate := func(name string, addr common.Address, block *big.Int, contract PrecompiledContract) error {
+		if _, ok := PrecompiledContractsIstanbul[addr]; ok {
+			return fmt.Errorf("precompile %q shadows builtin at %x", name, addr)
+		}
+		if prev, ok := ext.precompiles[addr]; ok {
+			return fmt.Errorf("precompiles %q and %q both at %x", prev.name, name, addr)
+		}
+		if block == nil {
+			block = new(big.Int)
+		}
+		ext.precompiles[addr] = &precompileActivation{name: name, block: block, contract: contract}
+		log.Info("Activating extension precompile", "name", name, "address", addr, "block", block)
+		return nil
+	}
+	for _, precompile := range config.Precompiles {
+		contract, err := lookupPrecompile(precompile.Name)
+		if err != nil {
+			return err
+		}
+		if err := activate(precompile.Name, precompile.Address, precompile.Block, contract); err != nil {
+			return err
+		}
+	}
+	if bridge := config.BridgeRelay; bridge != nil {
+		contract, err := newBridgePrecompile(bridge)
+		if err != nil {
+			return err
+		}
+		if err := activate("bridge", bridge.Address, bridge.Block, contract); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Precompile returns the extension precompile at an address if it is active at
+// the given block. The EVM consults it next to its own precompiles.
+func (ext *ChainExtensions) Precompile(number *big.Int, addr common.Address) (PrecompiledContract, bool) {
+	if ext == nil {
+		return nil, false
+	}
+	act, ok := ext.precompiles[addr]
+	if !ok || number.Cmp(act.block) < 0 {
+		return nil, false
+	}
+	return act.contract, true
+}
+
+// gasOverrideFork is a set of opcode gas costs taking effect at a fork block.
+type gasOverrideFork struct {
+	block *big.Int
+	costs map[OpCode]uint64
+}
+
+// resolveGasOverrides resolves the opcode gas overrides of the chain config.
+// Opcodes are given by name.
+func (ext *ChainExtensions) resolveGasOverrides(configs []params.GasOverrideConfig) error {
+	for _, config := range configs {
+		block := config.Block
+		if block == nil {
+			block = new(big.Int)
+		}
+		fork := gasOverrideFork{block: block, costs: make(map[OpCode]uint64)}
+		for name, cost := range config.Opcodes {
+			op := StringToOp(name)
+			if op == STOP && name != "STOP" {
+				return fmt.Errorf("unknown opcode %q in gas overrides at block %v", name, block)
+			}
+			fork.costs[op] = cost
+		}
+		ext.gasForks = append(ext.gasForks, fork)
+		log.Info("Overriding opcode gas costs", "block", block, "opcodes", len(fork.costs))
+	}
+	sort.SliceStable(ext.gasForks, func(i, j int) bool {
+		return ext.gasForks[i].block.Cmp(ext.gasForks[j].block) < 0
+	})
+	return nil
+}
+
+// ConstantGas returns the gas cost of an opcode set by the latest fork active
+// at the given block, if any. The interpreter charges it in place of the
+// constant gas of its jump tables; dynamic gas (memory expansion, storage
+// access and the like) is unaffected.
+func (ext *ChainExtensions) ConstantGas(number *big.Int, op OpCode) (uint64, bool) {
+	if ext == nil {
+		return 0, false
+	}
+	var (
+		cost  uint64
+		found bool
+	)
+	for _, fork := range ext.gasForks {
+		if number.Cmp(fork.block) < 0 {
+			break
+		}
+		if c, ok := fork.costs[op]; ok {
+			cost, found = c, true
+		}
+	}
+	return cost, found
+}
+
+// contractLimitFork is a set of contract size limits taking effect at a fork
+// block. Zero limits keep the ones of the previous fork.
+type contractLimitFork struct {
+	block       *big.Int
+	maxCodeSize uint64
+	maxCallData uint64
+}
+
+// resolveContractLimits resolves the contract size limits of the chain config.
+func (ext *ChainExtensions) resolveContractLimits(configs []params.ContractLimitConfig) error {
+	for _, config := range configs {
+		block := config.Block
+		if block == nil {
+			block = new(big.Int)
+		}
+		if config.MaxCodeSize == 0 && config.MaxCallDataSize == 0 {
+			return fmt.Errorf("no contract limits set at block %v", block)
+		}
+		ext.limitForks = append(ext.limitForks, contractLimitFork{block: block, maxCodeSize: config.MaxCodeSize, maxCallData: config.MaxCallDataSize})
+		log.Info("Overriding contract size limits", "block", block, "code", config.MaxCodeSize, "calldata", config.MaxCallDataSize)
+	}
+	sort.SliceStable(ext.limitForks, func(i, j int) bool {
+		return ext.limitForks[i].block.Cmp(ext.limitForks[j].block) < 0
+	})
+	return nil
+}
+
+// activeLimits returns the limits in effect at the given block, zero meaning
+// the default.
+func (ext *ChainExtensions) activeLimits(number *big.Int) (code uint64, calldata uint64) {
+	if ext == nil {
+		return 0, 0
+	}
+	for _, fork := range ext.limitForks {
+		if number.Cmp(fork.block) < 0 {
+			break
+		}
+		if fork.maxCodeSize != 0 {
+			code = fork.maxCodeSize
+		}
+		if fork.maxCallData != 0 {
+			calldata = fork.maxCallData
+		}
+	}
+	return code, calldata
+}
+
+// MaxCodeSize returns the maximum size of the code of contracts deployed at the
+// given block, the EIP-170 limit unless overridden.
+func (ext *ChainExtensions) MaxCodeSize(number *big.Int) uint64 {
+	if code, _ := ext.activeLimits(number); code != 0 {
+		return code
+	}
+	return params.MaxCodeSize
+}
+
+// MaxCallDataSize returns the maximum calldata size of the transactions of the
+// given block, zero if unlimited. The state transition rejects transactions
+// above it.
+func (ext *ChainExtensions) MaxCallDataSize(number *big.Int) uint64 {
+	_, calldata := ext.activeLimits(number)
+	return calldata
+}
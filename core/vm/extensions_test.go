@@ -0,0 +1,91 @@
+// Copyright 2020 The go-BHEereum Authors
+// This file is part of the go-BHEereum library.
+//
+// The go-BHEereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-BHEereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-BHEereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/BHEereum/go-BHEereum/params"
+)
+
+// Tests that opcode gas overrides take effect at their fork and are superseded
+// by later forks only for the opcodes those override.
+func TestGasOverrides(t *testing.T) {
+	ext, err := ResolveExtensions(&params.ChainConfig{GasOverrides: []params.GasOverrideConfig{
+		{Block: big.NewInt(20), Opcodes: map[string]uint64{"SLOAD": 1200}},
+		{Block: big.NewInt(10), Opcodes: map[string]uint64{"SLOAD": 1000, "BALANCE": 900}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create overrides: %v", err)
+	}
+	tests := []struct {
+		number int64
+		op     OpCode
+		cost   uint64
+		found  bool
+	}{
+		{9, SLOAD, 0, false},
+		{10, SLOAD, 1000, true},
+		{19, BALANCE, 900, true},
+		{20, SLOAD, 1200, true},
+		{20, BALANCE, 900, true}, // Kept from the earlier fork
+		{20, ADD, 0, false},
+	}
+	for i, tt := range tests {
+		cost, found := ext.ConstantGas(big.NewInt(tt.number), tt.op)
+		if cost != tt.cost || found != tt.found {
+			t.Errorf("test %d: override mismatch: have %d/%v, want %d/%v", i, cost, found, tt.cost, tt.found)
+		}
+	}
+	if _, err := ResolveExtensions(&params.ChainConfig{GasOverrides: []params.GasOverrideConfig{{Opcodes: map[string]uint64{"NOPE": 1}}}}); err == nil {
+		t.Errorf("unknown opcode accepted")
+	}
+}
+
+// Tests that contract size limits take effect at their fork, each limit being
+// kept until a later fork changes it.
+func TestContractLimits(t *testing.T) {
+	ext, err := ResolveExtensions(&params.ChainConfig{ContractLimits: []params.ContractLimitConfig{
+		{Block: big.NewInt(20), MaxCallDataSize: 65536},
+		{Block: big.NewInt(10), MaxCodeSize: 49152},
+	}})
+	if err != nil {
+		t.Fatalf("failed to create limits: %v", err)
+	}
+	tests := []struct {
+		number   int64
+		code     uint64
+		calldata uint64
+	}{
+		{9, params.MaxCodeSize, 0},
+		{10, 49152, 0},
+		{20, 49152, 65536},
+	}
+	for i, tt := range tests {
+		number := big.NewInt(tt.number)
+		if code := ext.MaxCodeSize(number); code != tt.code {
+			t.Errorf("test %d: code limit mismatch: have %d, want %d", i, code, tt.code)
+		}
+		if calldata := ext.MaxCallDataSize(number); calldata != tt.calldata {
+			t.Errorf("test %d: calldata limit mismatch: have %d, want %d", i, calldata, tt.calldata)
+		}
+	}
+	if _, err := ResolveExtensions(&params.ChainConfig{ContractLimits: []params.ContractLimitConfig{{Block: big.NewInt(1)}}}); err == nil {
+		t.Errorf("empty limits accepted")
+	}
+}